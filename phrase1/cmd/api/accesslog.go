@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// accessLog writes one line per request: method, path, status, duration,
+// response bytes, the caller (as require resolved it; see subjectSlot), and
+// the request id.
+// Errors (4xx and 5xx) are always logged, 5xx to warn; a sample of the
+// rest, at info, so a busy replica doesn't log every enqueue. Successful
+// probes and scrapes aren't logged at all.
+type accessLog struct {
+	// sample is the share of successful requests logged, from 0 to 1.
+	sample float64
+	info   *log.Logger
+	warn   *log.Logger
+}
+
+func (a *accessLog) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, caller := withSubjectSlot(r)
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		status := rec.code()
+		if status < 400 && (quietPath(r.URL.Path) || rand.Float64() >= a.sample) {
+			return
+		}
+		l := a.info
+		if status >= 500 {
+			l = a.warn
+		}
+		l.Printf("access method=%s path=%s status=%d duration_ms=%s bytes=%d subject=%s request_id=%s",
+			r.Method, strconv.Quote(r.URL.Path), status, strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', -1, 64), rec.bytes, caller.resolved(r), w.Header().Get("X-Request-Id"))
+	})
+}
+
+// quietPath reports whether path is a probe or the metrics scrape.
+func quietPath(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/startupz", "/metrics":
+		return true
+	}
+	return false
+}
+
+// responseRecorder notes the status and size of a response. It passes
+// Flush and Hijack through, so streams and WebSockets work behind it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+// code returns the response's status. A handler that wrote nothing
+// answered 200, unless it took the connection over.
+func (w *responseRecorder) code() int {
+	switch {
+	case w.status != 0:
+		return w.status
+	case w.hijacked:
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
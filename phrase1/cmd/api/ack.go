@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ackLevel is how far an enqueue gets before /enqueue answers, chosen with
+// ?ack=.
+type ackLevel string
+
+const (
+	// ackNone answers 202 once the message is in the api's buffer.
+	ackNone ackLevel = "none"
+	// ackQueued answers once the message is on the queue in Redis.
+	ackQueued ackLevel = "queued"
+	// ackPersisted answers once Redis replicas have the message too.
+	ackPersisted ackLevel = "persisted"
+)
+
+func parseAck(v string) (ackLevel, error) {
+	switch ackLevel(v) {
+	case "", ackQueued:
+		return ackQueued, nil
+	case ackNone, ackPersisted:
+		return ackLevel(v), nil
+	}
+	return "", fmt.Errorf("ack must be none, queued, or persisted")
+}
+
+// ackBuffer holds the writes of ack=none enqueues, which were answered
+// before reaching Redis, until its writers get to them.
+type ackBuffer struct {
+	writes  chan func(context.Context)
+	writers int
+	timeout time.Duration
+	logger  *log.Logger
+}
+
+func newAckBuffer(size, writers int, timeout time.Duration, logger *log.Logger) *ackBuffer {
+	if writers < 1 {
+		writers = 1
+	}
+	return &ackBuffer{writes: make(chan func(context.Context), size), writers: writers, timeout: timeout, logger: logger}
+}
+
+// offer buffers write, or reports false if the buffer is full.
+func (b *ackBuffer) offer(write func(context.Context)) bool {
+	select {
+	case b.writes <- write:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *ackBuffer) len() int {
+	return len(b.writes)
+}
+
+// Run writes buffered enqueues until ctx is canceled, then writes what's
+// left. Stop it only after the handlers that enqueue have drained.
+func (b *ackBuffer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < b.writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case write := <-b.writes:
+					b.write(ctx, write)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(b.writes); n > 0 {
+		b.logger.Printf("writing %d buffered enqueues before exit", n)
+	}
+	for {
+		select {
+		case write := <-b.writes:
+			b.write(ctx, write)
+		default:
+			return
+		}
+	}
+}
+
+func (b *ackBuffer) write(ctx context.Context, write func(context.Context)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.timeout)
+	defer cancel()
+	write(ctx)
+}
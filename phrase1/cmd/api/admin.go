@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/logging"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+// purgeQueue drops the waiting messages, and with ?dlq=true the dead-letter
+// queue as well.
+func purgeQueue(q *queue.RedisQueue, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		dlq, _ := strconv.ParseBool(r.URL.Query().Get("dlq"))
+		n, err := q.Purge(ctx, dlq)
+		if err != nil {
+			logger.Printf("purge failed: %v", err)
+			writeError(w, "purge failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("purged %d messages from %s (dlq=%t) by %s", n, q.Name(), dlq, requestSubject(r))
+		detail := "messages=" + strconv.FormatInt(n, 10) + " dlq=" + strconv.FormatBool(dlq)
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "queue.purge", Queue: q.Name(), Detail: detail}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		writeJSON(w, map[string]int64{"purged": n})
+	}
+}
+
+// pauseQueue stops workers from consuming on PUT and lets them resume on
+// DELETE.
+func pauseQueue(q queue.Queue, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		paused := r.Method == http.MethodPut
+		action := "queue.resume"
+		err := q.Resume(ctx)
+		if paused {
+			action = "queue.pause"
+			err = q.Pause(ctx)
+		}
+		if err != nil {
+			logger.Printf("%s failed: %v", action, err)
+			writeError(w, action+" failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("%s by %s", action, requestSubject(r))
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: action, Queue: q.Name()}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		writeJSON(w, map[string]bool{"paused": paused})
+	}
+}
+
+func logLevelHandler(levels *logging.Switch, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var req struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&req); err != nil {
+				writeError(w, `body must be {"level": "..."}`, http.StatusBadRequest)
+				return
+			}
+			l, err := logging.ParseLevel(req.Level)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			levels.Set(l)
+			logger.Printf("log level set to %s by %s", l, requestSubject(r))
+
+			ctx := r.Context()
+			if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "loglevel.set", Detail: l.String()}); err != nil {
+				logger.Printf("audit write error: %v", err)
+			}
+		}
+		writeJSON(w, map[string]string{"level": levels.Level().String()})
+	}
+}
+
+// slowMessages lists the slowest of the recent messages workers flagged as
+// slow, ?limit= of them (default 10).
+func slowMessages(q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 10
+		}
+		slow, err := q.SlowestRecent(ctx, limit)
+		if err != nil {
+			logger.Printf("slow messages failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, slow)
+	}
+}
+
+// registerPprof serves the runtime profiles under /debug/pprof/ for admins.
+func registerPprof(mux *http.ServeMux, authz *rbac.Authorizer) {
+	mux.HandleFunc("GET /debug/pprof/", require(authz, rbac.Admin, pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", require(authz, rbac.Admin, pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", require(authz, rbac.Admin, pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", require(authz, rbac.Admin, pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", require(authz, rbac.Admin, pprof.Trace))
+}
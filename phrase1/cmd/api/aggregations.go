@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// maxAggregateWindow caps ?window=: every minute in it is a hash to read.
+const maxAggregateWindow = 24 * time.Hour
+
+// listAggregations returns the rolling word counts the worker's aggregation
+// handler keeps: the top ?limit= words (default 20) over the last ?window=
+// (default 15m), or the counts of the ?word= words given.
+func listAggregations(q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		window := 15 * time.Minute
+		if v := query.Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < queue.AggregateBucket || d > maxAggregateWindow {
+				writeError(w, "window must be a duration from 1m to 24h", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		limit := 20
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxArchivePage {
+				writeError(w, "limit must be an integer from 1 to "+strconv.Itoa(maxArchivePage), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		agg, err := q.Aggregations(ctx, window, limit, query["word"], time.Now())
+		if err != nil {
+			logger.Printf("aggregations failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, struct {
+			queue.Aggregation
+			Window string `json:"window"`
+		}{agg, window.String()})
+	}
+}
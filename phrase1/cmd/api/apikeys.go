@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/apikey"
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+// issuedKey is a key with its secret, returned once by create and rotate.
+type issuedKey struct {
+	apikey.Key
+	Secret string `json:"secret"`
+}
+
+func keyPrincipal(k apikey.Key) rbac.Principal {
+	return rbac.Principal{Name: "apikey:" + k.ID, Role: k.Role()}
+}
+
+func listKeys(keys *apikey.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, keys.List())
+	}
+}
+
+// createKey issues a key from {"owner", "scopes", "expires_at"}.
+func createKey(keys *apikey.Store, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var req struct {
+			Owner     string    `json:"owner"`
+			Scopes    []string  `json:"scopes"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, "body must be a JSON object with owner, scopes, and optionally expires_at", http.StatusBadRequest)
+			return
+		}
+		scopes := make([]rbac.Role, len(req.Scopes))
+		for i, s := range req.Scopes {
+			role, err := rbac.ParseRole(s)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			scopes[i] = role
+		}
+
+		k, secret, err := keys.Create(ctx, req.Owner, scopes, req.ExpiresAt)
+		if errors.Is(err, apikey.ErrInvalid) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Printf("create key failed: %v", err)
+			writeError(w, "create key failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("created key %s for %s by %s", k.ID, k.Owner, requestSubject(r))
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "key.create", Detail: "id=" + k.ID + " owner=" + k.Owner + " role=" + string(k.Role())}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(issuedKey{Key: k, Secret: secret})
+	}
+}
+
+func revokeKey(keys *apikey.Store, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		k, err := keys.Revoke(ctx, r.PathValue("id"))
+		if errors.Is(err, apikey.ErrNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("revoke key failed: %v", err)
+			writeError(w, "revoke key failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("revoked key %s of %s by %s", k.ID, k.Owner, requestSubject(r))
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "key.revoke", Detail: "id=" + k.ID + " owner=" + k.Owner}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		writeJSON(w, k)
+	}
+}
+
+// rotateKey gives a key a new secret; ?grace=10m keeps the old one working
+// that long.
+func rotateKey(keys *apikey.Store, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var grace time.Duration
+		if v := r.URL.Query().Get("grace"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				writeError(w, "grace must be a non-negative duration such as 10m", http.StatusBadRequest)
+				return
+			}
+			grace = d
+		}
+		k, secret, err := keys.Rotate(ctx, r.PathValue("id"), grace)
+		switch {
+		case errors.Is(err, apikey.ErrNotFound):
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, apikey.ErrInactive):
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			logger.Printf("rotate key failed: %v", err)
+			writeError(w, "rotate key failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("rotated key %s of %s by %s (grace %s)", k.ID, k.Owner, requestSubject(r), grace)
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "key.rotate", Detail: "id=" + k.ID + " owner=" + k.Owner + " grace=" + grace.String()}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		writeJSON(w, issuedKey{Key: k, Secret: secret})
+	}
+}
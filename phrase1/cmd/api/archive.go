@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// maxArchivePage caps how many archived messages one request returns.
+const maxArchivePage = 1000
+
+// listArchive returns processed messages from the queue's archive stream,
+// newest first. ?after= and ?before= take stream ids from earlier answers to
+// page, ?since= and ?until= times, and ?order=oldest reads forward from the
+// lower bound instead.
+func listArchive(q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		aq := queue.ArchiveQuery{After: query.Get("after"), Before: query.Get("before"), Limit: 100}
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxArchivePage {
+				writeError(w, "limit must be an integer from 1 to "+strconv.Itoa(maxArchivePage), http.StatusBadRequest)
+				return
+			}
+			aq.Limit = int64(n)
+		}
+		for _, bound := range []struct {
+			param  string
+			cursor *string
+		}{{"since", &aq.After}, {"until", &aq.Before}} {
+			v := query.Get(bound.param)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, bound.param+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*bound.cursor = queue.ArchiveCursor(t)
+		}
+		switch query.Get("order") {
+		case "", "newest":
+		case "oldest":
+			aq.Oldest = true
+		default:
+			writeError(w, "order must be newest or oldest", http.StatusBadRequest)
+			return
+		}
+
+		msgs, err := q.Archived(ctx, aq)
+		if err != nil {
+			logger.Printf("archive failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, msgs)
+	}
+}
+
+// archiveBacklog returns what a stream client missed, as processed events
+// with their stream ids, oldest first: everything after the Last-Event-ID it
+// reconnected with, or the last ?history= messages.
+func archiveBacklog(ctx context.Context, q *queue.RedisQueue, r *http.Request) ([]queue.ArchivedMessage, error) {
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		return q.Archived(ctx, queue.ArchiveQuery{After: last, Limit: maxArchivePage, Oldest: true})
+	}
+	n, _ := strconv.Atoi(r.URL.Query().Get("history"))
+	if n <= 0 {
+		return nil, nil
+	}
+	msgs, err := q.Archived(ctx, queue.ArchiveQuery{Limit: int64(min(n, maxArchivePage))})
+	slices.Reverse(msgs)
+	return msgs, err
+}
+
+// archivedEvent is m as the processed event the worker published for it.
+func archivedEvent(m queue.ArchivedMessage) events.Event {
+	return events.Event{
+		Type:     events.MessageProcessed,
+		Queue:    m.Queue,
+		Message:  m.Message,
+		Time:     m.ProcessedAt,
+		Source:   m.Worker,
+		Duration: time.Duration(m.DurationMS) * time.Millisecond,
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/backlog"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// backlogSampler samples the queue's stats so the api can export how fast
+// its backlog moves, as metrics and in GET /stats, for autoscalers to scale
+// on the backlog's velocity rather than its depth. Every replica samples
+// the same shared counters, so they all export the same values. It also
+// raises queue.depth_* events as the depth crosses its watermarks.
+type backlogSampler struct {
+	q           *queue.RedisQueue
+	tracker     *backlog.Tracker
+	every       time.Duration
+	depth       *metrics.Gauge
+	arrival     *metrics.Gauge
+	drain       *metrics.Gauge
+	timeToDrain *metrics.Gauge
+	alarm       *backlog.Alarm
+	level       *metrics.Gauge
+	bus         *events.Bus
+	hostname    string
+	logger      *log.Logger
+}
+
+func newBacklogSampler(q *queue.RedisQueue, reg *metrics.Registry, bus *events.Bus, hostname string, logger *log.Logger) *backlogSampler {
+	warning := int64(envInt("DEPTH_WARNING_HIGH", 0))
+	critical := int64(envInt("DEPTH_CRITICAL_HIGH", 0))
+	marks := backlog.Watermarks{
+		WarningHigh:  warning,
+		WarningLow:   int64(envInt("DEPTH_WARNING_LOW", int(warning*8/10))),
+		CriticalHigh: critical,
+		CriticalLow:  int64(envInt("DEPTH_CRITICAL_LOW", int(critical*8/10))),
+	}
+	if err := marks.Validate(); err != nil {
+		logger.Fatalf("depth watermarks: %v", err)
+	}
+	return &backlogSampler{
+		q:           q,
+		tracker:     backlog.NewTracker(envDuration("BACKLOG_RATE_WINDOW", time.Minute)),
+		every:       envDuration("BACKLOG_SAMPLE_INTERVAL", 5*time.Second),
+		depth:       reg.NewGauge("queue_depth", "Messages waiting, at any priority.", "queue"),
+		arrival:     reg.NewGauge("queue_arrival_rate", "Messages enqueued per second over the backlog rate window.", "queue"),
+		drain:       reg.NewGauge("queue_drain_rate", "Messages processed or dead-lettered per second over the backlog rate window.", "queue"),
+		timeToDrain: reg.NewGauge("queue_time_to_drain_seconds", "Seconds until the backlog empties at the current rates; 86400 if it isn't shrinking.", "queue"),
+		alarm:       backlog.NewAlarm(marks),
+		level:       reg.NewGauge("queue_depth_alert_level", "Where the queue depth is against its watermarks: 0 normal, 1 warning, 2 critical.", "queue"),
+		bus:         bus,
+		hostname:    hostname,
+		logger:      logger,
+	}
+}
+
+// run samples until ctx is canceled.
+func (b *backlogSampler) run(ctx context.Context) {
+	ticker := time.NewTicker(b.every)
+	defer ticker.Stop()
+	for {
+		b.sample(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *backlogSampler) sample(ctx context.Context) {
+	stats, err := b.q.Stats(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			b.logger.Printf("backlog sample error: %v", err)
+		}
+		return
+	}
+	b.tracker.Add(backlog.Sample{
+		At:       time.Now(),
+		Depth:    stats.Depth,
+		Enqueued: stats.EnqueuedTotal,
+		Drained:  stats.ProcessedTotal + stats.DeadLetteredTotal,
+	})
+	name := b.q.Name()
+	b.depth.Set(float64(stats.Depth), name)
+	b.watermarks(stats.Depth)
+	if r, ok := b.tracker.Rates(); ok {
+		b.arrival.Set(r.ArrivalRate, name)
+		b.drain.Set(r.DrainRate, name)
+		b.timeToDrain.Set(r.TimeToDrain, name)
+	}
+}
+
+// watermarks moves the depth alarm and announces a new level.
+func (b *backlogSampler) watermarks(depth int64) {
+	level, changed := b.alarm.Update(depth)
+	b.level.Set(float64(level), b.q.Name())
+	if !changed {
+		return
+	}
+	typ := map[backlog.Level]events.Type{
+		backlog.Normal:   events.QueueDepthNormal,
+		backlog.Warning:  events.QueueDepthWarning,
+		backlog.Critical: events.QueueDepthCritical,
+	}[level]
+	msg := fmt.Sprintf("queue depth is %s at %d messages", level, depth)
+	b.logger.Printf("%s: %s", b.q.Name(), msg)
+	b.bus.Publish(events.Event{Type: typ, Queue: b.q.Name(), Message: msg, Source: b.hostname})
+}
+
+// backlogHeaders tells producers how deep the queue is and how long a new
+// message will wait, from b's last sample, so they can back off before the
+// queue fills: X-Queue-Depth is the depth, X-Estimated-Processing-Delay the
+// seconds the messages ahead take to drain at the current drain rate. A nil
+// b, or one without a sample yet, adds nothing.
+func backlogHeaders(b *backlogSampler, h http.HandlerFunc) http.HandlerFunc {
+	if b == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := b.tracker.Latest(); ok {
+			delay := 0.0
+			if s.Depth > 0 {
+				delay = backlog.MaxTimeToDrain.Seconds()
+				if rates, ok := b.tracker.Rates(); ok && rates.DrainRate > 0 {
+					delay = min(float64(s.Depth)/rates.DrainRate, delay)
+				}
+			}
+			w.Header().Set("X-Queue-Depth", strconv.FormatInt(s.Depth, 10))
+			w.Header().Set("X-Estimated-Processing-Delay", strconv.Itoa(int(math.Ceil(delay))))
+		}
+		h(w, r)
+	}
+}
+
+// rates returns the backlog's rates, or nil before there are two samples.
+func (b *backlogSampler) rates() *backlog.Rates {
+	r, ok := b.tracker.Rates()
+	if !ok {
+		return nil
+	}
+	return &r
+}
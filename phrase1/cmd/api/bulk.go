@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/maintenance"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/schema"
+	"learn_k8s/phrase1/internal/tracecontext"
+	"learn_k8s/phrase1/internal/usage"
+	"learn_k8s/phrase1/internal/validate"
+)
+
+const (
+	ndjson = "application/x-ndjson"
+	// bulkChunk is the most lines enqueued per Redis round trip.
+	bulkChunk = 100
+	// bulkMaxLine matches the /enqueue body limit.
+	bulkMaxLine = 1 << 20
+)
+
+// bulkResult is the response line for one request line, or for one line
+// or file of a file upload.
+type bulkResult struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Enqueued bool   `json:"enqueued"`
+	ID       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bulkSummary is the last response line. Error is set when the upload was
+// cut short; lines after the last result weren't read.
+type bulkSummary struct {
+	Done     bool   `json:"done"`
+	Queue    string `json:"queue"`
+	Enqueued int    `json:"enqueued"`
+	Failed   int    `json:"failed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// bulkEnqueuer serves POST /enqueue/batch and POST /enqueue/file.
+type bulkEnqueuer struct {
+	tenants  *tenancy
+	policies *policy.Store
+	maint    *maintenance.Switch
+	schemas  *schema.Registry
+	rules    *enqueueRules
+	tracker  *usage.Tracker
+	quotas   usage.Quotas
+	bus      *events.Bus
+	hostname string
+	reporter errreport.Reporter
+	logger   *log.Logger
+	// debugLog gets a line per message; requests are in the access log.
+	debugLog *log.Logger
+	redactor *redact.Messages
+}
+
+// bulkChunkState is a run of request lines waiting to be enqueued together.
+// Lines that were rejected on parsing keep their place in results.
+type bulkChunkState struct {
+	results  []bulkResult
+	valid    []int
+	payloads []string
+	messages []string
+	size     int64
+}
+
+func (c *bulkChunkState) reset() {
+	c.results = c.results[:0]
+	c.valid = c.valid[:0]
+	c.payloads = c.payloads[:0]
+	c.messages = c.messages[:0]
+	c.size = 0
+}
+
+// bulkStream is the response to a bulk upload: a bulkResult per message
+// streamed as its chunk lands, then the summary.
+type bulkStream struct {
+	q       *queue.RedisQueue
+	subject string
+	span    tracecontext.SpanContext
+	budget  time.Duration
+	enc     *json.Encoder
+	flusher http.Flusher
+	summary bulkSummary
+	chunk   bulkChunkState
+	// gone is set once a write fails: the client has stopped reading.
+	gone bool
+}
+
+// start resolves the queue an upload goes to, base or the caller's tenant
+// queue, and starts the response. On failure it writes the response and
+// returns nil.
+func (b *bulkEnqueuer) start(w http.ResponseWriter, r *http.Request, base *queue.RedisQueue) *bulkStream {
+	resolveCtx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	q := b.tenants.resolve(resolveCtx, w, r, base, b.logger)
+	if q == nil || queueFull(resolveCtx, w, q, b.policies.For(q.Name()), b.logger) {
+		return nil
+	}
+
+	// HTTP/1 servers otherwise stop reading the body once the response
+	// has started.
+	_ = http.NewResponseController(w).EnableFullDuplex()
+	clearDeadlines(w)
+	w.Header().Set("Content-Type", ndjson)
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	return &bulkStream{
+		q:       q,
+		subject: requestSubject(r),
+		span:    messageSpan(r),
+		budget:  requestBudget(r.Context()),
+		enc:     json.NewEncoder(w),
+		flusher: flusher,
+		summary: bulkSummary{Done: true, Queue: q.Name()},
+	}
+}
+
+// send writes the chunk's results and clears it; false means the client
+// is gone.
+func (s *bulkStream) send() bool {
+	for _, res := range s.chunk.results {
+		if res.Enqueued {
+			s.summary.Enqueued++
+		} else {
+			s.summary.Failed++
+		}
+		if err := s.enc.Encode(res); err != nil {
+			s.gone = true
+			return false
+		}
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	s.chunk.reset()
+	return true
+}
+
+// next enqueues the chunk once it's full. False means stop reading: the
+// client is gone, or the upload failed and the summary says why.
+func (b *bulkEnqueuer) next(r *http.Request, s *bulkStream) bool {
+	if len(s.chunk.results) < bulkChunk {
+		return true
+	}
+	err := b.flush(r, s.q, s.subject, &s.chunk)
+	if !s.send() {
+		return false
+	}
+	if err != nil {
+		s.summary.Error = err.Error()
+		return false
+	}
+	return true
+}
+
+// finish enqueues what's left, unless the upload already failed, and
+// writes the summary, with readErr as its error if reading the body failed.
+func (b *bulkEnqueuer) finish(r *http.Request, s *bulkStream, readErr string) {
+	if s.gone {
+		return
+	}
+	if s.summary.Error == "" {
+		s.summary.Error = readErr
+		if err := b.flush(r, s.q, s.subject, &s.chunk); err != nil && s.summary.Error == "" {
+			s.summary.Error = err.Error()
+		}
+		if !s.send() {
+			return
+		}
+	}
+	_ = s.enc.Encode(s.summary)
+}
+
+// handler streams an NDJSON body of /enqueue JSON bodies
+// ({"message":...}), one per line, into base or the caller's tenant queue.
+// Lines are enqueued in chunks of up to bulkChunk, each in one round trip and
+// all or nothing, and the response streams one bulkResult per line as each
+// chunk lands, then a bulkSummary.
+// Neither side is buffered whole, so uploads can be arbitrarily large.
+func (b *bulkEnqueuer) handler(base *queue.RedisQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, b.maint) {
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != ndjson {
+			w.Header().Set("Accept-Post", ndjson)
+			writeError(w, "content type must be "+ndjson, http.StatusUnsupportedMediaType)
+			return
+		}
+		s := b.start(w, r, base)
+		if s == nil {
+			return
+		}
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64<<10), bulkMaxLine)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			b.parse(s, line, text)
+			if !b.next(r, s) {
+				break
+			}
+		}
+		var readErr string
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				readErr = fmt.Sprintf("line %d is longer than %d bytes", line+1, bulkMaxLine)
+			} else {
+				readErr = "failed to read body"
+			}
+		}
+		b.finish(r, s, readErr)
+	}
+}
+
+// parse turns one request line into a message for the stream's chunk, or a
+// failed result if it isn't a valid /enqueue JSON body.
+func (b *bulkEnqueuer) parse(s *bulkStream, line int, text string) {
+	var req enqueueRequest
+	if err := json.Unmarshal([]byte(text), &req); err != nil {
+		s.chunk.results = append(s.chunk.results, bulkResult{Line: line, Error: "line is not a JSON object"})
+		return
+	}
+	msg := string(req.Message)
+	var str string
+	if err := json.Unmarshal(req.Message, &str); err == nil {
+		msg = strings.TrimSpace(str)
+	}
+	b.add(s, bulkResult{Line: line}, msg, req.SchemaVersion)
+}
+
+// add turns msg into an envelope for the stream's chunk, stamped with the
+// request's span and budget, or a failed result if it isn't a valid
+// message. res says where in the upload it came from.
+func (b *bulkEnqueuer) add(s *bulkStream, res bulkResult, msg string, schemaVersion int) {
+	chunk, q := &s.chunk, s.q
+	fail := func(msg string) {
+		res.Error = msg
+		chunk.results = append(chunk.results, res)
+	}
+	if msg == "" {
+		fail("message is required")
+		return
+	}
+	if schemaVersion < 0 {
+		fail("schema_version must be a positive integer")
+		return
+	}
+	if err := b.schemas.Validate(q.Name(), []byte(msg)); err != nil {
+		fail(err.Error())
+		return
+	}
+	if ve := b.rules.check(validate.Input{Queue: q.Name(), Text: msg, Size: len(msg)}); ve != nil {
+		fail(ve.Error())
+		return
+	}
+	envlp := envelope.New(msg)
+	envlp.SchemaVersion = schemaVersion
+	envlp.Metadata = s.span.SetMetadata(envlp.Metadata)
+	envlp.BudgetMS = s.budget.Milliseconds()
+	encoded, err := q.Encode(envlp)
+	if err != nil {
+		b.logger.Printf("encode envelope failed: %v", err)
+		fail("enqueue failed")
+		return
+	}
+	res.ID = envlp.ID
+	chunk.valid = append(chunk.valid, len(chunk.results))
+	chunk.results = append(chunk.results, res)
+	chunk.payloads = append(chunk.payloads, encoded)
+	chunk.messages = append(chunk.messages, msg)
+	chunk.size += int64(len(msg))
+}
+
+// flush enqueues the chunk's valid lines and fills in their results. An
+// error means the rest of the upload shouldn't be attempted either.
+func (b *bulkEnqueuer) flush(r *http.Request, q *queue.RedisQueue, subject string, chunk *bulkChunkState) error {
+	if len(chunk.valid) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	fail := func(msg string) {
+		for _, i := range chunk.valid {
+			chunk.results[i].ID = ""
+			chunk.results[i].Error = msg
+		}
+	}
+
+	n := int64(len(chunk.valid))
+	if _, err := b.tracker.ConsumeN(ctx, subject, n, chunk.size, b.quotas.For(subject)); err != nil {
+		if errors.Is(err, usage.ErrQuotaExceeded) {
+			fail(err.Error())
+			return err
+		}
+		b.logger.Printf("usage accounting failed: %v", err)
+		b.reporter.Report(errreport.Event{Err: err, Message: "usage accounting failed", TraceID: requestTraceID(r), Tags: map[string]string{"queue": q.Name()}})
+		fail("enqueue failed")
+		return errors.New("enqueue failed")
+	}
+	if err := q.EnqueueMany(ctx, chunk.payloads); err != nil {
+		b.logger.Printf("bulk enqueue failed: %v", err)
+		b.reporter.Report(errreport.Event{Err: err, Message: "bulk enqueue failed", TraceID: requestTraceID(r), Tags: map[string]string{"queue": q.Name()}})
+		if err := b.tracker.RefundN(ctx, subject, n, chunk.size); err != nil {
+			b.logger.Printf("usage refund failed: %v", err)
+		}
+		fail("enqueue failed")
+		return errors.New("enqueue failed")
+	}
+
+	for j, i := range chunk.valid {
+		chunk.results[i].Enqueued = true
+		b.debugLog.Printf("enqueued message: %q", b.redactor.Text(chunk.messages[j]))
+		b.bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: q.Name(), Message: chunk.messages[j], Source: b.hostname, Subject: subject})
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipMode picks what compression a route gets.
+type gzipMode int
+
+const (
+	// gzipRequests accepts request bodies sent with Content-Encoding: gzip.
+	gzipRequests gzipMode = 1 << iota
+	// gzipResponses compresses JSON responses for clients that accept gzip.
+	gzipResponses
+)
+
+// compression wraps routes in gzip handling. A nil *compression means it's
+// off and routes are left as they are.
+type compression struct {
+	writers sync.Pool
+}
+
+func newCompression(enabled bool) *compression {
+	if !enabled {
+		return nil
+	}
+	return &compression{writers: sync.Pool{New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gz
+	}}}
+}
+
+// wrap applies mode to h.
+func (c *compression) wrap(mode gzipMode, h http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mode&gzipRequests != 0 && !decompressBody(w, r) {
+			return
+		}
+		if mode&gzipResponses == 0 || !acceptsGzip(r) {
+			h(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, pool: &c.writers}
+		defer gw.close()
+		h(gw, r)
+	}
+}
+
+// decompressBody swaps a gzip request body for its decompressed form. Other
+// encodings get 415 naming the one that's accepted. On failure it writes the
+// response and returns false.
+func decompressBody(w http.ResponseWriter, r *http.Request) bool {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return true
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, "request body is not valid gzip", http.StatusBadRequest)
+			return false
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{zr, r.Body}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		return true
+	default:
+		w.Header().Set("Accept-Encoding", "gzip")
+		writeError(w, "unsupported content encoding "+enc, http.StatusUnsupportedMediaType)
+		return false
+	}
+}
+
+// acceptsGzip reports whether r's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response if, when the header is written,
+// it turns out to be JSON. Anything else (redirects, metrics text) goes
+// out as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	pool    *sync.Pool
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+		isJSON := mt == "application/json" || strings.HasSuffix(mt, "+json")
+		if isJSON && code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			w.gz = w.pool.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	_ = w.gz.Close()
+	w.gz.Reset(io.Discard)
+	w.pool.Put(w.gz)
+	w.gz = nil
+}
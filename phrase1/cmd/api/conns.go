@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// httpTuning holds the main listener's limits. Zero durations and sizes mean
+// net/http's defaults (no timeout, 1 MiB of headers); MaxConns 0 means no cap.
+type httpTuning struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxConns          int
+	// Grace is how long shutdown waits for in-flight requests.
+	Grace time.Duration
+}
+
+func loadHTTPTuning() httpTuning {
+	return httpTuning{
+		ReadTimeout:       time.Duration(envInt("HTTP_READ_TIMEOUT_SECONDS", 0)) * time.Second,
+		ReadHeaderTimeout: time.Duration(envInt("HTTP_READ_HEADER_TIMEOUT_SECONDS", 5)) * time.Second,
+		WriteTimeout:      time.Duration(envInt("HTTP_WRITE_TIMEOUT_SECONDS", 0)) * time.Second,
+		IdleTimeout:       time.Duration(envInt("HTTP_IDLE_TIMEOUT_SECONDS", 0)) * time.Second,
+		MaxHeaderBytes:    envInt("HTTP_MAX_HEADER_BYTES", 0),
+		MaxConns:          envInt("HTTP_MAX_CONNS", 0),
+		Grace:             time.Duration(envInt("HTTP_SHUTDOWN_GRACE_SECONDS", 10)) * time.Second,
+	}
+}
+
+func (t httpTuning) apply(srv *http.Server) {
+	srv.ReadTimeout = t.ReadTimeout
+	srv.ReadHeaderTimeout = t.ReadHeaderTimeout
+	srv.WriteTimeout = t.WriteTimeout
+	srv.IdleTimeout = t.IdleTimeout
+	srv.MaxHeaderBytes = t.MaxHeaderBytes
+}
+
+// clearDeadlines exempts a long-lived response (a stream or a bulk upload)
+// from the server's read and write timeouts.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// connMetrics are the connection metrics shared by every server.
+type connMetrics struct {
+	open     *metrics.Gauge
+	accepted *metrics.Counter
+	waits    *metrics.Counter
+	drain    *metrics.Gauge
+	forced   *metrics.Counter
+	inFlight *metrics.Gauge
+	shed     *metrics.Counter
+	logger   *log.Logger
+}
+
+func newConnMetrics(reg *metrics.Registry, logger *log.Logger) *connMetrics {
+	return &connMetrics{
+		logger:   logger,
+		open:     reg.NewGauge("http_connections", "Open HTTP connections by state (new, active, idle).", "server", "state"),
+		accepted: reg.NewCounter("http_connections_accepted_total", "HTTP connections accepted.", "server"),
+		waits:    reg.NewCounter("http_connection_limit_waits_total", "Times accepting paused because HTTP_MAX_CONNS connections were open.", "server"),
+		drain:    reg.NewGauge("http_shutdown_drain_seconds", "How long the last shutdown took to drain in-flight requests.", "server"),
+		forced:   reg.NewCounter("http_shutdown_forced_total", "Shutdowns that hit the grace period with requests still in flight.", "server"),
+		inFlight: reg.NewGauge("http_requests_in_flight", "HTTP requests being handled, streams included.", "server"),
+		shed:     reg.NewCounter("http_requests_shed_total", "HTTP requests answered 503 because MAX_INFLIGHT_REQUESTS were in flight.", "server"),
+	}
+}
+
+// connTracker follows one server's connections for connMetrics.
+type connTracker struct {
+	*connMetrics
+	name string
+
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+
+	// limited is the in-flight requests limitInFlight may shed.
+	limited atomic.Int64
+}
+
+func (m *connMetrics) tracker(name string) *connTracker {
+	return &connTracker{connMetrics: m, name: name, states: map[net.Conn]http.ConnState{}}
+}
+
+// hook is an http.Server ConnState callback.
+func (t *connTracker) hook(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.states[c]; ok {
+		t.open.Add(-1, t.name, prev.String())
+	} else {
+		t.accepted.Inc(t.name)
+	}
+	switch state {
+	case http.StateNew, http.StateActive, http.StateIdle:
+		t.states[c] = state
+		t.open.Add(1, t.name, state.String())
+	default:
+		// Hijacked connections (WebSockets) are no longer the server's.
+		delete(t.states, c)
+	}
+}
+
+// Open is the number of connections the server still holds.
+func (t *connTracker) Open() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.states)
+}
+
+// drained records a shutdown that started with open connections and took
+// took; forced means the grace period ran out first.
+func (t *connTracker) drained(open int, took time.Duration, forced bool) {
+	t.drain.Set(took.Seconds(), t.name)
+	if forced {
+		t.forced.Inc(t.name)
+		t.logger.Printf("%s server: grace period over after %s, closing %d of %d connections", t.name, took.Round(time.Millisecond), t.Open(), open)
+		return
+	}
+	if open > 0 {
+		t.logger.Printf("%s server: drained %d connections in %s", t.name, open, took.Round(time.Millisecond))
+	}
+}
+
+// limitInFlight counts the server's in-flight requests and, with maxInFlight
+// above 0, sheds the ones over it with a 503, so an overloaded replica
+// answers fast instead of queueing requests until they all time out.
+// Probes and the event streams show in the gauge but neither count toward
+// the limit nor get shed: failing a probe under load would restart the
+// pod, and a stream would hold its slot for as long as it's open.
+func (t *connTracker) limitInFlight(maxInFlight int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.inFlight.Add(1, t.name)
+		defer t.inFlight.Add(-1, t.name)
+		if maxInFlight <= 0 || neverShed(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer t.limited.Add(-1)
+		if t.limited.Add(1) > int64(maxInFlight) {
+			t.shed.Inc(t.name)
+			writeCodedError(w, codeOverloaded, "too many requests in flight, retry shortly", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// neverShed reports whether path is a probe or an event stream.
+func neverShed(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/startupz":
+		return true
+	}
+	return strings.HasSuffix(path, "/stream/processed") || strings.HasSuffix(path, "/ws/events")
+}
+
+// limitListener caps the connections accepted at once. Connections over the
+// cap wait in the kernel's backlog instead of being refused, so a load test
+// sees latency rather than errors.
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+	waits func()
+}
+
+func newLimitListener(l net.Listener, n int, waits func()) net.Listener {
+	return &limitListener{Listener: l, slots: make(chan struct{}, n), waits: waits}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		l.waits()
+		l.slots <- struct{}{}
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.slots }}, nil
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
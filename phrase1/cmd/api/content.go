@@ -0,0 +1,35 @@
+package main
+
+import (
+	"mime"
+	"strings"
+
+	"learn_k8s/phrase1/internal/codec"
+)
+
+// acceptedContentTypes is advertised in Accept-Post when /enqueue rejects a
+// request body.
+var acceptedContentTypes = []string{
+	"text/plain",
+	"application/json",
+	codec.MsgPack,
+	codec.Protobuf,
+	"application/cloudevents+json",
+}
+
+// isTextContentType reports whether an /enqueue body of this type is taken as
+// the message text. A missing type and form encoding (curl -d's default) are
+// accepted so the plain curl examples keep working.
+func isTextContentType(contentType string) bool {
+	if strings.TrimSpace(contentType) == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") ||
+		mt == "application/json" ||
+		strings.HasSuffix(mt, "+json") ||
+		mt == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig says which browser origins may call the api, and how.
+type corsConfig struct {
+	// Origins are scheme://host[:port] patterns, where * matches within a
+	// host label ("https://*.example.com"); a lone "*" allows any origin.
+	Origins []string
+	Methods []string
+	Headers []string
+	MaxAge  time.Duration
+}
+
+// corsExposed are the response headers a cross-origin script may read.
+const corsExposed = "X-Request-Id, Retry-After, Deprecation, Link, Accept-Post, X-Queue-Depth, X-Estimated-Processing-Delay"
+
+func loadCORS() corsConfig {
+	c := corsConfig{
+		Origins: envList("CORS_ALLOWED_ORIGINS"),
+		Methods: envList("CORS_ALLOWED_METHODS"),
+		Headers: envList("CORS_ALLOWED_HEADERS"),
+		MaxAge:  time.Duration(envInt("CORS_MAX_AGE_SECONDS", 600)) * time.Second,
+	}
+	if c.Methods == nil {
+		c.Methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	}
+	if c.Headers == nil {
+		c.Headers = []string{"Content-Type", "Content-Encoding", "Authorization", "X-API-Key", "X-Request-Id", "Idempotency-Key",
+			"X-Priority", "X-Delay-Seconds", "X-Schema-Version", "X-Request-Timeout", "traceparent", "tracestate", "baggage"}
+	}
+	for i, m := range c.Methods {
+		c.Methods[i] = strings.ToUpper(m)
+	}
+	return c
+}
+
+func (c corsConfig) allowOrigin(origin string) bool {
+	for _, p := range c.Origins {
+		if p == "*" || strings.EqualFold(p, origin) {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS answers preflight requests and marks responses to allowed origins
+// as readable. Requests from other origins are served as before, without the
+// headers, so the browser keeps the response from the page. With no origins
+// configured h is returned as is.
+func withCORS(c corsConfig, h http.Handler) http.Handler {
+	if len(c.Origins) == 0 {
+		return h
+	}
+	allowOrigin := func(w http.ResponseWriter, origin string) {
+		if slices.Contains(c.Origins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+	}
+	methods := strings.Join(c.Methods, ", ")
+	headers := strings.Join(c.Headers, ", ")
+	maxAge := strconv.Itoa(int(c.MaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allowOrigin(origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed && slices.Contains(c.Methods, r.Header.Get("Access-Control-Request-Method")) {
+				allowOrigin(w, origin)
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed {
+			allowOrigin(w, origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withSecurityHeaders sets the usual hardening headers on every response:
+// no MIME sniffing, no framing, no referrer, and HSTS if hsts is set (only
+// worth it where the api is reached over TLS).
+func withSecurityHeaders(hsts time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		hdr.Set("X-Frame-Options", "DENY")
+		hdr.Set("Content-Security-Policy", "frame-ancestors 'none'")
+		hdr.Set("Referrer-Policy", "no-referrer")
+		if hsts > 0 {
+			hdr.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hsts.Seconds())))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// dlqRecord is a dead-lettered message as ?detail=true explains it.
+type dlqRecord struct {
+	ID         string     `json:"id,omitempty"`
+	Payload    string     `json:"payload"`
+	EnqueuedAt *time.Time `json:"enqueued_at,omitempty"`
+	// Reason is why the message was dead-lettered: the dead-letter reason
+	// the worker recorded, or else the last attempt's error.
+	Reason  string           `json:"reason,omitempty"`
+	History []worker.Attempt `json:"history"`
+}
+
+func newDLQRecord(raw string) dlqRecord {
+	e := envelope.Decode(raw)
+	rec := dlqRecord{ID: e.ID, Payload: e.Payload, Reason: e.Metadata[worker.MetaDeadLetterReason], History: worker.History(e)}
+	if !e.EnqueuedAt.IsZero() {
+		rec.EnqueuedAt = &e.EnqueuedAt
+	}
+	if rec.History == nil {
+		rec.History = []worker.Attempt{}
+	}
+	if rec.Reason == "" && len(rec.History) > 0 {
+		rec.Reason = rec.History[len(rec.History)-1].Error
+	}
+	return rec
+}
+
+// listDLQ returns up to ?limit= dead-lettered messages, newest first, as
+// stored. With ?detail=true each is decoded instead, with why it was
+// dead-lettered and its attempt history.
+func listDLQ(q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		msgs, err := q.DeadLettered(ctx, limit)
+		if err != nil {
+			logger.Printf("dlq failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		if detail, _ := strconv.ParseBool(r.URL.Query().Get("detail")); detail {
+			recs := make([]dlqRecord, 0, len(msgs))
+			for _, raw := range msgs {
+				recs = append(recs, newDLQRecord(raw))
+			}
+			writeJSON(w, recs)
+			return
+		}
+		if msgs == nil {
+			msgs = []string{}
+		}
+		writeJSON(w, msgs)
+	}
+}
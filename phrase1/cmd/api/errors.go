@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/schema"
+	"learn_k8s/phrase1/internal/validate"
+)
+
+// problemTypePath prefixes the type URI of every problem. It's relative, so
+// it resolves against the api that answered, which serves a description
+// of each type there.
+const problemTypePath = "/problems/"
+
+// apiError is the body of every error response: an RFC 7807 problem
+// document, so clients can branch on Type (or Code, its last segment)
+// instead of matching message text.
+type apiError struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// Code and Message repeat Type's last segment and Detail for clients
+	// written before errors were problem documents.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID echoes the X-Request-Id header, for quoting in bug reports
+	// and finding the request in the api's logs.
+	RequestID string `json:"request_id,omitempty"`
+	// RetryAfter mirrors the Retry-After header in seconds. It is only set
+	// on errors worth retrying as is.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// Queue and Fields describe a payload that failed its queue's schema.
+	Queue  string              `json:"queue,omitempty"`
+	Fields []schema.FieldError `json:"fields,omitempty"`
+	// Violations are the enqueue rules a message broke.
+	Violations []validate.Violation `json:"violations,omitempty"`
+}
+
+// Error codes used beyond the per-status defaults.
+const (
+	codeMaintenance      = "maintenance"
+	codeQuotaExceeded    = "quota_exceeded"
+	codeRateLimited      = "rate_limited"
+	codeQueueFull        = "queue_full"
+	codeSchemaMismatch   = "schema_mismatch"
+	codeValidationFailed = "validation_failed"
+	codeNotReplicated    = "not_replicated"
+	codeRedisMemory      = "redis_memory"
+	codeOverloaded       = "overloaded"
+	codeShuttingDown     = "shutting_down"
+	codeAckBufferFull    = "ack_buffer_full"
+	codeNotSampled       = "not_sampled"
+)
+
+// statusCodes are the codes errors get when the caller names none. A 503
+// without a more specific code is a Redis call that failed.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:                  "bad_request",
+	http.StatusUnauthorized:                "unauthorized",
+	http.StatusForbidden:                   "forbidden",
+	http.StatusNotFound:                    "not_found",
+	http.StatusMethodNotAllowed:            "method_not_allowed",
+	http.StatusConflict:                    "conflict",
+	http.StatusRequestEntityTooLarge:       "too_large",
+	http.StatusUnsupportedMediaType:        "unsupported_media_type",
+	http.StatusUnprocessableEntity:         "invalid",
+	http.StatusTooManyRequests:             "too_many_requests",
+	http.StatusRequestHeaderFieldsTooLarge: "headers_too_large",
+	http.StatusInternalServerError:         "internal",
+	http.StatusServiceUnavailable:          "redis_unavailable",
+}
+
+// problemTitles summarize each problem type, for the title member and
+// GET /problems/{code}.
+var problemTitles = map[string]string{
+	"bad_request":            "The request is malformed",
+	"unauthorized":           "No valid credentials were presented",
+	"forbidden":              "The credentials don't allow this",
+	"not_found":              "No such resource",
+	"method_not_allowed":     "The route doesn't take this method",
+	"conflict":               "The request conflicts with the current state",
+	"too_large":              "The request body is too large",
+	"unsupported_media_type": "The content type isn't accepted here",
+	"invalid":                "The request is well-formed but can't be processed",
+	"too_many_requests":      "Too many requests",
+	"headers_too_large":      "The request headers are too large",
+	"internal":               "The api failed unexpectedly",
+	"redis_unavailable":      "Redis is unavailable",
+	codeMaintenance:          "The queue is down for maintenance",
+	codeQuotaExceeded:        "The daily quota is used up",
+	codeRateLimited:          "The tenant's rate limit is exceeded",
+	codeQueueFull:            "The queue is full",
+	codeSchemaMismatch:       "The payload doesn't match the queue's schema",
+	codeValidationFailed:     "The message breaks the queue's enqueue rules",
+	codeNotReplicated:        "Too few replicas acknowledged the write",
+	codeRedisMemory:          "Redis is near its memory limit",
+	codeOverloaded:           "Too many requests are in flight",
+	codeShuttingDown:         "The replica is shutting down",
+	codeAckBufferFull:        "The ack=none buffer is full",
+	codeNotSampled:           "The backlog hasn't been sampled yet",
+}
+
+// writeError answers with status and an apiError whose code follows from the
+// status. It replaces http.Error and takes its arguments in the same order.
+func writeError(w http.ResponseWriter, msg string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	writeAPIError(w, status, apiError{Code: code, Message: msg})
+}
+
+// writeCodedError is writeError with a specific code.
+func writeCodedError(w http.ResponseWriter, code, msg string, status int) {
+	writeAPIError(w, status, apiError{Code: code, Message: msg})
+}
+
+// writeAPIError fills in the problem members from e.Code and status, and
+// the request id and retry hint from the response headers already set, and
+// writes e. A 503 without a Retry-After gets one of a second, since they're
+// mostly Redis blips.
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	h := w.Header()
+	e.Type = problemTypePath + e.Code
+	e.Title = problemTitles[e.Code]
+	if e.Title == "" {
+		e.Title = http.StatusText(status)
+	}
+	e.Status = status
+	e.Detail = e.Message
+	e.RequestID = h.Get("X-Request-Id")
+	if status == http.StatusServiceUnavailable && h.Get("Retry-After") == "" {
+		h.Set("Retry-After", "1")
+	}
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		e.RetryAfter = secs
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// problemType describes the problem type {code} that error responses link
+// to.
+func problemType(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	title, ok := problemTitles[code]
+	if !ok {
+		writeError(w, "no such problem type", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]string{"type": problemTypePath + code, "title": title})
+}
+
+// withRequestID tags every request with an id in the X-Request-Id response
+// header: the caller's, if it sent a usable one, or a new one.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestID(id) {
+			id = envelope.NewID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, so an id can't
+// smuggle anything into logs or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// muxErrors gives the mux's own 404 and 405 responses apiError bodies too.
+func muxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &muxErrorWriter{ResponseWriter: w}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// muxErrorWriter swaps an error the mux writes for an apiError.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	replaced bool
+}
+
+func (w *muxErrorWriter) WriteHeader(code int) {
+	if code < 400 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.replaced = true
+	writeError(w.ResponseWriter, strings.ToLower(http.StatusText(code)), code)
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/maintenance"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/webhook"
+)
+
+// ingestHeaders are copied into the envelope metadata when present, so the
+// worker can tell deliveries apart without parsing provider payloads.
+var ingestHeaders = []string{"X-GitHub-Event", "X-GitHub-Delivery", "X-Request-Id", "User-Agent"}
+
+// parseSecrets reads "source=secret,source2=secret2".
+func parseSecrets(spec string) map[string][]byte {
+	out := map[string][]byte{}
+	for _, part := range strings.Split(spec, ",") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && name != "" && secret != "" {
+			out[name] = []byte(secret)
+		}
+	}
+	return out
+}
+
+// ingestWebhook accepts signed webhook deliveries for the sources configured
+// in the current secrets and enqueues the raw body tagged with its source.
+// Deliveries get 503 in maintenance mode so providers retry them later.
+func ingestWebhook(q queue.Queue, bus *events.Bus, secrets func() *map[string][]byte, maint *maintenance.Switch, hostname string, reporter errreport.Reporter, logger, msgLog *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, maint) {
+			return
+		}
+		source := r.PathValue("source")
+		secret, ok := (*secrets())[source]
+		if !ok {
+			writeError(w, "unknown source", http.StatusNotFound)
+			return
+		}
+
+		ctx := r.Context()
+
+		// A truncated body would fail its signature, so a delivery over the
+		// limit is refused as too large rather than as badly signed.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, "body exceeds 1 MiB", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			writeError(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		_ = r.Body.Close()
+
+		if err := webhook.Verify(secret, r.Header, body, time.Now()); err != nil {
+			logger.Printf("ingest %s rejected: %v", source, err)
+			status := http.StatusUnauthorized
+			if errors.Is(err, webhook.ErrMissingSignature) {
+				status = http.StatusBadRequest
+			}
+			writeError(w, err.Error(), status)
+			return
+		}
+
+		envlp := envelope.New(string(body))
+		envlp.Source = source
+		envlp.ContentType = r.Header.Get("Content-Type")
+		for _, h := range ingestHeaders {
+			if v := r.Header.Get(h); v != "" {
+				if envlp.Metadata == nil {
+					envlp.Metadata = map[string]string{}
+				}
+				envlp.Metadata[h] = v
+			}
+		}
+		envlp.Metadata = messageSpan(r).SetMetadata(envlp.Metadata)
+		envlp.BudgetMS = requestBudget(ctx).Milliseconds()
+
+		encoded, err := q.Encode(envlp)
+		if err != nil {
+			logger.Printf("encode envelope failed: %v", err)
+			writeError(w, "enqueue failed", http.StatusInternalServerError)
+			return
+		}
+		if err := q.Enqueue(ctx, encoded); err != nil {
+			logger.Printf("ingest %s enqueue failed: %v", source, err)
+			reporter.Report(errreport.Event{Err: err, Message: "ingest enqueue failed", MessageID: envlp.ID, TraceID: requestTraceID(r), Tags: map[string]string{"source": source}})
+			writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+			return
+		}
+
+		msgLog.Printf("ingested webhook from %s: %d bytes (id=%s)", source, len(body), envlp.ID)
+		bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: q.Name(), Message: envlp.Payload, Source: hostname, Subject: "webhook:" + source})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(enqueueResponse{Enqueued: true, Queue: q.Name(), ID: envlp.ID})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"learn_k8s/phrase1/internal/otlplog"
+	"learn_k8s/phrase1/internal/resource"
+)
+
+// newLogExporter returns an OTLP log exporter when OTEL_LOGS_EXPORTER=otlp,
+// configured from the standard OTEL_EXPORTER_OTLP_* variables, or nil.
+func newLogExporter(res resource.Resource, onError func(error)) *otlplog.Exporter {
+	if env("OTEL_LOGS_EXPORTER", "none") != "otlp" {
+		return nil
+	}
+	endpoint := env("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", "")
+	if endpoint == "" {
+		endpoint = strings.TrimRight(env("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/") + "/v1/logs"
+	}
+	headers := otlplog.ParseHeaders(env("OTEL_EXPORTER_OTLP_HEADERS", ""))
+	for k, v := range otlplog.ParseHeaders(env("OTEL_EXPORTER_OTLP_LOGS_HEADERS", "")) {
+		headers[k] = v
+	}
+	return otlplog.New(otlplog.Config{
+		Endpoint: endpoint,
+		Headers:  headers,
+		Resource: res,
+		Scope:    res.Get("service.name"),
+		OnError:  onError,
+	})
+}
+
+// logOutput is stdout, plus the exporter at severity when there is one.
+func logOutput(exp *otlplog.Exporter, severity otlplog.Severity, prefix string) io.Writer {
+	if exp == nil {
+		return os.Stdout
+	}
+	return io.MultiWriter(os.Stdout, exp.Writer(severity, prefix))
+}
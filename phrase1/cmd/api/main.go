@@ -3,117 +3,1280 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/errgroup"
 
+	"learn_k8s/phrase1/internal/apikey"
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/backlog"
+	"learn_k8s/phrase1/internal/buildinfo"
+	"learn_k8s/phrase1/internal/celery"
+	"learn_k8s/phrase1/internal/chaos"
+	"learn_k8s/phrase1/internal/cloudevents"
+	"learn_k8s/phrase1/internal/codec"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/health"
+	"learn_k8s/phrase1/internal/idempotency"
+	"learn_k8s/phrase1/internal/logging"
+	"learn_k8s/phrase1/internal/maintenance"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/otlplog"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/policy"
 	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/rbac"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/redisfailover"
+	"learn_k8s/phrase1/internal/redismetrics"
+	"learn_k8s/phrase1/internal/resource"
+	"learn_k8s/phrase1/internal/schedule"
+	"learn_k8s/phrase1/internal/schema"
+	"learn_k8s/phrase1/internal/secrets"
+	"learn_k8s/phrase1/internal/spool"
+	"learn_k8s/phrase1/internal/tenant"
+	"learn_k8s/phrase1/internal/usage"
+	"learn_k8s/phrase1/internal/validate"
 )
 
+// enqueueRequest is the JSON body form. Message is usually a string, but any
+// JSON value is accepted and queued as its JSON text, so structured payloads
+// can be checked against a queue's schema.
 type enqueueRequest struct {
-	Message string `json:"message"`
+	Message       json.RawMessage `json:"message"`
+	SchemaVersion int             `json:"schema_version,omitempty"`
 }
 
 type enqueueResponse struct {
 	Enqueued bool   `json:"enqueued"`
 	Queue    string `json:"queue"`
-	Message  string `json:"message"`
+	ID       string `json:"id"`
+	Message  string `json:"message,omitempty"`
+	// Spooled is set when Redis was unreachable and the message was kept on
+	// local disk, to be enqueued once it's back.
+	Spooled bool `json:"spooled,omitempty"`
+	// Duplicate is set when the Idempotency-Key was already used; ID is then
+	// the message enqueued the first time.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// DeliverAt is when a delayed message becomes available to workers.
+	DeliverAt string `json:"deliver_at,omitempty"`
+	// Buffered is set for ack=none: the message is in the api's buffer and
+	// hasn't reached Redis yet.
+	Buffered bool `json:"buffered,omitempty"`
+}
+
+// maxDelay caps X-Delay-Seconds, so a typo doesn't park a message in the
+// delayed set for years.
+const maxDelay = 7 * 24 * time.Hour
+
+// configSeen records the effective value of every variable read through the
+// env helpers, defaults included, for /statusz.
+var (
+	configMu   sync.Mutex
+	configSeen = map[string]string{}
+)
+
+func seen(key string, value any) {
+	configMu.Lock()
+	configSeen[key] = fmt.Sprint(value)
+	configMu.Unlock()
 }
 
 func env(key, fallback string) string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		v = fallback
+	}
+	seen(key, v)
+	return v
+}
+
+// invalidEnv collects the variables whose values didn't parse, so their
+// defaults were used instead; /startupz reports them.
+var invalidEnv []string
+
+func envInt(key string, fallback int) int {
+	n := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			n = parsed
+		}
+	}
+	seen(key, n)
+	return n
+}
+
+// envDuration parses a Go duration such as "750ms" or "2s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			d = parsed
+		}
+	}
+	seen(key, d)
+	return d
+}
+
+func envFloat(key string, fallback float64) float64 {
+	f := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			f = parsed
+		}
+	}
+	seen(key, f)
+	return f
+}
+
+func envBool(key string, fallback bool) bool {
+	b := fallback
 	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
-		return v
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			b = parsed
+		}
+	}
+	seen(key, b)
+	return b
+}
+
+func envList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	seen(key, strings.Join(out, ","))
+	return out
+}
+
+// loadSecrets reads the named secrets from the environment, <NAME>_FILE
+// files, and the Vault secret at VAULT_SECRET_PATH if VAULT_ADDR is set.
+func loadSecrets(ctx context.Context, names ...string) (*secrets.Store, error) {
+	static := map[string]string{}
+	files := map[string]string{}
+	for _, name := range names {
+		static[name] = env(name, "")
+		if path := env(name+"_FILE", ""); path != "" {
+			files[name] = path
+		}
+	}
+	var vault *secrets.Vault
+	if addr := env("VAULT_ADDR", ""); addr != "" {
+		vault = &secrets.Vault{
+			Addr:      addr,
+			Path:      env("VAULT_SECRET_PATH", ""),
+			Token:     env("VAULT_TOKEN", ""),
+			Role:      env("VAULT_ROLE", ""),
+			AuthMount: env("VAULT_AUTH_MOUNT", "kubernetes"),
+		}
+	}
+	store := secrets.New(static, files, vault)
+	_, err := store.Refresh(ctx)
+	return store, err
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type statsResponse struct {
+	queue.Stats
+	Paused  bool              `json:"paused"`
+	Workers []queue.Heartbeat `json:"workers"`
+	// Backlog is how fast the queue's backlog is moving; only GET /stats
+	// has it, once the api has sampled the queue twice.
+	Backlog *backlog.Rates `json:"backlog,omitempty"`
+}
+
+func queueStats(ctx context.Context, q *queue.RedisQueue) (statsResponse, error) {
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		return statsResponse{}, err
+	}
+	workers, err := q.Workers(ctx)
+	if err != nil {
+		return statsResponse{}, err
+	}
+	if workers == nil {
+		workers = []queue.Heartbeat{}
+	}
+	paused, err := q.Paused(ctx)
+	if err != nil {
+		return statsResponse{}, err
+	}
+	return statsResponse{Stats: stats, Paused: paused, Workers: workers}, nil
+}
+
+// negotiateEnvelope keeps v at the newest envelope version every live worker
+// of q reads, so workers and the api can be upgraded in either order.
+func negotiateEnvelope(ctx context.Context, q *queue.RedisQueue, v *envelope.WriteVersion, logger *log.Logger) {
+	const interval = 5 * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	warned := false
+	for {
+		workers, err := q.Workers(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Printf("envelope negotiation error: %v", err)
+			}
+		} else {
+			versions := make([]int, len(workers))
+			for i, w := range workers {
+				versions[i] = w.EnvelopeVersion
+			}
+			next, ok := envelope.Negotiate(versions)
+			if prev := v.Load(); next != prev {
+				logger.Printf("writing envelope v%d (was v%d)", next, prev)
+				v.Store(next)
+			}
+			if !ok && !warned {
+				logger.Printf("some workers read no envelope version this api writes (v%d to v%d)", envelope.MinVersion, envelope.Version)
+			}
+			warned = !ok
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	return fallback
+}
+
+// apiKeyTypes adds the api's own shared keys to the queue's.
+func apiKeyTypes(q *queue.RedisQueue) map[string]string {
+	keys := q.KeyTypes()
+	keys[q.Name()+":maintenance"] = "string"
+	keys["schemas"] = "hash"
+	return keys
 }
 
 func main() {
+	started := time.Now()
 	addr := env("HTTP_ADDR", ":8080")
 	redisAddr := env("REDIS_ADDR", "redis:6379") // overridden in docker-compose
 	queueName := env("QUEUE_NAME", "messages")
+	adminAddr := env("ADMIN_ADDR", ":8081")
+	tuning := loadHTTPTuning()
+	cors := loadCORS()
+	hsts := time.Duration(envInt("HSTS_MAX_AGE_SECONDS", 0)) * time.Second
 
-	logger := log.New(os.Stdout, "api ", log.LstdFlags|log.Lmicroseconds)
+	// Startup, shutdown, and failures use logger; per-message lines go to
+	// msgLog along with the access log, and extra detail to debugLog, so
+	// LOG_LEVEL can quiet them.
+	pod := podinfo.Identity{Pod: env("POD_NAME", ""), Namespace: env("POD_NAMESPACE", ""), Node: env("NODE_NAME", "")}
+	hostname, _ := os.Hostname()
+	res := resource.New(env("OTEL_SERVICE_NAME", "api"), buildinfo.Get().Version, hostname, pod, env("OTEL_RESOURCE_ATTRIBUTES", ""))
+	var logger *log.Logger
+	logExport := newLogExporter(res, func(err error) {
+		logger.Printf("log export error: %v", err)
+	})
+	prefix := pod.LogPrefix("api ")
+	levels := logging.NewSwitch(logging.Info)
+	logger = levels.Logger(logging.Warn, logOutput(logExport, otlplog.Warn, prefix), prefix, log.LstdFlags|log.Lmicroseconds)
+	msgLog := levels.Logger(logging.Info, logOutput(logExport, otlplog.Info, prefix), prefix, log.LstdFlags|log.Lmicroseconds)
+	debugLog := levels.Logger(logging.Debug, logOutput(logExport, otlplog.Debug, prefix), prefix, log.LstdFlags|log.Lmicroseconds)
+	if lvl, err := logging.ParseLevel(env("LOG_LEVEL", "info")); err != nil {
+		logger.Fatalf("%v", err)
+	} else {
+		levels.Set(lvl)
+	}
+	timeouts, err := loadRouteTimeouts()
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	// Message text in msgLog lines is redacted first.
+	redactor, err := redact.NewMessages(envList("REDACT_PII"), env("REDACT_PATTERN", ""), envList("REDACT_FIELDS"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	// creds keeps credentials current as they rotate; see loadSecrets.
+	creds, err := loadSecrets(context.Background(), "REDIS_USERNAME", "REDIS_PASSWORD", "JWT_SECRET", "INGEST_SECRETS")
+	if err != nil {
+		logger.Fatalf("load secrets: %v", err)
+	}
 
-	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	redisAddrs, err := redisfailover.ParseAddrs(redisAddr)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:                       redisAddrs[0],
+		CredentialsProviderContext: creds.RedisCredentials,
+	})
+	// With REDIS_WAIT_REPLICAS set, enqueues return only once that many
+	// replicas have the message; see queue.Replication.
+	redisWait := queue.Replication{Replicas: envInt("REDIS_WAIT_REPLICAS", 0), Timeout: envDuration("REDIS_WAIT_TIMEOUT", time.Second)}
 	q := queue.NewRedisQueue(rdb, queueName)
+	q.SetReplication(redisWait)
+	encoding, err := envelope.ParseEncoding(env("ENVELOPE_ENCODING", "json"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	q.SetEncoding(encoding)
+	// QUEUE_FORMAT=celery writes Celery task messages for Python workers
+	// instead of envelopes.
+	var format queue.Format
+	switch f := env("QUEUE_FORMAT", "envelope"); f {
+	case "envelope":
+	case "celery":
+		format = celery.Format{Task: env("CELERY_TASK", "tasks.process"), Origin: fmt.Sprintf("gen%d@%s", os.Getpid(), hostname)}
+		q.SetFormat(format)
+	default:
+		logger.Fatalf("unknown QUEUE_FORMAT %q (want envelope or celery)", f)
+	}
+	// writeVersion follows the envelope version the workers read; see
+	// negotiateEnvelope.
+	writeVersion := new(envelope.WriteVersion)
+	q.SetWriteVersion(writeVersion)
+
+	var faults *chaos.Injector
+	if envBool("CHAOS_ENABLED", false) {
+		faults = chaos.New(chaos.Config{
+			EnqueueFailureRate: envFloat("CHAOS_ENQUEUE_FAILURE_RATE", 0),
+			LatencyRate:        envFloat("CHAOS_LATENCY_RATE", 0),
+			MaxLatency:         time.Duration(envInt("CHAOS_LATENCY_MS", 0)) * time.Millisecond,
+		})
+		logger.Printf("chaos enabled: %s", faults)
+	}
+
+	tracker, err := errreport.New(env("ERROR_REPORTER_DSN", ""), errreport.Options{
+		Environment: env("ERROR_REPORTER_ENVIRONMENT", ""),
+		Release:     buildinfo.Get().Version,
+		ServerName:  hostname,
+		Tags:        map[string]string{"service": "api", "queue": queueName},
+		OnError: func(err error) {
+			logger.Printf("error report failed: %v", err)
+		},
+	})
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	// recentErrors feeds /statusz whether or not a tracker is configured.
+	recentErrors := errreport.NewRing(50)
+	reporter := errreport.Tee(tracker, recentErrors)
+	defer reporter.Flush(5 * time.Second)
+
+	// Long-lived streams use this as their parent context so Shutdown can end
+	// them instead of waiting for clients to hang up.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	reg := metrics.NewRegistry()
+	reg.LimitQueues(envList("METRICS_QUEUE_LABELS"), envInt("METRICS_QUEUE_MAX", metrics.DefaultMaxQueues))
+	buildinfo.Register(reg)
+	podinfo.Register(reg, pod)
+	resource.Register(reg, res)
+	redismetrics.Instrument(rdb, reg)
+	var redisSwitch *redisfailover.Switch
+	// With more than one address in REDIS_ADDR, the first is used while
+	// it's healthy and the next healthy one while it isn't; see
+	// redisfailover.
+	if len(redisAddrs) > 1 {
+		redisSwitch = redisfailover.New(redisAddrs, rdb.Options(), envDuration("REDIS_FAILOVER_AFTER", 10*time.Second), reg, logger)
+		rdb.AddHook(redisSwitch)
+	}
+	connStats := newConnMetrics(reg, logger)
+	memGuard := newMemoryGuard(rdb, reg, logger)
+
+	// With ENQUEUE_BATCH_MAX set, concurrent enqueues to any queue share
+	// pipelined round trips; see queue.Batcher.
+	var batcher *queue.Batcher
+	if n := envInt("ENQUEUE_BATCH_MAX", 0); n > 1 {
+		batchSize := reg.NewHistogram("queue_enqueue_batch_size", "Messages written per pipelined enqueue round trip.", []float64{1, 2, 5, 10, 20, 50, 100, 200, 500})
+		batcher = queue.NewBatcher(rdb, queue.BatchOptions{
+			MaxSize: n,
+			Window:  time.Duration(envInt("ENQUEUE_BATCH_WINDOW_MS", 0)) * time.Millisecond,
+			OnBatch: func(n int) { batchSize.Observe(float64(n)) },
+		})
+		q.SetBatcher(batcher)
+		logger.Printf("enqueue batching enabled: up to %d per round trip", n)
+	}
+
+	// bus carries events raised by this replica; feed carries events from
+	// every api and worker, relayed back from Redis, for the streaming
+	// endpoints.
+	bus := events.NewBus()
+	feed := events.NewBus()
+	eventsTransport := events.NewRedisTransport(rdb, q.EventsChannel(), func(err error) {
+		logger.Printf("event transport error: %v", err)
+	})
+	detachTransport := bus.Attach(eventsTransport, 256)
+	detachMetrics := bus.Attach(events.NewMetrics(reg), 256)
+
+	auditLog := audit.NewRedisLog(rdb, env("AUDIT_STREAM", "audit"), int64(envInt("AUDIT_MAX_LEN", 10000)), func(err error) {
+		logger.Printf("audit write error: %v", err)
+	})
+	// Every enqueue is audited, so the log is attached blocking: a burst
+	// outrunning its writes holds up enqueues instead of going unrecorded.
+	detachAudit := bus.AttachBlocking(auditLog, 1024)
+	backlogRates := newBacklogSampler(q, reg, bus, hostname, logger)
+
+	schemas := schema.NewRegistry(rdb, "schemas")
+	if path := env("SCHEMA_FILE", ""); path != "" {
+		if err := schemas.LoadFile(path); err != nil {
+			logger.Fatalf("load schemas: %v", err)
+		}
+	}
+	go schemas.Run(baseCtx, 5*time.Second, func(err error) {
+		logger.Printf("schema refresh error: %v", err)
+	})
+
+	// Enqueue rules run after schema validation, on the payload's text.
+	rules := &enqueueRules{rejects: reg.NewCounter("api_validation_rejects_total", "Enqueues rejected by a VALIDATION_FILE rule, counted once per rule broken.", "queue", "rule")}
+	if path := env("VALIDATION_FILE", ""); path != "" {
+		if rules.set, err = validate.LoadFile(path); err != nil {
+			logger.Fatalf("load validation rules: %v", err)
+		}
+		logger.Printf("loaded %d validation rules from %s", rules.set.Len(), path)
+	}
+
+	// Policies apply per queue name, tenant queues included. The queue
+	// objects pick up their DLQ and priorities from them; the handlers read
+	// max_depth per request.
+	var policies *policy.Store
+	if path := env("POLICY_FILE", ""); path != "" {
+		if policies, err = policy.Load(path); err != nil {
+			logger.Fatalf("load policies: %v", err)
+		}
+		go policies.Run(baseCtx, time.Duration(envInt("POLICY_RELOAD_SECONDS", 10))*time.Second, func() {
+			q.ApplyPolicy(policies.For(queueName))
+			logger.Printf("reloaded policies from %s", path)
+		}, func(err error) {
+			logger.Printf("policy reload error: %v", err)
+		})
+	}
+	q.ApplyPolicy(policies.For(queueName))
+
+	var tenants *tenancy
+	if path := env("TENANTS_FILE", ""); path != "" {
+		dir, err := tenant.LoadFile(path)
+		if err != nil {
+			logger.Fatalf("load tenants: %v", err)
+		}
+		tenants = &tenancy{
+			dir:     dir,
+			limiter: tenant.NewRateLimiter(rdb),
+			queueFor: func(id string) *queue.RedisQueue {
+				tq := queue.NewRedisQueue(rdb, tenant.QueueName(id, queueName))
+				tq.SetEncoding(encoding)
+				tq.SetReplication(redisWait)
+				tq.SetWriteVersion(writeVersion)
+				if format != nil {
+					tq.SetFormat(format)
+				}
+				if batcher != nil {
+					tq.SetBatcher(batcher)
+				}
+				tq.ApplyPolicy(policies.For(tq.Name()))
+				return tq
+			},
+		}
+		logger.Printf("multi-tenancy enabled: %d tenants", len(dir.Tenants()))
+	}
+
+	apiKeys := apikey.NewStore(rdb, "apikeys")
+	authz, err := newAuthorizer(env("RBAC_FILE", ""), creds.Get("JWT_SECRET"), tenants, apiKeys)
+	if err != nil {
+		logger.Fatalf("load rbac: %v", err)
+	}
+	if authz != nil {
+		logger.Printf("rbac enabled")
+		// Revocations made through other replicas apply here within this
+		// interval.
+		go apiKeys.Run(baseCtx, 5*time.Second, func(err error) {
+			logger.Printf("api key refresh error: %v", err)
+		})
+	}
+
+	// INGEST_SECRETS is swapped whole when it rotates, and a new JWT_SECRET
+	// goes to authz, which keeps accepting the old one until the next
+	// rotation. Redis credentials need nothing here: new connections ask
+	// creds for them.
+	var ingestSecrets atomic.Pointer[map[string][]byte]
+	sources := parseSecrets(creds.Get("INGEST_SECRETS"))
+	ingestSecrets.Store(&sources)
+	rotations := reg.NewCounter("secret_rotations_total", "Secrets that changed when they were read again.", "name")
+	go creds.Run(baseCtx, time.Duration(envInt("SECRETS_REFRESH_SECONDS", 30))*time.Second, func(names []string) {
+		for _, name := range names {
+			logger.Printf("secret %s rotated", name)
+			rotations.Inc(name)
+			switch name {
+			case "JWT_SECRET":
+				if authz != nil {
+					authz.SetJWTSecret([]byte(creds.Get(name)))
+				}
+			case "INGEST_SECRETS":
+				sources := parseSecrets(creds.Get(name))
+				ingestSecrets.Store(&sources)
+			}
+		}
+	}, func(err error) {
+		logger.Printf("secret refresh error: %v", err)
+	})
+
+	var spooled *spool.Spool
+	if dir := env("SPOOL_DIR", ""); dir != "" {
+		spooled, err = spool.Open(dir, int64(envInt("SPOOL_MAX_BYTES", 64<<20)))
+		if err != nil {
+			logger.Fatalf("open spool: %v", err)
+		}
+		defer spooled.Close()
+		if n := spooled.Len(); n > 0 {
+			logger.Printf("spool has %d messages from a previous run", n)
+		}
+		reg.NewGaugeFunc("queue_spool_messages", "Messages waiting in the local spool for Redis to come back.", func() float64 { return float64(spooled.Len()) })
+		reg.NewGaugeFunc("queue_spool_bytes", "Size of the local spool file.", func() float64 { return float64(spooled.Bytes()) })
+		// Replays are retried every second while Redis is down; only log
+		// when the reason changes.
+		lastErr := ""
+		go spooled.Run(baseCtx, time.Second, func(ctx context.Context, rec spool.Record) error {
+			sendCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+			sq := queue.NewRedisQueue(rdb, rec.Queue)
+			sq.SetReplication(redisWait)
+			return sq.Enqueue(sendCtx, rec.Payload)
+		}, func(n int) {
+			lastErr = ""
+			logger.Printf("replayed %d spooled messages", n)
+		}, func(err error) {
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				logger.Printf("spool replay stopped: %v", err)
+			}
+		})
+	}
+
+	// ?ack=none enqueues wait in acks for a writer; ?ack=persisted ones
+	// wait for Redis to replicate them.
+	acks := newAckBuffer(envInt("ACK_BUFFER_SIZE", 1000), envInt("ACK_BUFFER_WRITERS", 4), 5*time.Second, logger)
+	persisted := queue.Replication{
+		Replicas: envInt("ACK_PERSISTED_REPLICAS", max(1, redisWait.Replicas)),
+		Timeout:  time.Duration(envInt("ACK_PERSISTED_TIMEOUT_MS", 1000)) * time.Millisecond,
+	}
+	reg.NewGaugeFunc("queue_ack_buffer_messages", "ack=none enqueues waiting in the api's buffer.", func() float64 { return float64(acks.len()) })
+	ackLost := reg.NewCounter("queue_ack_buffer_lost_total", "ack=none enqueues that failed after being answered, by queue.", "queue")
+	ackNotReplicated := reg.NewCounter("queue_ack_not_replicated_total", "ack=persisted enqueues that too few replicas acknowledged in time, by queue.", "queue")
+
+	usageTracker := usage.NewTracker(rdb, queueName+":usage")
+	idempotent := idempotency.NewStore(rdb, queueName+":idempotency")
+	quotas := usage.Quotas{Default: usage.Quota{
+		Messages: int64(envInt("QUOTA_DAILY_MESSAGES", 0)),
+		Bytes:    int64(envInt("QUOTA_DAILY_BYTES", 0)),
+	}}
+	if path := env("QUOTAS_FILE", ""); path != "" {
+		overrides, err := usage.LoadOverrides(path)
+		if err != nil {
+			logger.Fatalf("load quotas: %v", err)
+		}
+		quotas.Overrides = overrides
+	}
+
+	// Shutdown order: on SIGINT/SIGTERM /readyz starts failing, and
+	// PRESTOP_DELAY later (sooner on a second signal, or as soon as any
+	// listener fails) the listeners stop accepting and drain; the
+	// background loops they rely on (maintenance refresh, event relay,
+	// enqueue batching and buffering) stop only after that, and then the
+	// event subscribers detach and Redis is closed.
+	signaled, stopSignals := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer stopSignals()
+	var draining atomic.Bool
+	ctx := drainAfter(signaled, envDuration("PRESTOP_DELAY", 0), &draining, logger)
+	listeners, listenCtx := errgroup.WithContext(ctx)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	var background errgroup.Group
+
+	maint := maintenance.NewSwitch(rdb, q.Name()+":maintenance")
+	background.Go(func() error {
+		maint.Run(backgroundCtx, 2*time.Second, func(err error) {
+			logger.Printf("maintenance refresh error: %v", err)
+		})
+		return nil
+	})
+	background.Go(func() error {
+		eventsTransport.Relay(backgroundCtx, feed)
+		return nil
+	})
+	background.Go(func() error {
+		negotiateEnvelope(backgroundCtx, q, writeVersion, logger)
+		return nil
+	})
+	background.Go(func() error {
+		backlogRates.run(backgroundCtx)
+		return nil
+	})
+	background.Go(func() error {
+		acks.Run(backgroundCtx)
+		return nil
+	})
+	if redisSwitch != nil {
+		background.Go(func() error {
+			redisSwitch.Run(backgroundCtx, envDuration("REDIS_HEALTH_INTERVAL", time.Second))
+			return nil
+		})
+	}
+	if memGuard != nil {
+		background.Go(func() error {
+			memGuard.run(backgroundCtx)
+			return nil
+		})
+	}
+	if batcher != nil {
+		background.Go(func() error {
+			batcher.Run(backgroundCtx)
+			return nil
+		})
+	}
+
+	if udpAddr := env("UDP_ADDR", ""); udpAddr != "" {
+		parseSyslog := env("UDP_FORMAT", "syslog") == "syslog"
+		listeners.Go(func() error {
+			if err := listenUDP(listenCtx, udpAddr, parseSyslog, q, bus, hostname, reporter, logger); err != nil {
+				return fmt.Errorf("udp listener: %w", err)
+			}
+			return nil
+		})
+	}
 
+	// Compression is chosen per route below: request bodies on the enqueue
+	// routes, responses on the ones that can return many messages.
+	gz := newCompression(envBool("HTTP_GZIP", true))
+
+	// v1 holds the versioned API routes, served under /v1 (see
+	// mountVersioned); mux has them and the unversioned operational ones.
 	mux := http.NewServeMux()
+	v1 := http.NewServeMux()
 
-	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-		defer cancel()
+	healthz := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 
 		if err := rdb.Ping(ctx).Err(); err != nil {
-			http.Error(w, fmt.Sprintf("redis ping failed: %v", err), http.StatusServiceUnavailable)
+			writeError(w, fmt.Sprintf("redis ping failed: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		if st := maint.State(); st.Enabled && st.FailHealth {
+			writeCodedError(w, codeMaintenance, "maintenance", http.StatusServiceUnavailable)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /problems/{code}", problemType)
+	// /readyz is /healthz until a shutdown starts, and then fails for the
+	// PRESTOP_DELAY the replica keeps serving, so load balancers stop
+	// sending it requests before it stops taking them.
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			writeCodedError(w, codeShuttingDown, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		healthz(w, r)
 	})
 
-	mux.HandleFunc("POST /enqueue", func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
+	startupChecks := health.NewRegistry(5 * time.Second)
+	startupChecks.Register(
+		health.RedisPing(rdb),
+		health.RedisKeys(rdb, apiKeyTypes(q), q.Name()+":startupz:"+hostname),
+		health.Config(func() []string { return invalidEnv }),
+	)
+	mux.HandleFunc("GET /startupz", startupChecks.TextHandler())
+
+	healthChecks := health.NewRegistry(2 * time.Second)
+	healthChecks.Register(
+		health.RedisPing(rdb),
+		health.QueueLag(q.OldestAge, time.Duration(envInt("QUEUE_LAG_MAX_SECONDS", 0))*time.Second),
+		health.Check{Name: "maintenance", Optional: true, Run: func(context.Context) error {
+			if maint.State().Enabled {
+				return errors.New("maintenance mode is on")
+			}
+			return nil
+		}},
+	)
+	if spooled != nil {
+		healthChecks.Register(health.Check{Name: "spool", Optional: true, Run: func(context.Context) error {
+			if n := spooled.Len(); n > 0 {
+				return fmt.Errorf("%d messages spooled on disk", n)
+			}
+			return nil
+		}})
+	}
+	mux.HandleFunc("GET /health", healthChecks.Handler())
+
+	// The sampler measures the base queue, so tenant queues go without
+	// backlog headers.
+	var depthHeaders *backlogSampler
+	if tenants == nil {
+		depthHeaders = backlogRates
+	}
+	v1.HandleFunc("POST /enqueue", require(authz, rbac.Producer, memGuard.wrap(backlogHeaders(depthHeaders, gz.wrap(gzipRequests|gzipResponses, func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, maint) {
+			return
+		}
+
+		ctx := r.Context()
+
+		// From here on q is the queue this request writes to: the caller's
+		// tenant queue in multi-tenant mode, the base queue otherwise.
+		q := tenants.resolve(ctx, w, r, q, logger)
+		if q == nil {
+			return
+		}
+		queueName := q.Name()
+		if queueFull(ctx, w, q, policies.For(queueName), logger) {
+			return
+		}
 
 		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 		if err != nil {
-			http.Error(w, "failed to read body", http.StatusBadRequest)
+			writeError(w, "failed to read body", http.StatusBadRequest)
 			return
 		}
 		_ = r.Body.Close()
 
-		msg := strings.TrimSpace(string(body))
-		if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
-			var req enqueueRequest
-			if err := json.Unmarshal(body, &req); err == nil {
-				msg = strings.TrimSpace(req.Message)
+		schemaVersion := 0
+		if v := r.Header.Get("X-Schema-Version"); v != "" {
+			if schemaVersion, err = strconv.Atoi(v); err != nil || schemaVersion < 1 {
+				writeError(w, "X-Schema-Version must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		var delay time.Duration
+		if v := r.Header.Get("X-Delay-Seconds"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || time.Duration(n)*time.Second > maxDelay {
+				writeError(w, fmt.Sprintf("X-Delay-Seconds must be an integer from 0 to %d", int(maxDelay.Seconds())), http.StatusBadRequest)
+				return
+			}
+			delay = time.Duration(n) * time.Second
+		}
+		priority := r.Header.Get("X-Priority")
+		if priority != "" {
+			if p := policies.For(queueName); !p.HasPriority(priority) {
+				writeError(w, fmt.Sprintf("X-Priority must be one of the queue's priorities %v", p.Priorities), http.StatusBadRequest)
+				return
+			}
+			if delay > 0 {
+				writeError(w, "X-Priority and X-Delay-Seconds can't be combined", http.StatusBadRequest)
+				return
+			}
+		}
+		ack, err := parseAck(r.URL.Query().Get("ack"))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ceMode := cloudevents.RequestMode(r)
+		var envlp envelope.Envelope
+		contentType := r.Header.Get("Content-Type")
+		switch {
+		case ceMode != cloudevents.ModeNone:
+			ce, err := cloudevents.FromRequest(r, body)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			envlp = envelope.New(string(ce.Data))
+			envlp.ContentType = ce.DataContentType
+			envlp.CloudEvent = &ce.Attributes
+		case codec.Binary(contentType) != "":
+			if len(body) == 0 {
+				writeError(w, "message is required", http.StatusBadRequest)
+				return
+			}
+			envlp = envelope.New(string(body))
+			envlp.ContentType = codec.Binary(contentType)
+		case !isTextContentType(contentType):
+			w.Header().Set("Accept-Post", strings.Join(acceptedContentTypes, ", "))
+			writeError(w, "unsupported content type "+contentType, http.StatusUnsupportedMediaType)
+			return
+		default:
+			msg := strings.TrimSpace(string(body))
+			if strings.Contains(strings.ToLower(contentType), "application/json") {
+				var req enqueueRequest
+				if err := json.Unmarshal(body, &req); err == nil {
+					var text string
+					if err := json.Unmarshal(req.Message, &text); err == nil {
+						msg = strings.TrimSpace(text)
+					} else {
+						msg = string(req.Message)
+					}
+					if req.SchemaVersion != 0 {
+						schemaVersion = req.SchemaVersion
+					}
+				}
+			}
+
+			if msg == "" {
+				writeError(w, "message is required", http.StatusBadRequest)
+				return
+			}
+			envlp = envelope.New(msg)
+		}
+
+		if schemaVersion < 0 {
+			writeError(w, "schema_version must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		envlp.SchemaVersion = schemaVersion
+		envlp.Metadata = messageSpan(r).SetMetadata(envlp.Metadata)
+		envlp.BudgetMS = requestBudget(ctx).Milliseconds()
+
+		// Binary payloads are validated here and logged, published, and
+		// echoed in their text rendering.
+		msg := envlp.Payload
+		if codec.Binary(envlp.ContentType) != "" {
+			rendered, err := codec.Render(envlp.ContentType, []byte(envlp.Payload))
+			if err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			msg = rendered
+		}
+		if err := schemas.Validate(queueName, []byte(msg)); err != nil {
+			if !writeSchemaError(w, queueName, err) {
+				writeError(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if ve := rules.check(validate.Input{Queue: queueName, ContentType: envlp.ContentType, Text: msg, Size: len(envlp.Payload)}); ve != nil {
+			writeValidationError(w, queueName, ve)
+			return
+		}
+
+		// A retry carrying the Idempotency-Key of an enqueue that already
+		// happened gets that message's id back instead of queueing it again.
+		// The key is released if this request ends up not enqueueing, or if
+		// an ack=none write fails later.
+		subject := requestSubject(r)
+		enqueued := false
+		release := func(context.Context) {}
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			if !validRequestID(key) {
+				writeError(w, "Idempotency-Key must be 1 to 128 printable characters", http.StatusBadRequest)
+				return
+			}
+			id, fresh, err := idempotent.Claim(ctx, subject, key, envlp.ID)
+			switch {
+			case err != nil && spooled == nil:
+				logger.Printf("idempotency check failed: %v", err)
+				writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				// Redis is likely down; the spool takes the message unchecked.
+			case !fresh:
+				debugLog.Printf("duplicate enqueue: key=%q id=%s queue=%s subject=%s", key, id, queueName, subject)
+				writeJSON(w, enqueueResponse{Enqueued: true, Duplicate: true, Queue: queueName, ID: id})
+				return
+			default:
+				release = func(ctx context.Context) {
+					if err := idempotent.Release(ctx, subject, key, envlp.ID); err != nil {
+						logger.Printf("idempotency release failed: %v", err)
+					}
+				}
+				defer func() {
+					if !enqueued {
+						release(context.WithoutCancel(ctx))
+					}
+				}()
 			}
 		}
 
-		if msg == "" {
-			http.Error(w, "message is required", http.StatusBadRequest)
+		encoded, err := q.Encode(envlp)
+		if err != nil {
+			logger.Printf("encode envelope failed: %v", err)
+			reporter.Report(errreport.Event{Err: err, Message: "encode envelope failed", MessageID: envlp.ID, TraceID: requestTraceID(r)})
+			writeError(w, "enqueue failed", http.StatusInternalServerError)
 			return
 		}
 
-		if err := q.Enqueue(ctx, msg); err != nil {
+		faults.Delay(ctx)
+		if err := faults.EnqueueError(); err != nil {
 			logger.Printf("enqueue failed: %v", err)
-			http.Error(w, "enqueue failed", http.StatusServiceUnavailable)
+			writeError(w, "enqueue failed", http.StatusServiceUnavailable)
 			return
 		}
 
-		logger.Printf("enqueued message: %q", msg)
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(enqueueResponse{Enqueued: true, Queue: queueName, Message: msg})
-	})
+		size := int64(len(envlp.Payload))
+		accounted := true
+		if _, err := usageTracker.Consume(ctx, subject, size, quotas.For(subject)); err != nil {
+			if errors.Is(err, usage.ErrQuotaExceeded) {
+				w.Header().Set("Retry-After", strconv.Itoa(secondsUntilTomorrow(time.Now())))
+				writeCodedError(w, codeQuotaExceeded, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			logger.Printf("usage accounting failed: %v", err)
+			if spooled == nil {
+				reporter.Report(errreport.Event{Err: err, Message: "usage accounting failed", MessageID: envlp.ID, TraceID: requestTraceID(r), Tags: map[string]string{"queue": queueName}})
+				writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+				return
+			}
+			// Redis is likely down; let the spool take the message unmetered.
+			accounted = false
+		}
 
-	srv := &http.Server{
-		Addr:              addr,
-		Handler:           mux,
-		ReadHeaderTimeout: 5 * time.Second,
+		// A delayed message waits in the queue's delayed set until the
+		// worker's mover promotes it; a prioritized one goes to its level's
+		// list.
+		var deliverAt time.Time
+		enqueue := q.Enqueue
+		switch {
+		case delay > 0:
+			deliverAt = time.Now().Add(delay)
+			enqueue = func(ctx context.Context, payload string) error {
+				return q.EnqueueAt(ctx, payload, deliverAt)
+			}
+		case priority != "":
+			enqueue = func(ctx context.Context, payload string) error {
+				return q.EnqueuePriority(ctx, payload, priority)
+			}
+		}
+		// write enqueues the message, or keeps it on the spool if Redis
+		// fails; onSpool reports the latter.
+		write := func(ctx context.Context) (onSpool bool, err error) {
+			err = enqueue(ctx, encoded)
+			if err == nil || errors.Is(err, queue.ErrNotReplicated) {
+				return false, err
+			}
+			logger.Printf("enqueue failed: %v", err)
+			reporter.Report(errreport.Event{Err: err, Message: "enqueue failed", MessageID: envlp.ID, TraceID: requestTraceID(r), Tags: map[string]string{"queue": queueName}})
+			if accounted {
+				if err := usageTracker.Refund(ctx, subject, size); err != nil {
+					logger.Printf("usage refund failed: %v", err)
+				}
+			}
+			// The spool replays onto the queue itself, which would deliver
+			// a delayed message early and a prioritized one late, so those
+			// fail instead.
+			if spooled != nil && delay == 0 && priority == "" {
+				if err := spooled.Append(spool.Record{Queue: queueName, Payload: encoded}); err != nil {
+					logger.Printf("spool append failed: %v", err)
+					reporter.Report(errreport.Event{Err: err, Message: "spool append failed", MessageID: envlp.ID, TraceID: requestTraceID(r), Tags: map[string]string{"queue": queueName}})
+				} else {
+					msgLog.Printf("spooled message: %q", redactor.Text(msg))
+					return true, nil
+				}
+			}
+			return false, err
+		}
+		published := func() {
+			debugLog.Printf("enqueued message: %q", redactor.Text(msg))
+			debugLog.Printf("enqueue detail: id=%s queue=%s subject=%s content_type=%q bytes=%d ack=%s", envlp.ID, queueName, subject, envlp.ContentType, size, ack)
+			bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: queueName, Message: msg, Source: hostname, Subject: subject})
+		}
+
+		// ack=none answers now and leaves the write to the buffer; a write
+		// that fails there with no spool to fall back on loses the message.
+		if ack == ackNone {
+			buffered := acks.offer(func(ctx context.Context) {
+				onSpool, err := write(ctx)
+				if err != nil {
+					ackLost.Inc(queueName)
+					release(ctx)
+					return
+				}
+				if !onSpool {
+					published()
+				}
+			})
+			if !buffered {
+				if accounted {
+					if err := usageTracker.Refund(ctx, subject, size); err != nil {
+						logger.Printf("usage refund failed: %v", err)
+					}
+				}
+				writeCodedError(w, codeAckBufferFull, "enqueue buffer is full, retry or use ack=queued", http.StatusServiceUnavailable)
+				return
+			}
+			enqueued = true
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(enqueueResponse{Enqueued: false, Buffered: true, Queue: queueName, ID: envlp.ID, Message: msg})
+			return
+		}
+
+		if ack == ackPersisted {
+			ctx = queue.WithReplication(ctx, persisted)
+		}
+		onSpool, err := write(ctx)
+		switch {
+		case errors.Is(err, queue.ErrNotReplicated):
+			// The message is on the queue, so a retry would enqueue it
+			// twice; keep the idempotency key and say what happened.
+			enqueued = true
+			logger.Printf("enqueue not persisted: id=%s queue=%s: %v", envlp.ID, queueName, err)
+			ackNotReplicated.Inc(queueName)
+			published()
+			writeCodedError(w, codeNotReplicated, fmt.Sprintf("enqueued as %s, but %v", envlp.ID, err), http.StatusGatewayTimeout)
+			return
+		case err != nil:
+			writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+			return
+		case onSpool:
+			enqueued = true
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(enqueueResponse{Enqueued: false, Spooled: true, Queue: queueName, ID: envlp.ID, Message: msg})
+			return
+		}
+
+		enqueued = true
+		published()
+
+		resp := enqueueResponse{Enqueued: true, Queue: queueName, ID: envlp.ID, Message: msg}
+		if delay > 0 {
+			resp.DeliverAt = deliverAt.UTC().Format(time.RFC3339)
+		}
+		if ceMode == cloudevents.ModeNone {
+			writeJSON(w, resp)
+			return
+		}
+		// CloudEvents producers get a CloudEvent back, in the mode they used.
+		resp.Message = ""
+		data, _ := json.Marshal(resp)
+		reply := cloudevents.Event{
+			Attributes: cloudevents.Attributes{
+				SpecVersion:     cloudevents.SpecVersion,
+				ID:              envelope.NewID(),
+				Source:          "/learn_k8s/api/" + queueName,
+				Type:            "com.learn_k8s.queue.enqueued",
+				Subject:         envlp.CloudEvent.ID,
+				Time:            time.Now().UTC().Format(time.RFC3339Nano),
+				DataContentType: "application/json",
+			},
+			Data: data,
+		}
+		if err := cloudevents.Write(w, ceMode, http.StatusOK, reply); err != nil {
+			logger.Printf("write cloudevent response failed: %v", err)
+		}
+	})))))
+
+	bulk := &bulkEnqueuer{
+		tenants:  tenants,
+		policies: policies,
+		maint:    maint,
+		schemas:  schemas,
+		rules:    rules,
+		tracker:  usageTracker,
+		quotas:   quotas,
+		bus:      bus,
+		hostname: hostname,
+		reporter: reporter,
+		logger:   logger,
+		debugLog: debugLog,
+		redactor: redactor,
+	}
+	v1.HandleFunc("POST /enqueue/batch", require(authz, rbac.Producer, memGuard.wrap(backlogHeaders(depthHeaders, gz.wrap(gzipRequests, bulk.handler(q))))))
+	v1.HandleFunc("POST /enqueue/file", require(authz, rbac.Producer, memGuard.wrap(backlogHeaders(depthHeaders, gz.wrap(gzipRequests, bulk.fileHandler(q))))))
+
+	reservations := &reserver{
+		tenants:  tenants,
+		policies: policies,
+		maint:    maint,
+		schemas:  schemas,
+		rules:    rules,
+		tracker:  usageTracker,
+		quotas:   quotas,
+		bus:      bus,
+		hostname: hostname,
+		reporter: reporter,
+		logger:   logger,
+		debugLog: debugLog,
+		redactor: redactor,
+		ttl:      envDuration("RESERVATION_TTL", 5*time.Minute),
+		results:  reg.NewCounter("api_reservations_total", "Two-phase enqueues by queue and result: reserved, committed, or expired.", "queue", "result"),
 	}
+	v1.HandleFunc("POST /enqueue/reserve", require(authz, rbac.Producer, memGuard.wrap(gz.wrap(gzipRequests, reservations.reserve(q)))))
+	v1.HandleFunc("POST /enqueue/commit/{token}", require(authz, rbac.Producer, backlogHeaders(depthHeaders, reservations.commit(q))))
+	background.Go(func() error {
+		reservations.refundLapsed(backgroundCtx, q)
+		return nil
+	})
+
+	v1.HandleFunc("POST /ingest/{source}", memGuard.wrap(ingestWebhook(q, bus, ingestSecrets.Load, maint, hostname, reporter, logger, msgLog)))
 
-	go func() {
-		logger.Printf("listening on %s (redis=%s queue=%s)", addr, redisAddr, queueName)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("server error: %v", err)
+	v1.HandleFunc("GET /stats", gz.wrap(gzipResponses, func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		resp, err := queueStats(ctx, q)
+		if err != nil {
+			logger.Printf("stats failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		resp.Backlog = backlogRates.rates()
+		writeJSON(w, resp)
+	}))
+
+	v1.HandleFunc("GET /tenants/{tenant}/stats", gz.wrap(gzipResponses, tenantStats(tenants, logger)))
+
+	v1.HandleFunc("GET /stats/recent", require(authz, rbac.Operator, gz.wrap(gzipResponses, func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		recent, err := q.RecentProcessed(ctx, limit)
+		if err != nil {
+			logger.Printf("recent failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
 		}
-	}()
+		writeJSON(w, recent)
+	})))
+
+	v1.HandleFunc("GET /stats/archive", require(authz, rbac.Operator, gz.wrap(gzipResponses, listArchive(q, logger))))
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
+	v1.HandleFunc("GET /stats/aggregations", require(authz, rbac.Operator, gz.wrap(gzipResponses, listAggregations(q, logger))))
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	_ = srv.Shutdown(shutdownCtx)
+	v1.HandleFunc("GET /stats/dlq", require(authz, rbac.Operator, gz.wrap(gzipResponses, listDLQ(q, logger))))
+
+	v1.HandleFunc("GET /messages/{id}", require(authz, rbac.Operator, gz.wrap(gzipResponses, messageStatus(q, logger))))
+	v1.HandleFunc("POST /messages/{id}/cancel", require(authz, rbac.Operator, cancelMessage(q, envDuration("CANCEL_TTL", 24*time.Hour), auditLog, logger)))
+
+	schedules := &scheduleAPI{store: schedule.NewStore(rdb, queueName), queueName: queueName, schemas: schemas, rules: rules, auditLog: auditLog, logger: logger}
+	v1.HandleFunc("POST /schedules", require(authz, rbac.Operator, schedules.create))
+	v1.HandleFunc("GET /schedules", require(authz, rbac.Operator, gz.wrap(gzipResponses, schedules.list)))
+	v1.HandleFunc("GET /schedules/{id}", require(authz, rbac.Operator, schedules.get))
+	v1.HandleFunc("PUT /schedules/{id}", require(authz, rbac.Operator, schedules.update))
+	v1.HandleFunc("DELETE /schedules/{id}", require(authz, rbac.Operator, schedules.delete))
+
+	v1.HandleFunc("GET /audit", require(authz, rbac.Operator, gz.wrap(gzipResponses, func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		entries, err := auditLog.Recent(ctx, int64(limit))
+		if err != nil {
+			logger.Printf("audit query failed: %v", err)
+			writeError(w, "audit query failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, entries)
+	})))
+
+	// Admin and debug routes get their own listener unless ADMIN_ADDR is
+	// empty, in which case they're mounted on the main one.
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /admin/tenants", require(authz, rbac.Operator, gz.wrap(gzipResponses, listTenants(tenants, logger))))
+	adminMux.HandleFunc("GET /admin/usage", require(authz, rbac.Operator, gz.wrap(gzipResponses, adminUsage(usageTracker, quotas, logger))))
+	adminMux.HandleFunc("GET /admin/maintenance", require(authz, rbac.Operator, getMaintenance(maint)))
+	adminMux.HandleFunc("PUT /admin/maintenance", require(authz, rbac.Operator, setMaintenance(maint, queueName, auditLog, logger)))
+	adminMux.HandleFunc("DELETE /admin/maintenance", require(authz, rbac.Operator, setMaintenance(maint, queueName, auditLog, logger)))
+	adminMux.HandleFunc("GET /admin/schemas", require(authz, rbac.Operator, gz.wrap(gzipResponses, listSchemas(schemas))))
+	adminMux.HandleFunc("GET /admin/schemas/{queue}", require(authz, rbac.Operator, getSchema(schemas)))
+	adminMux.HandleFunc("PUT /admin/schemas/{queue}", require(authz, rbac.Admin, putSchema(schemas, auditLog, logger)))
+	adminMux.HandleFunc("DELETE /admin/schemas/{queue}", require(authz, rbac.Admin, deleteSchema(schemas, auditLog, logger)))
+	adminMux.HandleFunc("PUT /admin/pause", require(authz, rbac.Operator, pauseQueue(q, auditLog, logger)))
+	adminMux.HandleFunc("DELETE /admin/pause", require(authz, rbac.Operator, pauseQueue(q, auditLog, logger)))
+	adminMux.HandleFunc("POST /admin/purge", require(authz, rbac.Admin, purgeQueue(q, auditLog, logger)))
+	adminMux.HandleFunc("GET /statusz", require(authz, rbac.Operator, statusz(q, maint, recentErrors, pod, hostname, started, logger)))
+	if authz != nil {
+		// Issuing keys takes an admin, so these only exist with RBAC on.
+		adminMux.HandleFunc("GET /admin/keys", require(authz, rbac.Admin, gz.wrap(gzipResponses, listKeys(apiKeys))))
+		adminMux.HandleFunc("POST /admin/keys", require(authz, rbac.Admin, createKey(apiKeys, auditLog, logger)))
+		adminMux.HandleFunc("DELETE /admin/keys/{id}", require(authz, rbac.Admin, revokeKey(apiKeys, auditLog, logger)))
+		adminMux.HandleFunc("POST /admin/keys/{id}/rotate", require(authz, rbac.Admin, rotateKey(apiKeys, auditLog, logger)))
+	}
+	adminMux.HandleFunc("GET /admin/slow", require(authz, rbac.Operator, gz.wrap(gzipResponses, slowMessages(q, logger))))
+	adminMux.HandleFunc("GET /admin/scaling-advice", require(authz, rbac.Operator, gz.wrap(gzipResponses, scalingAdvice(q, backlogRates, logger))))
+	adminMux.HandleFunc("GET /admin/loglevel", require(authz, rbac.Operator, logLevelHandler(levels, auditLog, logger)))
+	adminMux.HandleFunc("PUT /admin/loglevel", require(authz, rbac.Operator, logLevelHandler(levels, auditLog, logger)))
+	registerPprof(adminMux, authz)
+
+	mux.Handle("GET /dashboard/", dashboardHandler())
+	mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+
+	v1.HandleFunc("GET /stream/processed", require(authz, rbac.Operator, streamProcessed(feed, q, logger)))
+	v1.HandleFunc("GET /ws/events", require(authz, rbac.Operator, wsEvents(feed, envList("WS_ALLOWED_ORIGINS"), logger)))
+	mountVersioned(mux, v1, timeouts, reg)
+	mux.Handle("GET /metrics", reg.Handler())
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, buildinfo.Get())
+	})
+
+	access := &accessLog{sample: envFloat("ACCESS_LOG_SAMPLE", 1), info: msgLog, warn: logger}
+	routes := newRouteMetrics(reg)
+	if adminAddr == "" {
+		admin := withTimeouts(timeouts, adminMux, adminMux)
+		mux.Handle("/admin/", admin)
+		mux.Handle("/debug/", admin)
+		mux.Handle("/statusz", admin)
+	} else {
+		// The admin listener keeps plain settings: pprof profiles and
+		// traces run for as long as they're asked to.
+		adminConns := connStats.tracker("admin")
+		adminSrv := &http.Server{Addr: adminAddr, Handler: withRequestID(access.wrap(routes.wrap("admin", routePattern(adminMux, nil, nil), adminConns.limitInFlight(0, withSecurityHeaders(hsts, recoverPanics(reporter, logger, withTimeouts(timeouts, adminMux, muxErrors(adminMux)))))))), ReadHeaderTimeout: 5 * time.Second}
+		listeners.Go(func() error {
+			logger.Printf("admin listening on %s", adminAddr)
+			return serve(listenCtx, "admin", adminSrv, adminConns, 0, 10*time.Second)
+		})
+	}
+
+	httpConns := connStats.tracker("http")
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     withRequestID(access.wrap(routes.wrap("http", routePattern(mux, v1, adminMux), httpConns.limitInFlight(envInt("MAX_INFLIGHT_REQUESTS", 0), withSecurityHeaders(hsts, withCORS(cors, recoverPanics(reporter, logger, withTimeouts(timeouts, mux, muxErrors(mux))))))))),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	tuning.apply(srv)
+	srv.RegisterOnShutdown(cancelBase)
+	listeners.Go(func() error {
+		logger.Printf("listening on %s (redis=%s queue=%s version=%s)", addr, redisAddr, queueName, buildinfo.Get().Version)
+		return serve(listenCtx, "http", srv, httpConns, tuning.MaxConns, tuning.Grace)
+	})
+
+	failed := listeners.Wait()
+	if failed != nil {
+		logger.Printf("shutting down: %v", failed)
+	}
+	stopBackground()
+	_ = background.Wait()
+	detachAudit()
+	detachMetrics()
+	detachTransport()
 	_ = rdb.Close()
 	logger.Printf("shutdown complete")
+	if logExport != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		logExport.Shutdown(flushCtx)
+		cancelFlush()
+	}
+	if failed != nil {
+		reporter.Flush(5 * time.Second)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/maintenance"
+)
+
+const defaultMaintenanceMessage = "the queue is down for maintenance, retry later"
+
+// maintenanceRequest is the body of PUT /admin/maintenance.
+type maintenanceRequest struct {
+	Message    string `json:"message"`
+	FailHealth bool   `json:"fail_health"`
+}
+
+// inMaintenance writes the maintenance response and reports true if producers
+// should be turned away.
+func inMaintenance(w http.ResponseWriter, sw *maintenance.Switch) bool {
+	st := sw.State()
+	if !st.Enabled {
+		return false
+	}
+	msg := st.Message
+	if msg == "" {
+		msg = defaultMaintenanceMessage
+	}
+	w.Header().Set("Retry-After", "60")
+	writeCodedError(w, codeMaintenance, msg, http.StatusServiceUnavailable)
+	return true
+}
+
+func getMaintenance(sw *maintenance.Switch) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, sw.State())
+	}
+}
+
+// setMaintenance enables maintenance mode on PUT and disables it on DELETE.
+func setMaintenance(sw *maintenance.Switch, queueName string, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		st := maintenance.State{}
+		action := "maintenance.disable"
+		if r.Method == http.MethodPut {
+			var req maintenanceRequest
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+			if err != nil {
+				writeError(w, "failed to read body", http.StatusBadRequest)
+				return
+			}
+			if len(body) > 0 {
+				if err := json.Unmarshal(body, &req); err != nil {
+					writeError(w, "invalid JSON body", http.StatusBadRequest)
+					return
+				}
+			}
+			now := time.Now().UTC()
+			st = maintenance.State{Enabled: true, Message: req.Message, FailHealth: req.FailHealth, Since: &now, By: requestSubject(r)}
+			action = "maintenance.enable"
+		}
+
+		if err := sw.Set(ctx, st); err != nil {
+			logger.Printf("set maintenance failed: %v", err)
+			writeError(w, "set maintenance failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("%s by %s", action, requestSubject(r))
+		detail := ""
+		if st.Enabled {
+			detail = "fail_health=" + strconv.FormatBool(st.FailHealth)
+		}
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: action, Queue: queueName, Detail: detail}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		writeJSON(w, st)
+	}
+}
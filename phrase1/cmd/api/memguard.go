@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// memoryGuard turns producers away while Redis is close to its maxmemory,
+// rather than letting it evict keys or refuse writes halfway through an
+// enqueue. It polls INFO memory; between shedAt and refuseAt of maxmemory
+// it refuses a share of enqueues rising linearly to all of them, and at
+// refuseAt it refuses every one. A Redis with no maxmemory is never
+// guarded.
+type memoryGuard struct {
+	rdb      *redis.Client
+	every    time.Duration
+	shedAt   float64
+	refuseAt float64
+	// ratio is used_memory/maxmemory as float64 bits, 0 while unknown.
+	ratio   atomic.Uint64
+	used    *metrics.Gauge
+	max     *metrics.Gauge
+	rejects *metrics.Counter
+	logger  *log.Logger
+}
+
+// newMemoryGuard returns nil unless REDIS_MEMORY_REFUSE_AT is set.
+func newMemoryGuard(rdb *redis.Client, reg *metrics.Registry, logger *log.Logger) *memoryGuard {
+	refuseAt := envFloat("REDIS_MEMORY_REFUSE_AT", 0)
+	if refuseAt <= 0 {
+		return nil
+	}
+	shedAt := envFloat("REDIS_MEMORY_SHED_AT", refuseAt)
+	if shedAt <= 0 || shedAt > refuseAt {
+		logger.Fatalf("REDIS_MEMORY_SHED_AT must be above 0 and at most REDIS_MEMORY_REFUSE_AT")
+	}
+	return &memoryGuard{
+		rdb:      rdb,
+		every:    envDuration("REDIS_MEMORY_CHECK_INTERVAL", 5*time.Second),
+		shedAt:   shedAt,
+		refuseAt: refuseAt,
+		used:     reg.NewGauge("redis_memory_used_bytes", "Redis used_memory at the last memory guard check."),
+		max:      reg.NewGauge("redis_memory_max_bytes", "Redis maxmemory at the last memory guard check; 0 if unlimited."),
+		rejects:  reg.NewCounter("api_memory_guard_rejects_total", "Enqueues turned away because Redis was near maxmemory, by reason: shed or refused.", "reason"),
+		logger:   logger,
+	}
+}
+
+// run checks Redis's memory until ctx is canceled.
+func (g *memoryGuard) run(ctx context.Context) {
+	ticker := time.NewTicker(g.every)
+	defer ticker.Stop()
+	for {
+		g.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reads INFO memory. A failed check keeps the last ratio: an
+// unreachable Redis fails enqueues by itself.
+func (g *memoryGuard) check(ctx context.Context) {
+	info, err := g.rdb.Info(ctx, "memory").Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			g.logger.Printf("memory guard check error: %v", err)
+		}
+		return
+	}
+	used, maxmemory, err := parseMemoryInfo(info)
+	if err != nil {
+		g.logger.Printf("memory guard check error: %v", err)
+		return
+	}
+	g.used.Set(float64(used))
+	g.max.Set(float64(maxmemory))
+	ratio := 0.0
+	if maxmemory > 0 {
+		ratio = float64(used) / float64(maxmemory)
+	}
+	if old := math.Float64frombits(g.ratio.Swap(math.Float64bits(ratio))); (old < g.shedAt) != (ratio < g.shedAt) {
+		g.logger.Printf("redis memory at %.0f%% of maxmemory; shedding enqueues from %.0f%%", ratio*100, g.shedAt*100)
+	}
+}
+
+// wrap refuses h's requests while Redis is too full for them. A nil guard
+// lets everything through.
+func (g *memoryGuard) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if g == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ratio := math.Float64frombits(g.ratio.Load())
+		if ratio < g.shedAt {
+			h(w, r)
+			return
+		}
+		reason := "refused"
+		if ratio < g.refuseAt {
+			// Equal thresholds refuse everything above them, so this only
+			// runs with shedAt < refuseAt.
+			if rand.Float64() >= (ratio-g.shedAt)/(g.refuseAt-g.shedAt) {
+				h(w, r)
+				return
+			}
+			reason = "shed"
+		}
+		g.rejects.Inc(reason)
+		w.Header().Set("Retry-After", strconv.Itoa(int(g.every.Seconds())+1))
+		writeCodedError(w, codeRedisMemory, fmt.Sprintf("redis is at %.0f%% of its memory limit; retry once workers have drained the queue", ratio*100), http.StatusServiceUnavailable)
+	}
+}
+
+// parseMemoryInfo returns used_memory and maxmemory from an INFO memory
+// reply.
+func parseMemoryInfo(info string) (used, maxmemory int64, err error) {
+	found := 0
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if !ok {
+			continue
+		}
+		var dst *int64
+		switch k {
+		case "used_memory":
+			dst = &used
+		case "maxmemory":
+			dst = &maxmemory
+		default:
+			continue
+		}
+		if *dst, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("INFO memory %s: %w", k, err)
+		}
+		found++
+	}
+	if found < 2 {
+		return 0, 0, fmt.Errorf("INFO memory has no used_memory or maxmemory")
+	}
+	return used, maxmemory, nil
+}
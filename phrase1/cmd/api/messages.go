@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// cancelResponse answers POST /messages/{id}/cancel.
+type cancelResponse struct {
+	ID    string `json:"id"`
+	Queue string `json:"queue"`
+	// State is the message's status when it was cancelled: empty if no
+	// worker had taken it yet.
+	State string `json:"state,omitempty"`
+}
+
+// messageStatus returns where message {id} is in processing, with the
+// progress its handler last reported, as the worker recorded it (see
+// STATUS_TTL). A message no worker has taken yet has no status.
+func messageStatus(q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st, err := q.Status(r.Context(), r.PathValue("id"))
+		if errors.Is(err, queue.ErrNoStatus) {
+			writeError(w, "no status for message "+r.PathValue("id")+"; no worker has taken it yet, or its status has expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("message status failed: %v", err)
+			writeError(w, "message status failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, st)
+	}
+}
+
+// cancelMessage cancels message {id} for ttl: a worker that takes it
+// afterwards drops it, and one handling it asks its handler to stop (see
+// CANCEL_CHECK_INTERVAL). Either records it as cancelled. A message whose
+// status says it's already finished can't be cancelled.
+func cancelMessage(q *queue.RedisQueue, ttl time.Duration, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := r.PathValue("id")
+
+		st, err := q.Status(ctx, id)
+		if err != nil && !errors.Is(err, queue.ErrNoStatus) {
+			logger.Printf("message status failed: %v", err)
+			writeError(w, "cancel failed", http.StatusServiceUnavailable)
+			return
+		}
+		switch st.State {
+		case queue.StateProcessed, queue.StateDeadLettered, queue.StateCancelled:
+			writeError(w, "message "+id+" is already "+st.State, http.StatusConflict)
+			return
+		}
+		if err := q.Cancel(ctx, id, ttl); err != nil {
+			logger.Printf("cancel failed: %v", err)
+			writeError(w, "cancel failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("message.cancel id=%s by %s", id, requestSubject(r))
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "message.cancel", Queue: q.Name(), Detail: "id=" + id}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(cancelResponse{ID: id, Queue: q.Name(), State: st.State})
+	}
+}
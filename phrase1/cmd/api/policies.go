@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// queueFull enforces p's max_depth on q. When q is full, or its depth can't
+// be read, it writes the response and returns true.
+func queueFull(ctx context.Context, w http.ResponseWriter, q *queue.RedisQueue, p policy.Policy, logger *log.Logger) bool {
+	if p.MaxDepth <= 0 {
+		return false
+	}
+	depth, err := q.Depth(ctx)
+	if err != nil {
+		logger.Printf("queue %s depth check failed: %v", q.Name(), err)
+		writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+		return true
+	}
+	if depth >= p.MaxDepth {
+		writeCodedError(w, codeQueueFull, "queue is full ("+strconv.FormatInt(p.MaxDepth, 10)+" messages)", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"learn_k8s/phrase1/internal/apikey"
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+// newAuthorizer enables RBAC when a keys file or JWT secret is configured and
+// returns nil otherwise. Tenant keys act as producers, and keys issued
+// through /admin/keys as the highest of their scopes.
+func newAuthorizer(path, jwtSecret string, tenants *tenancy, keys *apikey.Store) (*rbac.Authorizer, error) {
+	if path == "" && jwtSecret == "" {
+		return nil, nil
+	}
+	var principals []rbac.Principal
+	if path != "" {
+		var err error
+		if principals, err = rbac.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	az, err := rbac.NewAuthorizer(principals, []byte(jwtSecret))
+	if err != nil {
+		return nil, err
+	}
+	if tenants != nil {
+		az.AddLookup(func(key string) (rbac.Principal, bool) {
+			tn, ok := tenants.dir.Lookup(key)
+			return rbac.Principal{Name: "tenant:" + tn.ID, Role: rbac.Producer}, ok
+		})
+	}
+	az.AddLookup(func(key string) (rbac.Principal, bool) {
+		k, ok := keys.Lookup(key)
+		return keyPrincipal(k), ok
+	})
+	return az, nil
+}
+
+// require only lets callers holding role through to h. With a nil authorizer
+// RBAC is off and h is returned as is.
+func require(az *rbac.Authorizer, role rbac.Role, h http.HandlerFunc) http.HandlerFunc {
+	if az == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := az.Authorize(requestKey(r), role)
+		switch {
+		case errors.Is(err, rbac.ErrForbidden):
+			writeError(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="queue"`)
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(rbac.WithPrincipal(r.Context(), p))
+		recordSubject(r)
+		h(w, r)
+	}
+}
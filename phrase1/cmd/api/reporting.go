@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/tracecontext"
+)
+
+// requestTraceID returns the trace id from r's traceparent header, or "".
+func requestTraceID(r *http.Request) string {
+	return errreport.TraceID(r.Header.Get("traceparent"))
+}
+
+// messageSpan is the span messages enqueued by r are stamped with: a child
+// of the caller's, or the root of a new trace, carrying its baggage along.
+func messageSpan(r *http.Request) tracecontext.SpanContext {
+	return tracecontext.FromHeader(r.Header).Child()
+}
+
+// recoverPanics reports a panicking handler to the error tracker and answers
+// 500, instead of net/http only logging it and dropping the connection.
+func recoverPanics(reporter errreport.Reporter, logger *log.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			stack := debug.Stack()
+			logger.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, stack)
+			reporter.Report(errreport.Event{
+				Err:     fmt.Errorf("%v", rec),
+				Message: "panic serving " + r.Method + " " + r.URL.Path,
+				Level:   errreport.Fatal,
+				TraceID: requestTraceID(r),
+				Tags:    map[string]string{"method": r.Method, "path": r.URL.Path},
+				Stack:   stack,
+			})
+			writeError(w, "internal error", http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
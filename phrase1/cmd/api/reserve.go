@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/maintenance"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/schema"
+	"learn_k8s/phrase1/internal/usage"
+	"learn_k8s/phrase1/internal/validate"
+)
+
+// reserveResponse answers POST /enqueue/reserve.
+type reserveResponse struct {
+	Token     string `json:"token"`
+	Queue     string `json:"queue"`
+	ID        string `json:"id"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// reserver serves the two-phase enqueue: POST /enqueue/reserve checks a
+// message and holds it under a token, and POST /enqueue/commit/{token}
+// enqueues it. Everything that can turn a message away happens on reserve,
+// so a producer can reserve, commit its own transaction, and then commit
+// the message knowing it will be taken.
+type reserver struct {
+	tenants  *tenancy
+	policies *policy.Store
+	maint    *maintenance.Switch
+	schemas  *schema.Registry
+	rules    *enqueueRules
+	tracker  *usage.Tracker
+	quotas   usage.Quotas
+	bus      *events.Bus
+	hostname string
+	reporter errreport.Reporter
+	logger   *log.Logger
+	// debugLog gets a line per message; requests are in the access log.
+	debugLog *log.Logger
+	redactor *redact.Messages
+	// ttl is how long a reservation waits for its commit.
+	ttl time.Duration
+	// results counts reservations by queue and result: reserved, committed,
+	// expired (committed too late, or never issued), or lapsed (never
+	// committed, its quota refunded).
+	results *metrics.Counter
+}
+
+// reservationCharge is the quota a reservation took, kept with it as its
+// queue.Reservation.Charge so it can be refunded if it lapses.
+type reservationCharge struct {
+	Subject string `json:"subject"`
+	Size    int64  `json:"size"`
+	Day     string `json:"day"`
+}
+
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "rsv_" + hex.EncodeToString(b), nil
+}
+
+// reserve takes an /enqueue body, text or {"message":...} JSON, into base
+// or the caller's tenant queue. It's checked as /enqueue checks it and
+// counted against the caller's quota, but held back until committed.
+func (s *reserver) reserve(base *queue.RedisQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, s.maint) {
+			return
+		}
+		ctx := r.Context()
+		q := s.tenants.resolve(ctx, w, r, base, s.logger)
+		if q == nil || queueFull(ctx, w, q, s.policies.For(q.Name()), s.logger) {
+			return
+		}
+		queueName := q.Name()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			writeError(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if !isTextContentType(contentType) {
+			w.Header().Set("Accept-Post", "text/plain, application/json")
+			writeError(w, "unsupported content type "+contentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		msg := strings.TrimSpace(string(body))
+		schemaVersion := 0
+		if strings.Contains(strings.ToLower(contentType), "application/json") {
+			var req enqueueRequest
+			if err := json.Unmarshal(body, &req); err == nil {
+				var text string
+				if err := json.Unmarshal(req.Message, &text); err == nil {
+					msg = strings.TrimSpace(text)
+				} else {
+					msg = string(req.Message)
+				}
+				schemaVersion = req.SchemaVersion
+			}
+		}
+		if msg == "" {
+			writeError(w, "message is required", http.StatusBadRequest)
+			return
+		}
+		if schemaVersion < 0 {
+			writeError(w, "schema_version must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		if err := s.schemas.Validate(queueName, []byte(msg)); err != nil {
+			if !writeSchemaError(w, queueName, err) {
+				writeError(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if ve := s.rules.check(validate.Input{Queue: queueName, Text: msg, Size: len(msg)}); ve != nil {
+			writeValidationError(w, queueName, ve)
+			return
+		}
+
+		// No producer budget: the time spent waiting for the commit would
+		// count against it.
+		envlp := envelope.New(msg)
+		envlp.SchemaVersion = schemaVersion
+		envlp.Metadata = messageSpan(r).SetMetadata(envlp.Metadata)
+		encoded, err := q.Encode(envlp)
+		if err != nil {
+			s.logger.Printf("encode envelope failed: %v", err)
+			writeError(w, "reserve failed", http.StatusInternalServerError)
+			return
+		}
+		token, err := newReservationToken()
+		if err != nil {
+			s.logger.Printf("reservation token failed: %v", err)
+			writeError(w, "reserve failed", http.StatusInternalServerError)
+			return
+		}
+
+		subject := requestSubject(r)
+		size := int64(len(msg))
+		used, err := s.tracker.Consume(ctx, subject, size, s.quotas.For(subject))
+		if err != nil {
+			if errors.Is(err, usage.ErrQuotaExceeded) {
+				w.Header().Set("Retry-After", strconv.Itoa(secondsUntilTomorrow(time.Now())))
+				writeCodedError(w, codeQuotaExceeded, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			s.logger.Printf("usage accounting failed: %v", err)
+			writeError(w, "reserve failed", http.StatusServiceUnavailable)
+			return
+		}
+		charge, _ := json.Marshal(reservationCharge{Subject: subject, Size: size, Day: used.Day})
+		expires := time.Now().Add(s.ttl)
+		if err := q.Reserve(ctx, token, queue.Reservation{ID: envlp.ID, Payload: encoded, Message: msg, Charge: string(charge)}, s.ttl); err != nil {
+			s.logger.Printf("reserve failed: %v", err)
+			s.reporter.Report(errreport.Event{Err: err, Message: "reserve failed", MessageID: envlp.ID, TraceID: requestTraceID(r), Tags: map[string]string{"queue": queueName}})
+			if err := s.tracker.Refund(ctx, subject, size); err != nil {
+				s.logger.Printf("usage refund failed: %v", err)
+			}
+			writeError(w, "reserve failed", http.StatusServiceUnavailable)
+			return
+		}
+		s.results.Inc(queueName, "reserved")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(reserveResponse{Token: token, Queue: queueName, ID: envlp.ID, ExpiresAt: expires.UTC().Format(time.RFC3339)})
+	}
+}
+
+// commit enqueues the message reserved as the path's token. Committing it
+// again, until it expires, answers with duplicate set instead of enqueueing
+// it twice. The tenant's limits were checked on reserve and aren't again.
+func (s *reserver) commit(base *queue.RedisQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, s.maint) {
+			return
+		}
+		ctx := r.Context()
+		q := s.tenants.queueOf(w, r, base)
+		if q == nil {
+			return
+		}
+		queueName := q.Name()
+		token := r.PathValue("token")
+
+		rsv, err := q.Reserved(ctx, token)
+		var fresh bool
+		if err == nil {
+			fresh, err = q.Commit(ctx, token)
+		}
+		switch {
+		case errors.Is(err, queue.ErrNoReservation):
+			s.results.Inc(queueName, "expired")
+			writeError(w, "no reservation "+token+"; it may have expired", http.StatusNotFound)
+			return
+		case err != nil:
+			s.logger.Printf("commit failed: %v", err)
+			s.reporter.Report(errreport.Event{Err: err, Message: "commit failed", MessageID: rsv.ID, TraceID: requestTraceID(r), Tags: map[string]string{"queue": queueName}})
+			writeError(w, "commit failed", http.StatusServiceUnavailable)
+			return
+		}
+		if fresh {
+			s.results.Inc(queueName, "committed")
+			s.debugLog.Printf("enqueued message: %q", s.redactor.Text(rsv.Message))
+			s.bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: queueName, Message: rsv.Message, Source: s.hostname, Subject: requestSubject(r)})
+		}
+		writeJSON(w, enqueueResponse{Enqueued: true, Duplicate: !fresh, Queue: queueName, ID: rsv.ID})
+	}
+}
+
+// refundLapsed hands back the quota of reservations that expired without a
+// commit, in base and the tenants' queues, until ctx is canceled.
+func (s *reserver) refundLapsed(ctx context.Context, base *queue.RedisQueue) {
+	ticker := time.NewTicker(min(s.ttl, 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, q := range s.tenants.queues(base) {
+			charges, err := q.Lapsed(ctx, time.Now(), 100)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Printf("lapsed reservations of %s error: %v", q.Name(), err)
+				}
+				continue
+			}
+			for _, raw := range charges {
+				s.results.Inc(q.Name(), "lapsed")
+				var c reservationCharge
+				if err := json.Unmarshal([]byte(raw), &c); err != nil || c.Subject == "" {
+					continue
+				}
+				if err := s.tracker.RefundDay(ctx, c.Day, c.Subject, 1, c.Size); err != nil {
+					s.logger.Printf("usage refund failed: %v", err)
+				}
+			}
+		}
+	}
+}
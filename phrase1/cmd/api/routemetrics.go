@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// routeMetrics counts and times every request by server, route pattern,
+// and status class, so a new route is measured the moment it's registered.
+// Labelling by pattern rather than path keeps the series bounded: ids in
+// paths all land on their route's one pattern.
+type routeMetrics struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+}
+
+func newRouteMetrics(reg *metrics.Registry) *routeMetrics {
+	return &routeMetrics{
+		requests: reg.NewCounter("http_requests_total", "HTTP requests by server, route pattern, and status class (2xx, 4xx, ...).", "server", "route", "code"),
+		duration: reg.NewHistogram("http_request_duration_seconds", "HTTP request latency by server, route pattern, and status class; streams count for as long as they're open.", nil, "server", "route", "code"),
+	}
+}
+
+// wrap measures server's requests, labelled by route(r).
+func (m *routeMetrics) wrap(server string, route func(*http.Request) string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		pattern, code := route(r), strconv.Itoa(rec.code()/100)+"xx"
+		m.requests.Inc(server, pattern, code)
+		m.duration.Observe(time.Since(start).Seconds(), server, pattern, code)
+	})
+}
+
+// routePattern returns a func naming the pattern that serves a request:
+// mux's own, or, for the paths mux hands on to the versioned api or the
+// admin mux (either may be nil), theirs. Versioned and legacy paths share
+// the api's pattern. Requests no route matches are "unmatched".
+func routePattern(mux, api, admin *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		_, p := mux.Handler(r)
+		switch {
+		case api != nil && (p == apiVersion+"/" || p == "/"):
+			sub := r
+			if rest, ok := strings.CutPrefix(r.URL.Path, apiVersion); ok && p != "/" {
+				sub = new(http.Request)
+				*sub = *r
+				sub.URL = &url.URL{Path: rest}
+			}
+			_, p = api.Handler(sub)
+		case admin != nil && (p == "/admin/" || p == "/debug/" || p == "/statusz"):
+			_, p = admin.Handler(r)
+		}
+		if p == "" {
+			return "unmatched"
+		}
+		return p
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/backlog"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// scalingAdviceResponse is the body of GET /admin/scaling-advice: the
+// inputs the advice came from, then the advice.
+type scalingAdviceResponse struct {
+	Queue              string  `json:"queue"`
+	ArrivalRate        float64 `json:"arrival_rate"`
+	Depth              int64   `json:"depth"`
+	ProcessingSeconds  float64 `json:"processing_seconds"`
+	ProcessingSamples  int     `json:"processing_samples"`
+	TargetUtilization  float64 `json:"target_utilization"`
+	DrainWithinSeconds float64 `json:"drain_within_seconds"`
+	MinReplicas        int     `json:"min_replicas"`
+	MaxReplicas        int     `json:"max_replicas,omitempty"`
+	RateWindowSeconds  float64 `json:"rate_window_seconds"`
+	backlog.Advice
+}
+
+// scalingAdvice recommends a worker replica count from the queue's arrival
+// rate (see backlogSampler), the mean processing time of the last
+// ?samples= archived messages (default 100), and the workers heartbeating
+// now. ?processing_ms= stands in for the archive, ?target_utilization=
+// (default 0.8), ?drain_within= (default 5m), ?min= (default 1), and ?max=
+// (default none) set the target. It advises only; nothing is scaled.
+func scalingAdvice(q *queue.RedisQueue, rates *backlogSampler, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		target := backlog.Target{Utilization: 0.8, DrainWithin: 5 * time.Minute, MinReplicas: 1}
+		if v := query.Get("target_utilization"); v != "" {
+			u, err := strconv.ParseFloat(v, 64)
+			if err != nil || u <= 0 || u > 1 {
+				writeError(w, "target_utilization must be a number above 0 and at most 1", http.StatusBadRequest)
+				return
+			}
+			target.Utilization = u
+		}
+		if v := query.Get("drain_within"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				writeError(w, "drain_within must be a non-negative duration", http.StatusBadRequest)
+				return
+			}
+			target.DrainWithin = d
+		}
+		for _, p := range []struct {
+			name string
+			dst  *int
+		}{{"min", &target.MinReplicas}, {"max", &target.MaxReplicas}} {
+			if v := query.Get(p.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					writeError(w, p.name+" must be a non-negative integer", http.StatusBadRequest)
+					return
+				}
+				*p.dst = n
+			}
+		}
+		if target.MaxReplicas > 0 && target.MinReplicas > target.MaxReplicas {
+			writeError(w, "min must not exceed max", http.StatusBadRequest)
+			return
+		}
+		samples := 100
+		if v := query.Get("samples"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxArchivePage {
+				writeError(w, "samples must be an integer from 1 to "+strconv.Itoa(maxArchivePage), http.StatusBadRequest)
+				return
+			}
+			samples = n
+		}
+
+		rate, ok := rates.tracker.Rates()
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(rates.every.Seconds())+1))
+			writeCodedError(w, codeNotSampled, "the arrival rate isn't sampled yet; try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+
+		var processing time.Duration
+		var measured int
+		if v := query.Get("processing_ms"); v != "" {
+			ms, err := strconv.ParseFloat(v, 64)
+			if err != nil || ms <= 0 {
+				writeError(w, "processing_ms must be a positive number", http.StatusBadRequest)
+				return
+			}
+			processing = time.Duration(ms * float64(time.Millisecond))
+		} else {
+			archived, err := q.Archived(ctx, queue.ArchiveQuery{Limit: int64(samples)})
+			if err != nil {
+				logger.Printf("scaling advice failed: %v", err)
+				writeError(w, "stats failed", http.StatusServiceUnavailable)
+				return
+			}
+			if len(archived) == 0 {
+				writeError(w, "no processed messages in the archive to time; pass processing_ms", http.StatusConflict)
+				return
+			}
+			var total int64
+			for _, m := range archived {
+				total += m.DurationMS
+			}
+			measured = len(archived)
+			processing = time.Duration(total) * time.Millisecond / time.Duration(measured)
+		}
+
+		stats, err := q.Stats(ctx)
+		if err != nil {
+			logger.Printf("scaling advice failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		workers, err := q.Workers(ctx)
+		if err != nil {
+			logger.Printf("scaling advice failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+
+		d := backlog.Demand{ArrivalRate: rate.ArrivalRate, Depth: stats.Depth, ProcessingTime: processing}
+		writeJSON(w, scalingAdviceResponse{
+			Queue:              q.Name(),
+			ArrivalRate:        d.ArrivalRate,
+			Depth:              d.Depth,
+			ProcessingSeconds:  processing.Seconds(),
+			ProcessingSamples:  measured,
+			TargetUtilization:  target.Utilization,
+			DrainWithinSeconds: target.DrainWithin.Seconds(),
+			MinReplicas:        target.MinReplicas,
+			MaxReplicas:        target.MaxReplicas,
+			RateWindowSeconds:  rate.Window,
+			Advice:             backlog.Advise(d, len(workers), target),
+		})
+	}
+}
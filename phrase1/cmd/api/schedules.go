@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/schedule"
+	"learn_k8s/phrase1/internal/schema"
+	"learn_k8s/phrase1/internal/validate"
+)
+
+// scheduleRequest is the body of POST /schedules and PUT /schedules/{id}.
+type scheduleRequest struct {
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+	Message  string `json:"message"`
+	Misfire  string `json:"misfire"`
+	Paused   bool   `json:"paused"`
+}
+
+// scheduleAPI serves /schedules, the recurring enqueues of the api's queue.
+// The workers' elected scheduler fires them.
+type scheduleAPI struct {
+	store     *schedule.Store
+	queueName string
+	schemas   *schema.Registry
+	rules     *enqueueRules
+	auditLog  *audit.Log
+	logger    *log.Logger
+}
+
+// decode reads a scheduleRequest and checks its message as /enqueue would,
+// so a schedule can't enqueue a message the queue turns away. It writes
+// the error and returns false if there's one.
+func (s *scheduleAPI) decode(w http.ResponseWriter, r *http.Request) (schedule.Schedule, bool) {
+	var req scheduleRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, "body must be a JSON object with cron and message, and optionally timezone, misfire, and paused", http.StatusBadRequest)
+		return schedule.Schedule{}, false
+	}
+	if req.Message != "" {
+		if err := s.schemas.Validate(s.queueName, []byte(req.Message)); err != nil {
+			if !writeSchemaError(w, s.queueName, err) {
+				writeError(w, err.Error(), http.StatusBadRequest)
+			}
+			return schedule.Schedule{}, false
+		}
+		if ve := s.rules.check(validate.Input{Queue: s.queueName, Text: req.Message, Size: len(req.Message)}); ve != nil {
+			writeValidationError(w, s.queueName, ve)
+			return schedule.Schedule{}, false
+		}
+	}
+	return schedule.Schedule{Cron: req.Cron, Timezone: req.Timezone, Message: req.Message, Misfire: req.Misfire, Paused: req.Paused}, true
+}
+
+func (s *scheduleAPI) create(w http.ResponseWriter, r *http.Request) {
+	sch, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+	sch, err := s.store.Create(r.Context(), sch)
+	if errors.Is(err, schedule.ErrInvalid) {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("create schedule failed: %v", err)
+		writeError(w, "create schedule failed", http.StatusServiceUnavailable)
+		return
+	}
+	s.audit(r, "schedule.create", sch)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(sch)
+}
+
+func (s *scheduleAPI) list(w http.ResponseWriter, r *http.Request) {
+	all, err := s.store.List(r.Context())
+	if err != nil {
+		s.logger.Printf("list schedules failed: %v", err)
+		writeError(w, "list schedules failed", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, all)
+}
+
+func (s *scheduleAPI) get(w http.ResponseWriter, r *http.Request) {
+	sch, err := s.store.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, schedule.ErrNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("get schedule failed: %v", err)
+		writeError(w, "get schedule failed", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, sch)
+}
+
+// update replaces a schedule's definition. Its next run is worked out from
+// now, so pausing and resuming one doesn't make up the runs in between.
+func (s *scheduleAPI) update(w http.ResponseWriter, r *http.Request) {
+	sch, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+	sch, err := s.store.Update(r.Context(), r.PathValue("id"), sch)
+	switch {
+	case errors.Is(err, schedule.ErrNotFound):
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, schedule.ErrInvalid):
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		s.logger.Printf("update schedule failed: %v", err)
+		writeError(w, "update schedule failed", http.StatusServiceUnavailable)
+		return
+	}
+	s.audit(r, "schedule.update", sch)
+	writeJSON(w, sch)
+}
+
+func (s *scheduleAPI) delete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := s.store.Delete(r.Context(), id)
+	if errors.Is(err, schedule.ErrNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("delete schedule failed: %v", err)
+		writeError(w, "delete schedule failed", http.StatusServiceUnavailable)
+		return
+	}
+	s.audit(r, "schedule.delete", schedule.Schedule{ID: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *scheduleAPI) audit(r *http.Request, action string, sch schedule.Schedule) {
+	detail := "id=" + sch.ID
+	if sch.Cron != "" {
+		detail += " cron=" + sch.Cron
+	}
+	s.logger.Printf("%s %s by %s", action, sch.ID, requestSubject(r))
+	if err := s.auditLog.Record(r.Context(), audit.Entry{Subject: requestSubject(r), Action: action, Queue: s.queueName, Detail: detail}); err != nil {
+		s.logger.Printf("audit write error: %v", err)
+	}
+}
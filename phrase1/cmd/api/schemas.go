@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"learn_k8s/phrase1/internal/audit"
+	"learn_k8s/phrase1/internal/schema"
+)
+
+// writeSchemaError reports err from schema.Validate. It returns false if err
+// wasn't a validation failure, leaving the response to the caller.
+func writeSchemaError(w http.ResponseWriter, queueName string, err error) bool {
+	var ve *schema.ValidationError
+	if !errors.As(err, &ve) {
+		return false
+	}
+	writeAPIError(w, http.StatusUnprocessableEntity, apiError{Code: codeSchemaMismatch, Message: "payload does not match schema", Queue: queueName, Fields: ve.Fields})
+	return true
+}
+
+func listSchemas(schemas *schema.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, schemas.All())
+	}
+}
+
+func getSchema(schemas *schema.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		src, ok := schemas.Get(r.PathValue("queue"))
+		if !ok {
+			writeError(w, "no schema registered", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write(src)
+	}
+}
+
+// putSchema registers the request body as the JSON Schema for {queue}.
+func putSchema(schemas *schema.Registry, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queueName := r.PathValue("queue")
+		ctx := r.Context()
+
+		src, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			writeError(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		_ = r.Body.Close()
+
+		if err := schemas.Put(ctx, queueName, src); err != nil {
+			if errors.Is(err, schema.ErrInvalidSchema) {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Printf("register schema failed: %v", err)
+			writeError(w, "register schema failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("registered schema for queue %s", queueName)
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "schema.put", Queue: queueName, Detail: "bytes=" + strconv.Itoa(len(src))}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deleteSchema(schemas *schema.Registry, auditLog *audit.Log, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queueName := r.PathValue("queue")
+		ctx := r.Context()
+
+		removed, err := schemas.Delete(ctx, queueName)
+		if err != nil {
+			logger.Printf("delete schema failed: %v", err)
+			writeError(w, "delete schema failed", http.StatusServiceUnavailable)
+			return
+		}
+		if !removed {
+			writeError(w, "no schema registered", http.StatusNotFound)
+			return
+		}
+		logger.Printf("removed schema for queue %s", queueName)
+		if err := auditLog.Record(ctx, audit.Entry{Subject: requestSubject(r), Action: "schema.delete", Queue: queueName}); err != nil {
+			logger.Printf("audit write error: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals start a shutdown; see drainAfter for a second one.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// drainAfter returns a context canceled delay after ctx is. In between,
+// draining is set, so readiness fails while the listeners still serve and
+// the endpoints that route to this replica have time to drop it. Another
+// shutdown signal during the delay cuts it short.
+func drainAfter(ctx context.Context, delay time.Duration, draining *atomic.Bool, logger *log.Logger) context.Context {
+	stopping, stop := context.WithCancel(context.Background())
+	go func() {
+		defer stop()
+		<-ctx.Done()
+		draining.Store(true)
+		if delay <= 0 {
+			return
+		}
+		again := make(chan os.Signal, 1)
+		signal.Notify(again, shutdownSignals...)
+		defer signal.Stop(again)
+		logger.Printf("shutting down in %s; /readyz fails until then, signal again to skip the wait", delay)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case sig := <-again:
+			logger.Printf("%s during the shutdown delay; shutting down now", sig)
+		}
+	}()
+	return stopping
+}
+
+// serve runs srv until ctx is canceled, then shuts it down, giving in-flight
+// requests up to grace to finish. conns follows its connections; maxConns
+// above 0 caps how many are open at once. A listener that fails is returned
+// as an error so the group running it stops the rest of the process.
+func serve(ctx context.Context, name string, srv *http.Server, conns *connTracker, maxConns int, grace time.Duration) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("%s server: %w", name, err)
+	}
+	if maxConns > 0 {
+		ln = newLimitListener(ln, maxConns, func() { conns.waits.Inc(name) })
+	}
+	srv.ConnState = conns.hook
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case err := <-errc:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("%s server: %w", name, err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	open, started := conns.Open(), time.Now()
+	err = srv.Shutdown(shutdownCtx)
+	conns.drained(open, time.Since(started), errors.Is(err, context.DeadlineExceeded))
+	if err != nil {
+		return fmt.Errorf("%s server shutdown: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"learn_k8s/phrase1/internal/buildinfo"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/maintenance"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+)
+
+type configEntry struct{ Key, Value string }
+
+func configSnapshot() []configEntry {
+	configMu.Lock()
+	defer configMu.Unlock()
+	out := make([]configEntry, 0, len(configSeen))
+	for k, v := range configSeen {
+		out = append(out, configEntry{Key: k, Value: redact.Value(k, v)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+type statuszPage struct {
+	Build       buildinfo.Info
+	Hostname    string
+	Pod         podinfo.Identity
+	Started     time.Time
+	Uptime      time.Duration
+	Goroutines  int
+	Stats       *statsResponse
+	StatsError  string
+	Maintenance maintenance.State
+	Errors      []errreport.Recorded
+	Config      []configEntry
+	Now         time.Time
+}
+
+var statuszTemplate = template.Must(template.New("statusz").Funcs(template.FuncMap{
+	"ago": func(now, t time.Time) string { return now.Sub(t).Round(time.Second).String() },
+}).Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>statusz: {{.Hostname}}</title>
+<style>
+body { font: 14px system-ui, sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { border: 1px solid #ccc; padding: 3px 8px; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+.err { color: #b00; }
+code { font-size: 13px; }
+</style></head><body>
+<h1>api {{.Hostname}}</h1>
+
+<h2>Process</h2>
+<table>
+<tr><th>version</th><td>{{.Build.Version}} ({{.Build.Commit}}, built {{.Build.BuildTime}})</td></tr>
+<tr><th>started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}, up {{.Uptime}}</td></tr>
+<tr><th>pod</th><td>{{if .Pod.Empty}}not in Kubernetes{{else}}{{.Pod.Namespace}}/{{.Pod.Pod}} on {{.Pod.Node}}{{end}}</td></tr>
+<tr><th>runtime</th><td>{{.Build.GoVersion}}, {{.Goroutines}} goroutines</td></tr>
+<tr><th>maintenance</th><td>{{if .Maintenance.Enabled}}<span class="err">on</span>: {{.Maintenance.Message}}{{else}}off{{end}}</td></tr>
+</table>
+
+<h2>Queue</h2>
+{{with .Stats}}
+<table>
+<tr><th>queue</th><td>{{.Queue}}{{if .Paused}} <span class="err">(paused)</span>{{end}}</td></tr>
+<tr><th>depth</th><td>{{.Depth}}</td></tr>
+<tr><th>dead-letter depth</th><td>{{.DLQDepth}}</td></tr>
+<tr><th>enqueued / processed / dead-lettered</th><td>{{.EnqueuedTotal}} / {{.ProcessedTotal}} / {{.DeadLetteredTotal}}</td></tr>
+</table>
+
+<h2>Workers ({{len .Workers}})</h2>
+<table>
+<tr><th>id</th><th>pod</th><th>node</th><th>started</th><th>last seen</th><th>processed</th></tr>
+{{range .Workers}}<tr><td>{{.ID}}</td><td>{{.Pod}}</td><td>{{.Node}}</td><td>{{ago $.Now .StartedAt}} ago</td><td>{{ago $.Now .LastSeen}} ago</td><td>{{.Processed}}</td></tr>
+{{else}}<tr><td colspan="6">no live workers</td></tr>
+{{end}}</table>
+{{else}}<p class="err">stats unavailable: {{.StatsError}}</p>{{end}}
+
+<h2>Recent errors ({{len .Errors}})</h2>
+<table>
+<tr><th>when</th><th>what</th><th>error</th><th>message id</th><th>trace id</th></tr>
+{{range .Errors}}<tr><td>{{ago $.Now .Time}} ago</td><td>{{.Message}}</td><td class="err">{{.Err}}</td><td><code>{{.MessageID}}</code></td><td><code>{{.TraceID}}</code></td></tr>
+{{else}}<tr><td colspan="5">none since start</td></tr>
+{{end}}</table>
+
+<h2>Config</h2>
+<table>
+{{range .Config}}<tr><th><code>{{.Key}}</code></th><td><code>{{.Value}}</code></td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+// statusz renders a one-page HTML summary of this replica for debugging.
+func statusz(q *queue.RedisQueue, maint *maintenance.Switch, errs *errreport.Ring, pod podinfo.Identity, hostname string, started time.Time, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		now := time.Now()
+		page := statuszPage{
+			Build:       buildinfo.Get(),
+			Hostname:    hostname,
+			Pod:         pod,
+			Started:     started,
+			Uptime:      now.Sub(started).Round(time.Second),
+			Goroutines:  runtime.NumGoroutine(),
+			Maintenance: maint.State(),
+			Errors:      errs.Recent(),
+			Config:      configSnapshot(),
+			Now:         now,
+		}
+		if stats, err := queueStats(ctx, q); err != nil {
+			page.StatsError = err.Error()
+		} else {
+			page.Stats = &stats
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statuszTemplate.Execute(w, page); err != nil {
+			logger.Printf("render statusz failed: %v", err)
+		}
+	}
+}
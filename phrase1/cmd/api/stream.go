@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// streamProcessed relays processed events from the cluster-wide feed to the
+// client as Server-Sent Events until the client disconnects or the server
+// shuts down. It starts with the archived backlog the client asks for (see
+// archiveBacklog); those events carry their stream ids, so a reconnecting
+// EventSource picks up where the archive left off.
+func streamProcessed(feed *events.Bus, q *queue.RedisQueue, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribing first means nothing processed while the backlog is
+		// read is missed, though it may be sent twice.
+		ch, unsubscribe := feed.Subscribe(64)
+		defer unsubscribe()
+		backlog, err := archiveBacklog(r.Context(), q, r)
+		if err != nil {
+			logger.Printf("stream backlog failed: %v", err)
+		}
+
+		clearDeadlines(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// Stop nginx-style ingresses from buffering the stream.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		logger.Printf("stream client connected: %s", r.RemoteAddr)
+		defer logger.Printf("stream client disconnected: %s", r.RemoteAddr)
+
+		for _, m := range backlog {
+			b, err := json.Marshal(archivedEvent(m))
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: processed\nid: %s\ndata: %s\n\n", m.StreamID, b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(15 * time.Second)
+		defer keepalive.Stop()
+
+		ctx := r.Context()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case e := <-ch:
+				if e.Type != events.MessageProcessed {
+					continue
+				}
+				b, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: processed\ndata: %s\n\n", b); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
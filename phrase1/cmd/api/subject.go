@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+// requestKey returns the API key presented in X-API-Key or as a bearer
+// token, or "".
+func requestKey(r *http.Request) string {
+	key := strings.TrimSpace(r.Header.Get("X-API-Key"))
+	if key == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	return key
+}
+
+// requestSubject identifies the caller for the audit log. A presented API key
+// is only fingerprinted (never stored in clear), a JWT is named by its
+// subject, and requests without either are "anonymous".
+func requestSubject(r *http.Request) string {
+	if p, ok := rbac.FromContext(r.Context()); ok && p.Token {
+		return "jwt:" + p.Name
+	}
+	key := requestKey(r)
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// subjectSlot carries the caller require authorized back out to middleware
+// that wraps it. Those only see the request before require added the
+// principal, so on their own they'd name a JWT caller by the token's
+// fingerprint.
+type subjectSlot struct {
+	subject atomic.Pointer[string]
+}
+
+type subjectSlotKey struct{}
+
+// withSubjectSlot returns r with an empty slot for require to fill.
+func withSubjectSlot(r *http.Request) (*http.Request, *subjectSlot) {
+	slot := new(subjectSlot)
+	return r.WithContext(context.WithValue(r.Context(), subjectSlotKey{}, slot)), slot
+}
+
+// recordSubject fills r's slot, if it has one, with requestSubject(r).
+func recordSubject(r *http.Request) {
+	if slot, ok := r.Context().Value(subjectSlotKey{}).(*subjectSlot); ok {
+		s := requestSubject(r)
+		slot.subject.Store(&s)
+	}
+}
+
+// resolved returns the subject require recorded, or requestSubject(r) for a
+// request it didn't authorize.
+func (s *subjectSlot) resolved(r *http.Request) string {
+	if p := s.subject.Load(); p != nil {
+		return *p
+	}
+	return requestSubject(r)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/tenant"
+)
+
+// tenancy routes enqueues to per-tenant queues. A nil *tenancy means
+// single-tenant mode, where everything goes to the base queue.
+type tenancy struct {
+	dir     *tenant.Directory
+	limiter *tenant.RateLimiter
+	// queueFor returns the namespaced queue of a tenant.
+	queueFor func(id string) *queue.RedisQueue
+}
+
+// resolve returns the queue an enqueue from r should go to after checking the
+// tenant's quotas. On failure it writes the response and returns nil.
+func (t *tenancy) resolve(ctx context.Context, w http.ResponseWriter, r *http.Request, base *queue.RedisQueue, logger *log.Logger) *queue.RedisQueue {
+	if t == nil {
+		return base
+	}
+	tn, ok := t.lookup(w, r)
+	if !ok {
+		return nil
+	}
+	tq := t.queueFor(tn.ID)
+
+	if tn.MaxDepth > 0 {
+		depth, err := tq.Depth(ctx)
+		if err != nil {
+			logger.Printf("tenant %s depth check failed: %v", tn.ID, err)
+			writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+			return nil
+		}
+		if depth >= tn.MaxDepth {
+			writeCodedError(w, codeQueueFull, "tenant queue is full ("+strconv.FormatInt(tn.MaxDepth, 10)+" messages)", http.StatusTooManyRequests)
+			return nil
+		}
+	}
+
+	allowed, err := t.limiter.Allow(ctx, tn.ID, tn.RatePerSec)
+	if err != nil {
+		logger.Printf("tenant %s rate check failed: %v", tn.ID, err)
+		writeError(w, "enqueue failed", http.StatusServiceUnavailable)
+		return nil
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", "1")
+		writeCodedError(w, codeRateLimited, "tenant rate limit exceeded ("+strconv.FormatInt(tn.RatePerSec, 10)+"/s)", http.StatusTooManyRequests)
+		return nil
+	}
+	return tq
+}
+
+// queueOf returns the queue of the tenant r's key belongs to, without
+// checking its quotas: for requests that finish an enqueue already let in,
+// like a reservation's commit. On failure it writes the response and
+// returns nil.
+func (t *tenancy) queueOf(w http.ResponseWriter, r *http.Request, base *queue.RedisQueue) *queue.RedisQueue {
+	if t == nil {
+		return base
+	}
+	tn, ok := t.lookup(w, r)
+	if !ok {
+		return nil
+	}
+	return t.queueFor(tn.ID)
+}
+
+// queues returns base and every tenant's queue.
+func (t *tenancy) queues(base *queue.RedisQueue) []*queue.RedisQueue {
+	out := []*queue.RedisQueue{base}
+	if t == nil {
+		return out
+	}
+	for _, tn := range t.dir.Tenants() {
+		out = append(out, t.queueFor(tn.ID))
+	}
+	return out
+}
+
+// lookup returns the tenant r's key belongs to, or writes a 401.
+func (t *tenancy) lookup(w http.ResponseWriter, r *http.Request) (tenant.Tenant, bool) {
+	tn, ok := t.dir.Lookup(requestKey(r))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="queue"`)
+		writeError(w, "a valid API key is required", http.StatusUnauthorized)
+	}
+	return tn, ok
+}
+
+// tenantStats serves the stats of {tenant}'s queue to that tenant's keys.
+func tenantStats(t *tenancy, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t == nil {
+			writeError(w, "multi-tenancy is not enabled", http.StatusNotFound)
+			return
+		}
+		caller, ok := t.dir.Lookup(requestKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="queue"`)
+			writeError(w, "a valid API key is required", http.StatusUnauthorized)
+			return
+		}
+		if caller.ID != r.PathValue("tenant") {
+			writeError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := r.Context()
+		resp, err := queueStats(ctx, t.queueFor(caller.ID))
+		if err != nil {
+			logger.Printf("tenant stats failed: %v", err)
+			writeError(w, "stats failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, resp)
+	}
+}
+
+type tenantSummary struct {
+	tenant.Tenant
+	Stats queue.Stats `json:"stats"`
+}
+
+// listTenants serves every tenant's limits and queue stats.
+func listTenants(t *tenancy, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t == nil {
+			writeError(w, "multi-tenancy is not enabled", http.StatusNotFound)
+			return
+		}
+		ctx := r.Context()
+
+		out := []tenantSummary{}
+		for _, tn := range t.dir.Tenants() {
+			stats, err := t.queueFor(tn.ID).Stats(ctx)
+			if err != nil {
+				logger.Printf("tenant stats failed: %v", err)
+				writeError(w, "stats failed", http.StatusServiceUnavailable)
+				return
+			}
+			out = append(out, tenantSummary{Tenant: tn, Stats: stats})
+		}
+		writeJSON(w, out)
+	}
+}
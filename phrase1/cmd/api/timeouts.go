@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRouteTimeouts bounds each route by mux pattern; versioned routes
+// go without their /v1 prefix, and routes not listed here run unbounded
+// (the streams and bulk uploads, which run for as long as the client
+// keeps them open). HTTP_ROUTE_TIMEOUTS overrides them.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"GET /healthz":                  2 * time.Second,
+	"GET /readyz":                   2 * time.Second,
+	"POST /enqueue":                 5 * time.Second,
+	"POST /enqueue/reserve":         5 * time.Second,
+	"POST /enqueue/commit/{token}":  5 * time.Second,
+	"POST /ingest/{source}":         5 * time.Second,
+	"GET /stats":                    2 * time.Second,
+	"GET /stats/recent":             2 * time.Second,
+	"GET /stats/dlq":                2 * time.Second,
+	"GET /stats/archive":            2 * time.Second,
+	"GET /stats/aggregations":       2 * time.Second,
+	"GET /messages/{id}":            2 * time.Second,
+	"POST /messages/{id}/cancel":    2 * time.Second,
+	"POST /schedules":               2 * time.Second,
+	"GET /schedules":                2 * time.Second,
+	"GET /schedules/{id}":           2 * time.Second,
+	"PUT /schedules/{id}":           2 * time.Second,
+	"DELETE /schedules/{id}":        2 * time.Second,
+	"GET /tenants/{tenant}/stats":   2 * time.Second,
+	"GET /audit":                    2 * time.Second,
+	"GET /statusz":                  2 * time.Second,
+	"GET /admin/tenants":            5 * time.Second,
+	"GET /admin/usage":              2 * time.Second,
+	"GET /admin/slow":               2 * time.Second,
+	"GET /admin/scaling-advice":     2 * time.Second,
+	"PUT /admin/maintenance":        5 * time.Second,
+	"DELETE /admin/maintenance":     5 * time.Second,
+	"PUT /admin/schemas/{queue}":    5 * time.Second,
+	"DELETE /admin/schemas/{queue}": 5 * time.Second,
+	"PUT /admin/pause":              5 * time.Second,
+	"DELETE /admin/pause":           5 * time.Second,
+	"POST /admin/purge":             5 * time.Second,
+	"PUT /admin/loglevel":           2 * time.Second,
+	"POST /admin/keys":              5 * time.Second,
+	"DELETE /admin/keys/{id}":       5 * time.Second,
+	"POST /admin/keys/{id}/rotate":  5 * time.Second,
+}
+
+// routeTimeouts maps mux patterns to how long their requests may take.
+type routeTimeouts map[string]time.Duration
+
+// loadRouteTimeouts reads HTTP_ROUTE_TIMEOUTS, "pattern=duration" pairs
+// separated by commas, over the defaults; a zero duration lifts a route's
+// limit.
+func loadRouteTimeouts() (routeTimeouts, error) {
+	t := routeTimeouts{}
+	for pattern, d := range defaultRouteTimeouts {
+		t[pattern] = d
+	}
+	for _, pair := range envList("HTTP_ROUTE_TIMEOUTS") {
+		pattern, v, ok := strings.Cut(pair, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if !ok || err != nil || d < 0 {
+			return nil, fmt.Errorf("HTTP_ROUTE_TIMEOUTS: %q isn't pattern=duration", pair)
+		}
+		t[strings.Join(strings.Fields(pattern), " ")] = d
+	}
+	return t, nil
+}
+
+// requestTimeoutHeader lets a client ask for less time than the route
+// allows, e.g. "X-Request-Timeout: 1500ms".
+const requestTimeoutHeader = "X-Request-Timeout"
+
+type budgetKey struct{}
+
+// requestBudget returns how long the request was given in all, or 0 if it
+// has no limit.
+func requestBudget(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(budgetKey{}).(time.Duration)
+	return d
+}
+
+// withTimeouts gives each request to mux the timeout of the route it
+// matches, or the client's shorter X-Request-Timeout, as a context deadline
+// that handlers and the Redis calls they make observe. next serves the
+// request; it's mux itself, wrapped.
+func withTimeouts(t routeTimeouts, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		d := t[pattern]
+		if v := r.Header.Get(requestTimeoutHeader); v != "" && pattern != "" {
+			client, err := time.ParseDuration(v)
+			if err != nil || client <= 0 {
+				writeError(w, requestTimeoutHeader+" must be a positive duration such as 2s", http.StatusBadRequest)
+				return
+			}
+			if d == 0 || client < d {
+				d = client
+			}
+		}
+		if d <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, budgetKey{}, d)))
+	})
+}
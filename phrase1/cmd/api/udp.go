@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/syslog"
+)
+
+// listenUDP enqueues every line received on addr until ctx is canceled. With
+// parseSyslog, syslog headers are parsed into envelope metadata and only the
+// message text is queued; otherwise lines are queued verbatim.
+func listenUDP(ctx context.Context, addr string, parseSyslog bool, q queue.Queue, bus *events.Bus, hostname string, reporter errreport.Reporter, logger *log.Logger) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	logger.Printf("udp listener on %s (syslog=%t)", conn.LocalAddr(), parseSyslog)
+
+	// 64 KiB is the largest possible UDP payload.
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Printf("udp read error: %v", err)
+			continue
+		}
+		peer := from.String()
+		if h, _, err := net.SplitHostPort(peer); err == nil {
+			peer = h
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimRight(line, "\r\x00")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			enqueueUDPLine(ctx, line, peer, parseSyslog, q, bus, hostname, reporter, logger)
+		}
+	}
+}
+
+func enqueueUDPLine(ctx context.Context, line, peer string, parseSyslog bool, q queue.Queue, bus *events.Bus, hostname string, reporter errreport.Reporter, logger *log.Logger) {
+	envlp := envelope.New(line)
+	envlp.Source = "udp:" + peer
+	if parseSyslog {
+		m := syslog.Parse(line)
+		envlp.Source = "syslog:" + peer
+		envlp.Payload = m.Text
+		if m.Format != "" {
+			envlp.Metadata = map[string]string{
+				"syslog_format":   m.Format,
+				"syslog_facility": strconv.Itoa(m.Facility),
+				"syslog_severity": m.SeverityName(),
+			}
+			if m.Hostname != "" {
+				envlp.Metadata["syslog_hostname"] = m.Hostname
+			}
+			if m.AppName != "" {
+				envlp.Metadata["syslog_app"] = m.AppName
+			}
+		}
+	}
+
+	encoded, err := q.Encode(envlp)
+	if err != nil {
+		logger.Printf("encode envelope failed: %v", err)
+		return
+	}
+	// UDP senders can't be told to retry, so a failed enqueue is dropped.
+	enqCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := q.Enqueue(enqCtx, encoded); err != nil {
+		logger.Printf("udp enqueue failed, dropping line from %s: %v", peer, err)
+		reporter.Report(errreport.Event{Err: err, Message: "udp enqueue failed", MessageID: envlp.ID, Tags: map[string]string{"source": envlp.Source}})
+		return
+	}
+	bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: q.Name(), Message: envlp.Payload, Source: hostname, Subject: envlp.Source})
+}
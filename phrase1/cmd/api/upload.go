@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"learn_k8s/phrase1/internal/queue"
+)
+
+const multipartForm = "multipart/form-data"
+
+// fileHandler streams a multipart/form-data upload into base or the
+// caller's tenant queue: each line of each file becomes a message, or each
+// whole file with ?split=file. Form fields that aren't files are skipped.
+// Messages are enqueued and answered as for handler, with each result
+// naming its file; parts are read as they arrive, so files can be
+// arbitrarily large with one message per line.
+func (b *bulkEnqueuer) fileHandler(base *queue.RedisQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance(w, b.maint) {
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != multipartForm {
+			w.Header().Set("Accept-Post", multipartForm)
+			writeError(w, "content type must be "+multipartForm, http.StatusUnsupportedMediaType)
+			return
+		}
+		wholeFiles := false
+		switch r.URL.Query().Get("split") {
+		case "", "lines":
+		case "file":
+			wholeFiles = true
+		default:
+			writeError(w, "split must be lines or file", http.StatusBadRequest)
+			return
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, "body is not multipart: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s := b.start(w, r, base)
+		if s == nil {
+			return
+		}
+
+		var readErr string
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				readErr = "failed to read body"
+				break
+			}
+			name := part.FileName()
+			if name == "" {
+				continue
+			}
+			var more bool
+			if wholeFiles {
+				more, readErr = b.addFile(r, s, name, part)
+			} else {
+				more, readErr = b.addLines(r, s, name, part)
+			}
+			if !more {
+				break
+			}
+		}
+		b.finish(r, s, readErr)
+	}
+}
+
+// addFile adds the file name as one message. It returns whether to go on
+// with the upload, and why reading it failed, if it did.
+func (b *bulkEnqueuer) addFile(r *http.Request, s *bulkStream, name string, file io.Reader) (bool, string) {
+	body, err := io.ReadAll(io.LimitReader(file, bulkMaxLine+1))
+	if err != nil {
+		return false, "failed to read body"
+	}
+	if len(body) > bulkMaxLine {
+		s.chunk.results = append(s.chunk.results, bulkResult{File: name, Error: fmt.Sprintf("file is longer than %d bytes", bulkMaxLine)})
+	} else {
+		b.add(s, bulkResult{File: name}, strings.TrimSpace(string(body)), 0)
+	}
+	return b.next(r, s), ""
+}
+
+// addLines adds each line of the file name as a message, skipping blank
+// ones. It returns whether to go on with the upload, and why reading it
+// failed, if it did.
+func (b *bulkEnqueuer) addLines(r *http.Request, s *bulkStream, name string, file io.Reader) (bool, string) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), bulkMaxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		b.add(s, bulkResult{File: name, Line: line}, text, 0)
+		if !b.next(r, s) {
+			return false, ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return false, fmt.Sprintf("%s: line %d is longer than %d bytes", name, line+1, bulkMaxLine)
+		}
+		return false, "failed to read body"
+	}
+	return true, ""
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/usage"
+)
+
+// secondsUntilTomorrow is the Retry-After for a caller over its daily quota.
+func secondsUntilTomorrow(now time.Time) int {
+	now = now.UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(tomorrow.Sub(now).Seconds()) + 1
+}
+
+// adminUsage serves per-key usage for ?day=YYYY-MM-DD (default today),
+// optionally narrowed to one ?subject=.
+func adminUsage(tracker *usage.Tracker, quotas usage.Quotas, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		day := r.URL.Query().Get("day")
+		if day == "" {
+			day = usage.Day(time.Now())
+		} else if _, err := time.Parse(time.DateOnly, day); err != nil {
+			writeError(w, "day must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		subject := r.URL.Query().Get("subject")
+
+		ctx := r.Context()
+		all, err := tracker.ForDay(ctx, day)
+		if err != nil {
+			logger.Printf("usage query failed: %v", err)
+			writeError(w, "usage query failed", http.StatusServiceUnavailable)
+			return
+		}
+
+		out := make([]usage.Usage, 0, len(all))
+		for _, u := range all {
+			if subject != "" && u.Subject != subject {
+				continue
+			}
+			if q := quotas.For(u.Subject); q != (usage.Quota{}) {
+				u.Quota = &q
+			}
+			out = append(out, u)
+		}
+		writeJSON(w, out)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/validate"
+)
+
+// enqueueRules are the VALIDATION_FILE rules checked before enqueueing, with
+// rejections counted per queue and rule.
+type enqueueRules struct {
+	set     *validate.Set
+	rejects *metrics.Counter
+}
+
+// check returns the rules in breaks, or nil.
+func (v *enqueueRules) check(in validate.Input) *validate.Error {
+	var ve *validate.Error
+	if err := v.set.Check(in); !errors.As(err, &ve) {
+		return nil
+	}
+	for _, violation := range ve.Violations {
+		v.rejects.Inc(in.Queue, violation.Rule)
+	}
+	return ve
+}
+
+func writeValidationError(w http.ResponseWriter, queueName string, ve *validate.Error) {
+	writeAPIError(w, http.StatusUnprocessableEntity, apiError{Code: codeValidationFailed, Message: "message breaks enqueue rules", Queue: queueName, Violations: ve.Violations})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// apiVersion prefixes the versioned routes. A /v2 would get its own mux,
+// mounted next to this one, with handlers shared where nothing changed.
+const apiVersion = "/v1"
+
+// mountVersioned serves api under apiVersion and, for clients that predate
+// it, at its old unprefixed paths with deprecation headers. Legacy hits are
+// counted per route so it's clear when the old paths can go. Both get the
+// routes' timeouts.
+func mountVersioned(mux, api *http.ServeMux, timeouts routeTimeouts, reg *metrics.Registry) {
+	legacy := reg.NewCounter("http_legacy_requests_total", "Requests to API routes without the "+apiVersion+" prefix.", "route")
+	h := withTimeouts(timeouts, api, muxErrors(api))
+	mux.Handle(apiVersion+"/", http.StripPrefix(apiVersion, h))
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := api.Handler(r); pattern != "" {
+			successor := apiVersion + r.URL.Path
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			w.Header().Add("Warning", `299 - "deprecated API path, use `+successor+`"`)
+			legacy.Inc(pattern)
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
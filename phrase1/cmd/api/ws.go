@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"learn_k8s/phrase1/internal/events"
+)
+
+// wsEvents streams lifecycle events from the cluster-wide feed to a WebSocket
+// client as JSON text frames. originPatterns lists extra allowed Origin
+// hosts; same-origin requests are always accepted.
+func wsEvents(feed *events.Bus, originPatterns []string, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The hijacked connection keeps whatever deadlines the server set.
+		clearDeadlines(w)
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: originPatterns})
+		if err != nil {
+			// Accept has already written an error response.
+			logger.Printf("websocket accept failed: %v", err)
+			return
+		}
+		defer c.CloseNow()
+
+		// We never expect data from the client; CloseRead handles pings/close
+		// frames and cancels ctx when the client goes away.
+		ctx := c.CloseRead(r.Context())
+
+		ch, unsubscribe := feed.Subscribe(64)
+		defer unsubscribe()
+
+		logger.Printf("websocket client connected: %s", r.RemoteAddr)
+		defer logger.Printf("websocket client disconnected: %s", r.RemoteAddr)
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				c.Close(websocket.StatusGoingAway, "server shutting down")
+				return
+			case <-ping.C:
+				pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				err := c.Ping(pingCtx)
+				cancel()
+				if err != nil {
+					return
+				}
+			case e := <-ch:
+				b, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				err = c.Write(writeCtx, websocket.MessageText, b)
+				cancel()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
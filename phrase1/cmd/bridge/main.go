@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/asynq"
+	"learn_k8s/phrase1/internal/bridge"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redisfailover"
+)
+
+func env(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// envDuration parses a Go duration such as "750ms" or "2s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+func envList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func main() {
+	queueName := env("QUEUE_NAME", "messages")
+	mode := env("BRIDGE_MODE", "sqs")
+	direction := env("BRIDGE_DIRECTION", "in")
+	outboundName := env("BRIDGE_OUTBOUND_QUEUE", queueName+":outbound")
+
+	pod := podinfo.Identity{Pod: env("POD_NAME", ""), Namespace: env("POD_NAMESPACE", ""), Node: env("NODE_NAME", "")}
+	logger := log.New(os.Stdout, pod.LogPrefix("bridge "), log.LstdFlags|log.Lmicroseconds)
+
+	// The bridge doesn't fail over: it stays on the first REDIS_ADDR.
+	redisAddrs, err := redisfailover.ParseAddrs(env("REDIS_ADDR", "redis:6379"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	redisAddr := redisAddrs[0]
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Username: env("REDIS_USERNAME", ""),
+		Password: env("REDIS_PASSWORD", ""),
+	})
+	// Inbound messages are acknowledged at the source only once
+	// REDIS_WAIT_REPLICAS replicas have them.
+	q := queue.NewRedisQueue(rdb, queueName)
+	q.SetReplication(queue.Replication{Replicas: envInt("REDIS_WAIT_REPLICAS", 0), Timeout: envDuration("REDIS_WAIT_TIMEOUT", time.Second)})
+	outbound := queue.NewRedisQueue(rdb, outboundName)
+	encoding, err := envelope.ParseEncoding(env("ENVELOPE_ENCODING", "json"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	q.SetEncoding(encoding)
+	hostname, _ := os.Hostname()
+
+	bus := events.NewBus()
+	eventsTransport := events.NewRedisTransport(rdb, q.EventsChannel(), func(err error) {
+		logger.Printf("event transport error: %v", err)
+	})
+	detachTransport := bus.Attach(eventsTransport, 256)
+	onEnqueued := func(e envelope.Envelope) {
+		bus.Publish(events.Event{Type: events.MessageEnqueued, Queue: queueName, Message: e.Payload, Source: hostname, Subject: e.Source})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	switch mode {
+	case "sqs":
+		fromBroker := direction == "in" || direction == "both"
+		toBroker := direction == "out" || direction == "both"
+		if !fromBroker && !toBroker {
+			logger.Fatalf("unknown BRIDGE_DIRECTION %q (want in, out, or both)", direction)
+		}
+
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logger.Fatalf("aws config: %v", err)
+		}
+		client := sqs.NewFromConfig(awsCfg)
+		sqsCfg := bridge.SQSConfig{
+			QueueURL:    env("SQS_QUEUE_URL", ""),
+			WaitTime:    time.Duration(envInt("SQS_WAIT_SECONDS", 20)) * time.Second,
+			MaxMessages: int32(envInt("SQS_MAX_MESSAGES", 10)),
+		}
+		if sqsCfg.QueueURL == "" {
+			logger.Fatalf("SQS_QUEUE_URL is required")
+		}
+		logger.Printf("starting sqs bridge (redis=%s queue=%s outbound=%s sqs=%s direction=%s)", redisAddr, queueName, outboundName, sqsCfg.QueueURL, direction)
+
+		if fromBroker {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bridge.SQSInbound(ctx, client, sqsCfg, q, onEnqueued, logger); err != nil {
+					logger.Printf("sqs inbound error: %v", err)
+				}
+			}()
+		}
+		if toBroker {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bridge.SQSOutbound(ctx, client, sqsCfg, outbound, logger); err != nil {
+					logger.Printf("sqs outbound error: %v", err)
+				}
+			}()
+		}
+	case "mqtt":
+		mqttCfg := bridge.MQTTConfig{
+			Broker:       env("MQTT_BROKER", "tcp://mosquitto:1883"),
+			ClientID:     env("MQTT_CLIENT_ID", "learn_k8s-bridge-"+hostname),
+			Username:     env("MQTT_USERNAME", ""),
+			Password:     env("MQTT_PASSWORD", ""),
+			Topics:       envList("MQTT_TOPICS"),
+			QoS:          byte(envInt("MQTT_QOS", 1)),
+			ResultsTopic: env("MQTT_RESULTS_TOPIC", ""),
+		}
+		if len(mqttCfg.Topics) == 0 {
+			mqttCfg.Topics = []string{"sensors/#"}
+		}
+		logger.Printf("starting mqtt bridge (redis=%s queue=%s broker=%s topics=%v results=%q)", redisAddr, queueName, mqttCfg.Broker, mqttCfg.Topics, mqttCfg.ResultsTopic)
+
+		// Processed events come from the workers over Redis pub/sub, so the
+		// bridge relays them into its own bus to publish results back.
+		var results <-chan events.Event
+		if mqttCfg.ResultsTopic != "" {
+			feed := events.NewBus()
+			go eventsTransport.Relay(ctx, feed)
+			ch, unsubscribe := feed.Subscribe(256)
+			defer unsubscribe()
+			results = ch
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bridge.RunMQTT(ctx, mqttCfg, q, results, onEnqueued, logger); err != nil {
+				logger.Printf("mqtt bridge error: %v", err)
+			}
+		}()
+	case "asynq":
+		fromBroker := direction == "in" || direction == "both"
+		toBroker := direction == "out" || direction == "both"
+		if !fromBroker && !toBroker {
+			logger.Fatalf("unknown BRIDGE_DIRECTION %q (want in, out, or both)", direction)
+		}
+		tasks := asynq.NewQueue(rdb, env("ASYNQ_QUEUE", "default"), q)
+		tasks.SetTaskType(env("ASYNQ_TASK_TYPE", asynq.DefaultTaskType))
+		logger.Printf("starting asynq bridge (redis=%s queue=%s outbound=%s asynq_queue=%s direction=%s)", redisAddr, queueName, outboundName, tasks.Name(), direction)
+
+		if fromBroker {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bridge.AsynqInbound(ctx, tasks, q, onEnqueued, logger); err != nil {
+					logger.Printf("asynq inbound error: %v", err)
+				}
+			}()
+		}
+		if toBroker {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bridge.AsynqOutbound(ctx, outbound, tasks, logger); err != nil {
+					logger.Printf("asynq outbound error: %v", err)
+				}
+			}()
+		}
+	default:
+		logger.Fatalf("unknown BRIDGE_MODE %q (want sqs, mqtt, or asynq)", mode)
+	}
+
+	wg.Wait()
+	detachTransport()
+	_ = rdb.Close()
+	logger.Printf("shutdown complete")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/dispatch"
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+// apiError is the body of every error response, as the api sends them.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Code: strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"), Message: msg})
+}
+
+// requestKey is the credential presented in X-API-Key or as a bearer token.
+func requestKey(r *http.Request) string {
+	key := strings.TrimSpace(r.Header.Get("X-API-Key"))
+	if key == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	return key
+}
+
+// require only lets callers holding role through to h. With a nil authorizer
+// RBAC is off and h is returned as is.
+func require(az *rbac.Authorizer, role rbac.Role, h http.HandlerFunc) http.HandlerFunc {
+	if az == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := az.Authorize(requestKey(r), role)
+		switch {
+		case errors.Is(err, rbac.ErrForbidden):
+			writeError(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dispatcher"`)
+			writeError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r.WithContext(rbac.WithPrincipal(r.Context(), p)))
+	}
+}
+
+// subject names the caller in log lines.
+func subject(r *http.Request) string {
+	if p, ok := rbac.FromContext(r.Context()); ok {
+		return p.Name
+	}
+	return "anonymous"
+}
+
+// withoutSecret is sub as listed: the secret is only shown on create.
+func withoutSecret(sub dispatch.Subscription) dispatch.Subscription {
+	sub.Secret = ""
+	return sub
+}
+
+// createSubscription registers {"url", "secret", "filter"}; secret is
+// generated when left out.
+func createSubscription(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			URL    string          `json:"url"`
+			Secret string          `json:"secret"`
+			Filter dispatch.Filter `json:"filter"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, "body must be a JSON object with url, and optionally secret and filter", http.StatusBadRequest)
+			return
+		}
+		sub, err := store.Create(r.Context(), req.URL, req.Secret, req.Filter)
+		if errors.Is(err, dispatch.ErrInvalid) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Printf("create subscription failed: %v", err)
+			writeError(w, "create subscription failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("created subscription %s for %s by %s", sub.ID, sub.URL, subject(r))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(sub)
+	}
+}
+
+func listSubscriptions(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subs, err := store.List(r.Context())
+		if err != nil {
+			logger.Printf("list subscriptions failed: %v", err)
+			writeError(w, "list subscriptions failed", http.StatusServiceUnavailable)
+			return
+		}
+		for i := range subs {
+			subs[i] = withoutSecret(subs[i])
+		}
+		writeJSON(w, subs)
+	}
+}
+
+func getSubscription(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, err := store.Get(r.Context(), r.PathValue("id"))
+		if errors.Is(err, dispatch.ErrNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("get subscription failed: %v", err)
+			writeError(w, "get subscription failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, withoutSecret(sub))
+	}
+}
+
+func deleteSubscription(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := store.Delete(r.Context(), id)
+		if errors.Is(err, dispatch.ErrNotFound) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Printf("delete subscription failed: %v", err)
+			writeError(w, "delete subscription failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("deleted subscription %s by %s", id, subject(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deadLetters returns a subscription's dead-lettered deliveries, newest
+// first; ?limit= caps them (default 50).
+func deadLetters(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := r.PathValue("id")
+		limit := int64(50)
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if _, err := store.Get(ctx, id); err != nil {
+			subscriptionError(w, err, logger)
+			return
+		}
+		count, ds, err := store.DeadLettered(ctx, id, limit)
+		if err != nil {
+			logger.Printf("read dead letters failed: %v", err)
+			writeError(w, "read dead letters failed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, struct {
+			Count      int64               `json:"count"`
+			Deliveries []dispatch.Delivery `json:"deliveries"`
+		}{count, ds})
+	}
+}
+
+// redrive puts a subscription's dead letters back on the schedule.
+func redrive(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := r.PathValue("id")
+		if _, err := store.Get(ctx, id); err != nil {
+			subscriptionError(w, err, logger)
+			return
+		}
+		n, err := store.Redrive(ctx, id, time.Now())
+		if err != nil {
+			logger.Printf("redrive failed: %v", err)
+			writeError(w, "redrive failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("redrove %d deliveries of subscription %s by %s", n, id, subject(r))
+		writeJSON(w, map[string]int{"redriven": n})
+	}
+}
+
+// purgeDeadLetters drops a subscription's dead letters.
+func purgeDeadLetters(store *dispatch.Store, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := r.PathValue("id")
+		if _, err := store.Get(ctx, id); err != nil {
+			subscriptionError(w, err, logger)
+			return
+		}
+		n, err := store.Purge(ctx, id)
+		if err != nil {
+			logger.Printf("purge dead letters failed: %v", err)
+			writeError(w, "purge dead letters failed", http.StatusServiceUnavailable)
+			return
+		}
+		logger.Printf("purged %d dead letters of subscription %s by %s", n, id, subject(r))
+		writeJSON(w, map[string]int{"purged": n})
+	}
+}
+
+func subscriptionError(w http.ResponseWriter, err error, logger *log.Logger) {
+	if errors.Is(err, dispatch.ErrNotFound) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Printf("get subscription failed: %v", err)
+	writeError(w, "get subscription failed", http.StatusServiceUnavailable)
+}
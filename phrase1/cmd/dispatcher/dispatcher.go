@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/dispatch"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// dispatcher fans the processed archive out to subscriptions and delivers
+// the result. Both halves keep their state in Redis, so replicas share the
+// work: fan-out reads the archive as a consumer group, and deliveries are
+// claimed from a shared schedule with a lease.
+type dispatcher struct {
+	store     *dispatch.Store
+	consumer  string
+	group     string
+	batch     int64
+	claimIdle time.Duration
+	client    *http.Client
+	retry     dispatch.Retry
+	lease     time.Duration
+	poll      time.Duration
+	logger    *log.Logger
+
+	fannedOut  *metrics.Counter
+	deliveries *metrics.Counter
+	seconds    *metrics.Histogram
+	pending    *metrics.Gauge
+}
+
+// fanOut schedules a delivery of each message archived on q to every
+// subscription whose filter it matches, until ctx is canceled. Archive
+// entries are acked once their deliveries are scheduled; a batch that
+// can't be is read again after claimIdle.
+func (d *dispatcher) fanOut(ctx context.Context, q *queue.RedisQueue) error {
+	reader, err := q.ArchiveReader(ctx, d.group, d.consumer)
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		msgs, err := reader.Read(ctx, d.batch, time.Second, d.claimIdle)
+		if err == nil && len(msgs) > 0 {
+			err = d.schedule(ctx, q, reader, msgs)
+		}
+		if err != nil && ctx.Err() == nil {
+			d.logger.Printf("fan-out %s: %v", q.Name(), err)
+			sleepCtx(ctx, time.Second)
+		}
+	}
+	return nil
+}
+
+func (d *dispatcher) schedule(ctx context.Context, q *queue.RedisQueue, reader *queue.ArchiveReader, msgs []queue.ArchivedMessage) error {
+	subs, err := d.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	var ds []dispatch.Delivery
+	ids := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.StreamID)
+		// A pending entry trimmed from the stream since comes back empty.
+		if m.ID == "" {
+			continue
+		}
+		for _, sub := range subs {
+			if sub.Filter.Matches(m) {
+				ds = append(ds, dispatch.NewDelivery(sub, m))
+			}
+		}
+	}
+	n, err := d.store.Schedule(ctx, time.Now(), ds...)
+	if err != nil {
+		return err
+	}
+	d.fannedOut.Add(float64(n), q.Name())
+	return reader.Ack(ctx, ids...)
+}
+
+// deliver claims due deliveries one at a time and sends them until ctx is
+// canceled, waiting poll between tries while none are due.
+func (d *dispatcher) deliver(ctx context.Context) error {
+	for ctx.Err() == nil {
+		claimed, err := d.store.Claim(ctx, time.Now(), d.lease, 1)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Printf("claim deliveries: %v", err)
+				sleepCtx(ctx, time.Second)
+			}
+			continue
+		}
+		if len(claimed) == 0 {
+			sleepCtx(ctx, d.poll)
+			continue
+		}
+		d.send(ctx, claimed[0])
+	}
+	return nil
+}
+
+// send tries one delivery and settles it: dropped once delivered, retried
+// later or dead-lettered if it failed. A delivery that can't be settled is
+// due again when its lease runs out.
+func (d *dispatcher) send(ctx context.Context, dl dispatch.Delivery) {
+	// Settled even when ctx is canceled mid-send, so a shutdown doesn't
+	// leave it waiting out its lease.
+	settle := context.WithoutCancel(ctx)
+	sub, err := d.store.Get(ctx, dl.Subscription)
+	if errors.Is(err, dispatch.ErrNotFound) {
+		if err := d.store.Drop(settle, dl); err != nil {
+			d.logger.Printf("drop delivery %s: %v", dl.ID, err)
+		}
+		d.deliveries.Inc(dl.Subscription, "dropped")
+		return
+	}
+	if err != nil {
+		d.logger.Printf("delivery %s: load subscription: %v", dl.ID, err)
+		return
+	}
+
+	start := time.Now()
+	_, err = dispatch.Send(ctx, d.client, sub, dl, start)
+	d.seconds.Observe(time.Since(start).Seconds())
+	if err == nil {
+		if err := d.store.Drop(settle, dl); err != nil {
+			d.logger.Printf("settle delivery %s: %v", dl.ID, err)
+		}
+		d.deliveries.Inc(sub.ID, "delivered")
+		return
+	}
+	if ctx.Err() != nil {
+		// Interrupted by shutdown rather than failed: it's tried again
+		// after the lease, without using up an attempt.
+		return
+	}
+	dead, serr := d.store.Failed(settle, dl, err, errors.Is(err, dispatch.ErrGone), d.retry, time.Now())
+	if serr != nil {
+		d.logger.Printf("settle delivery %s: %v", dl.ID, serr)
+		return
+	}
+	if dead {
+		d.deliveries.Inc(sub.ID, "dead_lettered")
+		d.logger.Printf("dead-lettered delivery %s after %d attempts: %v", dl.ID, dl.Attempts+1, err)
+		return
+	}
+	d.deliveries.Inc(sub.ID, "retried")
+}
+
+// watchPending keeps the pending gauge current until ctx is canceled.
+func (d *dispatcher) watchPending(ctx context.Context, every time.Duration) {
+	for {
+		if n, err := d.store.Pending(ctx); err == nil {
+			d.pending.Set(float64(n))
+		}
+		if !sleepCtx(ctx, every) {
+			return
+		}
+	}
+}
+
+// sleepCtx waits for d, and reports false if ctx was canceled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/errgroup"
+
+	"learn_k8s/phrase1/internal/buildinfo"
+	"learn_k8s/phrase1/internal/dispatch"
+	"learn_k8s/phrase1/internal/health"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/rbac"
+	"learn_k8s/phrase1/internal/redisfailover"
+	"learn_k8s/phrase1/internal/redismetrics"
+)
+
+func env(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// envDuration parses a Go duration such as "750ms" or "2s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+func envList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// newAuthorizer enables RBAC when a keys file or JWT secret is configured and
+// returns nil otherwise.
+func newAuthorizer(path, jwtSecret string) (*rbac.Authorizer, error) {
+	if path == "" && jwtSecret == "" {
+		return nil, nil
+	}
+	var principals []rbac.Principal
+	if path != "" {
+		var err error
+		if principals, err = rbac.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return rbac.NewAuthorizer(principals, []byte(jwtSecret))
+}
+
+// The dispatcher turns processed messages into webhooks. Consumers register
+// subscriptions (an endpoint, a signing secret, and a filter) through its
+// API; it reads the processed archive of each queue in DISPATCH_QUEUES,
+// fans every message out to the subscriptions it matches, and POSTs it to
+// each, retrying with backoff and dead-lettering per subscription.
+func main() {
+	redisAddr := env("REDIS_ADDR", "redis:6379")
+	httpAddr := env("HTTP_ADDR", ":8090")
+	queues := envList("DISPATCH_QUEUES")
+	if len(queues) == 0 {
+		queues = []string{env("QUEUE_NAME", "messages")}
+	}
+
+	pod := podinfo.Identity{Pod: env("POD_NAME", ""), Namespace: env("POD_NAMESPACE", ""), Node: env("NODE_NAME", "")}
+	logger := log.New(os.Stdout, pod.LogPrefix("dispatcher "), log.LstdFlags|log.Lmicroseconds)
+
+	redisAddrs, err := redisfailover.ParseAddrs(redisAddr)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddrs[0],
+		Username: env("REDIS_USERNAME", ""),
+		Password: env("REDIS_PASSWORD", ""),
+	})
+	defer rdb.Close()
+	hostname, _ := os.Hostname()
+
+	authz, err := newAuthorizer(env("RBAC_FILE", ""), os.Getenv("JWT_SECRET"))
+	if err != nil {
+		logger.Fatalf("rbac: %v", err)
+	}
+
+	reg := metrics.NewRegistry()
+	reg.LimitQueues(envList("METRICS_QUEUE_LABELS"), envInt("METRICS_QUEUE_MAX", metrics.DefaultMaxQueues))
+	redismetrics.Instrument(rdb, reg)
+	var redisSwitch *redisfailover.Switch
+	// REDIS_ADDR may list standbys; see redisfailover.
+	if len(redisAddrs) > 1 {
+		redisSwitch = redisfailover.New(redisAddrs, rdb.Options(), envDuration("REDIS_FAILOVER_AFTER", 10*time.Second), reg, logger)
+		rdb.AddHook(redisSwitch)
+	}
+	timeout := envDuration("DISPATCH_TIMEOUT", 10*time.Second)
+	store := dispatch.NewStore(rdb, env("DISPATCH_PREFIX", "dispatch:"))
+	d := &dispatcher{
+		store:     store,
+		consumer:  hostname,
+		group:     env("DISPATCH_GROUP", "dispatcher"),
+		batch:     int64(envInt("DISPATCH_BATCH", 100)),
+		claimIdle: envDuration("DISPATCH_CLAIM_IDLE", time.Minute),
+		client:    &http.Client{Timeout: timeout},
+		retry: dispatch.Retry{
+			MaxAttempts: envInt("DISPATCH_MAX_ATTEMPTS", 8),
+			Backoff:     envDuration("DISPATCH_BACKOFF", 5*time.Second),
+			MaxBackoff:  envDuration("DISPATCH_MAX_BACKOFF", time.Hour),
+		},
+		// Long enough that a delivery still being sent isn't claimed again.
+		lease:  2*timeout + 5*time.Second,
+		poll:   envDuration("DISPATCH_POLL_INTERVAL", 500*time.Millisecond),
+		logger: logger,
+
+		fannedOut:  reg.NewCounter("dispatcher_fanned_out_total", "Deliveries scheduled for processed messages matching a subscription.", "queue"),
+		deliveries: reg.NewCounter("dispatcher_deliveries_total", "Delivery attempts by subscription and result: delivered, retried, dead_lettered, or dropped (subscription deleted).", "subscription", "result"),
+		seconds:    reg.NewHistogram("dispatcher_delivery_seconds", "Time taken by a webhook POST.", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}),
+		pending:    reg.NewGauge("dispatcher_pending_deliveries", "Deliveries waiting to be tried, across all dispatchers."),
+	}
+	concurrency := max(envInt("DISPATCH_CONCURRENCY", 8), 1)
+
+	healthChecks := health.NewRegistry(2 * time.Second)
+	healthChecks.Register(health.RedisPing(rdb))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /health", healthChecks.Handler())
+	mux.Handle("GET /metrics", reg.Handler())
+	mux.HandleFunc("POST /subscriptions", require(authz, rbac.Operator, createSubscription(store, logger)))
+	mux.HandleFunc("GET /subscriptions", require(authz, rbac.Operator, listSubscriptions(store, logger)))
+	mux.HandleFunc("GET /subscriptions/{id}", require(authz, rbac.Operator, getSubscription(store, logger)))
+	mux.HandleFunc("DELETE /subscriptions/{id}", require(authz, rbac.Operator, deleteSubscription(store, logger)))
+	mux.HandleFunc("GET /subscriptions/{id}/dlq", require(authz, rbac.Operator, deadLetters(store, logger)))
+	mux.HandleFunc("POST /subscriptions/{id}/dlq/redrive", require(authz, rbac.Operator, redrive(store, logger)))
+	mux.HandleFunc("DELETE /subscriptions/{id}/dlq", require(authz, rbac.Operator, purgeDeadLetters(store, logger)))
+	srv := &http.Server{Addr: httpAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	logger.Printf("starting dispatcher (redis=%s queues=%s http=%s concurrency=%d version=%s)", redisAddr, strings.Join(queues, ","), httpAddr, concurrency, buildinfo.Get().Version)
+
+	g, gctx := errgroup.WithContext(ctx)
+	if redisSwitch != nil {
+		go redisSwitch.Run(gctx, envDuration("REDIS_HEALTH_INTERVAL", time.Second))
+	}
+	g.Go(func() error {
+		return serve(gctx, "http", srv, 5*time.Second)
+	})
+	for _, name := range queues {
+		q := queue.NewRedisQueue(rdb, name)
+		g.Go(func() error { return d.fanOut(gctx, q) })
+	}
+	for range concurrency {
+		g.Go(func() error { return d.deliver(gctx) })
+	}
+	go d.watchPending(gctx, 10*time.Second)
+
+	if err := g.Wait(); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	logger.Printf("shutdown complete")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/redisfailover"
+)
+
+// doctor validates the environment the api and worker would run in and prints
+// actionable diagnostics. It reads the same env vars as the other binaries so
+// it can be run with `docker compose exec worker /doctor` (or `kubectl exec`).
+
+type status string
+
+const (
+	statusOK   status = "OK"
+	statusWarn status = "WARN"
+	statusFail status = "FAIL"
+)
+
+type result struct {
+	name   string
+	status status
+	detail string
+	hint   string
+}
+
+func env(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func checkConfig(component string) []result {
+	var out []result
+
+	// REDIS_ADDR may list standbys after the primary; each must parse.
+	redisAddr := env("REDIS_ADDR", "redis:6379")
+	addrs, err := redisfailover.ParseAddrs(redisAddr)
+	if err != nil {
+		out = append(out, result{"config REDIS_ADDR", statusFail, err.Error(),
+			"set REDIS_ADDR to something like redis:6379"})
+	}
+	redisOK := err == nil
+	for _, addr := range addrs {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			out = append(out, result{"config REDIS_ADDR", statusFail, fmt.Sprintf("%q is not host:port: %v", addr, err),
+				"set REDIS_ADDR to something like redis:6379 (compose) or my-redis.default.svc:6379 (k8s)"})
+			redisOK = false
+		} else if _, err := strconv.Atoi(port); err != nil {
+			out = append(out, result{"config REDIS_ADDR", statusFail, fmt.Sprintf("port %q is not a number", port),
+				"set REDIS_ADDR to something like redis:6379"})
+			redisOK = false
+		}
+	}
+	if redisOK {
+		out = append(out, result{"config REDIS_ADDR", statusOK, redisAddr, ""})
+	}
+
+	queueName := env("QUEUE_NAME", "messages")
+	if strings.ContainsAny(queueName, " \t\r\n") {
+		out = append(out, result{"config QUEUE_NAME", statusFail, fmt.Sprintf("%q contains whitespace", queueName),
+			"use a simple key name such as messages; api and worker must agree on it"})
+	} else {
+		out = append(out, result{"config QUEUE_NAME", statusOK, queueName, ""})
+	}
+
+	if component == "api" || component == "all" {
+		addr := env("HTTP_ADDR", ":8080")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			out = append(out, result{"config HTTP_ADDR", statusFail, fmt.Sprintf("%q is not [host]:port: %v", addr, err),
+				"use :8080 to listen on all interfaces"})
+		} else {
+			out = append(out, result{"config HTTP_ADDR", statusOK, addr, ""})
+		}
+	}
+
+	if component == "worker" || component == "all" {
+		if v := strings.TrimSpace(os.Getenv("PROCESSING_DELAY_MS")); v != "" {
+			n, err := strconv.Atoi(v)
+			switch {
+			case err != nil:
+				out = append(out, result{"config PROCESSING_DELAY_MS", statusWarn, fmt.Sprintf("%q is not an integer; the worker will silently use 0", v),
+					"set PROCESSING_DELAY_MS to a whole number of milliseconds, e.g. 500"})
+			case n < 0:
+				out = append(out, result{"config PROCESSING_DELAY_MS", statusWarn, fmt.Sprintf("%d is negative; no delay will be applied", n), ""})
+			default:
+				out = append(out, result{"config PROCESSING_DELAY_MS", statusOK, v + "ms", ""})
+			}
+		}
+	}
+
+	return out
+}
+
+// redisHint turns common go-redis/net errors into something a student can act on.
+func redisHint(err error, addr string) string {
+	msg := err.Error()
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("host in %q does not resolve; inside compose use the service name (redis), from your laptop use localhost, in k8s use the Service DNS name", addr)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "nothing is listening on that port; is the redis container/pod running and is the port right?"
+	case errors.Is(err, context.DeadlineExceeded) || strings.Contains(msg, "i/o timeout"):
+		return "connection timed out; check NetworkPolicies, security groups, or that you are on the same network as redis"
+	case strings.HasPrefix(msg, "NOAUTH"):
+		return "redis requires a password; set REDIS_PASSWORD (and REDIS_USERNAME for ACL users)"
+	case strings.HasPrefix(msg, "WRONGPASS"):
+		return "REDIS_USERNAME/REDIS_PASSWORD were rejected; check the Secret the pod mounts"
+	case strings.Contains(msg, "without any password configured"):
+		return "REDIS_PASSWORD is set but redis has no password; unset it or configure requirepass"
+	}
+	return ""
+}
+
+func checkRedis(ctx context.Context, rdb *redis.Client, addr, queueName string, maxSkew time.Duration) []result {
+	var out []result
+
+	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := rdb.Ping(pingCtx).Err(); err != nil {
+		name := "redis connectivity"
+		msg := err.Error()
+		if strings.HasPrefix(msg, "NOAUTH") || strings.HasPrefix(msg, "WRONGPASS") || strings.Contains(msg, "without any password configured") {
+			name = "redis auth"
+		}
+		// Nothing else can be checked without a working connection.
+		return append(out, result{name, statusFail, msg, redisHint(err, addr)})
+	}
+	out = append(out, result{"redis connectivity", statusOK, "PING " + addr, ""})
+	if env("REDIS_PASSWORD", "") != "" {
+		out = append(out, result{"redis auth", statusOK, "authenticated", ""})
+	} else {
+		out = append(out, result{"redis auth", statusOK, "no password configured", ""})
+	}
+
+	typ, err := rdb.Type(ctx, queueName).Result()
+	switch {
+	case err != nil:
+		out = append(out, result{"queue key", statusFail, err.Error(), ""})
+	case typ == "none":
+		out = append(out, result{"queue key", statusOK, fmt.Sprintf("%q does not exist yet (created on first enqueue)", queueName), ""})
+	case typ != "list":
+		out = append(out, result{"queue key", statusFail, fmt.Sprintf("%q is a %s, expected a list", queueName, typ),
+			fmt.Sprintf("another app is using this key; pick a different QUEUE_NAME or `redis-cli DEL %s`", queueName)})
+	default:
+		n, _ := rdb.LLen(ctx, queueName).Result()
+		out = append(out, result{"queue key", statusOK, fmt.Sprintf("%q is a list with %d pending messages", queueName, n), ""})
+	}
+
+	before := time.Now()
+	redisNow, err := rdb.Time(ctx).Result()
+	if err != nil {
+		out = append(out, result{"clock skew", statusWarn, "TIME failed: " + err.Error(), ""})
+		return out
+	}
+	// Compare against the midpoint of the round trip to exclude network latency.
+	localNow := before.Add(time.Since(before) / 2)
+	skew := localNow.Sub(redisNow)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		out = append(out, result{"clock skew", statusWarn, fmt.Sprintf("local clock differs from redis by %s", skew.Round(time.Millisecond)),
+			"timestamps in processed output will not line up; check NTP/chrony on the node"})
+	} else {
+		out = append(out, result{"clock skew", statusOK, skew.Round(time.Millisecond).String(), ""})
+	}
+
+	return out
+}
+
+func checkOutputPath(path string) result {
+	name := "output path"
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return result{name, statusFail, err.Error(),
+			fmt.Sprintf("cannot create %s; mount a volume there or set OUTPUT_PATH to a writable location", dir)}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		hint := "set OUTPUT_PATH to a writable location"
+		if errors.Is(err, os.ErrPermission) {
+			hint = fmt.Sprintf("%s is not writable by uid %d; fix volume ownership (fsGroup in k8s, chown in the entrypoint)", dir, os.Getuid())
+		}
+		return result{name, statusFail, err.Error(), hint}
+	}
+	_ = f.Close()
+	return result{name, statusOK, path + " is writable", ""}
+}
+
+func main() {
+	component := flag.String("component", env("DOCTOR_COMPONENT", "all"), "which binary's config to validate: api, worker, or all")
+	maxSkew := flag.Duration("max-clock-skew", 2*time.Second, "warn when local and redis clocks differ by more than this")
+	flag.Parse()
+
+	switch *component {
+	case "api", "worker", "all":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -component %q (want api, worker, or all)\n", *component)
+		os.Exit(2)
+	}
+
+	// Only the first of several addresses is checked: the one the
+	// components use while it's healthy.
+	redisAddrs, err := redisfailover.ParseAddrs(env("REDIS_ADDR", "redis:6379"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	redisAddr := redisAddrs[0]
+	queueName := env("QUEUE_NAME", "messages")
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     redisAddr,
+		Username: env("REDIS_USERNAME", ""),
+		Password: env("REDIS_PASSWORD", ""),
+	})
+	defer rdb.Close()
+
+	results := checkConfig(*component)
+	results = append(results, checkRedis(context.Background(), rdb, redisAddr, queueName, *maxSkew)...)
+	if *component == "worker" || *component == "all" {
+		results = append(results, checkOutputPath(env("OUTPUT_PATH", "/data/processed.log")))
+	}
+
+	failed := 0
+	for _, r := range results {
+		fmt.Printf("[%-4s] %s: %s\n", r.status, r.name, r.detail)
+		if r.hint != "" {
+			fmt.Printf("       -> %s\n", r.hint)
+		}
+		if r.status == statusFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d check(s) failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("\nall checks passed")
+}
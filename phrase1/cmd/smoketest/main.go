@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// smoketest checks a deployment end to end: it enqueues a marker message
+// through the live api and waits until a worker has processed it, exiting
+// non-zero if that doesn't happen in time. It's meant to run after a deploy,
+// as a Helm test hook or a Kubernetes Job, or with
+// `docker compose exec worker /smoketest`.
+
+func env(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+type client struct {
+	base   string
+	apiKey string
+	http   *http.Client
+}
+
+func (c *client) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// enqueue submits marker and returns the message id the api assigned.
+func (c *client) enqueue(ctx context.Context, marker string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"message": marker})
+	b, err := c.do(ctx, http.MethodPost, "/v1/enqueue", body)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Enqueued bool   `json:"enqueued"`
+		ID       string `json:"id"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", fmt.Errorf("enqueue response: %w", err)
+	}
+	if !resp.Enqueued {
+		return "", errors.New("enqueue response: not enqueued")
+	}
+	return resp.ID, nil
+}
+
+// processed reports whether marker is among the recently processed messages.
+func (c *client) processed(ctx context.Context, marker string) (bool, error) {
+	b, err := c.do(ctx, http.MethodGet, "/v1/stats/recent?limit=100", nil)
+	if err != nil {
+		return false, err
+	}
+	var recent []struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &recent); err != nil {
+		return false, fmt.Errorf("recent response: %w", err)
+	}
+	for _, r := range recent {
+		if r.Message == marker {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// inOutput reports whether a line of the worker output file at path carries
+// marker.
+func inOutput(path, marker string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		if strings.Contains(sc.Text(), " | "+marker) {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
+
+func main() {
+	apiURL := flag.String("api", env("SMOKE_API_URL", "http://api:8080"), "base URL of the api")
+	apiKey := flag.String("api-key", env("SMOKE_API_KEY", ""), "API key to send as X-API-Key (producer and operator roles) when auth is enabled")
+	output := flag.String("output", env("SMOKE_OUTPUT_PATH", ""), "worker output file to look for the marker in, instead of the api's recent list")
+	timeout := flag.Duration("timeout", 60*time.Second, "how long to wait for the marker to be processed")
+	poll := flag.Duration("poll", 500*time.Millisecond, "how often to check")
+	flag.Parse()
+
+	logger := log.New(os.Stdout, "smoketest ", log.LstdFlags|log.Lmicroseconds)
+	c := &client{base: strings.TrimRight(*apiURL, "/"), apiKey: *apiKey, http: &http.Client{Timeout: 10 * time.Second}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	hostname, _ := os.Hostname()
+	marker := fmt.Sprintf("smoketest %s %d", hostname, time.Now().UnixNano())
+	start := time.Now()
+	id, err := c.enqueue(ctx, marker)
+	if err != nil {
+		logger.Printf("FAIL: enqueue: %v", err)
+		os.Exit(1)
+	}
+	where := c.base + "/v1/stats/recent"
+	if *output != "" {
+		where = *output
+	}
+	logger.Printf("enqueued %q (id=%s), waiting up to %s for it in %s", marker, id, *timeout, where)
+
+	ticker := time.NewTicker(*poll)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		var done bool
+		if *output != "" {
+			done, err = inOutput(*output, marker)
+		} else {
+			done, err = c.processed(ctx, marker)
+		}
+		if done {
+			logger.Printf("OK: processed in %s", time.Since(start).Round(time.Millisecond))
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			if lastErr == nil || err.Error() != lastErr.Error() {
+				logger.Printf("check failed (retrying): %v", err)
+			}
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			logger.Printf("FAIL: message id=%s not processed within %s", id, *timeout)
+			os.Exit(1)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/wordcount"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// aggregateHandler names the word counter in error and slow-message reports.
+const aggregateHandler = "aggregate"
+
+// aggregator is the handler for OUTPUT_HANDLER=aggregate: it counts the
+// words in each message into per-minute Redis hashes, which the api adds up
+// into rolling counts at /stats/aggregations. The state lives in Redis, not
+// the worker, so any number of replicas count into the same totals and a
+// restarted worker loses nothing. Each message is counted once, even if
+// it's redelivered within the retention.
+type aggregator struct {
+	q         *queue.RedisQueue
+	words     *wordcount.Counter
+	retention time.Duration
+	redact    *redact.Messages
+	counted   *metrics.Counter
+	total     *metrics.Counter
+}
+
+func (a *aggregator) Handle(ctx context.Context, m worker.Message) error {
+	// Redacted first, so an email address or card number never becomes a
+	// keyword, and nor does the placeholder standing in for it.
+	counts := a.words.Count(strings.ReplaceAll(a.redact.Text(m.Text), redact.Placeholder, " "))
+	ok, err := a.q.CountWords(ctx, m.Envelope.ID, counts, time.Now(), a.retention)
+	if err != nil {
+		return worker.Classify(worker.ClassSink, err)
+	}
+	if !ok {
+		a.counted.Inc(a.q.Name(), "duplicate")
+		return nil
+	}
+	a.counted.Inc(a.q.Name(), "counted")
+	var n int64
+	for _, c := range counts {
+		n += c
+	}
+	a.total.Add(float64(n), a.q.Name())
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"learn_k8s/phrase1/internal/downstream"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// forwardHandler names the HTTP forwarder in error and slow-message reports.
+const forwardHandler = "http"
+
+// httpForward is the handler for OUTPUT_HANDLER=http: it POSTs each message
+// to url, continuing the message's trace, so the next service shows up in
+// the same trace as the producer. What's left of the handler's deadline goes
+// along as X-Request-Timeout, which the api understands too.
+//
+// Requests go through guard, which holds the whole fleet to the host's
+// concurrency and rate limits and fails them fast while its circuit breaker
+// is open.
+type httpForward struct {
+	url    string
+	host   string
+	client *http.Client
+	guard  *downstream.Guard
+	// requests counts requests by host and code class ("2xx" to "5xx", or
+	// "error" if there was no answer); rejected counts those the breaker
+	// failed; seconds times them; waited times the waits for limits.
+	requests *metrics.Counter
+	rejected *metrics.Counter
+	seconds  *metrics.Histogram
+	waited   *metrics.Histogram
+}
+
+func (f *httpForward) Handle(ctx context.Context, m worker.Message) error {
+	permit, err := f.guard.Acquire(ctx, f.host)
+	if err != nil {
+		err = fmt.Errorf("forward to %s: %w", f.host, err)
+		if errors.Is(err, downstream.ErrOpen) {
+			f.rejected.Inc(f.host)
+			err = worker.Classify(worker.ClassDownstream, err)
+		}
+		return err
+	}
+	f.waited.Observe(permit.RateWait.Seconds(), f.host, "rate")
+	f.waited.Observe(permit.SlotWait.Seconds(), f.host, "concurrency")
+	start := time.Now()
+	status, err := f.post(ctx, m)
+	f.seconds.Observe(time.Since(start).Seconds(), f.host)
+	// The breaker counts what says the host is in trouble: no answer, an
+	// error on its side, or being told to back off. A 4xx is the message's
+	// problem.
+	hostFailed := status == 0 || status >= 500 || status == http.StatusTooManyRequests
+	permit.Done(ctx, hostFailed)
+	class := "error"
+	if status > 0 {
+		class = strconv.Itoa(status/100) + "xx"
+	}
+	f.requests.Inc(f.host, class)
+	if hostFailed {
+		return worker.Classify(worker.ClassDownstream, err)
+	}
+	return worker.Classify(worker.ClassValidation, err)
+}
+
+// post sends m and returns the status it was answered with, 0 if none.
+func (f *httpForward) post(ctx context.Context, m worker.Message) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, strings.NewReader(m.Text))
+	if err != nil {
+		return 0, err
+	}
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid([]byte(m.Text)) {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Message-Id", m.Envelope.ID)
+	m.Span.Inject(req.Header)
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline).Milliseconds(); left > 0 {
+			req.Header.Set("X-Request-Timeout", strconv.FormatInt(left, 10)+"ms")
+		}
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("forward: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("forward: %s answered %s", f.url, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
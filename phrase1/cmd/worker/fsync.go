@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fsyncPolicy says when lines written to OUTPUT_PATH are synced to disk.
+type fsyncPolicy string
+
+const (
+	// fsyncAlways syncs before a message counts as handled, so a processed
+	// message's line survives a crash of the node.
+	fsyncAlways fsyncPolicy = "always"
+	// fsyncInterval syncs in the background every so often, or after a
+	// batch of lines; a crash loses what was written since.
+	fsyncInterval fsyncPolicy = "interval"
+	// fsyncNever leaves it to the kernel, which writes dirty pages back
+	// within about 30 seconds.
+	fsyncNever fsyncPolicy = "never"
+)
+
+func parseFsyncPolicy(v string) (fsyncPolicy, error) {
+	switch p := fsyncPolicy(v); p {
+	case fsyncAlways, fsyncInterval, fsyncNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown OUTPUT_FSYNC %q (want always, interval, or never)", v)
+}
+
+// appendLine writes line to the output file, opening it first if needed, and
+// syncs it if the policy asks for that now.
+func (o *fileOutput) appendLine(line string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.open(); err != nil {
+		return err
+	}
+	if _, err := o.f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	o.pending++
+	if o.fsync == fsyncAlways || o.fsync == fsyncInterval && o.syncEvery > 0 && o.pending >= o.syncEvery {
+		return o.sync()
+	}
+	return nil
+}
+
+// open keeps the output file open between messages, and opens it again if
+// it was moved or deleted since, so rotating or removing it works as it did
+// when every line opened the file.
+func (o *fileOutput) open() error {
+	if o.f != nil {
+		if info, err := os.Stat(o.path); err == nil && os.SameFile(info, o.info) {
+			return nil
+		}
+		_ = o.sync()
+		_ = o.f.Close()
+		o.f = nil
+	}
+	if err := ensureParentDir(o.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	o.f, o.info = f, info
+	return nil
+}
+
+// sync flushes the lines written since the last sync to disk.
+func (o *fileOutput) sync() error {
+	if o.f == nil || o.pending == 0 {
+		return nil
+	}
+	start := time.Now()
+	err := o.f.Sync()
+	if o.onSync != nil {
+		o.onSync(time.Since(start), err)
+	}
+	if err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	o.pending = 0
+	return nil
+}
+
+// runSync syncs the output file every interval under fsyncInterval until ctx
+// is canceled. Errors go to onSync.
+func (o *fileOutput) runSync(ctx context.Context, interval time.Duration) {
+	if o.fsync != fsyncInterval || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			o.mu.Lock()
+			_ = o.sync()
+			o.mu.Unlock()
+		}
+	}
+}
+
+// Close syncs what's left, whatever the policy, and closes the file.
+func (o *fileOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return nil
+	}
+	err := o.sync()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	o.f = nil
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/opensearch"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// searchDoc is a processed message as indexed in OpenSearch.
+type searchDoc struct {
+	ID          string    `json:"id"`
+	Queue       string    `json:"queue"`
+	Message     string    `json:"message"`
+	Source      string    `json:"source,omitempty"`
+	Worker      string    `json:"worker,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	ProcessedAt time.Time `json:"processed_at"`
+	StreamID    string    `json:"stream_id"`
+}
+
+// searchTemplate is the index template for the indexer's daily indices, so
+// each one gets the same mappings instead of whatever dynamic mapping
+// guesses from its first document.
+func searchTemplate(prefix string, replicas int) map[string]any {
+	keyword := map[string]any{"type": "keyword"}
+	return map[string]any{
+		"index_patterns": []string{prefix + "*"},
+		"template": map[string]any{
+			"settings": map[string]any{
+				"number_of_shards":   1,
+				"number_of_replicas": replicas,
+			},
+			"mappings": map[string]any{
+				"properties": map[string]any{
+					"id":    keyword,
+					"queue": keyword,
+					"message": map[string]any{
+						"type":   "text",
+						"fields": map[string]any{"keyword": map[string]any{"type": "keyword", "ignore_above": 256}},
+					},
+					"source":       keyword,
+					"worker":       keyword,
+					"duration_ms":  map[string]any{"type": "long"},
+					"processed_at": map[string]any{"type": "date"},
+					"stream_id":    keyword,
+				},
+			},
+		},
+	}
+}
+
+// indexer is WORKER_MODE=indexer: it reads the processed archive as a Redis
+// consumer group and bulk-indexes it into OpenSearch, one index per queue and
+// day. Entries are acked once indexed, so a restarted or scaled indexer
+// neither skips nor (thanks to the message id as document id) duplicates
+// them. Rejections for overload (429) are retried with backoff; a document
+// the cluster refuses outright is logged and skipped.
+type indexer struct {
+	q          *queue.RedisQueue
+	client     *opensearch.Client
+	consumer   string
+	group      string
+	prefix     string
+	replicas   int
+	batch      int64
+	block      time.Duration
+	claimIdle  time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+	indexed    *metrics.Counter
+	rejected   *metrics.Counter
+	retries    *metrics.Counter
+	bulk       *metrics.Histogram
+	logger     *log.Logger
+}
+
+func newIndexer(q *queue.RedisQueue, client *opensearch.Client, hostname string, reg *metrics.Registry, logger *log.Logger) *indexer {
+	return &indexer{
+		q:          q,
+		client:     client,
+		consumer:   hostname,
+		group:      env("OPENSEARCH_GROUP", "opensearch"),
+		prefix:     env("OPENSEARCH_INDEX_PREFIX", "processed-"),
+		replicas:   envInt("OPENSEARCH_REPLICAS", 0),
+		batch:      int64(envInt("OPENSEARCH_BATCH", 500)),
+		block:      envDuration("OPENSEARCH_FLUSH_INTERVAL", time.Second),
+		claimIdle:  envDuration("OPENSEARCH_CLAIM_IDLE", time.Minute),
+		backoff:    envDuration("OPENSEARCH_BACKOFF", 500*time.Millisecond),
+		maxBackoff: envDuration("OPENSEARCH_MAX_BACKOFF", 30*time.Second),
+		indexed:    reg.NewCounter("worker_indexer_indexed_total", "Processed messages indexed into OpenSearch.", "queue"),
+		rejected:   reg.NewCounter("worker_indexer_rejected_total", "Processed messages OpenSearch refused to index and the indexer skipped.", "queue"),
+		retries:    reg.NewCounter("worker_indexer_retries_total", "Bulk requests, or documents in them, retried after a backoff, by reason: throttled (429) or error.", "queue", "reason"),
+		bulk:       reg.NewHistogram("worker_indexer_bulk_seconds", "Time taken by a _bulk request to OpenSearch.", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}),
+		logger:     logger,
+	}
+}
+
+// run installs the index template and indexes the archive until ctx is
+// canceled. Entries read but not yet indexed when it stops stay pending in
+// the group and are read again on the next start.
+func (ix *indexer) run(ctx context.Context) error {
+	backoff := ix.backoff
+	for {
+		err := ix.client.PutIndexTemplate(ctx, ix.prefix+"template", searchTemplate(ix.prefix, ix.replicas))
+		if err == nil {
+			break
+		}
+		ix.logger.Printf("indexer: install index template (retrying in %s): %v", backoff, err)
+		if !sleepCtx(ctx, backoff) {
+			return nil
+		}
+		backoff = min(2*backoff, ix.maxBackoff)
+	}
+	reader, err := ix.q.ArchiveReader(ctx, ix.group, ix.consumer)
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		msgs, err := reader.Read(ctx, ix.batch, ix.block, ix.claimIdle)
+		if err != nil {
+			if ctx.Err() == nil {
+				ix.logger.Printf("indexer: read archive: %v", err)
+				sleepCtx(ctx, time.Second)
+			}
+			continue
+		}
+		ix.index(ctx, reader, msgs)
+	}
+	return nil
+}
+
+// index bulk-indexes msgs, retrying what was throttled or failed with
+// backoff until all are indexed or skipped, or ctx is canceled.
+func (ix *indexer) index(ctx context.Context, reader *queue.ArchiveReader, msgs []queue.ArchivedMessage) {
+	// A pending entry trimmed from the stream since comes back empty; it's
+	// gone, so it's only acked.
+	var gone []string
+	msgs = slices.DeleteFunc(msgs, func(m queue.ArchivedMessage) bool {
+		if m.ID == "" {
+			gone = append(gone, m.StreamID)
+		}
+		return m.ID == ""
+	})
+	if err := reader.Ack(ctx, gone...); err != nil && ctx.Err() == nil {
+		ix.logger.Printf("indexer: ack archive: %v", err)
+	}
+	backoff := ix.backoff
+	for len(msgs) > 0 {
+		docs := make([]opensearch.Doc, len(msgs))
+		for i, m := range msgs {
+			docs[i] = opensearch.Doc{
+				Index: ix.prefix + m.Queue + "-" + m.ProcessedAt.UTC().Format("2006.01.02"),
+				ID:    m.ID,
+				Body:  searchDoc{ID: m.ID, Queue: m.Queue, Message: m.Message, Source: m.Source, Worker: m.Worker, DurationMS: m.DurationMS, ProcessedAt: m.ProcessedAt, StreamID: m.StreamID},
+			}
+		}
+		start := time.Now()
+		failed, err := ix.client.Bulk(ctx, docs)
+		ix.bulk.Observe(time.Since(start).Seconds())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			reason := "error"
+			if errors.Is(err, opensearch.ErrTooManyRequests) {
+				reason = "throttled"
+			}
+			ix.retries.Inc(ix.q.Name(), reason)
+			ix.logger.Printf("indexer: bulk of %d failed (retrying in %s): %v", len(msgs), backoff, err)
+			if !sleepCtx(ctx, backoff) {
+				return
+			}
+			backoff = min(2*backoff, ix.maxBackoff)
+			continue
+		}
+
+		retry := map[int]bool{}
+		reason := "error"
+		for _, f := range failed {
+			if f.Retryable() {
+				retry[f.Doc] = true
+				if f.Status == http.StatusTooManyRequests {
+					reason = "throttled"
+				}
+				continue
+			}
+			ix.rejected.Inc(ix.q.Name())
+			ix.logger.Printf("indexer: skipping message id=%s: %d %s", msgs[f.Doc].ID, f.Status, f.Reason)
+		}
+		var done []string
+		var again []queue.ArchivedMessage
+		for i, m := range msgs {
+			if retry[i] {
+				again = append(again, m)
+			} else {
+				done = append(done, m.StreamID)
+			}
+		}
+		ix.indexed.Add(float64(len(msgs)-len(failed)), ix.q.Name())
+		if err := reader.Ack(ctx, done...); err != nil && ctx.Err() == nil {
+			// Unacked entries are indexed again later, under the same ids.
+			ix.logger.Printf("indexer: ack archive: %v", err)
+		}
+		msgs = again
+		if len(msgs) == 0 {
+			return
+		}
+		ix.retries.Inc(ix.q.Name(), reason)
+		ix.logger.Printf("indexer: %d documents not indexed (%s, retrying in %s)", len(msgs), reason, backoff)
+		if !sleepCtx(ctx, backoff) {
+			return
+		}
+		backoff = min(2*backoff, ix.maxBackoff)
+	}
+}
+
+// sleepCtx waits for d, and reports false if ctx was canceled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
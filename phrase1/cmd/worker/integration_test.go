@@ -0,0 +1,551 @@
+//go:build integration
+
+// Integration tests run the worker loop against a real Redis started with
+// testcontainers, so they need Docker:
+//
+//	go test -tags integration ./cmd/worker/
+//
+// INTEGRATION_REDIS_ADDR points them at an existing Redis instead.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	"learn_k8s/phrase1/internal/chaos"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/opensearch"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/wordcount"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// drainIdle is how long a test worker waits on an empty queue before its
+// run returns; BRPOP can't wait less than a second.
+const drainIdle = time.Second
+
+// redisAddr is the container's host:port, shared by every test; each test
+// uses its own queue name.
+var redisAddr string
+
+func TestMain(m *testing.M) {
+	if redisAddr = os.Getenv("INTEGRATION_REDIS_ADDR"); redisAddr != "" {
+		os.Exit(m.Run())
+	}
+	ctx := context.Background()
+	image := os.Getenv("REDIS_IMAGE")
+	if image == "" {
+		image = "redis:7-alpine"
+	}
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "start redis: %v\n", err)
+		os.Exit(1)
+	}
+	redisAddr, err = c.Endpoint(ctx, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "redis endpoint: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = c.Terminate(ctx)
+	os.Exit(code)
+}
+
+func newQueue(t *testing.T) *queue.RedisQueue {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	q := queue.NewRedisQueue(rdb, strings.ReplaceAll(t.Name(), "/", "_"))
+	clear := func() {
+		for key := range q.KeyTypes() {
+			_ = rdb.Del(context.Background(), key).Err()
+		}
+	}
+	clear()
+	t.Cleanup(func() {
+		clear()
+		_ = rdb.Close()
+	})
+	return q
+}
+
+// testWorker is a worker writing to its own temporary output file.
+type testWorker struct {
+	*worker.Worker
+	out *fileOutput
+}
+
+func newWorker(t *testing.T, q queue.Queue, name string, opts ...worker.Option) *testWorker {
+	t.Helper()
+	out := &fileOutput{path: filepath.Join(t.TempDir(), name+".log")}
+	opts = append([]worker.Option{
+		worker.WithHandlerName(outputHandler),
+		worker.WithHostname(name),
+		worker.WithDrainIdle(drainIdle),
+		worker.WithMigrations(payloadMigrations()),
+		worker.WithReporter(errreport.Nop{}),
+		worker.WithLogger(log.New(os.Stderr, name+" ", log.Lmicroseconds)),
+	}, opts...)
+	return &testWorker{Worker: worker.New(q, out, opts...), out: out}
+}
+
+func enqueue(t *testing.T, q *queue.RedisQueue, envs ...envelope.Envelope) {
+	t.Helper()
+	for _, e := range envs {
+		raw, err := q.Encode(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Enqueue(context.Background(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func stats(t *testing.T, q *queue.RedisQueue) queue.Stats {
+	t.Helper()
+	s, err := q.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// outputMessages returns the message field of every line in w's output.
+func outputMessages(t *testing.T, w *testWorker) []string {
+	t.Helper()
+	b, err := os.ReadFile(w.out.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if fields := strings.Split(line, " | "); len(fields) > 1 {
+			out = append(out, fields[1])
+		}
+	}
+	return out
+}
+
+func TestProcessedInOrder(t *testing.T) {
+	q := newQueue(t)
+	enqueue(t, q, envelope.New("one"), envelope.New("two"), envelope.New("three"))
+
+	w := newWorker(t, q, "w1")
+	_ = w.Run(context.Background())
+
+	if got := strings.Join(outputMessages(t, w), ","); got != "one,two,three" {
+		t.Errorf("output %q, want one,two,three", got)
+	}
+	s := stats(t, q)
+	if s.Depth != 0 || s.EnqueuedTotal != 3 || s.ProcessedTotal != 3 || s.DeadLetteredTotal != 0 {
+		t.Errorf("stats %+v", s)
+	}
+	recent, err := q.RecentProcessed(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 3 || recent[0].Message != "three" {
+		t.Errorf("recent %+v, want three newest of 3", recent)
+	}
+}
+
+func TestOutputTemplate(t *testing.T) {
+	q := newQueue(t)
+	e := envelope.New(`say "hi", twice`)
+	e.Metadata = map[string]string{"tenant": "acme"}
+	enqueue(t, q, e, envelope.New("plain"))
+
+	w := newWorker(t, q, "w1")
+	t.Setenv("OUTPUT_TEMPLATE", `{{csv .ID .Message .Metadata.tenant .Attempt}}`)
+	tmpl, err := parseOutputTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.out.tmpl = tmpl
+	_ = w.Run(context.Background())
+
+	b, err := os.ReadFile(w.out.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output %q, want 2 lines", b)
+	}
+	if want := e.ID + `,"say ""hi"", twice",acme,1`; lines[0] != want {
+		t.Errorf("line %q, want %q", lines[0], want)
+	}
+	if !strings.HasSuffix(lines[1], ",plain,,1") {
+		t.Errorf("line %q, want an empty tenant", lines[1])
+	}
+}
+
+func TestOutputJSONL(t *testing.T) {
+	q := newQueue(t)
+	e := envelope.New("one")
+	enqueue(t, q, e, envelope.New("two"))
+
+	w := newWorker(t, q, "w1")
+	w.out.format, w.out.queue, w.out.worker = formatJSONL, q.Name(), "w1"
+	_ = w.Run(context.Background())
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	schema, err := c.Compile("output.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(w.out.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output %q, want 2 lines", b)
+	}
+	for _, line := range lines {
+		var v any
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if err := schema.Validate(v); err != nil {
+			t.Errorf("%s: %v", line, err)
+		}
+	}
+	var rec jsonlRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ID != e.ID || rec.Message != "one" || rec.Queue != q.Name() || rec.Worker != "w1" || rec.Attempt != 1 || rec.Result != "processed" {
+		t.Errorf("record %+v", rec)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	q := newQueue(t)
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	t.Cleanup(func() {
+		keys, _ := rdb.Keys(context.Background(), q.Name()+":agg:*").Result()
+		for _, k := range keys {
+			_ = rdb.Del(context.Background(), k).Err()
+		}
+		_ = rdb.Close()
+	})
+	e := envelope.New("Order 42 is late; the order shipped")
+	// The first message again stands in for a redelivery.
+	enqueue(t, q, e, envelope.New("shipped"), e)
+
+	reg := metrics.NewRegistry()
+	a := &aggregator{
+		q:         q,
+		words:     wordcount.New(wordcount.DefaultStopwords, 3, 32),
+		retention: time.Hour,
+		counted:   reg.NewCounter("counted", "", "queue", "result"),
+		total:     reg.NewCounter("total", "", "queue"),
+	}
+	w := worker.New(q, a, worker.WithDrainIdle(drainIdle), worker.WithReporter(errreport.Nop{}), worker.WithLogger(log.New(os.Stderr, "w1 ", log.Lmicroseconds)))
+	_ = w.Run(context.Background())
+
+	agg, err := q.Aggregations(context.Background(), 5*time.Minute, 10, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.Messages != 2 || agg.Words != 5 {
+		t.Errorf("counted %d messages, %d words; want 2 and 5, the redelivery not counted", agg.Messages, agg.Words)
+	}
+	want := []queue.WordCount{{Word: "order", Count: 2}, {Word: "shipped", Count: 2}, {Word: "late", Count: 1}}
+	if !slices.Equal(agg.Top, want) {
+		t.Errorf("top %v, want %v", agg.Top, want)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	t.Run("undecodable payload", func(t *testing.T) {
+		q := newQueue(t)
+		bad := envelope.New("\xc1")
+		bad.ContentType = "application/msgpack"
+		enqueue(t, q, bad, envelope.New("good"))
+
+		w := newWorker(t, q, "w1")
+		_ = w.Run(context.Background())
+
+		if got := outputMessages(t, w); len(got) != 1 || got[0] != "good" {
+			t.Errorf("output %q, want only good", got)
+		}
+		s := stats(t, q)
+		if s.DLQDepth != 1 || s.DeadLetteredTotal != 1 || s.ProcessedTotal != 1 {
+			t.Errorf("stats %+v", s)
+		}
+		dlq, err := q.DeadLettered(context.Background(), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dlq) != 1 || envelope.Decode(dlq[0]).ID != bad.ID {
+			t.Errorf("dlq %q, want the msgpack envelope %s as enqueued", dlq, bad.ID)
+		}
+	})
+
+	t.Run("output unwritable", func(t *testing.T) {
+		q := newQueue(t)
+		enqueue(t, q, envelope.New("lost sink"))
+
+		w := newWorker(t, q, "w1")
+		notDir := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(notDir, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		w.out.path = filepath.Join(notDir, "out.log")
+		_ = w.Run(context.Background())
+
+		s := stats(t, q)
+		if s.DLQDepth != 1 || s.ProcessedTotal != 0 {
+			t.Errorf("stats %+v, want 1 dead-lettered and none processed", s)
+		}
+	})
+}
+
+// TestFailoverGraceful stops a worker while it's handling a message and lets
+// a second one take over: every message is processed exactly once, including
+// the one in flight when the first worker was told to stop.
+func TestFailoverGraceful(t *testing.T) {
+	q := newQueue(t)
+	const n = 5
+	for i := 0; i < n; i++ {
+		enqueue(t, q, envelope.New(fmt.Sprintf("m%d", i)))
+	}
+
+	first := newWorker(t, q, "first", worker.WithDrainIdle(0), worker.WithProcessingDelay(300*time.Millisecond))
+	ctx, stop := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = first.Run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for first.Current() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("first worker never picked up a message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	wg.Wait()
+
+	second := newWorker(t, q, "second")
+	_ = second.Run(context.Background())
+
+	seen := map[string]int{}
+	for _, m := range append(outputMessages(t, first), outputMessages(t, second)...) {
+		seen[m]++
+	}
+	for i := 0; i < n; i++ {
+		if m := fmt.Sprintf("m%d", i); seen[m] != 1 {
+			t.Errorf("%s processed %d times, want once", m, seen[m])
+		}
+	}
+	if len(outputMessages(t, first)) == 0 {
+		t.Error("first worker didn't finish the message it was handling")
+	}
+	if s := stats(t, q); s.ProcessedTotal != n || s.Depth != 0 {
+		t.Errorf("stats %+v, want %d processed and none waiting", s, n)
+	}
+}
+
+// TestFailoverCrash has the first worker die after popping a message but
+// before completing it. Delivery is at most once: the message is gone from the
+// queue and isn't redelivered to the second worker, which handles the rest.
+func TestFailoverCrash(t *testing.T) {
+	q := newQueue(t)
+	enqueue(t, q, envelope.New("in flight"), envelope.New("after"))
+
+	first := newWorker(t, q, "first", worker.WithChaos(chaos.New(chaos.Config{DropAckRate: 1})))
+	raw, err := q.Dequeue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Process(context.Background(), raw)
+
+	second := newWorker(t, q, "second")
+	_ = second.Run(context.Background())
+
+	if got := outputMessages(t, first); len(got) != 0 {
+		t.Errorf("crashed worker wrote %q", got)
+	}
+	if got := outputMessages(t, second); len(got) != 1 || got[0] != "after" {
+		t.Errorf("second worker output %q, want only after", got)
+	}
+	if s := stats(t, q); s.ProcessedTotal != 1 || s.DLQDepth != 0 || s.Depth != 0 {
+		t.Errorf("stats %+v, want 1 processed, nothing dead-lettered or waiting", s)
+	}
+}
+
+// TestDelayedMover runs two movers on one queue: one wins the lease and
+// promotes the due message, and the one not yet due stays delayed.
+func TestDelayedMover(t *testing.T) {
+	q := newQueue(t)
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	t.Cleanup(func() {
+		_ = rdb.Del(context.Background(), q.Name()+":delayed:mover").Err()
+		_ = rdb.Close()
+	})
+	for at, msg := range map[time.Time]string{time.Now().Add(-time.Second): "due", time.Now().Add(time.Hour): "later"} {
+		raw, err := q.Encode(envelope.New(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.EnqueueAt(context.Background(), raw, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var movers []*delayedMover
+	for _, name := range []string{"a", "b"} {
+		m := newDelayedMover(rdb, q, name, metrics.NewRegistry(), log.New(os.Stderr, name+" ", log.Lmicroseconds))
+		m.poll = 50 * time.Millisecond
+		movers = append(movers, m)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(ctx)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stats(t, q).Depth == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if movers[0].elector.Leading() == movers[1].elector.Leading() {
+		t.Errorf("leading: a=%t b=%t, want exactly one", movers[0].elector.Leading(), movers[1].elector.Leading())
+	}
+	cancel()
+	wg.Wait()
+
+	raw, err := q.DequeueWithin(context.Background(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := envelope.Decode(raw).Payload; got != "due" {
+		t.Errorf("promoted %q, want due", got)
+	}
+	n, lag, err := q.Delayed(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || lag != 0 {
+		t.Errorf("delayed %d with lag %s, want 1 not yet due", n, lag)
+	}
+}
+
+func TestIndexer(t *testing.T) {
+	q := newQueue(t)
+	q.SetArchive(100)
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	t.Cleanup(func() {
+		_ = rdb.Del(context.Background(), q.ArchiveKey()).Err()
+		_ = rdb.Close()
+	})
+	_ = rdb.Del(context.Background(), q.ArchiveKey()).Err()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := q.Archive(context.Background(), queue.ArchivedMessage{ID: id, Message: "msg " + id, ProcessedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The fake cluster throttles the first bulk request and indexes the rest.
+	var mu sync.Mutex
+	var bulks int
+	indexed := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if bulks++; bulks == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		dec := json.NewDecoder(r.Body)
+		for {
+			var action map[string]map[string]string
+			var doc searchDoc
+			if dec.Decode(&action) != nil || dec.Decode(&doc) != nil {
+				break
+			}
+			indexed[action["index"]["_id"]] = action["index"]["_index"]
+		}
+		_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer srv.Close()
+
+	ix := newIndexer(q, opensearch.New(srv.URL, "", ""), "ix", metrics.NewRegistry(), log.New(os.Stderr, "ix ", log.Lmicroseconds))
+	ix.block = 100 * time.Millisecond
+	ix.backoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ix.run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(indexed)
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	index := ix.prefix + q.Name() + "-" + time.Now().UTC().Format("2006.01.02")
+	for _, id := range []string{"a", "b", "c"} {
+		if indexed[id] != index {
+			t.Errorf("message %s indexed into %q, want %q", id, indexed[id], index)
+		}
+	}
+	if bulks < 2 {
+		t.Errorf("%d bulk requests, want the throttled one retried", bulks)
+	}
+	pending, err := rdb.XPending(context.Background(), q.ArchiveKey(), ix.group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("%d archive entries pending, want all acked", pending.Count)
+	}
+}
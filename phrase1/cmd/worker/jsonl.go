@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"learn_k8s/phrase1/internal/codec"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// outputFormat is how each processed message is written to OUTPUT_PATH.
+type outputFormat string
+
+const (
+	// formatText is the "time | message | key=value" line, or
+	// OUTPUT_TEMPLATE if set.
+	formatText outputFormat = "text"
+	// formatJSONL is one jsonlRecord per line; output.schema.json
+	// describes it.
+	formatJSONL outputFormat = "jsonl"
+)
+
+func parseOutputFormat(v string) (outputFormat, error) {
+	switch f := outputFormat(v); f {
+	case formatText, formatJSONL:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown OUTPUT_FORMAT %q (want text or jsonl)", v)
+}
+
+// jsonlVersion is the record version written as "v". Bump it, and the
+// schema, when a field changes meaning or goes away; adding one doesn't.
+const jsonlVersion = 1
+
+// jsonlRecord is a processed message as a JSON Lines record, for loading into
+// analytics tools. Times are RFC 3339 in UTC.
+type jsonlRecord struct {
+	Version     int       `json:"v"`
+	ID          string    `json:"id"`
+	Queue       string    `json:"queue"`
+	Message     string    `json:"message"`
+	Source      string    `json:"source,omitempty"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Attempt     int       `json:"attempt"`
+	Worker      string    `json:"worker"`
+	// Result is "processed": a line is only written for a message that was
+	// handled. It's there so failure records can be added without a new
+	// version.
+	Result string `json:"result"`
+	// DurationMS is from dequeue to the line being written; WaitMS is from
+	// enqueue to dequeue.
+	DurationMS    int64  `json:"duration_ms"`
+	WaitMS        int64  `json:"wait_ms"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	TraceID       string `json:"trace_id,omitempty"`
+	Pod           string `json:"pod,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	Node          string `json:"node,omitempty"`
+}
+
+// newJSONLRecord is the record for m, processed now by the worker named
+// workerID. The message text is redacted.
+func newJSONLRecord(m worker.Message, queue, workerID string, pod podinfo.Identity, redactor *redact.Messages) jsonlRecord {
+	now := time.Now().UTC()
+	envlp := m.Envelope
+	rec := jsonlRecord{
+		Version:       jsonlVersion,
+		ID:            envlp.ID,
+		Queue:         queue,
+		Message:       redactor.Text(m.Text),
+		Source:        envlp.Source,
+		EnqueuedAt:    envlp.EnqueuedAt.UTC(),
+		ProcessedAt:   now,
+		Attempt:       m.Attempt,
+		Worker:        workerID,
+		Result:        "processed",
+		SchemaVersion: m.SchemaVersion,
+		ContentType:   codec.Binary(envlp.ContentType),
+		TraceID:       m.Span.TraceID,
+		Pod:           pod.Pod,
+		Namespace:     pod.Namespace,
+		Node:          pod.Node,
+	}
+	if !m.Started.IsZero() {
+		rec.DurationMS = now.Sub(m.Started).Milliseconds()
+		if !envlp.EnqueuedAt.IsZero() {
+			rec.WaitMS = max(m.Started.Sub(envlp.EnqueuedAt).Milliseconds(), 0)
+		}
+	}
+	return rec
+}
+
+// jsonlLine renders m as a JSON Lines record.
+func (o *fileOutput) jsonlLine(m worker.Message) (string, error) {
+	b, err := json.Marshal(newJSONLRecord(m, o.queue, o.worker, o.pod, o.redact))
+	return string(b), err
+}
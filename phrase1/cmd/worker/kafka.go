@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// kafkaHandler names the Kafka sink in error and slow-message reports.
+const kafkaHandler = "kafka"
+
+// kafkaSink is the handler for OUTPUT_HANDLER=kafka: it publishes each
+// processed message to a topic as a JSON Lines record, keyed by message id so
+// a message's redeliveries land on the same partition. The write waits for
+// the broker's acknowledgment, so a message isn't acked in Redis before Kafka
+// has it; a failed write is retried and dead-lettered like any handler error.
+type kafkaSink struct {
+	w      *kafka.Writer
+	queue  string
+	worker string
+	pod    podinfo.Identity
+	redact *redact.Messages
+}
+
+func (k *kafkaSink) Handle(ctx context.Context, m worker.Message) error {
+	value, err := json.Marshal(newJSONLRecord(m, k.queue, k.worker, k.pod, k.redact))
+	if err != nil {
+		return err
+	}
+	msg := kafka.Message{Key: []byte(m.Envelope.ID), Value: value}
+	if m.Span.IsValid() {
+		msg.Headers = []kafka.Header{{Key: "traceparent", Value: []byte(m.Span.Traceparent())}}
+	}
+	if err := k.w.WriteMessages(ctx, msg); err != nil {
+		return worker.Classify(worker.ClassSink, fmt.Errorf("publish to kafka topic %s: %w", k.w.Topic, err))
+	}
+	return nil
+}
+
+// check fetches the topic's metadata, for /health: it fails while no broker
+// answers or the topic has no partitions yet.
+func (k *kafkaSink) check(ctx context.Context) error {
+	client := &kafka.Client{Addr: k.w.Addr, Transport: k.w.Transport}
+	resp, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{k.w.Topic}})
+	if err != nil {
+		return err
+	}
+	for _, t := range resp.Topics {
+		if t.Error != nil {
+			return t.Error
+		}
+		if len(t.Partitions) == 0 {
+			return fmt.Errorf("topic %s has no partitions", t.Name)
+		}
+	}
+	return nil
+}
+
+// Close flushes messages still buffered in the writer.
+func (k *kafkaSink) Close() error {
+	return k.w.Close()
+}
@@ -2,104 +2,879 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	// Quiet hours are in TZ's local time; the image has no zoneinfo.
+	_ "time/tzdata"
 
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/sync/errgroup"
 
+	"learn_k8s/phrase1/internal/asynq"
+	"learn_k8s/phrase1/internal/buildinfo"
+	"learn_k8s/phrase1/internal/celery"
+	"learn_k8s/phrase1/internal/chaos"
+	"learn_k8s/phrase1/internal/downstream"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/health"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/notify"
+	"learn_k8s/phrase1/internal/opensearch"
+	"learn_k8s/phrase1/internal/otlplog"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/policy"
 	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/redisfailover"
+	"learn_k8s/phrase1/internal/redismetrics"
+	"learn_k8s/phrase1/internal/resource"
+	"learn_k8s/phrase1/internal/secrets"
+	"learn_k8s/phrase1/internal/slo"
+	"learn_k8s/phrase1/internal/spool"
+	"learn_k8s/phrase1/internal/statedump"
+	"learn_k8s/phrase1/internal/thumbnail"
+	"learn_k8s/phrase1/internal/wordcount"
+	"learn_k8s/phrase1/pkg/worker"
 )
 
-func env(key, fallback string) string {
-	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
-		return v
+// configSeen records the effective value of every variable read through the
+// env helpers, defaults included, for the SIGQUIT state dump.
+var (
+	configMu   sync.Mutex
+	configSeen = map[string]string{}
+)
+
+func seen(key string, value any) {
+	configMu.Lock()
+	configSeen[key] = fmt.Sprint(value)
+	configMu.Unlock()
+}
+
+func configSnapshot() map[string]string {
+	configMu.Lock()
+	defer configMu.Unlock()
+	out := make(map[string]string, len(configSeen))
+	for k, v := range configSeen {
+		out[k] = redact.Value(k, v)
 	}
-	return fallback
+	return out
 }
 
-func envInt(key string, fallback int) int {
+func env(key, fallback string) string {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
-		return fallback
+		v = fallback
 	}
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		return fallback
+	seen(key, v)
+	return v
+}
+
+// invalidEnv collects the variables whose values didn't parse, so their
+// defaults were used instead; /startupz reports them.
+var invalidEnv []string
+
+func envInt(key string, fallback int) int {
+	n := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			n = parsed
+		}
 	}
+	seen(key, n)
 	return n
 }
 
-func ensureParentDir(path string) error {
-	dir := filepath.Dir(path)
-	return os.MkdirAll(dir, 0o755)
+func envFloat(key string, fallback float64) float64 {
+	f := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			f = parsed
+		}
+	}
+	seen(key, f)
+	return f
+}
+
+// envDuration parses a Go duration such as "750ms" or "2s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			d = parsed
+		}
+	}
+	seen(key, d)
+	return d
 }
 
-func appendLine(path string, line string) error {
-	if err := ensureParentDir(path); err != nil {
-		return err
+// envList reads a comma-separated list, skipping empty items.
+func envList(key string) []string {
+	var out []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
 	}
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		return err
+	seen(key, strings.Join(out, ","))
+	return out
+}
+
+// loadSecrets reads the named secrets from the environment, <NAME>_FILE
+// files, and the Vault secret at VAULT_SECRET_PATH if VAULT_ADDR is set.
+func loadSecrets(ctx context.Context, names ...string) (*secrets.Store, error) {
+	static := map[string]string{}
+	files := map[string]string{}
+	for _, name := range names {
+		static[name] = env(name, "")
+		if path := env(name+"_FILE", ""); path != "" {
+			files[name] = path
+		}
+	}
+	var vault *secrets.Vault
+	if addr := env("VAULT_ADDR", ""); addr != "" {
+		vault = &secrets.Vault{
+			Addr:      addr,
+			Path:      env("VAULT_SECRET_PATH", ""),
+			Token:     env("VAULT_TOKEN", ""),
+			Role:      env("VAULT_ROLE", ""),
+			AuthMount: env("VAULT_AUTH_MOUNT", "kubernetes"),
+		}
+	}
+	store := secrets.New(static, files, vault)
+	_, err := store.Refresh(ctx)
+	return store, err
+}
+
+func envBool(key string, fallback bool) bool {
+	b := fallback
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			invalidEnv = append(invalidEnv, key)
+		} else {
+			b = parsed
+		}
+	}
+	seen(key, b)
+	return b
+}
+
+// pushLabels reads PUSHGATEWAY_LABELS, "key=value,key=value".
+func pushLabels(s string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); ok && k != "" {
+			out[k] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// heartbeat reports this worker as alive, and what it's handling, until ctx
+// is canceled.
+func heartbeat(ctx context.Context, q queue.Queue, id string, pod podinfo.Identity, w *worker.Worker, logger *log.Logger) {
+	const interval = 5 * time.Second
+	hb := queue.Heartbeat{ID: id, Pod: pod.Pod, Namespace: pod.Namespace, Node: pod.Node, StartedAt: time.Now(), EnvelopeVersion: envelope.Version}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hb.LastSeen = time.Now()
+		hb.Processed = w.Processed()
+		hb.Current = ""
+		if cur := w.Current(); cur != nil {
+			hb.Current = cur.ID
+		}
+		if err := q.Heartbeat(ctx, hb, 3*interval); err != nil && ctx.Err() == nil {
+			logger.Printf("heartbeat error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
-	defer f.Close()
-	_, err = fmt.Fprintln(f, line)
-	return err
 }
 
 func main() {
+	started := time.Now()
 	redisAddr := env("REDIS_ADDR", "redis:6379")
 	queueName := env("QUEUE_NAME", "messages")
 	outputPath := env("OUTPUT_PATH", "/data/processed.log")
 	processingDelay := time.Duration(envInt("PROCESSING_DELAY_MS", 0)) * time.Millisecond
+	metricsAddr := env("METRICS_ADDR", ":9090")
+	workerMode := env("WORKER_MODE", "consume")
+
+	pod := podinfo.Identity{Pod: env("POD_NAME", ""), Namespace: env("POD_NAMESPACE", ""), Node: env("NODE_NAME", "")}
+	hostname, _ := os.Hostname()
+	res := resource.New(env("OTEL_SERVICE_NAME", "worker"), buildinfo.Get().Version, hostname, pod, env("OTEL_RESOURCE_ATTRIBUTES", ""))
+	var logger *log.Logger
+	logExport := newLogExporter(res, func(err error) {
+		logger.Printf("log export error: %v", err)
+	})
+	prefix := pod.LogPrefix("worker ")
+	logger = log.New(logOutput(logExport, otlplog.Info, prefix), prefix, log.LstdFlags|log.Lmicroseconds)
 
-	logger := log.New(os.Stdout, "worker ", log.LstdFlags|log.Lmicroseconds)
+	// creds keeps credentials current as they rotate; see loadSecrets.
+	creds, err := loadSecrets(context.Background(), "REDIS_USERNAME", "REDIS_PASSWORD")
+	if err != nil {
+		logger.Fatalf("load secrets: %v", err)
+	}
 
-	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	redisAddrs, err := redisfailover.ParseAddrs(redisAddr)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr:                       redisAddrs[0],
+		CredentialsProviderContext: creds.RedisCredentials,
+	})
+	// REDIS_WAIT_REPLICAS applies to the file source's enqueues.
 	q := queue.NewRedisQueue(rdb, queueName)
+	q.SetReplication(queue.Replication{Replicas: envInt("REDIS_WAIT_REPLICAS", 0), Timeout: envDuration("REDIS_WAIT_TIMEOUT", time.Second)})
+	encoding, err := envelope.ParseEncoding(env("ENVELOPE_ENCODING", "json"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	q.SetEncoding(encoding)
+	// Processed messages are also kept in the stream processed:<queue>, for
+	// the api's archive and stream backlog.
+	q.SetArchive(int64(envInt("ARCHIVE_MAXLEN", 10000)))
+	var policies *policy.Store
+	if path := env("POLICY_FILE", ""); path != "" {
+		if policies, err = policy.Load(path); err != nil {
+			logger.Fatalf("load policies: %v", err)
+		}
+	}
+	q.ApplyPolicy(policies.For(queueName))
+	// consumed is the queue the worker takes messages from: q, or an asynq
+	// or Celery queue for tasks from those producers (QUEUE_FORMAT).
+	var consumed queue.Queue = q
+	switch format := env("QUEUE_FORMAT", "envelope"); format {
+	case "envelope":
+	case "asynq":
+		consumed = asynq.NewQueue(rdb, env("ASYNQ_QUEUE", "default"), q)
+	case "celery":
+		consumed = celery.NewQueue(q, celery.Format{Task: env("CELERY_TASK", "tasks.process"), Origin: fmt.Sprintf("gen%d@%s", os.Getpid(), hostname)})
+	default:
+		logger.Fatalf("unknown QUEUE_FORMAT %q (want envelope, asynq, or celery)", format)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	reg := metrics.NewRegistry()
+	reg.LimitQueues(envList("METRICS_QUEUE_LABELS"), envInt("METRICS_QUEUE_MAX", metrics.DefaultMaxQueues))
+	buildinfo.Register(reg)
+	podinfo.Register(reg, pod)
+	resource.Register(reg, res)
+	redismetrics.Instrument(rdb, reg)
+	var redisSwitch *redisfailover.Switch
+	// Standbys listed after the primary in REDIS_ADDR take over while it's
+	// down.
+	if len(redisAddrs) > 1 {
+		redisSwitch = redisfailover.New(redisAddrs, rdb.Options(), envDuration("REDIS_FAILOVER_AFTER", 10*time.Second), reg, logger)
+		rdb.AddHook(redisSwitch)
+	}
+	bus := events.NewBus()
+	eventsTransport := events.NewRedisTransport(rdb, q.EventsChannel(), func(err error) {
+		logger.Printf("event transport error: %v", err)
+	})
+	detachTransport := bus.Attach(eventsTransport, 256)
+	detachMetrics := bus.Attach(events.NewMetrics(reg), 256)
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-stop
-		cancel()
-	}()
+	emit := func(typ events.Type, msg string, cause error, took time.Duration) {
+		e := events.Event{Type: typ, Queue: queueName, Message: msg, Source: hostname, Duration: took}
+		if cause != nil {
+			e.Error = cause.Error()
+		}
+		bus.Publish(e)
+	}
 
-	logger.Printf("starting (redis=%s queue=%s output=%s delay=%s)", redisAddr, queueName, outputPath, processingDelay)
+	// Message text is redacted wherever the worker logs or writes it out.
+	redactor, err := redact.NewMessages(envList("REDACT_PII"), env("REDACT_PATTERN", ""), envList("REDACT_FIELDS"))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
 
-	for {
-		msg, err := q.Dequeue(ctx)
+	// The handler is what the worker does with each message: append it to
+	// OUTPUT_PATH, POST it to FORWARD_URL, publish it to Kafka, send it as a
+	// notification, make thumbnails of the image it names, or count its
+	// words.
+	handlerName := env("OUTPUT_HANDLER", outputHandler)
+	var handler worker.Handler
+	var output *fileOutput
+	var sink *kafkaSink
+	switch handlerName {
+	case outputHandler:
+		fsync, err := parseFsyncPolicy(env("OUTPUT_FSYNC", string(fsyncNever)))
 		if err != nil {
-			if ctx.Err() != nil {
-				break
+			logger.Fatalf("%v", err)
+		}
+		fsyncSeconds := reg.NewHistogram("worker_output_fsync_seconds", "Time taken to sync the output file to disk.", []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1})
+		output = &fileOutput{path: outputPath, pod: pod, redact: redactor, fsync: fsync, syncEvery: envInt("OUTPUT_FSYNC_BATCH", 0), onSync: func(d time.Duration, err error) {
+			fsyncSeconds.Observe(d.Seconds())
+			if err != nil {
+				logger.Printf("output fsync failed: %v", err)
 			}
-			logger.Printf("dequeue error: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
+		}}
+		// A write that keeps failing (a detached volume, a full disk) goes
+		// to the spill, in memory or in OUTPUT_SPILL_DIR, until the file
+		// takes writes again.
+		output.retries = envInt("OUTPUT_WRITE_RETRIES", 3)
+		output.retryBackoff = envDuration("OUTPUT_WRITE_BACKOFF", 200*time.Millisecond)
+		if maxBytes := int64(envInt("OUTPUT_SPILL_MAX_BYTES", 8<<20)); maxBytes > 0 {
+			if dir := env("OUTPUT_SPILL_DIR", ""); dir != "" {
+				spooled, err := spool.Open(dir, maxBytes)
+				if err != nil {
+					logger.Fatalf("open output spill: %v", err)
+				}
+				defer spooled.Close()
+				if n := spooled.Len(); n > 0 {
+					logger.Printf("output spill has %d lines from a previous run", n)
+				}
+				output.spill = diskSpill{spooled}
+			} else {
+				output.spill = &memSpill{maxBytes: maxBytes}
+			}
+			reg.NewGaugeFunc("worker_output_spill_lines", "Output lines waiting in the spill for the output file to take writes again.", func() float64 { return float64(output.spill.Len()) })
+		}
+		if output.format, err = parseOutputFormat(env("OUTPUT_FORMAT", string(formatText))); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		output.queue, output.worker = consumed.Name(), hostname
+		if output.tmpl, err = parseOutputTemplate(); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if output.tmpl != nil && output.format != formatText {
+			logger.Fatalf("OUTPUT_TEMPLATE only applies to OUTPUT_FORMAT=%s", formatText)
+		}
+		handler = output
+	case forwardHandler:
+		forwardURL := env("FORWARD_URL", "")
+		if forwardURL == "" {
+			logger.Fatalf("FORWARD_URL is required with OUTPUT_HANDLER=%s", forwardHandler)
+		}
+		u, err := url.Parse(forwardURL)
+		if err != nil || u.Host == "" {
+			logger.Fatalf("FORWARD_URL %q is not an absolute URL", forwardURL)
+		}
+		timeout := time.Duration(envInt("FORWARD_TIMEOUT_SECONDS", 10)) * time.Second
+		// The limits are kept under the host, not the queue, so every
+		// forwarder calling it shares them.
+		guard := downstream.New(rdb, "downstream:", downstream.Config{
+			Concurrency:     envInt("FORWARD_MAX_CONCURRENCY", 0),
+			Lease:           2 * timeout,
+			Rate:            int64(envInt("FORWARD_RATE", 0)),
+			BreakerFailures: envInt("FORWARD_BREAKER_FAILURES", 5),
+			BreakerCooldown: envDuration("FORWARD_BREAKER_COOLDOWN", 30*time.Second),
+		})
+		breakerState := reg.NewGauge("worker_forward_breaker_state", "Circuit breaker state per downstream host: 0 closed, 1 half-open, 2 open.", "host")
+		breakerState.Set(0, u.Host)
+		guard.OnState = func(host string, s downstream.State) {
+			breakerState.Set(float64(s), host)
+			logger.Printf("circuit breaker for %s is %s", host, s)
+		}
+		handler = &httpForward{
+			url:      forwardURL,
+			host:     u.Host,
+			client:   &http.Client{Timeout: timeout},
+			guard:    guard,
+			requests: reg.NewCounter("worker_forward_requests_total", "Requests forwarded, by downstream host and status class (2xx to 5xx, or error when there was no answer).", "host", "code"),
+			rejected: reg.NewCounter("worker_forward_breaker_rejected_total", "Requests failed without being sent because the host's circuit breaker was open.", "host"),
+			seconds:  reg.NewHistogram("worker_forward_seconds", "Time taken by forwarded requests, by downstream host.", []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "host"),
+			waited:   reg.NewHistogram("worker_forward_limit_wait_seconds", "Time forwarded requests waited for the host's rate or concurrency limit.", []float64{0, .01, .05, .1, .25, .5, 1, 2.5, 5}, "host", "limit"),
+		}
+	case kafkaHandler:
+		brokers := envList("KAFKA_BROKERS")
+		if len(brokers) == 0 {
+			logger.Fatalf("KAFKA_BROKERS is required with OUTPUT_HANDLER=%s", kafkaHandler)
 		}
+		var acks kafka.RequiredAcks
+		if err := acks.UnmarshalText([]byte(env("KAFKA_ACKS", "all"))); err != nil {
+			logger.Fatalf("KAFKA_ACKS: %v", err)
+		}
+		sink = &kafkaSink{
+			w: &kafka.Writer{
+				Addr:     kafka.TCP(brokers...),
+				Topic:    env("KAFKA_TOPIC", "processed"),
+				Balancer: &kafka.Hash{},
+				// Each message is written on its own and waited for, so
+				// there's no batch worth waiting a second to fill.
+				BatchTimeout:           envDuration("KAFKA_BATCH_TIMEOUT", 5*time.Millisecond),
+				RequiredAcks:           acks,
+				AllowAutoTopicCreation: envBool("KAFKA_AUTO_CREATE_TOPIC", true),
+			},
+			queue:  consumed.Name(),
+			worker: hostname,
+			pod:    pod,
+			redact: redactor,
+		}
+		handler = sink
+	case notifyHandler:
+		n := &notifier{
+			transport: env("NOTIFY_TRANSPORT", "smtp"),
+			limiter:   notify.NewLimiter(rdb, consumed.Name()+":notify:rate", int64(envInt("NOTIFY_RATE", 10))),
+			queue:     consumed.Name(),
+			sent:      reg.NewCounter("worker_notifications_total", "Notification requests handled, by transport and result: sent, failed (retried), or rejected (dead-lettered).", "queue", "transport", "result"),
+			waited:    reg.NewHistogram("worker_notify_rate_wait_seconds", "Time a notification waited for the shared NOTIFY_RATE.", []float64{0, .01, .05, .1, .25, .5, 1, 2.5, 5}),
+		}
+		if dir := env("NOTIFY_TEMPLATE_DIR", ""); dir != "" {
+			n.templates, err = notify.LoadTemplates(os.DirFS(dir))
+		} else {
+			n.templates, err = notify.DefaultTemplates()
+		}
+		if err != nil {
+			logger.Fatalf("load notification templates: %v", err)
+		}
+		switch n.transport {
+		case "smtp":
+			n.sender = &notify.SMTP{
+				Addr:     env("NOTIFY_SMTP_ADDR", "mailpit:1025"),
+				From:     env("NOTIFY_FROM", "learn-k8s@example.com"),
+				Username: env("NOTIFY_SMTP_USERNAME", ""),
+				Password: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			}
+		case "webhook":
+			url := env("NOTIFY_WEBHOOK_URL", "")
+			if url == "" {
+				logger.Fatalf("NOTIFY_WEBHOOK_URL is required with NOTIFY_TRANSPORT=webhook")
+			}
+			n.sender = &notify.Webhook{URL: url, Token: os.Getenv("NOTIFY_WEBHOOK_TOKEN"), Client: &http.Client{Timeout: envDuration("NOTIFY_TIMEOUT", 10*time.Second)}}
+		default:
+			logger.Fatalf("unknown NOTIFY_TRANSPORT %q (want smtp or webhook)", n.transport)
+		}
+		handler = n
+	case thumbnailHandler:
+		awsCfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			logger.Fatalf("aws config: %v", err)
+		}
+		t := &thumbnailer{
+			s3: s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+				// MinIO and most other S3-compatible stores want the bucket
+				// in the path rather than the host name.
+				o.UsePathStyle = envBool("S3_PATH_STYLE", false)
+			}),
+			bucket:   env("THUMBNAIL_BUCKET", ""),
+			prefix:   env("THUMBNAIL_PREFIX", "thumbnails/"),
+			maxBytes: int64(envInt("THUMBNAIL_MAX_BYTES", 20<<20)),
+			opts: thumbnail.Options{
+				MaxPixels: envInt("THUMBNAIL_MAX_PIXELS", 50_000_000),
+				Quality:   envInt("THUMBNAIL_QUALITY", 85),
+			},
+			queue:   consumed.Name(),
+			made:    reg.NewCounter("worker_thumbnails_total", "Thumbnail requests handled, by result: made, failed (retried), or rejected (dead-lettered).", "queue", "result"),
+			seconds: reg.NewHistogram("worker_thumbnail_seconds", "Time taken by each step of making thumbnails: download, decode, resize (per size), upload (per size).", []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "step"),
+		}
+		sizes := envList("THUMBNAIL_SIZES")
+		if len(sizes) == 0 {
+			sizes = []string{"256x256"}
+		}
+		for _, v := range sizes {
+			size, err := thumbnail.ParseSize(v)
+			if err != nil {
+				logger.Fatalf("THUMBNAIL_SIZES: %v", err)
+			}
+			t.sizes = append(t.sizes, size)
+		}
+		handler = t
+	case aggregateHandler:
+		stopwords := wordcount.DefaultStopwords
+		if _, ok := os.LookupEnv("AGGREGATE_STOPWORDS"); ok {
+			// Set but empty counts every word.
+			stopwords = envList("AGGREGATE_STOPWORDS")
+		}
+		handler = &aggregator{
+			q:         q,
+			words:     wordcount.New(stopwords, envInt("AGGREGATE_MIN_LENGTH", 3), envInt("AGGREGATE_MAX_LENGTH", 32)),
+			retention: envDuration("AGGREGATE_RETENTION", 24*time.Hour),
+			redact:    redactor,
+			counted:   reg.NewCounter("worker_aggregated_messages_total", "Messages the aggregation handler saw, by result: counted, or duplicate (redelivered, not counted again).", "queue", "result"),
+			total:     reg.NewCounter("worker_aggregated_words_total", "Words counted by the aggregation handler.", "queue"),
+		}
+	default:
+		logger.Fatalf("unknown OUTPUT_HANDLER %q (want %s, %s, %s, %s, %s, or %s)", handlerName, outputHandler, forwardHandler, kafkaHandler, notifyHandler, thumbnailHandler, aggregateHandler)
+	}
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", reg.Handler())
+	startupChecks := health.NewRegistry(5 * time.Second)
+	startupChecks.Register(
+		health.RedisPing(rdb),
+		health.RedisKeys(rdb, q.KeyTypes(), queueName+":startupz:"+hostname),
+		health.Config(func() []string { return invalidEnv }),
+	)
+	healthChecks := health.NewRegistry(2 * time.Second)
+	healthChecks.Register(
+		health.RedisPing(rdb),
+		health.QueueLag(consumed.OldestAge, time.Duration(envInt("QUEUE_LAG_MAX_SECONDS", 0))*time.Second),
+	)
+	if (workerMode == "consume" || workerMode == "drain") && handlerName == outputHandler {
+		startupChecks.Register(health.Writable("output", outputPath))
+		healthChecks.Register(health.Writable("sink", outputPath))
+		if output.spill != nil {
+			healthChecks.Register(health.Check{Name: "output_spill", Optional: true, Run: func(context.Context) error {
+				if n := output.spill.Len(); n > 0 {
+					return fmt.Errorf("%d output lines spilled", n)
+				}
+				return nil
+			}})
+		}
+	}
+	if (workerMode == "consume" || workerMode == "drain") && sink != nil {
+		healthChecks.Register(health.Check{Name: "kafka", Run: sink.check})
+	}
+	// The indexer feeds the processed archive into OpenSearch.
+	var search *opensearch.Client
+	if workerMode == "indexer" {
+		url := env("OPENSEARCH_URL", "")
+		if url == "" {
+			logger.Fatalf("OPENSEARCH_URL is required with WORKER_MODE=indexer")
+		}
+		search = opensearch.New(url, env("OPENSEARCH_USERNAME", ""), os.Getenv("OPENSEARCH_PASSWORD"))
+		healthChecks.Register(health.Check{Name: "opensearch", Run: search.Ping})
+	}
+	metricsMux.HandleFunc("GET /startupz", startupChecks.TextHandler())
+	metricsMux.HandleFunc("GET /health", healthChecks.Handler())
+	metricsMux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(buildinfo.Get())
+	})
+	metricsSrv := &http.Server{Addr: metricsAddr, Handler: metricsMux, ReadHeaderTimeout: 5 * time.Second}
+
+	reporter, err := errreport.New(env("ERROR_REPORTER_DSN", ""), errreport.Options{
+		Environment: env("ERROR_REPORTER_ENVIRONMENT", ""),
+		Release:     buildinfo.Get().Version,
+		ServerName:  hostname,
+		Tags:        map[string]string{"service": "worker", "queue": queueName},
+		OnError: func(err error) {
+			logger.Printf("error report failed: %v", err)
+		},
+	})
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	defer reporter.Flush(5 * time.Second)
+
+	var faults *chaos.Injector
+	if envBool("CHAOS_ENABLED", false) {
+		faults = chaos.New(chaos.Config{
+			LatencyRate: envFloat("CHAOS_LATENCY_RATE", 0),
+			MaxLatency:  time.Duration(envInt("CHAOS_LATENCY_MS", 0)) * time.Millisecond,
+			DropAckRate: envFloat("CHAOS_DROP_ACK_RATE", 0),
+			PanicRate:   envFloat("CHAOS_PANIC_RATE", 0),
+		})
+		logger.Printf("chaos enabled: %s", faults)
+	}
 
-		logger.Printf("dequeued message: %q", msg)
-		if processingDelay > 0 {
-			time.Sleep(processingDelay)
+	// With FAILURE_BUDGET set the worker backs off while more than that
+	// share of its recent messages fail, and reports degraded meanwhile.
+	var budget *worker.FailureBudget
+	if ratio := envFloat("FAILURE_BUDGET", 0); ratio > 0 {
+		budget = worker.NewFailureBudget(ratio, envInt("FAILURE_BUDGET_WINDOW", 100), envDuration("FAILURE_BUDGET_MAX_BACKOFF", 30*time.Second))
+		healthChecks.Register(health.Check{Name: "failure_budget", Optional: true, Run: budget.Check})
+		reg.NewGaugeFunc("worker_failure_rate", "Share of this worker's recent messages whose handler failed.", budget.FailureRate)
+		reg.NewGaugeFunc("worker_failure_budget_exhausted", "1 while the failure rate is over FAILURE_BUDGET and the worker is slowed down.", func() float64 {
+			if budget.Exhausted() {
+				return 1
+			}
+			return 0
+		})
+	}
+
+	options := []worker.Option{worker.WithHandlerTimeout(envDuration("HANDLER_TIMEOUT", 0))}
+	if envBool("PRODUCER_DEADLINES", false) {
+		options = append(options, worker.WithProducerDeadlines())
+	}
+	if ttl := envDuration("STATUS_TTL", time.Hour); ttl > 0 {
+		options = append(options, worker.WithStatus(q, ttl))
+	}
+	if every := envDuration("CANCEL_CHECK_INTERVAL", time.Second); every > 0 {
+		options = append(options, worker.WithCancellation(q, every))
+	}
+	if n := envInt("POISON_MAX_STRIKES", 0); n > 0 {
+		options = append(options, worker.WithPoisonDetection(q, n, reg.NewCounter("queue_poison_messages_total", "Messages quarantined for crashing or timing out the handler too often.", "queue", "reason")))
+	}
+
+	var drainIdle time.Duration
+	if workerMode == "drain" {
+		drainIdle = time.Duration(envInt("DRAIN_IDLE_SECONDS", 5)) * time.Second
+	}
+	options = append(options,
+		worker.WithHandlerName(handlerName),
+		worker.WithHostname(hostname),
+		worker.WithDrainIdle(drainIdle),
+		worker.WithProcessingDelay(processingDelay),
+		worker.WithChaos(faults),
+		worker.WithMigrations(payloadMigrations()),
+		worker.WithLatency(slo.NewLatency(reg, time.Duration(envFloat("LATENCY_SLO_SECONDS", 5)*float64(time.Second)), envFloat("LATENCY_SLO_TARGET", 0.99))),
+		worker.WithSlowThreshold(envDuration("SLOW_THRESHOLD", 0), reg.NewCounter("queue_slow_messages_total", "Messages whose processing exceeded the slow threshold.", "queue", "handler")),
+		worker.WithFailureClasses(reg.NewCounter("queue_handler_failures_total", "Failed attempts at messages by class: timeout, downstream, validation, panic, sink, or unknown.", "queue", "handler", "class")),
+		worker.WithFailureBudget(budget),
+		worker.WithPolicy(func() policy.Policy { return policies.For(queueName) }),
+		worker.WithRedaction(redactor),
+		worker.WithReporter(reporter),
+		worker.WithLogger(logger),
+		worker.WithEvents(emit),
+	)
+	w := worker.New(consumed, handler, options...)
+	reg.NewGaugeFunc("worker_quiet_hours", "1 while the worker waits out its queue's quiet hours.", func() float64 {
+		if w.Quiet() {
+			return 1
 		}
+		return 0
+	})
 
-		processed := fmt.Sprintf("%s | %s", time.Now().Format(time.RFC3339Nano), msg)
-		logger.Printf("processed message: %q", msg)
-		if err := appendLine(outputPath, processed); err != nil {
-			logger.Printf("write output error: %v", err)
+	dump := statedump.New()
+	dump.Add("process", func(context.Context) any {
+		return map[string]any{
+			"pid":        os.Getpid(),
+			"hostname":   hostname,
+			"pod":        pod,
+			"build":      buildinfo.Get(),
+			"mode":       workerMode,
+			"uptime":     time.Since(started).Round(time.Millisecond).String(),
+			"goroutines": runtime.NumGoroutine(),
+		}
+	})
+	dump.Add("in_flight", func(context.Context) any {
+		cur := w.Current()
+		if cur == nil {
+			return map[string]any{"message": nil, "processed": w.Processed()}
+		}
+		return map[string]any{"message": cur, "running_for": time.Since(cur.Started).Round(time.Millisecond).String(), "processed": w.Processed()}
+	})
+	dump.Add("queue", func(ctx context.Context) any {
+		stats, err := consumed.Stats(ctx)
+		if err != nil {
+			return map[string]string{"error": err.Error()}
+		}
+		paused, err := consumed.Paused(ctx)
+		if err != nil {
+			return map[string]any{"stats": stats, "paused_error": err.Error()}
+		}
+		return map[string]any{"stats": stats, "paused": paused}
+	})
+	dump.Add("config", func(context.Context) any { return configSnapshot() })
+	dump.OnSIGQUIT(os.Stderr)
+
+	// Start and stop order: the metrics server comes up first and goes down
+	// last, so /health and /metrics cover the whole run. The consumer stops
+	// on SIGINT/SIGTERM, when a drain finds the queue empty, or when the
+	// metrics server fails; its heartbeat keeps the worker listed until the
+	// last message is done and is then removed.
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	g, gctx := errgroup.WithContext(ctx)
+	if redisSwitch != nil {
+		go redisSwitch.Run(gctx, envDuration("REDIS_HEALTH_INTERVAL", time.Second))
+	}
+	if policies != nil {
+		go policies.Run(gctx, time.Duration(envInt("POLICY_RELOAD_SECONDS", 10))*time.Second, func() {
+			q.ApplyPolicy(policies.For(queueName))
+			logger.Printf("reloaded policies from %s", env("POLICY_FILE", ""))
+		}, func(err error) {
+			logger.Printf("policy reload error: %v", err)
+		})
+	}
+	// Rotated Redis credentials are used as connections are redialed.
+	rotations := reg.NewCounter("secret_rotations_total", "Secrets that changed when they were read again.", "name")
+	go creds.Run(gctx, time.Duration(envInt("SECRETS_REFRESH_SECONDS", 30))*time.Second, func(names []string) {
+		for _, name := range names {
+			logger.Printf("secret %s rotated", name)
+			rotations.Inc(name)
+		}
+	}, func(err error) {
+		logger.Printf("secret refresh error: %v", err)
+	})
+	// OUTPUT_FSYNC=interval syncs the output file in the background; it's
+	// synced once more when it's closed.
+	if output != nil {
+		go output.runSync(gctx, envDuration("OUTPUT_FSYNC_INTERVAL", time.Second))
+		// Flushes are retried every second while the file won't take
+		// writes; only log when the reason changes.
+		lastErr := ""
+		go output.runSpill(gctx, time.Second, func(n int) {
+			lastErr = ""
+			logger.Printf("wrote %d spilled output lines", n)
+		}, func(err error) {
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				logger.Printf("output spill flush stopped: %v", err)
+			}
+		})
+	}
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
+	g.Go(func() error {
+		return serve(metricsCtx, "metrics", metricsSrv, 5*time.Second)
+	})
+	g.Go(func() error {
+		defer stopMetrics()
+		switch workerMode {
+		case "consume", "drain":
+			logger.Printf("starting %s (redis=%s queue=%s output=%s delay=%s metrics=%s version=%s)", workerMode, redisAddr, consumed.Name(), outputPath, processingDelay, metricsAddr, buildinfo.Get().Version)
+			// A long-running consumer also campaigns to move delayed
+			// messages, so a plain deployment needs nothing else for them.
+			moverCtx, stopMover := context.WithCancel(gctx)
+			var movers errgroup.Group
+			if workerMode == "consume" && envBool("DELAYED_MOVER", true) {
+				mover := newDelayedMover(rdb, q, hostname, reg, logger)
+				movers.Go(func() error {
+					mover.run(moverCtx)
+					return nil
+				})
+			}
+			if workerMode == "consume" && envBool("RETENTION", true) {
+				retainedOutput := ""
+				if output != nil {
+					retainedOutput = outputPath
+				}
+				retention := newRetentionJob(rdb, q, hostname, retainedOutput, reg, logger)
+				movers.Go(func() error {
+					retention.run(moverCtx)
+					return nil
+				})
+			}
+			if workerMode == "consume" && envBool("SCHEDULER", true) {
+				sched := newScheduler(rdb, q, hostname, emit, reporter, redactor, reg, logger)
+				movers.Go(func() error {
+					sched.run(moverCtx)
+					return nil
+				})
+			}
+			consume(gctx, w, consumed, hostname, pod, logger)
+			stopMover()
+			_ = movers.Wait()
+			return nil
+		case "mover":
+			logger.Printf("starting delayed mover (redis=%s queue=%s metrics=%s)", redisAddr, queueName, metricsAddr)
+			var movers errgroup.Group
+			if envBool("SCHEDULER", true) {
+				sched := newScheduler(rdb, q, hostname, emit, reporter, redactor, reg, logger)
+				movers.Go(func() error {
+					sched.run(gctx)
+					return nil
+				})
+			}
+			newDelayedMover(rdb, q, hostname, reg, logger).run(gctx)
+			_ = movers.Wait()
+			return nil
+		case "source":
+			logger.Printf("starting file source (redis=%s queue=%s dir=%s metrics=%s)", redisAddr, queueName, env("SOURCE_DIR", ""), metricsAddr)
+			if err := runFileSource(gctx, rdb, q, emit, reporter, redactor, logger); err != nil {
+				return fmt.Errorf("file source: %w", err)
+			}
+			return nil
+		case "indexer":
+			logger.Printf("starting indexer (redis=%s queue=%s opensearch=%s metrics=%s)", redisAddr, queueName, search.URL, metricsAddr)
+			if err := newIndexer(q, search, hostname, reg, logger).run(gctx); err != nil {
+				return fmt.Errorf("indexer: %w", err)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown WORKER_MODE %q (want consume, drain, source, mover, or indexer)", workerMode)
+		}
+	})
+	failed := g.Wait()
+	if failed != nil {
+		logger.Printf("shutting down: %v", failed)
+	}
+	if output != nil {
+		// The consumer is done, so this is the last chance for the spill.
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		n, err := output.flushSpill(flushCtx)
+		if n > 0 {
+			logger.Printf("wrote %d spilled output lines on shutdown", n)
+		}
+		if err != nil {
+			logger.Printf("output spill flush failed on shutdown: %v", err)
+		}
+		cancelFlush()
+		if output.spill != nil && output.spill.Len() > 0 {
+			if _, onDisk := output.spill.(diskSpill); onDisk {
+				logger.Printf("%d output lines stay in the spill for the next start", output.spill.Len())
+			} else {
+				logger.Printf("%d spilled output lines lost: the output file still won't take writes", output.spill.Len())
+			}
+		}
+		if err := output.Close(); err != nil {
+			logger.Printf("close output: %v", err)
+		}
+	}
+
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			logger.Printf("close kafka writer: %v", err)
 		}
 	}
 
+	detachMetrics()
+	// A drain run is usually a Job that exits before Prometheus scrapes it,
+	// so its final numbers go to the Pushgateway instead.
+	if pushURL := env("PUSHGATEWAY_URL", ""); pushURL != "" && workerMode == "drain" {
+		pushCtx, cancelPush := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := reg.Push(pushCtx, pushURL, env("PUSHGATEWAY_JOB", "worker-drain"), pushLabels(env("PUSHGATEWAY_LABELS", ""))); err != nil {
+			logger.Printf("pushgateway error: %v", err)
+		} else {
+			logger.Printf("pushed metrics to %s", pushURL)
+		}
+		cancelPush()
+	}
+	detachTransport()
 	_ = rdb.Close()
 	logger.Printf("shutdown complete")
+	if logExport != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		logExport.Shutdown(flushCtx)
+		cancelFlush()
+	}
+	if failed != nil {
+		reporter.Flush(5 * time.Second)
+		os.Exit(1)
+	}
+}
+
+// consume runs the dequeue loop until ctx is canceled or, in drain mode, the
+// queue stays empty, with a heartbeat running alongside it.
+func consume(ctx context.Context, w *worker.Worker, q queue.Queue, hostname string, pod podinfo.Identity, logger *log.Logger) {
+	hbCtx, stopHeartbeat := context.WithCancel(context.Background())
+	var hb errgroup.Group
+	hb.Go(func() error {
+		heartbeat(hbCtx, q, hostname, pod, w, logger)
+		return nil
+	})
+
+	_ = w.Run(ctx)
+
+	stopHeartbeat()
+	_ = hb.Wait()
+	removeCtx, cancelRemove := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelRemove()
+	if err := q.RemoveHeartbeat(removeCtx, hostname); err != nil {
+		logger.Printf("remove heartbeat error: %v", err)
+	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+
+	"learn_k8s/phrase1/internal/migrate"
+)
+
+// payloadMigrations lists the upgrade steps for versioned payloads. The
+// worker handles payloads in the latest version; add a step here (and bump
+// producers afterwards) whenever the payload shape changes.
+func payloadMigrations() *migrate.Registry {
+	m := migrate.NewRegistry()
+	// v2 spelled out "qty" as "quantity".
+	m.Register(1, renameField("qty", "quantity"))
+	return m
+}
+
+// renameField moves a top-level field of a JSON object payload. Payloads that
+// aren't objects, or don't have the field, are left alone.
+func renameField(from, to string) migrate.Func {
+	return func(payload string) (string, error) {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(payload), &obj); err != nil {
+			return payload, nil
+		}
+		v, ok := obj[from]
+		if !ok {
+			return payload, nil
+		}
+		delete(obj, from)
+		obj[to] = v
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/leader"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// delayedMover promotes due delayed messages onto the queue. Every replica
+// runs one, but only the holder of the queue's mover lease promotes; the
+// others measure the delayed set, so due-message lag is still reported when
+// no replica holds the lease.
+type delayedMover struct {
+	q        *queue.RedisQueue
+	elector  *leader.Elector
+	poll     time.Duration
+	batch    int
+	promoted *metrics.Counter
+	waiting  *metrics.Gauge
+	lag      *metrics.Gauge
+	logger   *log.Logger
+}
+
+func newDelayedMover(rdb *redis.Client, q *queue.RedisQueue, hostname string, reg *metrics.Registry, logger *log.Logger) *delayedMover {
+	m := &delayedMover{
+		q:        q,
+		elector:  leader.New(rdb, q.Name()+":delayed:mover", hostname, time.Duration(envInt("DELAYED_LEASE_SECONDS", 15))*time.Second),
+		poll:     time.Duration(envInt("DELAYED_POLL_MS", 1000)) * time.Millisecond,
+		batch:    envInt("DELAYED_BATCH", 100),
+		promoted: reg.NewCounter("queue_delayed_promoted_total", "Delayed messages moved onto the queue once due.", "queue"),
+		waiting:  reg.NewGauge("queue_delayed_messages", "Messages in the delayed set, due or not.", "queue"),
+		lag:      reg.NewGauge("queue_delayed_due_lag_seconds", "How overdue the earliest delayed message not yet promoted is.", "queue"),
+		logger:   logger,
+	}
+	leading := reg.NewGauge("queue_delayed_mover_leader", "1 while this replica holds the delayed mover lease.", "queue")
+	m.elector.OnChange(func(on bool) {
+		if on {
+			leading.Set(1, q.Name())
+			logger.Printf("delayed mover: acquired lease for %s", q.Name())
+			return
+		}
+		leading.Set(0, q.Name())
+		logger.Printf("delayed mover: released lease for %s", q.Name())
+	})
+	return m
+}
+
+// run campaigns for the lease and measures the delayed set until ctx is
+// canceled, and returns once the lease is released.
+func (m *delayedMover) run(ctx context.Context) {
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		m.elector.Run(ctx, m.promote)
+	}()
+	defer func() { <-elected }()
+	ticker := time.NewTicker(m.poll)
+	defer ticker.Stop()
+	for {
+		m.measure(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *delayedMover) measure(ctx context.Context) {
+	n, lag, err := m.q.Delayed(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Printf("delayed mover: measure error: %v", err)
+		}
+		return
+	}
+	m.waiting.Set(float64(n), m.q.Name())
+	m.lag.Set(lag.Seconds(), m.q.Name())
+}
+
+// promote moves due messages in batches every poll while this replica leads.
+// A full batch is followed straight away by the next one, so a backlog of
+// due messages drains without waiting for the ticker.
+func (m *delayedMover) promote(ctx context.Context) {
+	ticker := time.NewTicker(m.poll)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := m.q.PromoteDue(ctx, time.Now(), m.batch)
+			if err != nil {
+				if ctx.Err() == nil {
+					m.logger.Printf("delayed mover: promote error: %v", err)
+				}
+				break
+			}
+			m.promoted.Add(float64(n), m.q.Name())
+			if n < m.batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/notify"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// notifyHandler names the notification sender in error and slow-message
+// reports.
+const notifyHandler = "notify"
+
+// notifier is the handler for OUTPUT_HANDLER=notify: each message is a
+// notification request, rendered from a template and sent by SMTP or to a
+// webhook provider under a rate shared by all workers. A request that can
+// never go through (malformed, an unknown template, a refused recipient) is
+// dead-lettered at once; anything else is retried under the queue's policy.
+type notifier struct {
+	templates *notify.Templates
+	sender    notify.Sender
+	transport string
+	limiter   *notify.Limiter
+	queue     string
+	sent      *metrics.Counter
+	waited    *metrics.Histogram
+}
+
+func (n *notifier) Handle(ctx context.Context, m worker.Message) error {
+	err := n.send(ctx, m)
+	result := "sent"
+	switch {
+	case errors.Is(err, notify.ErrRejected):
+		result = "rejected"
+		err = worker.Permanent(err)
+	case err != nil:
+		result = "failed"
+	}
+	n.sent.Inc(n.queue, n.transport, result)
+	return err
+}
+
+func (n *notifier) send(ctx context.Context, m worker.Message) error {
+	req, err := notify.ParseRequest(m.Text)
+	if err != nil {
+		return err
+	}
+	note, err := n.templates.Render(req)
+	if err != nil {
+		return err
+	}
+	note.ID = m.Envelope.ID
+	waited, err := n.limiter.Wait(ctx)
+	n.waited.Observe(waited.Seconds())
+	if err != nil {
+		return err
+	}
+	err = n.sender.Send(ctx, note)
+	if err != nil && !errors.Is(err, notify.ErrRejected) {
+		// The provider failing, not the notification being refused.
+		err = worker.Classify(worker.ClassDownstream, err)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/leader"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/rotate"
+)
+
+// retentionJob keeps a long-running deployment from filling up: it trims the
+// processed archive to ARCHIVE_RETENTION and rotates, compresses, and prunes
+// the output file. Only the holder of the queue's retention lease runs it, as
+// the replicas share both the archive and (in compose) the output volume.
+type retentionJob struct {
+	q          *queue.RedisQueue
+	elector    *leader.Elector
+	interval   time.Duration
+	maxAge     time.Duration
+	outputPath string
+	policy     rotate.Policy
+	trimmed    *metrics.Counter
+	rotations  *metrics.Counter
+	reclaimed  *metrics.Counter
+	logger     *log.Logger
+}
+
+// newRetentionJob returns the job for q; outputPath is empty when the worker
+// doesn't write an output file.
+func newRetentionJob(rdb *redis.Client, q *queue.RedisQueue, hostname, outputPath string, reg *metrics.Registry, logger *log.Logger) *retentionJob {
+	interval := envDuration("RETENTION_INTERVAL", 5*time.Minute)
+	j := &retentionJob{
+		q:          q,
+		elector:    leader.New(rdb, q.Name()+":retention", hostname, time.Duration(envInt("RETENTION_LEASE_SECONDS", 30))*time.Second),
+		interval:   interval,
+		maxAge:     envDuration("ARCHIVE_RETENTION", 24*time.Hour),
+		outputPath: outputPath,
+		policy: rotate.Policy{
+			MaxBytes: int64(envInt("OUTPUT_ROTATE_BYTES", 64<<20)),
+			Keep:     envInt("OUTPUT_KEEP", 5),
+			// Writers move to the new file on their next line, so one
+			// interval is plenty for the old one to go quiet.
+			Settle: interval,
+		},
+		trimmed:   reg.NewCounter("queue_archive_trimmed_total", "Processed archive entries dropped for being older than ARCHIVE_RETENTION.", "queue"),
+		rotations: reg.NewCounter("worker_output_rotations_total", "Times the output file was rotated.", "queue"),
+		reclaimed: reg.NewCounter("worker_retention_reclaimed_bytes_total", "Space the retention job freed, by what it trimmed: archive (Redis memory) or output (disk).", "queue", "what"),
+		logger:    logger,
+	}
+	leading := reg.NewGauge("worker_retention_leader", "1 while this replica holds the retention lease.", "queue")
+	j.elector.OnChange(func(on bool) {
+		if on {
+			leading.Set(1, q.Name())
+			logger.Printf("retention: acquired lease for %s", q.Name())
+			return
+		}
+		leading.Set(0, q.Name())
+		logger.Printf("retention: released lease for %s", q.Name())
+	})
+	return j
+}
+
+// run campaigns for the lease until ctx is canceled, and returns once the
+// lease is released.
+func (j *retentionJob) run(ctx context.Context) {
+	j.elector.Run(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			j.pass(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// pass trims the archive and rotates the output once, logging what that
+// reclaimed. A failure in one doesn't keep the other from running.
+func (j *retentionJob) pass(ctx context.Context) {
+	now := time.Now()
+	if j.maxAge > 0 {
+		n, freed, err := j.q.TrimArchive(ctx, now.Add(-j.maxAge))
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				j.logger.Printf("retention: archive trim error: %v", err)
+			}
+		case n > 0:
+			j.trimmed.Add(float64(n), j.q.Name())
+			j.reclaimed.Add(float64(freed), j.q.Name(), "archive")
+			j.logger.Printf("retention: trimmed %d archived messages older than %s from %s, freeing %d bytes", n, j.maxAge, j.q.ArchiveKey(), freed)
+		}
+	}
+	if j.outputPath == "" {
+		return
+	}
+	res, err := rotate.Run(j.outputPath, j.policy, now)
+	if res.Rotated != "" {
+		j.rotations.Inc(j.q.Name())
+		j.logger.Printf("retention: rotated %s to %s", j.outputPath, res.Rotated)
+	}
+	if res.Reclaimed() > 0 {
+		j.reclaimed.Add(float64(res.Reclaimed()), j.q.Name(), "output")
+		j.logger.Printf("retention: compressed %d and removed %d old output files, freeing %d bytes", res.Compressed, res.Removed, res.Reclaimed())
+	}
+	if err != nil {
+		j.logger.Printf("retention: output rotation error: %v", err)
+	}
+}
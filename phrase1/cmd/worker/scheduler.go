@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/leader"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/schedule"
+)
+
+// maxMissed caps the missed runs a late schedule counts, so one that ran
+// every minute and was down for a year doesn't stall the scheduler.
+const maxMissed = 10000
+
+// scheduler enqueues the queue's schedules (see /schedules on the api) as
+// they come due. Every replica may run one, but only the holder of the
+// queue's scheduler lease fires them; each run is also claimed atomically,
+// so replicas overlapping for a moment can't fire it twice.
+type scheduler struct {
+	q       *queue.RedisQueue
+	store   *schedule.Store
+	elector *leader.Elector
+	poll    time.Duration
+	grace   time.Duration
+	// catchUp is the most messages a catch_up_all schedule enqueues for
+	// one misfire; older missed runs past it are dropped.
+	catchUp  int
+	emit     func(events.Type, string, error, time.Duration)
+	reporter errreport.Reporter
+	redactor *redact.Messages
+	runs     *metrics.Counter
+	missed   *metrics.Counter
+	logger   *log.Logger
+}
+
+func newScheduler(rdb *redis.Client, q *queue.RedisQueue, hostname string, emit func(events.Type, string, error, time.Duration), reporter errreport.Reporter, redactor *redact.Messages, reg *metrics.Registry, logger *log.Logger) *scheduler {
+	s := &scheduler{
+		q:        q,
+		store:    schedule.NewStore(rdb, q.Name()),
+		elector:  leader.New(rdb, q.Name()+":scheduler", hostname, time.Duration(envInt("SCHEDULER_LEASE_SECONDS", 15))*time.Second),
+		poll:     envDuration("SCHEDULER_POLL", time.Second),
+		grace:    envDuration("SCHEDULE_MISFIRE_GRACE", time.Minute),
+		catchUp:  max(envInt("SCHEDULE_CATCH_UP_MAX", 100), 1),
+		emit:     emit,
+		reporter: reporter,
+		redactor: redactor,
+		runs:     reg.NewCounter("queue_schedule_runs_total", "Schedule runs by result: fired, caught_up (a missed run enqueued by catch_up_all), skipped (missed, with misfire skip), retried (the enqueue failed and the run was put back), or failed (it couldn't be, and the run was lost).", "queue", "result"),
+		missed:   reg.NewCounter("queue_schedule_missed_runs_total", "Schedule runs that came due while an earlier run was still waiting to fire, or were skipped for firing late.", "queue"),
+		logger:   logger,
+	}
+	leading := reg.NewGauge("queue_scheduler_leader", "1 while this replica holds the scheduler lease.", "queue")
+	s.elector.OnChange(func(on bool) {
+		if on {
+			leading.Set(1, q.Name())
+			logger.Printf("scheduler: acquired lease for %s", q.Name())
+			return
+		}
+		leading.Set(0, q.Name())
+		logger.Printf("scheduler: released lease for %s", q.Name())
+	})
+	return s
+}
+
+// run campaigns for the lease until ctx is canceled, and returns once the
+// lease is released.
+func (s *scheduler) run(ctx context.Context) {
+	s.elector.Run(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(s.poll)
+		defer ticker.Stop()
+		for {
+			s.tick(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// tick fires every schedule due by now.
+func (s *scheduler) tick(ctx context.Context, now time.Time) {
+	due, err := s.store.Due(ctx, now, 100)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Printf("scheduler: due error: %v", err)
+		}
+		return
+	}
+	for _, d := range due {
+		s.fire(ctx, d, now)
+	}
+}
+
+// fire claims d's run and enqueues its message. A run more than the grace
+// late is a misfire, and the runs missed since are enqueued once, skipped,
+// or caught up one by one, as the schedule says.
+func (s *scheduler) fire(ctx context.Context, d schedule.Due, now time.Time) {
+	sch, err := s.store.Get(ctx, d.ID)
+	if errors.Is(err, schedule.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		s.logger.Printf("scheduler: read schedule %s error: %v", d.ID, err)
+		return
+	}
+	next, ok := sch.Next(now)
+	claimed, err := s.store.Claim(ctx, d, next, ok)
+	if err != nil {
+		s.logger.Printf("scheduler: claim schedule %s error: %v", d.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	// Runs that came due while d's waited are folded into it, unless
+	// they're caught up.
+	missed := sch.Missed(d.At, now, maxMissed)
+	run := schedule.Run{At: d.At, FiredAt: now.UTC(), Missed: len(missed)}
+	runs := []time.Time{d.At}
+	if late := now.Sub(d.At); late > s.grace {
+		when := fmt.Sprintf("missed its %s run by %s, and %d since", d.At.Format(time.RFC3339), late.Round(time.Second), len(missed))
+		switch sch.Misfire {
+		case schedule.MisfireSkip:
+			run.Skipped = true
+			s.missed.Add(float64(len(missed)+1), s.q.Name())
+			s.runs.Inc(s.q.Name(), "skipped")
+			s.logger.Printf("scheduler: schedule %s %s; skipping", sch.ID, when)
+			s.record(ctx, sch.ID, run)
+			return
+		case schedule.MisfireCatchUpAll:
+			runs = append(runs, missed...)
+			if drop := len(runs) - s.catchUp; drop > 0 {
+				runs = runs[drop:]
+				s.logger.Printf("scheduler: schedule %s %s; catching up the last %d, dropping %d", sch.ID, when, len(runs), drop)
+			} else {
+				s.logger.Printf("scheduler: schedule %s %s; catching up", sch.ID, when)
+			}
+		default:
+			s.logger.Printf("scheduler: schedule %s %s; enqueuing once", sch.ID, when)
+		}
+	}
+
+	// All of a catch-up is enqueued in one transaction, or none of it.
+	payloads := make([]string, 0, len(runs))
+	var lastID string
+	for _, at := range runs {
+		envlp := envelope.New(sch.Message)
+		envlp.Source = "schedule:" + sch.ID
+		envlp.Metadata = map[string]string{schedule.MetaScheduledAt: at.UTC().Format(time.RFC3339)}
+		encoded, err := s.q.Encode(envlp)
+		if err != nil {
+			// Encoding would fail again, so the run stays claimed.
+			s.fail(ctx, sch.ID, run, lastID, err)
+			return
+		}
+		payloads = append(payloads, encoded)
+		lastID = envlp.ID
+	}
+	if len(payloads) == 1 {
+		err = s.q.Enqueue(ctx, payloads[0])
+	} else {
+		err = s.q.EnqueueMany(ctx, payloads)
+	}
+	if err != nil {
+		// Give the run back, so the next tick tries it again; it's only
+		// lost if Redis is gone for the release as well.
+		released, relErr := s.store.Release(context.WithoutCancel(ctx), d, next, ok)
+		if relErr != nil || !released {
+			if relErr != nil {
+				err = fmt.Errorf("%w; releasing the run: %v", err, relErr)
+			}
+			s.fail(ctx, sch.ID, run, lastID, err)
+			return
+		}
+		s.runs.Inc(s.q.Name(), "retried")
+		s.logger.Printf("scheduler: schedule %s enqueue error, retrying: %v", sch.ID, err)
+		return
+	}
+	s.missed.Add(float64(len(missed)), s.q.Name())
+	s.runs.Inc(s.q.Name(), "fired")
+	if n := len(runs) - 1; n > 0 {
+		run.CaughtUp = n
+		s.runs.Add(float64(n), s.q.Name(), "caught_up")
+	}
+	run.MessageID = lastID
+	for range runs {
+		s.emit(events.MessageEnqueued, sch.Message, nil, 0)
+	}
+	s.logger.Printf("enqueued %d message(s) from schedule %s: %q", len(runs), sch.ID, s.redactor.Text(sch.Message))
+	s.record(ctx, sch.ID, run)
+}
+
+// fail records run of schedule id as failed with err, for good.
+func (s *scheduler) fail(ctx context.Context, id string, run schedule.Run, messageID string, err error) {
+	s.logger.Printf("scheduler: schedule %s enqueue error: %v", id, err)
+	s.reporter.Report(errreport.Event{Err: err, Message: "schedule enqueue failed", MessageID: messageID, Tags: map[string]string{"queue": s.q.Name(), "source": "schedule:" + id}})
+	s.runs.Inc(s.q.Name(), "failed")
+	run.Error = err.Error()
+	s.record(ctx, id, run)
+}
+
+func (s *scheduler) record(ctx context.Context, id string, run schedule.Run) {
+	if err := s.store.Record(ctx, id, run); err != nil {
+		s.logger.Printf("scheduler: record run of %s error: %v", id, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/filesource"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+)
+
+// runFileSource enqueues files from SOURCE_DIR until ctx is canceled
+// (WORKER_MODE=source). It's meant to run as a sidecar sharing a volume with
+// a container that can only write files.
+func runFileSource(ctx context.Context, rdb *redis.Client, q *queue.RedisQueue, emit func(events.Type, string, error, time.Duration), reporter errreport.Reporter, redactor *redact.Messages, logger *log.Logger) error {
+	cfg := filesource.Config{
+		Dir:        env("SOURCE_DIR", ""),
+		Pattern:    env("SOURCE_PATTERN", "*"),
+		Mode:       filesource.Mode(env("SOURCE_MODE", string(filesource.ModeDrop))),
+		Poll:       time.Duration(envInt("SOURCE_POLL_MS", 1000)) * time.Millisecond,
+		SplitLines: envBool("SOURCE_SPLIT_LINES", false),
+	}
+
+	enqueue := func(ctx context.Context, payload, file string) error {
+		envlp := envelope.New(payload)
+		envlp.Source = "file:" + file
+		encoded, err := q.Encode(envlp)
+		if err != nil {
+			return err
+		}
+		if err := q.Enqueue(ctx, encoded); err != nil {
+			reporter.Report(errreport.Event{Err: err, Message: "file source enqueue failed", MessageID: envlp.ID, Tags: map[string]string{"source": envlp.Source}})
+			return err
+		}
+		logger.Printf("enqueued message from %s: %q", file, redactor.Text(payload))
+		emit(events.MessageEnqueued, payload, nil, 0)
+		return nil
+	}
+
+	offsets := filesource.NewRedisOffsets(rdb, q.Name()+":source:offsets")
+	src, err := filesource.New(cfg, enqueue, offsets, logger)
+	if err != nil {
+		return err
+	}
+	return src.Run(ctx)
+}
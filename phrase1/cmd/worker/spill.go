@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"learn_k8s/phrase1/internal/spool"
+)
+
+// errSpillFull is returned when the spill buffer can't take another line.
+var errSpillFull = errors.New("output spill buffer is full")
+
+// spill holds output lines that couldn't be written, in order, until the
+// output file takes writes again.
+type spill interface {
+	Append(line string) error
+	// Len is the number of lines waiting.
+	Len() int64
+	// Replay writes waiting lines in order until there are none left or
+	// write fails, and returns how many were written.
+	Replay(ctx context.Context, write func(line string) error) (int, error)
+}
+
+// memSpill is a spill in memory, capped at maxBytes of lines. It's lost if
+// the worker dies before the file is back.
+type memSpill struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	lines []string
+	bytes int64
+}
+
+func (s *memSpill) Append(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes+int64(len(line)) > s.maxBytes {
+		return errSpillFull
+	}
+	s.lines = append(s.lines, line)
+	s.bytes += int64(len(line))
+	return nil
+}
+
+func (s *memSpill) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.lines))
+}
+
+func (s *memSpill) Replay(ctx context.Context, write func(line string) error) (int, error) {
+	n := 0
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		if len(s.lines) == 0 {
+			s.mu.Unlock()
+			return n, nil
+		}
+		line := s.lines[0]
+		s.mu.Unlock()
+		if err := write(line); err != nil {
+			return n, err
+		}
+		s.mu.Lock()
+		s.lines[0] = ""
+		s.lines = s.lines[1:]
+		s.bytes -= int64(len(line))
+		s.mu.Unlock()
+		n++
+	}
+	return n, ctx.Err()
+}
+
+// diskSpill is a spill in a spool file, which a restarted worker picks up
+// again. It has to be on another volume than the output.
+type diskSpill struct {
+	*spool.Spool
+}
+
+func (s diskSpill) Append(line string) error {
+	err := s.Spool.Append(spool.Record{Queue: "output", Payload: line})
+	if errors.Is(err, spool.ErrFull) {
+		return errSpillFull
+	}
+	return err
+}
+
+func (s diskSpill) Replay(ctx context.Context, write func(line string) error) (int, error) {
+	return s.Spool.Replay(ctx, func(_ context.Context, r spool.Record) error {
+		return write(r.Payload)
+	})
+}
+
+// writeLine appends line to the output file, retrying a failed write with
+// backoff and spilling the line if it still fails. While anything is
+// spilled, new lines are spilled too, so the file keeps their order.
+func (o *fileOutput) writeLine(ctx context.Context, line string) error {
+	if o.spill != nil && o.spill.Len() > 0 {
+		return o.spillLine(line, nil)
+	}
+	err := o.appendLine(line)
+	backoff := o.retryBackoff
+	for attempt := 0; err != nil && attempt < o.retries; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return o.spillLine(line, err)
+		}
+		backoff *= 2
+		err = o.appendLine(line)
+	}
+	if err == nil {
+		return nil
+	}
+	return o.spillLine(line, err)
+}
+
+// spillLine puts line in the spill buffer; cause is why it wasn't written.
+// Without a spill, or with a full one, the message fails with cause.
+func (o *fileOutput) spillLine(line string, cause error) error {
+	if o.spill == nil {
+		return cause
+	}
+	if err := o.spill.Append(line); err != nil {
+		if cause == nil {
+			return err
+		}
+		return fmt.Errorf("%w (%v)", cause, err)
+	}
+	return nil
+}
+
+// flushSpill writes spilled lines to the output file, in order, until none
+// are left or a write fails, and returns how many it wrote.
+func (o *fileOutput) flushSpill(ctx context.Context) (int, error) {
+	if o.spill == nil || o.spill.Len() == 0 {
+		return 0, nil
+	}
+	return o.spill.Replay(ctx, o.appendLine)
+}
+
+// runSpill flushes the spill every interval until ctx is canceled. onFlushed
+// is called with the lines written, onError when a flush stopped early.
+func (o *fileOutput) runSpill(ctx context.Context, interval time.Duration, onFlushed func(n int), onError func(error)) {
+	if o.spill == nil {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := o.flushSpill(ctx)
+			if n > 0 {
+				onFlushed(n)
+			}
+			if err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
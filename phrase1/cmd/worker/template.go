@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"learn_k8s/phrase1/internal/cloudevents"
+	"learn_k8s/phrase1/internal/codec"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/podinfo"
+)
+
+// outputRecord is what an OUTPUT_TEMPLATE renders: one processed message.
+type outputRecord struct {
+	// Time is when the message was handled.
+	Time time.Time
+	ID   string
+	// Message is the payload as text, redacted.
+	Message       string
+	Source        string
+	SchemaVersion int
+	// ContentType is set for binary payloads only, as in the default format.
+	ContentType string
+	EnqueuedAt  time.Time
+	Attempt     int
+	Metadata    map[string]string
+	Pod         podinfo.Identity
+	CloudEvent  *cloudevents.Attributes
+}
+
+// outputFuncs quote values for the formats a template is likely to produce.
+var outputFuncs = template.FuncMap{
+	// json renders v as JSON: a quoted string, a number, an object.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// csv renders its arguments as one CSV row, quoting where needed.
+	"csv": func(fields ...any) (string, error) {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			row[i] = fmt.Sprint(f)
+		}
+		if err := w.Write(row); err != nil {
+			return "", err
+		}
+		w.Flush()
+		return strings.TrimSuffix(buf.String(), "\n"), w.Error()
+	},
+	// logfmt quotes a value if it's empty or has spaces, quotes, or =.
+	"logfmt": func(v any) string {
+		s := fmt.Sprint(v)
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"=\\") {
+			return strconv.Quote(s)
+		}
+		return s
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339Nano)
+	},
+}
+
+// parseOutputTemplate reads OUTPUT_TEMPLATE, or the file OUTPUT_TEMPLATE_FILE
+// names, and checks it against a sample record so a typo fails at startup
+// rather than on every message. It returns nil when neither is set.
+func parseOutputTemplate() (*template.Template, error) {
+	text := env("OUTPUT_TEMPLATE", "")
+	if path := env("OUTPUT_TEMPLATE_FILE", ""); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read OUTPUT_TEMPLATE_FILE: %w", err)
+		}
+		text = string(b)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("output").Option("missingkey=zero").Funcs(outputFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse OUTPUT_TEMPLATE: %w", err)
+	}
+	sample := outputRecord{Time: time.Now(), ID: envelope.NewID(), Message: "sample", EnqueuedAt: time.Now(), Attempt: 1, Metadata: map[string]string{}}
+	if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, fmt.Errorf("OUTPUT_TEMPLATE: %w", err)
+	}
+	return tmpl, nil
+}
+
+// newOutputRecord is the record for a message with text, handled now.
+func newOutputRecord(envlp envelope.Envelope, text string, schemaVersion, attempt int, pod podinfo.Identity) outputRecord {
+	return outputRecord{
+		Time:          time.Now(),
+		ID:            envlp.ID,
+		Message:       text,
+		Source:        envlp.Source,
+		SchemaVersion: schemaVersion,
+		ContentType:   codec.Binary(envlp.ContentType),
+		EnqueuedAt:    envlp.EnqueuedAt,
+		Attempt:       attempt,
+		Metadata:      envlp.Metadata,
+		Pod:           pod,
+		CloudEvent:    envlp.CloudEvent,
+	}
+}
+
+// renderOutput runs tmpl for r. A trailing newline, which a template file
+// usually ends with, is dropped; the output file adds its own.
+func renderOutput(tmpl *template.Template, r outputRecord) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
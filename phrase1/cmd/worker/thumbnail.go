@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/thumbnail"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// thumbnailHandler names the thumbnail maker in error and slow-message
+// reports.
+const thumbnailHandler = "thumbnail"
+
+// thumbnailRequest is a message for the thumbnail handler: the object to
+// make thumbnails of. Bucket defaults to THUMBNAIL_BUCKET.
+type thumbnailRequest struct {
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key"`
+}
+
+// thumbnailer is the handler for OUTPUT_HANDLER=thumbnail: it downloads the
+// image a message names from S3 (or anything S3-compatible), scales it down
+// to each of sizes, and uploads the results to <prefix><size>/<key> in the
+// same bucket. Most of the time goes to decoding and resampling, so it's the
+// example of a CPU-bound worker. Uploads overwrite, so a redelivered message
+// just makes the same thumbnails again.
+type thumbnailer struct {
+	s3       *s3.Client
+	bucket   string
+	prefix   string
+	sizes    []thumbnail.Size
+	maxBytes int64
+	opts     thumbnail.Options
+	queue    string
+	made     *metrics.Counter
+	seconds  *metrics.Histogram
+}
+
+func (t *thumbnailer) Handle(ctx context.Context, m worker.Message) error {
+	err := t.handle(ctx, m)
+	result := "made"
+	switch {
+	case errors.Is(err, worker.ErrPermanent):
+		result = "rejected"
+	case err != nil:
+		result = "failed"
+	}
+	t.made.Inc(t.queue, result)
+	return err
+}
+
+func (t *thumbnailer) handle(ctx context.Context, m worker.Message) error {
+	var req thumbnailRequest
+	if err := json.Unmarshal([]byte(m.Text), &req); err != nil {
+		return worker.Permanent(fmt.Errorf("not a thumbnail request: %w", err))
+	}
+	if req.Bucket == "" {
+		req.Bucket = t.bucket
+	}
+	if req.Key == "" || req.Bucket == "" {
+		return worker.Permanent(errors.New("thumbnail request needs a key and a bucket"))
+	}
+	// A bucket notification for the thumbnails themselves would otherwise
+	// make thumbnails of thumbnails forever.
+	if strings.HasPrefix(req.Key, t.prefix) {
+		return worker.Permanent(fmt.Errorf("%s is a thumbnail", req.Key))
+	}
+
+	m.Progress(0, "download")
+	start := time.Now()
+	data, err := t.download(ctx, req)
+	t.seconds.Observe(time.Since(start).Seconds(), "download")
+	if err != nil {
+		return err
+	}
+	m.Progress(10, "decode")
+	start = time.Now()
+	img, format, err := thumbnail.Decode(bytes.NewReader(data), t.opts)
+	t.seconds.Observe(time.Since(start).Seconds(), "decode")
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrUnsupported) {
+			err = worker.Permanent(err)
+		}
+		return fmt.Errorf("s3://%s/%s: %w", req.Bucket, req.Key, err)
+	}
+	for i, size := range t.sizes {
+		// Resizing doesn't watch ctx, so a cancelled message stops here.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m.Progress(20+80*float64(i)/float64(len(t.sizes)), size.String())
+		var out bytes.Buffer
+		start = time.Now()
+		res, err := thumbnail.Make(&out, img, format, size, t.opts)
+		t.seconds.Observe(time.Since(start).Seconds(), "resize")
+		if err != nil {
+			return err
+		}
+		key := t.prefix + size.String() + "/" + req.Key
+		start = time.Now()
+		_, err = t.s3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(req.Bucket),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(out.Bytes()),
+			ContentLength: aws.Int64(int64(out.Len())),
+			ContentType:   aws.String(res.ContentType()),
+			Metadata:      map[string]string{"source-key": req.Key, "source-size": res.Source.String()},
+		})
+		t.seconds.Observe(time.Since(start).Seconds(), "upload")
+		if err != nil {
+			return worker.Classify(worker.ClassSink, fmt.Errorf("upload s3://%s/%s: %w", req.Bucket, key, err))
+		}
+	}
+	return nil
+}
+
+// download reads the object, up to maxBytes.
+func (t *thumbnailer) download(ctx context.Context, req thumbnailRequest) ([]byte, error) {
+	obj, err := t.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(req.Bucket), Key: aws.String(req.Key)})
+	if err != nil {
+		var missing *types.NoSuchKey
+		if errors.As(err, &missing) {
+			err = worker.Permanent(err)
+		} else {
+			err = worker.Classify(worker.ClassDownstream, err)
+		}
+		return nil, fmt.Errorf("download s3://%s/%s: %w", req.Bucket, req.Key, err)
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(obj.Body, t.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download s3://%s/%s: %w", req.Bucket, req.Key, err)
+	}
+	if int64(len(data)) > t.maxBytes {
+		return nil, worker.Permanent(fmt.Errorf("s3://%s/%s is over %d bytes", req.Bucket, req.Key, t.maxBytes))
+	}
+	return data, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"learn_k8s/phrase1/internal/codec"
+	"learn_k8s/phrase1/internal/podinfo"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/pkg/worker"
+)
+
+// outputHandler names the file output in error and slow-message reports.
+const outputHandler = "file"
+
+// fileOutput is the worker binary's handler: it appends a line per message
+// to path.
+type fileOutput struct {
+	path   string
+	pod    podinfo.Identity
+	redact *redact.Messages
+	// format is text (the default when empty) or jsonl; queue and worker
+	// go into jsonl records.
+	format outputFormat
+	queue  string
+	worker string
+	// tmpl renders each text line from an outputRecord, if set, instead of
+	// the default "time | message | key=value ..." format.
+	tmpl *template.Template
+	// fsync is when lines are synced to disk (never if empty); under
+	// fsyncInterval syncEvery lines also trigger a sync, if set. onSync
+	// observes each sync.
+	fsync     fsyncPolicy
+	syncEvery int
+	onSync    func(time.Duration, error)
+	// A failed write is tried retries more times, retryBackoff apart and
+	// doubling, and then goes to spill, if set, for runSpill to write later.
+	retries      int
+	retryBackoff time.Duration
+	spill        spill
+
+	mu      sync.Mutex
+	f       *os.File
+	info    os.FileInfo
+	pending int
+}
+
+func (o *fileOutput) Handle(ctx context.Context, m worker.Message) error {
+	line, err := o.line(m)
+	if err != nil {
+		return fmt.Errorf("render output: %w", err)
+	}
+	if err := o.writeLine(ctx, line); err != nil {
+		return worker.Classify(worker.ClassSink, fmt.Errorf("write output: %w", err))
+	}
+	return nil
+}
+
+// line renders m in the output's format.
+func (o *fileOutput) line(m worker.Message) (string, error) {
+	envlp := m.Envelope
+	switch {
+	case o.format == formatJSONL:
+		return o.jsonlLine(m)
+	case o.tmpl != nil:
+		return renderOutput(o.tmpl, newOutputRecord(envlp, o.redact.Text(m.Text), m.SchemaVersion, m.Attempt, o.pod))
+	}
+	line := fmt.Sprintf("%s | %s", time.Now().Format(time.RFC3339Nano), o.redact.Text(m.Text))
+	if envlp.Source != "" {
+		line += " | source=" + envlp.Source
+	}
+	if m.SchemaVersion > 0 {
+		line += fmt.Sprintf(" | schema_version=%d", m.SchemaVersion)
+	}
+	if ct := codec.Binary(envlp.ContentType); ct != "" {
+		line += " | content_type=" + ct
+	}
+	if pairs := o.pod.Pairs(); len(pairs) > 0 {
+		line += " | " + strings.Join(pairs, " ")
+	}
+	if envlp.CloudEvent != nil {
+		line += " | " + strings.Join(envlp.CloudEvent.Pairs(), " ")
+	}
+	return line, nil
+}
+
+func ensureParentDir(path string) error {
+	dir := filepath.Dir(path)
+	return os.MkdirAll(dir, 0o755)
+}
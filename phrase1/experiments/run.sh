@@ -142,7 +142,7 @@ enqueue_batch() {
   local p="$4"
 
   # Use xargs to avoid external load tools.
-  seq 1 "$n" | xargs -I{} -P "$p" curl -sS -o /dev/null -X POST "$api_url/enqueue" -d "${prefix}{}"
+  seq 1 "$n" | xargs -I{} -P "$p" curl -sS -o /dev/null -X POST "$api_url/v1/enqueue" -d "${prefix}{}"
 }
 
 cmd_clean() {
@@ -346,7 +346,7 @@ cmd_slam_api() {
   started_at="$(date +%s)"
 
   local counts
-  counts="$(seq 1 "$n" | xargs -I{} -P "$p" curl -sS -o /dev/null -w "%{http_code}\n" -X POST "$api_url/enqueue" -d "${prefix}{}" | sort | uniq -c)"
+  counts="$(seq 1 "$n" | xargs -I{} -P "$p" curl -sS -o /dev/null -w "%{http_code}\n" -X POST "$api_url/v1/enqueue" -d "${prefix}{}" | sort | uniq -c)"
   echo "$counts"
 
   local ended_at
@@ -0,0 +1,325 @@
+// Package apikey manages API keys created at runtime through the api's admin
+// endpoints, next to the fixed ones in RBAC_FILE. Keys live in a Redis hash so
+// every api replica sees them; only a SHA-256 of each secret is stored, so
+// a secret is shown once, when it's created or rotated.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+var (
+	// ErrNotFound is returned for an unknown key ID.
+	ErrNotFound = errors.New("no such key")
+	// ErrInvalid wraps errors for keys that can't be created as asked.
+	ErrInvalid = errors.New("invalid key")
+	// ErrInactive is returned when rotating a revoked or expired key.
+	ErrInactive = errors.New("key is revoked or expired")
+)
+
+// secretPrefix marks the secrets this package issues, so they're easy to
+// spot in a leaked config or a secret scanner.
+const secretPrefix = "qk_"
+
+// Key is a key's metadata.
+type Key struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// Scopes are the roles granted; the key acts as the highest of them.
+	Scopes    []rbac.Role `json:"scopes"`
+	CreatedAt time.Time   `json:"created_at"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	RotatedAt *time.Time  `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time  `json:"revoked_at,omitempty"`
+	// PreviousUntil is when the secret replaced by the last rotation stops
+	// working.
+	PreviousUntil *time.Time `json:"previous_until,omitempty"`
+}
+
+// Role is the highest of k's scopes.
+func (k Key) Role() rbac.Role {
+	var best rbac.Role
+	for _, s := range k.Scopes {
+		if s.Allows(best) {
+			best = s
+		}
+	}
+	return best
+}
+
+// Active reports whether k can be used at now.
+func (k Key) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// record is a Key as stored, with its secrets' hashes.
+type record struct {
+	Key
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+}
+
+// Store keeps keys in the Redis hash key, one field per key ID, and a copy
+// in memory to authenticate requests against.
+type Store struct {
+	client *redis.Client
+	key    string
+	now    func() time.Time
+
+	mu     sync.RWMutex
+	byID   map[string]record
+	byHash map[string]string
+}
+
+// NewStore keeps keys in the Redis hash key.
+func NewStore(client *redis.Client, key string) *Store {
+	return &Store{client: client, key: key, now: time.Now, byID: map[string]record{}, byHash: map[string]string{}}
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create issues a key for owner with scopes, expiring at expires unless
+// that's zero, and returns it with its secret.
+func (s *Store) Create(ctx context.Context, owner string, scopes []rbac.Role, expires time.Time) (Key, string, error) {
+	if strings.TrimSpace(owner) == "" {
+		return Key{}, "", fmt.Errorf("%w: owner is required", ErrInvalid)
+	}
+	if len(scopes) == 0 {
+		return Key{}, "", fmt.Errorf("%w: at least one scope is required", ErrInvalid)
+	}
+	for _, sc := range scopes {
+		if _, err := rbac.ParseRole(string(sc)); err != nil {
+			return Key{}, "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+	now := s.now().UTC()
+	if !expires.IsZero() && !expires.After(now) {
+		return Key{}, "", fmt.Errorf("%w: expires_at is in the past", ErrInvalid)
+	}
+	id, err := newID()
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+	rec := record{Key: Key{ID: id, Owner: owner, Scopes: scopes, CreatedAt: now}, Hash: hash(secret)}
+	if !expires.IsZero() {
+		expires = expires.UTC()
+		rec.ExpiresAt = &expires
+	}
+	if err := s.save(ctx, rec); err != nil {
+		return Key{}, "", err
+	}
+	return rec.Key, secret, nil
+}
+
+// Revoke disables the key id for good. Revoking a revoked key is a no-op.
+func (s *Store) Revoke(ctx context.Context, id string) (Key, error) {
+	rec, err := s.load(ctx, id)
+	if err != nil {
+		return Key{}, err
+	}
+	if rec.RevokedAt == nil {
+		now := s.now().UTC()
+		rec.RevokedAt = &now
+		if err := s.save(ctx, rec); err != nil {
+			return Key{}, err
+		}
+	}
+	return rec.Key, nil
+}
+
+// Rotate gives the key id a new secret and returns it. The old secret keeps
+// working for grace, which may be zero.
+func (s *Store) Rotate(ctx context.Context, id string, grace time.Duration) (Key, string, error) {
+	rec, err := s.load(ctx, id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	now := s.now().UTC()
+	if !rec.Active(now) {
+		return Key{}, "", fmt.Errorf("%w: %s", ErrInactive, id)
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+	rec.RotatedAt = &now
+	rec.PreviousHash, rec.PreviousUntil = "", nil
+	if grace > 0 {
+		until := now.Add(grace)
+		rec.PreviousHash, rec.PreviousUntil = rec.Hash, &until
+	}
+	rec.Hash = hash(secret)
+	if err := s.save(ctx, rec); err != nil {
+		return Key{}, "", err
+	}
+	return rec.Key, secret, nil
+}
+
+// load reads id from Redis rather than the copy, so a change made through
+// another replica isn't undone.
+func (s *Store) load(ctx context.Context, id string) (record, error) {
+	src, err := s.client.HGet(ctx, s.key, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return record{}, ErrNotFound
+	}
+	if err != nil {
+		return record{}, err
+	}
+	var rec record
+	if err := json.Unmarshal([]byte(src), &rec); err != nil {
+		return record{}, fmt.Errorf("key %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+func (s *Store) save(ctx context.Context, rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, s.key, rec.ID, b).Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.byID[rec.ID]; ok {
+		delete(s.byHash, old.Hash)
+		delete(s.byHash, old.PreviousHash)
+	}
+	s.index(rec)
+	return nil
+}
+
+// index adds rec to the copy; the caller holds mu.
+func (s *Store) index(rec record) {
+	s.byID[rec.ID] = rec
+	s.byHash[rec.Hash] = rec.ID
+	if rec.PreviousHash != "" {
+		s.byHash[rec.PreviousHash] = rec.ID
+	}
+}
+
+// List returns every key, oldest first, including revoked and expired ones.
+func (s *Store) List() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Key, 0, len(s.byID))
+	for _, rec := range s.byID {
+		out = append(out, rec.Key)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Lookup returns the active key whose secret, or previous secret within its
+// grace period, is secret.
+func (s *Store) Lookup(secret string) (Key, bool) {
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return Key{}, false
+	}
+	h := hash(secret)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.byID[s.byHash[h]]
+	if !ok {
+		return Key{}, false
+	}
+	now := s.now()
+	if !rec.Active(now) {
+		return Key{}, false
+	}
+	if h == rec.PreviousHash && (rec.PreviousUntil == nil || !now.Before(*rec.PreviousUntil)) {
+		return Key{}, false
+	}
+	return rec.Key, true
+}
+
+// Refresh reloads the keys from Redis, picking up changes made through
+// other replicas. Records that don't decode are skipped and reported in the
+// returned error.
+func (s *Store) Refresh(ctx context.Context) error {
+	all, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]record, len(all))
+	var bad []string
+	for id, src := range all {
+		var rec record
+		if err := json.Unmarshal([]byte(src), &rec); err != nil || rec.ID != id {
+			bad = append(bad, id)
+			continue
+		}
+		byID[id] = rec
+	}
+	s.mu.Lock()
+	s.byID, s.byHash = map[string]record{}, map[string]string{}
+	for _, rec := range byID {
+		s.index(rec)
+	}
+	s.mu.Unlock()
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return fmt.Errorf("skipped unreadable keys: %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// Run refreshes every interval until ctx is canceled.
+func (s *Store) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package apikey
+
+import (
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/rbac"
+)
+
+func TestLookup(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	s := NewStore(nil, "apikeys")
+	s.now = func() time.Time { return now }
+	s.index(record{Key: Key{ID: "live", Owner: "ci", Scopes: []rbac.Role{rbac.Producer, rbac.Operator}}, Hash: hash("qk_live")})
+	s.index(record{Key: Key{ID: "expired", ExpiresAt: &past}, Hash: hash("qk_expired")})
+	s.index(record{Key: Key{ID: "revoked", RevokedAt: &past}, Hash: hash("qk_revoked")})
+	s.index(record{Key: Key{ID: "grace", PreviousUntil: &future}, Hash: hash("qk_new"), PreviousHash: hash("qk_old")})
+	s.index(record{Key: Key{ID: "rotated", PreviousUntil: &past}, Hash: hash("qk_newer"), PreviousHash: hash("qk_older")})
+
+	for secret, want := range map[string]string{
+		"qk_live":    "live",
+		"qk_expired": "",
+		"qk_revoked": "",
+		"qk_new":     "grace",
+		"qk_old":     "grace",
+		"qk_newer":   "rotated",
+		"qk_older":   "",
+		"qk_unknown": "",
+		"live":       "",
+	} {
+		k, ok := s.Lookup(secret)
+		if ok != (want != "") || k.ID != want {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", secret, k.ID, ok, want)
+		}
+	}
+	if k, _ := s.Lookup("qk_live"); k.Role() != rbac.Operator {
+		t.Errorf("role %q, want the highest scope", k.Role())
+	}
+}
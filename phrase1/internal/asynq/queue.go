@@ -0,0 +1,360 @@
+package asynq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// Metadata keys carrying task fields through an envelope.
+const (
+	MetaType  = "asynq_type"
+	MetaQueue = "asynq_queue"
+	MetaRetry = "asynq_retry"
+)
+
+// DefaultTaskType is the type given to messages that didn't come from
+// asynq, unless SetTaskType picks another.
+const DefaultTaskType = "message"
+
+// pollInterval is how often an empty queue is checked again. asynq lists
+// can't be blocked on, since a task's id and its hash are written apart.
+const pollInterval = time.Second
+
+// statsTTL matches how long asynq keeps its daily counters.
+const statsTTL = 90 * 24 * time.Hour
+
+// allQueues is the set asynq lists queues from.
+const allQueues = "asynq:queues"
+
+// Queue is one asynq queue behind the queue.Queue interface. Messages go in
+// and out as envelopes, converted to and from tasks on the way; the parts of
+// queue.Queue asynq has no place for (heartbeats, slow and recent lists) are
+// kept by home, the repo's own queue of the same deployment.
+type Queue struct {
+	client   *redis.Client
+	name     string
+	home     *queue.RedisQueue
+	taskType string
+}
+
+var _ queue.Queue = (*Queue)(nil)
+
+// NewQueue returns the asynq queue name, e.g. "default".
+func NewQueue(client *redis.Client, name string, home *queue.RedisQueue) *Queue {
+	return &Queue{client: client, name: name, home: home, taskType: DefaultTaskType}
+}
+
+// SetTaskType sets the type of tasks enqueued from messages that don't carry
+// one, i.e. the handler asynq servers route them to.
+func (q *Queue) SetTaskType(t string) {
+	q.taskType = t
+}
+
+func (q *Queue) key(suffix string) string {
+	return "asynq:{" + q.name + "}:" + suffix
+}
+
+func (q *Queue) taskKey(id string) string { return q.key("t:" + id) }
+
+// Name is the asynq queue name.
+func (q *Queue) Name() string { return q.name }
+
+// DLQName is the sorted set asynq keeps archived tasks in.
+func (q *Queue) DLQName() string { return q.key("archived") }
+
+// Encode serializes e as the repo's queue would; Enqueue turns it into a
+// task.
+func (q *Queue) Encode(e envelope.Envelope) (string, error) {
+	return q.home.Encode(e)
+}
+
+// fromTask wraps a task in an envelope. The task id becomes the message id,
+// so logs on both sides line up.
+func fromTask(m TaskMessage, pendingSince int64) envelope.Envelope {
+	e := envelope.Envelope{
+		Version:  envelope.Version,
+		ID:       m.ID,
+		Source:   "asynq:" + m.Type,
+		Payload:  string(m.Payload),
+		Metadata: map[string]string{MetaType: m.Type, MetaQueue: m.Queue},
+	}
+	if pendingSince > 0 {
+		e.EnqueuedAt = time.Unix(0, pendingSince).UTC()
+	}
+	if m.Retry != DefaultMaxRetry {
+		e.Metadata[MetaRetry] = strconv.Itoa(int(m.Retry))
+	}
+	return e
+}
+
+// toTask is fromTask's inverse for q.
+func (q *Queue) toTask(e envelope.Envelope) TaskMessage {
+	m := TaskMessage{
+		Type:    e.Metadata[MetaType],
+		Payload: []byte(e.Payload),
+		ID:      e.ID,
+		Queue:   q.name,
+		Retry:   DefaultMaxRetry,
+		Timeout: DefaultTimeout,
+	}
+	if m.Type == "" {
+		m.Type = q.taskType
+	}
+	if m.ID == "" {
+		m.ID = envelope.NewID()
+	}
+	if n, err := strconv.Atoi(e.Metadata[MetaRetry]); err == nil {
+		m.Retry = int32(n)
+	}
+	return m
+}
+
+// ErrTaskIDConflict is returned by Enqueue for a message whose id is already
+// a task in the queue.
+var ErrTaskIDConflict = errors.New("asynq: task id conflicts with another task")
+
+// pushScript writes a pending task as asynq's enqueue does, pushing its id
+// with ARGV[4] (LPUSH to enqueue, RPUSH to requeue at the head).
+var pushScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "msg", ARGV[1], "state", "pending", "pending_since", ARGV[3])
+redis.call(ARGV[4], KEYS[2], ARGV[2])
+return 1
+`)
+
+func (q *Queue) push(ctx context.Context, payload, cmd string) error {
+	m := q.toTask(envelope.Decode(payload))
+	if err := q.client.SAdd(ctx, allQueues, q.name).Err(); err != nil {
+		return err
+	}
+	keys := []string{q.taskKey(m.ID), q.key("pending")}
+	n, err := pushScript.Run(ctx, q.client, keys, m.Marshal(), m.ID, time.Now().UnixNano(), cmd).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrTaskIDConflict, m.ID)
+	}
+	return nil
+}
+
+// Enqueue adds a pending task for the envelope in payload.
+func (q *Queue) Enqueue(ctx context.Context, payload string) error {
+	return q.push(ctx, payload, "LPUSH")
+}
+
+// Requeue puts a dequeued message back as the next task to run.
+func (q *Queue) Requeue(ctx context.Context, payload string) error {
+	return q.push(ctx, payload, "RPUSH")
+}
+
+// popScript takes the next pending task unless the queue is paused. The task
+// is deleted rather than moved to active: nothing will complete it.
+var popScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return nil
+end
+local id = redis.call("RPOP", KEYS[1])
+if not id then
+	return nil
+end
+local key = ARGV[1] .. id
+local v = redis.call("HMGET", key, "msg", "pending_since")
+redis.call("DEL", key)
+return v
+`)
+
+// pop returns the next task as an encoded envelope, or redis.Nil.
+func (q *Queue) pop(ctx context.Context) (string, error) {
+	for {
+		res, err := popScript.Run(ctx, q.client, []string{q.key("pending"), q.key("paused")}, q.key("t:")).Slice()
+		if err != nil {
+			return "", err
+		}
+		msg, _ := res[0].(string)
+		if msg == "" {
+			// The id outlived its task; asynq would skip it too.
+			continue
+		}
+		m, err := Unmarshal([]byte(msg))
+		if err != nil {
+			return "", err
+		}
+		since, _ := res[1].(string)
+		ns, _ := strconv.ParseInt(since, 10, 64)
+		return q.home.Encode(fromTask(m, ns))
+	}
+}
+
+// DequeueWithin is Dequeue giving up with queue.ErrEmpty after wait.
+func (q *Queue) DequeueWithin(ctx context.Context, wait time.Duration) (string, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		raw, err := q.pop(ctx)
+		if !errors.Is(err, redis.Nil) {
+			return raw, err
+		}
+		if time.Now().After(deadline) {
+			return "", queue.ErrEmpty
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(min(pollInterval, time.Until(deadline)+time.Millisecond)):
+		}
+	}
+}
+
+// Dequeue waits until a task is pending or ctx is canceled.
+func (q *Queue) Dequeue(ctx context.Context) (string, error) {
+	for {
+		raw, err := q.pop(ctx)
+		if !errors.Is(err, redis.Nil) {
+			return raw, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// archiveScript files a failed task under archived and counts it as
+// processed and failed, as asynq does after the last retry.
+var archiveScript = redis.NewScript(`
+redis.call("HSET", KEYS[1], "msg", ARGV[1], "state", "archived")
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+redis.call("INCR", KEYS[3])
+redis.call("INCR", KEYS[4])
+for _, k in ipairs({KEYS[5], KEYS[6]}) do
+	if redis.call("INCR", k) == 1 then
+		redis.call("EXPIRE", k, ARGV[4])
+	end
+end
+return 1
+`)
+
+// DeadLetter archives the task for payload.
+func (q *Queue) DeadLetter(ctx context.Context, payload string) error {
+	now := time.Now()
+	m := q.toTask(envelope.Decode(payload))
+	m.ErrorMsg = "dead-lettered by worker"
+	m.LastFailedAt = now.Unix()
+	day := now.UTC().Format(time.DateOnly)
+	keys := []string{
+		q.taskKey(m.ID), q.DLQName(),
+		q.key("processed"), q.key("failed"),
+		q.key("processed:" + day), q.key("failed:" + day),
+	}
+	return archiveScript.Run(ctx, q.client, keys, m.Marshal(), m.ID, now.Unix(), int(statsTTL.Seconds())).Err()
+}
+
+// RecordProcessed counts a finished task in asynq's counters and lists it in
+// home's recent messages.
+func (q *Queue) RecordProcessed(ctx context.Context, msg string, at time.Time) error {
+	daily := q.key("processed:" + at.UTC().Format(time.DateOnly))
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Incr(ctx, q.key("processed"))
+		p.Incr(ctx, daily)
+		p.Expire(ctx, daily, statsTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return q.home.RecordProcessed(ctx, msg, at)
+}
+
+// Archive keeps a processed task in home's archive stream.
+func (q *Queue) Archive(ctx context.Context, m queue.ArchivedMessage) error {
+	m.Queue = q.home.Name()
+	return q.home.Archive(ctx, m)
+}
+
+// Depth is the number of pending tasks.
+func (q *Queue) Depth(ctx context.Context) (int64, error) {
+	return q.client.LLen(ctx, q.key("pending")).Result()
+}
+
+// Stats reads asynq's counters. asynq doesn't count enqueues, and its
+// failures include the tasks archived here.
+func (q *Queue) Stats(ctx context.Context) (queue.Stats, error) {
+	var depth, archived *redis.IntCmd
+	var processed, failed *redis.StringCmd
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		depth = p.LLen(ctx, q.key("pending"))
+		archived = p.ZCard(ctx, q.DLQName())
+		processed = p.Get(ctx, q.key("processed"))
+		failed = p.Get(ctx, q.key("failed"))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return queue.Stats{}, err
+	}
+	p, _ := strconv.ParseInt(processed.Val(), 10, 64)
+	f, _ := strconv.ParseInt(failed.Val(), 10, 64)
+	return queue.Stats{
+		Queue:             q.name,
+		Depth:             depth.Val(),
+		DLQDepth:          archived.Val(),
+		ProcessedTotal:    p - f,
+		DeadLetteredTotal: f,
+	}, nil
+}
+
+// OldestAge is how long the next pending task has been waiting.
+func (q *Queue) OldestAge(ctx context.Context) (time.Duration, error) {
+	id, err := q.client.LIndex(ctx, q.key("pending"), -1).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ns, err := q.client.HGet(ctx, q.taskKey(id), "pending_since").Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(time.Unix(0, ns)), nil
+}
+
+// Pause pauses the queue for asynq servers too.
+func (q *Queue) Pause(ctx context.Context) error {
+	return q.client.SetNX(ctx, q.key("paused"), time.Now().Unix(), 0).Err()
+}
+
+func (q *Queue) Resume(ctx context.Context) error {
+	return q.client.Del(ctx, q.key("paused")).Err()
+}
+
+// Paused reports a pause set here or with asynq's CLI.
+func (q *Queue) Paused(ctx context.Context) (bool, error) {
+	n, err := q.client.Exists(ctx, q.key("paused")).Result()
+	return n == 1, err
+}
+
+func (q *Queue) RecordSlow(ctx context.Context, m queue.SlowMessage) error {
+	return q.home.RecordSlow(ctx, m)
+}
+
+func (q *Queue) Heartbeat(ctx context.Context, hb queue.Heartbeat, ttl time.Duration) error {
+	return q.home.Heartbeat(ctx, hb, ttl)
+}
+
+func (q *Queue) RemoveHeartbeat(ctx context.Context, id string) error {
+	return q.home.RemoveHeartbeat(ctx, id)
+}
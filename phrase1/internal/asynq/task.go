@@ -0,0 +1,153 @@
+// Package asynq reads and writes tasks in the Redis layout of
+// github.com/hibiken/asynq (v0.23 and later), so the worker can consume tasks
+// Asynq clients enqueue and Asynq servers can run messages this repo
+// produces, while a service migrates from one to the other.
+//
+// Only pending tasks are handled: scheduled, retry, and aggregating tasks
+// stay with Asynq, and tasks are consumed at most once like the repo's own
+// queue, with no lease or retries. Failed tasks are archived, where Asynq's
+// CLI and asynqmon show them.
+package asynq
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TaskMessage is asynq's internal task record, stored protobuf-encoded in
+// the "msg" field of the task's hash. Field numbers follow asynq's
+// internal/proto/asynq.proto.
+type TaskMessage struct {
+	Type         string
+	Payload      []byte
+	ID           string
+	Queue        string
+	Retry        int32
+	Retried      int32
+	ErrorMsg     string
+	Timeout      int64
+	Deadline     int64
+	UniqueKey    string
+	LastFailedAt int64
+	Retention    int64
+	CompletedAt  int64
+	GroupKey     string
+}
+
+// DefaultTimeout is the timeout, in seconds, asynq gives tasks enqueued with
+// neither a timeout nor a deadline.
+const DefaultTimeout = 1800
+
+// DefaultMaxRetry is asynq's default retry count.
+const DefaultMaxRetry = 25
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// Marshal encodes m as asynq stores it.
+func (m TaskMessage) Marshal() []byte {
+	b := appendString(nil, 1, m.Type)
+	if len(m.Payload) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Payload)
+	}
+	b = appendString(b, 3, m.ID)
+	b = appendString(b, 4, m.Queue)
+	b = appendVarint(b, 5, int64(m.Retry))
+	b = appendVarint(b, 6, int64(m.Retried))
+	b = appendString(b, 7, m.ErrorMsg)
+	b = appendVarint(b, 8, m.Timeout)
+	b = appendVarint(b, 9, m.Deadline)
+	b = appendString(b, 10, m.UniqueKey)
+	b = appendVarint(b, 11, m.LastFailedAt)
+	b = appendVarint(b, 12, m.Retention)
+	b = appendVarint(b, 13, m.CompletedAt)
+	b = appendString(b, 14, m.GroupKey)
+	return b
+}
+
+var errMalformed = errors.New("asynq: malformed task message")
+
+// Unmarshal decodes a task message. Fields it doesn't know are skipped, so
+// newer asynq versions still decode.
+func Unmarshal(b []byte) (TaskMessage, error) {
+	var m TaskMessage
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, errMalformed
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return m, errMalformed
+			}
+			b = b[n:]
+			switch num {
+			case 5:
+				m.Retry = int32(v)
+			case 6:
+				m.Retried = int32(v)
+			case 8:
+				m.Timeout = int64(v)
+			case 9:
+				m.Deadline = int64(v)
+			case 11:
+				m.LastFailedAt = int64(v)
+			case 12:
+				m.Retention = int64(v)
+			case 13:
+				m.CompletedAt = int64(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return m, errMalformed
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				m.Type = string(v)
+			case 2:
+				m.Payload = append([]byte(nil), v...)
+			case 3:
+				m.ID = string(v)
+			case 4:
+				m.Queue = string(v)
+			case 7:
+				m.ErrorMsg = string(v)
+			case 10:
+				m.UniqueKey = string(v)
+			case 14:
+				m.GroupKey = string(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return m, errMalformed
+			}
+			b = b[n:]
+		}
+	}
+	if m.ID == "" {
+		return m, fmt.Errorf("%w: no id", errMalformed)
+	}
+	return m, nil
+}
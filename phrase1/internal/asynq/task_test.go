@@ -0,0 +1,36 @@
+package asynq
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTaskMessageWire(t *testing.T) {
+	m := TaskMessage{Type: "email:send", Payload: []byte(`{"to":"a"}`), ID: "t1", Queue: "default", Retry: 25, Timeout: 1800}
+	// As asynq's generated code writes it: fields in number order, zero
+	// values left out.
+	want := []byte("\x0a\x0aemail:send\x12\x0a{\"to\":\"a\"}\x1a\x02t1\x22\x07default\x28\x19\x40\x88\x0e")
+	got := m.Marshal()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Marshal = %q, want %q", got, want)
+	}
+	back, err := Unmarshal(append(got, "\xa8\x01\x07"...)) // plus an unknown field 21
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, m) {
+		t.Errorf("Unmarshal = %+v, want %+v", back, m)
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	for name, b := range map[string]string{
+		"truncated": "\x0a\x0aemail",
+		"no id":     "\x0a\x04ping",
+	} {
+		if _, err := Unmarshal([]byte(b)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
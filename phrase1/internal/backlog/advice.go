@@ -0,0 +1,102 @@
+package backlog
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Demand is the load to size a queue's workers for.
+type Demand struct {
+	// ArrivalRate is messages enqueued per second.
+	ArrivalRate float64
+	// Depth is the messages already waiting.
+	Depth int64
+	// ProcessingTime is the mean time a worker takes over one message.
+	ProcessingTime time.Duration
+}
+
+// Target is how the workers should be sized.
+type Target struct {
+	// Utilization is the share of their time replicas should be busy, in
+	// (0, 1]. Below 1 leaves headroom for bursts, since a replica kept busy
+	// all the time builds a queue at the slightest one.
+	Utilization float64
+	// DrainWithin is how soon the waiting messages should be gone, on top
+	// of keeping up with arrivals; 0 ignores them.
+	DrainWithin time.Duration
+	// MinReplicas and MaxReplicas bound the advice; MaxReplicas 0 is
+	// unbounded.
+	MinReplicas, MaxReplicas int
+}
+
+// Advice is a worker replica count for a Demand, with the working that led
+// to it.
+type Advice struct {
+	Current     int `json:"current_replicas"`
+	Recommended int `json:"recommended_replicas"`
+	// ReplicaRate is the messages one replica handles per second. Workers
+	// handle one message at a time, so it's 1 / ProcessingTime.
+	ReplicaRate float64 `json:"replica_rate"`
+	// Utilization is the share of the current replicas' time the arrivals
+	// take up; above 1 they can't keep up and the backlog grows. It's 0
+	// with no replicas.
+	Utilization float64 `json:"utilization"`
+	// SteadyState is the replicas keeping up with arrivals at the target
+	// utilization, and Backlog the replicas more draining the waiting
+	// messages in time takes.
+	SteadyState float64 `json:"steady_state_replicas"`
+	Backlog     float64 `json:"backlog_replicas"`
+	// Steps spell out the arithmetic.
+	Steps []string `json:"steps"`
+}
+
+// Advise sizes the workers for d, given the current replicas, to meet t.
+// The count is Little's law — replicas busy = arrivals × processing time —
+// scaled up to leave the target's headroom, plus the replicas draining the
+// backlog within t.DrainWithin takes.
+func Advise(d Demand, current int, t Target) Advice {
+	a := Advice{Current: current}
+	secs := d.ProcessingTime.Seconds()
+	if secs <= 0 {
+		a.Recommended = max(current, t.MinReplicas)
+		a.Steps = append(a.Steps, "no processing time to size from; keeping the current replicas")
+		return a
+	}
+	a.ReplicaRate = 1 / secs
+	a.Steps = append(a.Steps, fmt.Sprintf("one replica handles 1 / %s = %s msg/s", num(secs)+"s", num(a.ReplicaRate)))
+
+	if current > 0 {
+		a.Utilization = d.ArrivalRate / (float64(current) * a.ReplicaRate)
+		a.Steps = append(a.Steps, fmt.Sprintf("the %d replica(s) now are %s / (%d × %s) = %.0f%% utilized", current, num(d.ArrivalRate), current, num(a.ReplicaRate), a.Utilization*100))
+	}
+
+	usable := a.ReplicaRate * t.Utilization
+	a.SteadyState = d.ArrivalRate / usable
+	a.Steps = append(a.Steps, fmt.Sprintf("keeping up with %s msg/s at %.0f%% utilization takes %s / (%s × %s) = %s replica(s)", num(d.ArrivalRate), t.Utilization*100, num(d.ArrivalRate), num(a.ReplicaRate), num(t.Utilization), num(a.SteadyState)))
+
+	if d.Depth > 0 && t.DrainWithin > 0 {
+		extra := float64(d.Depth) / t.DrainWithin.Seconds()
+		a.Backlog = extra / usable
+		a.Steps = append(a.Steps, fmt.Sprintf("draining the %d waiting within %s takes %d / %s = %s msg/s more, or %s / (%s × %s) = %s replica(s)", d.Depth, t.DrainWithin, d.Depth, num(t.DrainWithin.Seconds())+"s", num(extra), num(extra), num(a.ReplicaRate), num(t.Utilization), num(a.Backlog)))
+	}
+
+	// Round up, ignoring float error, so 3.0000000001 replicas is 3.
+	total := a.SteadyState + a.Backlog
+	a.Recommended = int(math.Ceil(total - 1e-9))
+	a.Steps = append(a.Steps, fmt.Sprintf("ceil(%s + %s) = %d replica(s)", num(a.SteadyState), num(a.Backlog), a.Recommended))
+	switch {
+	case a.Recommended < t.MinReplicas:
+		a.Recommended = t.MinReplicas
+		a.Steps = append(a.Steps, fmt.Sprintf("raised to the minimum of %d", t.MinReplicas))
+	case t.MaxReplicas > 0 && a.Recommended > t.MaxReplicas:
+		a.Recommended = t.MaxReplicas
+		a.Steps = append(a.Steps, fmt.Sprintf("capped at the maximum of %d", t.MaxReplicas))
+	}
+	return a
+}
+
+// num formats v to three significant figures.
+func num(v float64) string {
+	return fmt.Sprintf("%.3g", v)
+}
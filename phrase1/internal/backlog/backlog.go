@@ -0,0 +1,120 @@
+// Package backlog derives how fast a queue's backlog moves — its arrival
+// and drain rates, and how long it would take to empty — by sampling the
+// queue's depth and cumulative counters over a window, and sizes the
+// workers that would keep up with it.
+package backlog
+
+import (
+	"sync"
+	"time"
+)
+
+// MaxTimeToDrain caps the time to drain. A backlog that isn't shrinking
+// reports the cap, so scaling rules see a large finite number rather than
+// infinity.
+const MaxTimeToDrain = 24 * time.Hour
+
+// Sample is a queue's depth and cumulative counters at one moment.
+type Sample struct {
+	At    time.Time
+	Depth int64
+	// Enqueued counts the messages ever enqueued.
+	Enqueued int64
+	// Drained counts the messages ever taken off the queue for good:
+	// processed or dead-lettered.
+	Drained int64
+}
+
+// Rates is how a queue's backlog moved over the last window.
+type Rates struct {
+	// Window is the seconds the rates were measured over, which is less
+	// than the tracker's window until it has sampled that long.
+	Window float64 `json:"window_seconds"`
+	// ArrivalRate is messages enqueued per second.
+	ArrivalRate float64 `json:"arrival_rate"`
+	// DrainRate is messages processed or dead-lettered per second.
+	DrainRate float64 `json:"drain_rate"`
+	// TimeToDrain is the seconds until the backlog empties if both rates
+	// hold: 0 when it's empty, MaxTimeToDrain when it isn't shrinking.
+	TimeToDrain float64 `json:"time_to_drain_seconds"`
+}
+
+// Tracker keeps the samples of the last window. It's safe for concurrent
+// use.
+type Tracker struct {
+	window  time.Duration
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// NewTracker returns a Tracker measuring rates over window.
+func NewTracker(window time.Duration) *Tracker {
+	return &Tracker{window: window}
+}
+
+// Add records s, which must be newer than the samples before it. Counters
+// going backwards mean the queue's stats were reset, and the samples
+// before s are dropped.
+func (t *Tracker) Add(s Sample) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.samples); n > 0 {
+		last := t.samples[n-1]
+		if s.Enqueued < last.Enqueued || s.Drained < last.Drained {
+			t.samples = t.samples[:0]
+		}
+	}
+	t.samples = append(t.samples, s)
+	// Keep the newest sample at least a window old, so the rates span the
+	// whole window.
+	cut := 0
+	for cut+1 < len(t.samples) && s.At.Sub(t.samples[cut+1].At) >= t.window {
+		cut++
+	}
+	t.samples = append(t.samples[:0], t.samples[cut:]...)
+}
+
+// Latest returns the newest sample, and false before the first.
+func (t *Tracker) Latest() (Sample, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) == 0 {
+		return Sample{}, false
+	}
+	return t.samples[len(t.samples)-1], true
+}
+
+// Rates returns the rates between the oldest and newest samples, and false
+// until there are two.
+func (t *Tracker) Rates() (Rates, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < 2 {
+		return Rates{}, false
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	secs := last.At.Sub(first.At).Seconds()
+	if secs <= 0 {
+		return Rates{}, false
+	}
+	r := Rates{
+		Window:      secs,
+		ArrivalRate: float64(last.Enqueued-first.Enqueued) / secs,
+		DrainRate:   float64(last.Drained-first.Drained) / secs,
+	}
+	r.TimeToDrain = timeToDrain(last.Depth, r.DrainRate-r.ArrivalRate)
+	return r, true
+}
+
+// timeToDrain is the seconds depth messages take to drain at net messages
+// a second, capped at MaxTimeToDrain.
+func timeToDrain(depth int64, net float64) float64 {
+	maxSecs := MaxTimeToDrain.Seconds()
+	if depth <= 0 {
+		return 0
+	}
+	if net <= 0 {
+		return maxSecs
+	}
+	return min(float64(depth)/net, maxSecs)
+}
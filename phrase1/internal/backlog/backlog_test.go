@@ -0,0 +1,91 @@
+package backlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRates(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker(time.Minute)
+	if _, ok := tr.Rates(); ok {
+		t.Fatal("rates from no samples")
+	}
+	if _, ok := tr.Latest(); ok {
+		t.Fatal("latest of no samples")
+	}
+	tr.Add(Sample{At: t0, Depth: 100, Enqueued: 1000, Drained: 900})
+	if _, ok := tr.Rates(); ok {
+		t.Fatal("rates from one sample")
+	}
+	tr.Add(Sample{At: t0.Add(30 * time.Second), Depth: 70, Enqueued: 1030, Drained: 960})
+	tr.Add(Sample{At: t0.Add(60 * time.Second), Depth: 40, Enqueued: 1060, Drained: 1020})
+
+	r, ok := tr.Rates()
+	if !ok {
+		t.Fatal("no rates")
+	}
+	// 60 arrived and 120 drained over 60s: the backlog of 40 shrinks by 1/s.
+	want := Rates{Window: 60, ArrivalRate: 1, DrainRate: 2, TimeToDrain: 40}
+	if r != want {
+		t.Errorf("rates %+v, want %+v", r, want)
+	}
+
+	if s, _ := tr.Latest(); s.Depth != 40 {
+		t.Errorf("latest depth %d, want 40", s.Depth)
+	}
+
+	// Past the window, the oldest sample is dropped.
+	tr.Add(Sample{At: t0.Add(90 * time.Second), Depth: 70, Enqueued: 1150, Drained: 1050})
+	r, _ = tr.Rates()
+	if r.Window != 60 || r.ArrivalRate != 2 || r.DrainRate != 1.5 {
+		t.Errorf("rates %+v, want the last 60s", r)
+	}
+	if r.TimeToDrain != MaxTimeToDrain.Seconds() {
+		t.Errorf("growing backlog drains in %gs, want the cap", r.TimeToDrain)
+	}
+}
+
+func TestRatesAfterReset(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker(time.Minute)
+	tr.Add(Sample{At: t0, Enqueued: 500, Drained: 500})
+	tr.Add(Sample{At: t0.Add(10 * time.Second), Enqueued: 0, Drained: 0})
+	if r, ok := tr.Rates(); ok {
+		t.Errorf("rates %+v across a stats reset", r)
+	}
+	tr.Add(Sample{At: t0.Add(20 * time.Second), Enqueued: 10, Drained: 10})
+	if r, _ := tr.Rates(); r.ArrivalRate != 1 || r.DrainRate != 1 || r.TimeToDrain != 0 {
+		t.Errorf("rates %+v, want 1/s each way and an empty backlog", r)
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	target := Target{Utilization: 0.8, DrainWithin: 5 * time.Minute, MinReplicas: 1}
+	d := Demand{ArrivalRate: 12, Depth: 600, ProcessingTime: 250 * time.Millisecond}
+
+	a := Advise(d, 3, target)
+	// 4 msg/s a replica, 3.2 of it usable: 12/3.2 = 3.75 replicas for
+	// arrivals, 600/300s = 2 msg/s more is 0.625 for the backlog.
+	if a.ReplicaRate != 4 || a.Utilization != 1 || a.SteadyState != 3.75 || a.Backlog != 0.625 || a.Recommended != 5 {
+		t.Errorf("advice %+v", a)
+	}
+	if len(a.Steps) != 5 {
+		t.Errorf("steps %q", a.Steps)
+	}
+
+	target.MaxReplicas = 4
+	if a := Advise(d, 3, target); a.Recommended != 4 {
+		t.Errorf("recommended %d past the maximum of 4", a.Recommended)
+	}
+
+	idle := Advise(Demand{ProcessingTime: time.Second}, 2, target)
+	if idle.Recommended != 1 || idle.Utilization != 0 {
+		t.Errorf("idle queue advice %+v, want the minimum", idle)
+	}
+
+	target.MinReplicas = 0
+	if a := Advise(Demand{ArrivalRate: 1}, 2, target); a.Recommended != 2 {
+		t.Errorf("without a processing time recommended %d, want the current 2", a.Recommended)
+	}
+}
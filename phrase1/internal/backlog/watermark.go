@@ -0,0 +1,88 @@
+package backlog
+
+import "fmt"
+
+// Level is how worrying a queue's depth is.
+type Level int
+
+const (
+	Normal Level = iota
+	Warning
+	Critical
+)
+
+func (l Level) String() string {
+	switch l {
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return "normal"
+}
+
+// Watermarks are the depths a queue's Level changes at. A level is raised
+// when the depth reaches its high mark and lowered only once the depth
+// falls to its low mark, so a depth hovering around one mark doesn't flap
+// between levels. A zero high mark turns its level off.
+type Watermarks struct {
+	WarningHigh, WarningLow   int64
+	CriticalHigh, CriticalLow int64
+}
+
+// Validate reports marks that can't work: a low mark above its high one,
+// or a critical level below the warning one.
+func (w Watermarks) Validate() error {
+	if w.WarningHigh < 0 || w.CriticalHigh < 0 || w.WarningLow < 0 || w.CriticalLow < 0 {
+		return fmt.Errorf("watermarks must not be negative")
+	}
+	if w.WarningHigh > 0 && w.WarningLow > w.WarningHigh {
+		return fmt.Errorf("warning low watermark %d is above its high watermark %d", w.WarningLow, w.WarningHigh)
+	}
+	if w.CriticalHigh > 0 && w.CriticalLow > w.CriticalHigh {
+		return fmt.Errorf("critical low watermark %d is above its high watermark %d", w.CriticalLow, w.CriticalHigh)
+	}
+	if w.WarningHigh > 0 && w.CriticalHigh > 0 && w.CriticalHigh < w.WarningHigh {
+		return fmt.Errorf("critical high watermark %d is below the warning one %d", w.CriticalHigh, w.WarningHigh)
+	}
+	return nil
+}
+
+// Alarm tracks a queue's Level as its depth changes. It isn't safe for
+// concurrent use.
+type Alarm struct {
+	marks Watermarks
+	level Level
+}
+
+// NewAlarm returns an Alarm at Normal.
+func NewAlarm(marks Watermarks) *Alarm {
+	return &Alarm{marks: marks}
+}
+
+// Level returns the current level.
+func (a *Alarm) Level() Level { return a.level }
+
+// Update moves the alarm to the level depth calls for and returns it, and
+// whether it changed.
+func (a *Alarm) Update(depth int64) (Level, bool) {
+	next := a.level
+	m := a.marks
+	// Fall first, level by level, then rise, so one sample that jumps
+	// across several marks lands on the right level.
+	if next == Critical && (m.CriticalHigh == 0 || depth <= m.CriticalLow) {
+		next = Warning
+	}
+	if next == Warning && (m.WarningHigh == 0 || depth <= m.WarningLow) {
+		next = Normal
+	}
+	if next < Warning && m.WarningHigh > 0 && depth >= m.WarningHigh {
+		next = Warning
+	}
+	if next < Critical && m.CriticalHigh > 0 && depth >= m.CriticalHigh {
+		next = Critical
+	}
+	changed := next != a.level
+	a.level = next
+	return next, changed
+}
@@ -0,0 +1,58 @@
+package backlog
+
+import "testing"
+
+func TestAlarm(t *testing.T) {
+	marks := Watermarks{WarningHigh: 100, WarningLow: 80, CriticalHigh: 1000, CriticalLow: 800}
+	a := NewAlarm(marks)
+	steps := []struct {
+		depth   int64
+		level   Level
+		changed bool
+	}{
+		{50, Normal, false},
+		{100, Warning, true},
+		{90, Warning, false}, // between the marks: no flapping
+		{101, Warning, false},
+		{80, Normal, true},
+		{99, Normal, false},
+		{1500, Critical, true}, // straight past both high marks
+		{900, Critical, false},
+		{800, Warning, true},
+		{10, Normal, true},
+		{1000, Critical, true},
+		{0, Normal, true}, // straight past both low marks
+	}
+	for i, s := range steps {
+		level, changed := a.Update(s.depth)
+		if level != s.level || changed != s.changed {
+			t.Errorf("step %d: depth %d gave %s (changed %v), want %s (changed %v)", i, s.depth, level, changed, s.level, s.changed)
+		}
+	}
+}
+
+func TestAlarmCriticalOnly(t *testing.T) {
+	a := NewAlarm(Watermarks{CriticalHigh: 10, CriticalLow: 5})
+	for _, depth := range []int64{9, 10, 6, 5} {
+		a.Update(depth)
+	}
+	if a.Level() != Normal {
+		t.Errorf("level %s after falling to the critical low mark, want normal", a.Level())
+	}
+}
+
+func TestWatermarksValidate(t *testing.T) {
+	for name, w := range map[string]Watermarks{
+		"negative":       {WarningHigh: -1},
+		"warning low":    {WarningHigh: 10, WarningLow: 20},
+		"critical low":   {CriticalHigh: 10, CriticalLow: 20},
+		"critical below": {WarningHigh: 100, CriticalHigh: 50},
+	} {
+		if err := w.Validate(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if err := (Watermarks{WarningHigh: 100, WarningLow: 80}).Validate(); err != nil {
+		t.Errorf("valid marks: %v", err)
+	}
+}
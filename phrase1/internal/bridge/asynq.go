@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"learn_k8s/phrase1/internal/asynq"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// AsynqInbound moves pending tasks from src into q until ctx is canceled, so
+// Asynq producers feed the repo's queue. onEnqueued, if non-nil, is called
+// for each message enqueued.
+func AsynqInbound(ctx context.Context, src *asynq.Queue, q *queue.RedisQueue, onEnqueued func(envelope.Envelope), logger *log.Logger) error {
+	return relay(ctx, src, q, onEnqueued, logger)
+}
+
+// AsynqOutbound moves messages from q to dst as tasks until ctx is canceled,
+// so Asynq servers run what the repo's producers enqueue.
+func AsynqOutbound(ctx context.Context, q *queue.RedisQueue, dst *asynq.Queue, logger *log.Logger) error {
+	return relay(ctx, q, dst, nil, logger)
+}
+
+// relay pops messages from one queue and enqueues them on the other. A
+// message that can't be enqueued is put back at the head of from and
+// retried; one whose id is already a task is dropped as a duplicate.
+func relay(ctx context.Context, from, to queue.Queue, onMoved func(envelope.Envelope), logger *log.Logger) error {
+	for {
+		raw, err := from.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Printf("dequeue from %s error: %v", from.Name(), err)
+			sleep(ctx, retryDelay)
+			continue
+		}
+
+		// Finish with a fresh context so a message taken off Redis at
+		// shutdown still lands somewhere.
+		moveCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = to.Enqueue(moveCtx, raw)
+		switch {
+		case errors.Is(err, asynq.ErrTaskIDConflict):
+			logger.Printf("skipping duplicate: %v", err)
+		case err != nil:
+			cancel()
+			logger.Printf("enqueue to %s failed, requeueing: %v", to.Name(), err)
+			requeue(from, raw, logger)
+			sleep(ctx, retryDelay)
+			continue
+		case onMoved != nil:
+			onMoved(envelope.Decode(raw))
+		}
+		cancel()
+	}
+}
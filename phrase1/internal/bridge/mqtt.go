@@ -0,0 +1,121 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+type MQTTConfig struct {
+	Broker   string // e.g. tcp://mosquitto:1883
+	ClientID string
+	Username string
+	Password string
+	Topics   []string
+	QoS      byte
+	// ResultsTopic, if set, gets a message for every processed event.
+	ResultsTopic string
+}
+
+// mqttResult is published to ResultsTopic for each processed message.
+type mqttResult struct {
+	Queue       string    `json:"queue"`
+	Message     string    `json:"message"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Worker      string    `json:"worker,omitempty"`
+}
+
+// RunMQTT subscribes to cfg.Topics and enqueues every message received until
+// ctx is canceled. Processed events read from results are published to
+// cfg.ResultsTopic; results may be nil when that isn't wanted.
+func RunMQTT(ctx context.Context, cfg MQTTConfig, q *queue.RedisQueue, results <-chan events.Event, onEnqueued func(envelope.Envelope), logger *log.Logger) error {
+	handle := func(_ mqtt.Client, m mqtt.Message) {
+		envlp := envelope.New(string(m.Payload()))
+		envlp.Source = "mqtt:" + m.Topic()
+		envlp.Metadata = map[string]string{
+			"mqtt_topic": m.Topic(),
+			"mqtt_qos":   strconv.Itoa(int(m.Qos())),
+		}
+		encoded, err := q.Encode(envlp)
+		if err != nil {
+			logger.Printf("encode envelope failed: %v", err)
+			return
+		}
+		enqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := q.Enqueue(enqCtx, encoded); err != nil {
+			logger.Printf("enqueue failed for mqtt message on %s: %v", m.Topic(), err)
+			return
+		}
+		if onEnqueued != nil {
+			onEnqueued(envlp)
+		}
+	}
+
+	filters := make(map[string]byte, len(cfg.Topics))
+	for _, t := range cfg.Topics {
+		filters[t] = cfg.QoS
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(retryDelay).
+		// Subscriptions don't survive a reconnect with a clean session, so
+		// (re)subscribe on every connect.
+		SetOnConnectHandler(func(c mqtt.Client) {
+			logger.Printf("connected to %s", cfg.Broker)
+			if len(filters) == 0 {
+				return
+			}
+			if tok := c.SubscribeMultiple(filters, handle); tok.Wait() && tok.Error() != nil {
+				logger.Printf("mqtt subscribe error: %v", tok.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Printf("mqtt connection lost: %v", err)
+		})
+
+	client := mqtt.NewClient(opts)
+	// With connect retry the token only completes once connected, so don't
+	// block shutdown on it.
+	client.Connect()
+	defer client.Disconnect(250)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+			if e.Type != events.MessageProcessed || cfg.ResultsTopic == "" {
+				continue
+			}
+			b, err := json.Marshal(mqttResult{Queue: e.Queue, Message: e.Message, ProcessedAt: e.Time, Worker: e.Source})
+			if err != nil {
+				continue
+			}
+			tok := client.Publish(cfg.ResultsTopic, cfg.QoS, false, b)
+			if !tok.WaitTimeout(5 * time.Second) {
+				logger.Printf("mqtt publish to %s timed out", cfg.ResultsTopic)
+			} else if err := tok.Error(); err != nil {
+				logger.Printf("mqtt publish to %s failed: %v", cfg.ResultsTopic, err)
+			}
+		}
+	}
+}
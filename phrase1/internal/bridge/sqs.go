@@ -0,0 +1,168 @@
+// Package bridge relays messages between the Redis queue and external
+// brokers for hybrid-cloud demos.
+package bridge
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// SQSAPI is the part of *sqs.Client the bridge uses.
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, opts ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, in *sqs.DeleteMessageInput, opts ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	SendMessage(ctx context.Context, in *sqs.SendMessageInput, opts ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+type SQSConfig struct {
+	QueueURL string
+	// WaitTime is the long-poll duration, at most 20s.
+	WaitTime    time.Duration
+	MaxMessages int32
+}
+
+// retryDelay is how long the relays back off after a broker or Redis error.
+const retryDelay = time.Second
+
+// SQSInbound moves messages from SQS into q until ctx is canceled. A message
+// is deleted from SQS only after it has been enqueued, so a crash in between
+// redelivers it once its visibility timeout expires (at-least-once).
+// onEnqueued, if non-nil, is called for each message enqueued.
+func SQSInbound(ctx context.Context, client SQSAPI, cfg SQSConfig, q *queue.RedisQueue, onEnqueued func(envelope.Envelope), logger *log.Logger) error {
+	source := "sqs:" + path.Base(cfg.QueueURL)
+	for ctx.Err() == nil {
+		out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(cfg.QueueURL),
+			MaxNumberOfMessages:   cfg.MaxMessages,
+			WaitTimeSeconds:       int32(cfg.WaitTime / time.Second),
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Printf("sqs receive error: %v", err)
+			sleep(ctx, retryDelay)
+			continue
+		}
+
+		for _, m := range out.Messages {
+			envlp := fromSQS(m, source)
+			encoded, err := q.Encode(envlp)
+			if err != nil {
+				logger.Printf("encode envelope failed: %v", err)
+				continue
+			}
+			if err := q.Enqueue(ctx, encoded); err != nil {
+				// Leave it in SQS; it comes back after the visibility timeout.
+				logger.Printf("enqueue failed for sqs message %s: %v", aws.ToString(m.MessageId), err)
+				continue
+			}
+			if onEnqueued != nil {
+				onEnqueued(envlp)
+			}
+			if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(cfg.QueueURL),
+				ReceiptHandle: m.ReceiptHandle,
+			}); err != nil {
+				logger.Printf("sqs delete failed for %s (will be redelivered): %v", aws.ToString(m.MessageId), err)
+			}
+		}
+	}
+	return nil
+}
+
+// fromSQS wraps an SQS message in an envelope. Bodies that are already
+// envelopes, e.g. ones SQSOutbound sent from another cluster, keep their ID
+// and metadata.
+func fromSQS(m types.Message, source string) envelope.Envelope {
+	body := aws.ToString(m.Body)
+	envlp := envelope.Decode(body)
+	if envlp.ID == "" {
+		envlp = envelope.New(body)
+	}
+	if envlp.Source == "" {
+		envlp.Source = source
+	}
+	if envlp.Metadata == nil {
+		envlp.Metadata = make(map[string]string)
+	}
+	envlp.Metadata["sqs_message_id"] = aws.ToString(m.MessageId)
+	for k, v := range m.MessageAttributes {
+		if v.StringValue != nil {
+			envlp.Metadata["sqs_"+k] = *v.StringValue
+		}
+	}
+	return envlp
+}
+
+// SQSOutbound moves messages from q to SQS until ctx is canceled. A message
+// that can't be sent is put back at the head of q and retried.
+func SQSOutbound(ctx context.Context, client SQSAPI, cfg SQSConfig, q *queue.RedisQueue, logger *log.Logger) error {
+	for {
+		raw, err := q.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Printf("dequeue error: %v", err)
+			sleep(ctx, retryDelay)
+			continue
+		}
+
+		// SQS bodies must be text, so protobuf envelopes go out as JSON.
+		body := raw
+		if !strings.HasPrefix(raw, "{") {
+			if e := envelope.Decode(raw); e.ID != "" {
+				if body, err = e.Encode(); err != nil {
+					body = raw
+				}
+			}
+		}
+
+		// Send with a fresh context so a message taken off Redis at shutdown
+		// still gets delivered or put back.
+		sendCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err = client.SendMessage(sendCtx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(cfg.QueueURL),
+			MessageBody: aws.String(body),
+		})
+		if err != nil {
+			cancel()
+			logger.Printf("sqs send failed, requeueing: %v", err)
+			requeue(q, raw, logger)
+			sleep(ctx, retryDelay)
+			continue
+		}
+		cancel()
+	}
+}
+
+// requeue puts raw back at the head of q after a failed send. It gets a
+// context of its own: the send's may be the thing that ran out.
+func requeue(q queue.Queue, raw string, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := q.Requeue(ctx, raw); err != nil {
+		logger.Printf("requeue failed, message lost: %v", err)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,64 @@
+// Package buildinfo identifies the running build. Version, Commit, and
+// BuildTime are meant to be set with -ldflags "-X ..."; anything left empty
+// falls back to what the Go toolchain embedded (module version and VCS
+// stamp), so plain `go build` in a checkout still reports its commit.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
+
+// Register exports the build as the constant build_info gauge, the usual way
+// to join a version onto other series in PromQL.
+func Register(reg *metrics.Registry) {
+	info := Get()
+	reg.NewGauge("build_info", "Build of this process; always 1.", "version", "commit", "go_version").
+		Set(1, info.Version, info.Commit, info.GoVersion)
+}
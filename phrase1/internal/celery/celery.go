@@ -0,0 +1,219 @@
+// Package celery reads and writes Celery task messages (protocol 2, JSON
+// serializer) as kombu's Redis transport stores them, so Python Celery
+// producers can feed the worker and the api can feed Celery workers.
+//
+// kombu keeps a queue as a Redis list named after it, LPUSHed by producers
+// and BRPOPed by consumers, the same as this repo's queues; only the list
+// elements differ. A message's positional and keyword arguments map to the
+// payload: a task called with a single string argument carries that string,
+// anything else carries {"args":[...],"kwargs":{...}}. Messages written here
+// call their task with the payload as the only argument.
+package celery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// Metadata keys carrying task fields through an envelope.
+const (
+	MetaTask    = "celery_task"
+	MetaRetries = "celery_retries"
+	MetaETA     = "celery_eta"
+)
+
+// message is kombu's message envelope.
+type message struct {
+	Body            string     `json:"body"`
+	ContentEncoding string     `json:"content-encoding"`
+	ContentType     string     `json:"content-type"`
+	Headers         headers    `json:"headers"`
+	Properties      properties `json:"properties"`
+}
+
+// headers are Celery's protocol 2 task headers. Celery reads every one of
+// them, so they're written even when null.
+type headers struct {
+	Lang         string      `json:"lang"`
+	Task         string      `json:"task"`
+	ID           string      `json:"id"`
+	Shadow       *string     `json:"shadow"`
+	ETA          *string     `json:"eta"`
+	Expires      *string     `json:"expires"`
+	Group        *string     `json:"group"`
+	GroupIndex   *int        `json:"group_index"`
+	Retries      int         `json:"retries"`
+	TimeLimit    [2]*float64 `json:"timelimit"`
+	RootID       string      `json:"root_id"`
+	ParentID     *string     `json:"parent_id"`
+	ArgsRepr     string      `json:"argsrepr"`
+	KwargsRepr   string      `json:"kwargsrepr"`
+	Origin       string      `json:"origin"`
+	IgnoreResult bool        `json:"ignore_result"`
+}
+
+type properties struct {
+	CorrelationID string       `json:"correlation_id"`
+	ReplyTo       string       `json:"reply_to,omitempty"`
+	DeliveryMode  int          `json:"delivery_mode"`
+	DeliveryInfo  deliveryInfo `json:"delivery_info"`
+	Priority      int          `json:"priority"`
+	BodyEncoding  string       `json:"body_encoding"`
+	DeliveryTag   string       `json:"delivery_tag"`
+}
+
+type deliveryInfo struct {
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// embed is the third element of a protocol 2 body.
+type embed struct {
+	Callbacks json.RawMessage `json:"callbacks"`
+	Errbacks  json.RawMessage `json:"errbacks"`
+	Chain     json.RawMessage `json:"chain"`
+	Chord     json.RawMessage `json:"chord"`
+}
+
+// Format writes envelopes as Celery messages; it's a queue.Format.
+type Format struct {
+	// Task is the task name for messages that don't carry one, i.e. the
+	// function Celery workers call.
+	Task string
+	// Origin names the producer in the message, like Celery's
+	// "gen<pid>@<host>".
+	Origin string
+}
+
+// ErrBinaryPayload is returned for payloads that aren't text, which the
+// JSON serializer can't carry.
+var ErrBinaryPayload = errors.New("celery: binary payloads can't be sent as JSON task arguments")
+
+// Encode returns e as a message for the Celery queue name, calling the task
+// with e's payload.
+func (f Format) Encode(e envelope.Envelope, queue string) (string, error) {
+	if !utf8.ValidString(e.Payload) {
+		return "", ErrBinaryPayload
+	}
+	body, err := json.Marshal([]any{[]string{e.Payload}, map[string]any{}, embed{}})
+	if err != nil {
+		return "", err
+	}
+	task := e.Metadata[MetaTask]
+	if task == "" {
+		task = f.Task
+	}
+	id := e.ID
+	if id == "" {
+		id = envelope.NewID()
+	}
+	retries, _ := strconv.Atoi(e.Metadata[MetaRetries])
+	m := message{
+		Body:            base64.StdEncoding.EncodeToString(body),
+		ContentEncoding: "utf-8",
+		ContentType:     "application/json",
+		Headers: headers{
+			Lang:         "py",
+			Task:         task,
+			ID:           id,
+			Retries:      retries,
+			RootID:       id,
+			ArgsRepr:     argsRepr(e.Payload),
+			KwargsRepr:   "{}",
+			Origin:       f.Origin,
+			IgnoreResult: true,
+		},
+		Properties: properties{
+			CorrelationID: id,
+			DeliveryMode:  2,
+			DeliveryInfo:  deliveryInfo{RoutingKey: queue},
+			BodyEncoding:  "base64",
+			DeliveryTag:   envelope.NewID(),
+		},
+	}
+	if eta := e.Metadata[MetaETA]; eta != "" {
+		m.Headers.ETA = &eta
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// argsRepr approximates Python's repr of the argument tuple, which Celery
+// shows in logs and Flower.
+func argsRepr(payload string) string {
+	if len(payload) > 256 {
+		payload = payload[:256] + "..."
+	}
+	return "(" + strconv.Quote(payload) + ",)"
+}
+
+// IsMessage reports whether raw looks like a Celery message rather than an
+// envelope or a bare payload.
+func IsMessage(raw string) bool {
+	return strings.HasPrefix(raw, "{") && strings.Contains(raw, `"headers"`) && strings.Contains(raw, `"body"`)
+}
+
+// Decode turns a Celery protocol 2 message into an envelope whose id is the
+// task id.
+func Decode(raw string) (envelope.Envelope, error) {
+	var m message
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return envelope.Envelope{}, fmt.Errorf("celery: %w", err)
+	}
+	if m.Headers.Task == "" || m.Headers.ID == "" {
+		return envelope.Envelope{}, errors.New("celery: not a protocol 2 task message")
+	}
+	if ct := m.ContentType; ct != "application/json" {
+		return envelope.Envelope{}, fmt.Errorf("celery: unsupported serializer %s", ct)
+	}
+	body := []byte(m.Body)
+	if m.Properties.BodyEncoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(m.Body); err != nil {
+			return envelope.Envelope{}, fmt.Errorf("celery: body: %w", err)
+		}
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(body, &parts); err != nil || len(parts) < 2 {
+		return envelope.Envelope{}, errors.New("celery: body is not [args, kwargs, embed]")
+	}
+	var args []json.RawMessage
+	var kwargs map[string]json.RawMessage
+	if json.Unmarshal(parts[0], &args) != nil || json.Unmarshal(parts[1], &kwargs) != nil {
+		return envelope.Envelope{}, errors.New("celery: body is not [args, kwargs, embed]")
+	}
+
+	payload := ""
+	var s string
+	if len(args) == 1 && len(kwargs) == 0 && json.Unmarshal(args[0], &s) == nil {
+		payload = s
+	} else {
+		b, err := json.Marshal(map[string]any{"args": args, "kwargs": kwargs})
+		if err != nil {
+			return envelope.Envelope{}, err
+		}
+		payload = string(b)
+	}
+
+	e := envelope.Envelope{
+		Version:  envelope.Version,
+		ID:       m.Headers.ID,
+		Source:   "celery:" + m.Headers.Task,
+		Payload:  payload,
+		Metadata: map[string]string{MetaTask: m.Headers.Task},
+	}
+	if m.Headers.Retries > 0 {
+		e.Metadata[MetaRetries] = strconv.Itoa(m.Headers.Retries)
+	}
+	if m.Headers.ETA != nil {
+		e.Metadata[MetaETA] = *m.Headers.ETA
+	}
+	return e, nil
+}
@@ -0,0 +1,66 @@
+package celery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// pythonMessage is what Celery 5 pushes for add.delay(2, 3, debug=True).
+const pythonMessage = `{"body": "W1syLCAzXSwgeyJkZWJ1ZyI6IHRydWV9LCB7ImNhbGxiYWNrcyI6IG51bGwsICJlcnJiYWNrcyI6IG51bGwsICJjaGFpbiI6IG51bGwsICJjaG9yZCI6IG51bGx9XQ==", "content-encoding": "utf-8", "content-type": "application/json", "headers": {"lang": "py", "task": "tasks.add", "id": "5a6e7c1e-0d7b-4bd6-9d0e-1f3c2b8a9e10", "shadow": null, "eta": null, "expires": null, "group": null, "group_index": null, "retries": 0, "timelimit": [null, null], "root_id": "5a6e7c1e-0d7b-4bd6-9d0e-1f3c2b8a9e10", "parent_id": null, "argsrepr": "(2, 3)", "kwargsrepr": "{'debug': True}", "origin": "gen42@laptop", "ignore_result": false}, "properties": {"correlation_id": "5a6e7c1e-0d7b-4bd6-9d0e-1f3c2b8a9e10", "reply_to": "b3d1d5a0-3c4f-3a57-9d3e-6f1c1b1e2a3b", "delivery_mode": 2, "delivery_info": {"exchange": "", "routing_key": "celery"}, "priority": 0, "body_encoding": "base64", "delivery_tag": "0f2d5c8e-6a1b-4f0e-8d7c-3b2a1e0f9d8c"}}`
+
+func TestDecodePythonMessage(t *testing.T) {
+	if !IsMessage(pythonMessage) {
+		t.Fatal("IsMessage = false")
+	}
+	e, err := Decode(pythonMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "5a6e7c1e-0d7b-4bd6-9d0e-1f3c2b8a9e10" || e.Source != "celery:tasks.add" || e.Metadata[MetaTask] != "tasks.add" {
+		t.Errorf("envelope %+v", e)
+	}
+	if want := `{"args":[2,3],"kwargs":{"debug":true}}`; e.Payload != want {
+		t.Errorf("payload %s, want %s", e.Payload, want)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	in := envelope.New("hello")
+	raw, err := Format{Task: "tasks.process", Origin: "gen1@test"}.Encode(in, "celery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m message
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Headers.Task != "tasks.process" || m.Headers.ID != in.ID || m.Properties.DeliveryInfo.RoutingKey != "celery" {
+		t.Errorf("message %+v", m)
+	}
+	body, _ := base64.StdEncoding.DecodeString(m.Body)
+	if want := `[["hello"],{},{"callbacks":null,"errbacks":null,"chain":null,"chord":null}]`; string(body) != want {
+		t.Errorf("body %s, want %s", body, want)
+	}
+	// Celery looks these headers up by key, so null must still be present.
+	for _, key := range []string{`"eta":null`, `"shadow":null`, `"parent_id":null`, `"timelimit":[null,null]`} {
+		if !strings.Contains(raw, key) {
+			t.Errorf("message lacks %s: %s", key, raw)
+		}
+	}
+
+	out, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != in.ID || out.Payload != "hello" {
+		t.Errorf("decoded %+v", out)
+	}
+	if _, err := (Format{Task: "t"}).Encode(envelope.New("\xff\xfe"), "celery"); err != ErrBinaryPayload {
+		t.Errorf("binary payload err = %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package celery
+
+import (
+	"context"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// Queue is a Celery queue behind the queue.Queue interface: Celery messages
+// come out of Dequeue as envelopes and go back in as Celery messages.
+// Everything else, from stats to heartbeats, is the embedded RedisQueue's.
+type Queue struct {
+	*queue.RedisQueue
+}
+
+var _ queue.Queue = (*Queue)(nil)
+
+// NewQueue sets q to write Celery messages in format f and wraps it.
+func NewQueue(q *queue.RedisQueue, f Format) *Queue {
+	q.SetFormat(f)
+	return &Queue{RedisQueue: q}
+}
+
+// toEnvelope converts a Celery message to an encoded envelope. Other list
+// elements, and Celery messages this package can't read (protocol 1, pickle),
+// are returned as they are, so the worker handles them as bare payloads.
+func (q *Queue) toEnvelope(raw string) string {
+	if !IsMessage(raw) {
+		return raw
+	}
+	e, err := Decode(raw)
+	if err != nil {
+		return raw
+	}
+	encoded, err := e.Encode()
+	if err != nil {
+		return raw
+	}
+	return encoded
+}
+
+func (q *Queue) Dequeue(ctx context.Context) (string, error) {
+	raw, err := q.RedisQueue.Dequeue(ctx)
+	if err != nil {
+		return "", err
+	}
+	return q.toEnvelope(raw), nil
+}
+
+func (q *Queue) DequeueWithin(ctx context.Context, wait time.Duration) (string, error) {
+	raw, err := q.RedisQueue.DequeueWithin(ctx, wait)
+	if err != nil {
+		return "", err
+	}
+	return q.toEnvelope(raw), nil
+}
+
+// Requeue puts a dequeued message back as a Celery message.
+func (q *Queue) Requeue(ctx context.Context, payload string) error {
+	if e := envelope.Decode(payload); e.ID != "" {
+		if encoded, err := q.Encode(e); err == nil {
+			payload = encoded
+		}
+	}
+	return q.RedisQueue.Requeue(ctx, payload)
+}
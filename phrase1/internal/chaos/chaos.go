@@ -0,0 +1,82 @@
+// Package chaos injects faults into the api and worker for resilience
+// experiments. A nil *Injector is valid and never injects anything, so callers
+// don't need to guard every call site.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrInjected is returned for failures produced by the injector.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config holds per-fault probabilities in [0, 1].
+type Config struct {
+	EnqueueFailureRate float64
+	LatencyRate        float64
+	MaxLatency         time.Duration
+	DropAckRate        float64
+	PanicRate          float64
+}
+
+type Injector struct {
+	cfg Config
+}
+
+func New(cfg Config) *Injector {
+	return &Injector{cfg: cfg}
+}
+
+func (i *Injector) String() string {
+	if i == nil {
+		return "off"
+	}
+	c := i.cfg
+	return fmt.Sprintf("enqueue_fail=%.2f latency=%.2f(max %s) drop_ack=%.2f panic=%.2f",
+		c.EnqueueFailureRate, c.LatencyRate, c.MaxLatency, c.DropAckRate, c.PanicRate)
+}
+
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// EnqueueError returns ErrInjected with probability EnqueueFailureRate.
+func (i *Injector) EnqueueError() error {
+	if i != nil && roll(i.cfg.EnqueueFailureRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Delay sleeps for a random duration up to MaxLatency with probability
+// LatencyRate, returning early if ctx is canceled.
+func (i *Injector) Delay(ctx context.Context) time.Duration {
+	if i == nil || i.cfg.MaxLatency <= 0 || !roll(i.cfg.LatencyRate) {
+		return 0
+	}
+	d := time.Duration(rand.Int64N(int64(i.cfg.MaxLatency))) + 1
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return d
+}
+
+// DropAck reports whether the caller should skip completing a message, as if
+// the consumer died after doing the work but before acknowledging it.
+func (i *Injector) DropAck() bool {
+	return i != nil && roll(i.cfg.DropAckRate)
+}
+
+// MaybePanic panics with probability PanicRate.
+func (i *Injector) MaybePanic(where string) {
+	if i != nil && roll(i.cfg.PanicRate) {
+		panic(fmt.Sprintf("chaos: injected panic in %s", where))
+	}
+}
@@ -0,0 +1,313 @@
+// Package cloudevents implements the parts of CloudEvents 1.0 and its HTTP
+// protocol binding (structured and binary content modes) the api needs.
+package cloudevents
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	SpecVersion = "1.0"
+	// StructuredContentType marks a structured-mode request or response.
+	StructuredContentType = "application/cloudevents+json"
+)
+
+type Mode int
+
+const (
+	// ModeNone means the request was not a CloudEvent.
+	ModeNone Mode = iota
+	ModeStructured
+	ModeBinary
+)
+
+// Attributes are the context attributes of an event. Extensions hold any
+// attribute not defined by the spec, stringified.
+type Attributes struct {
+	SpecVersion     string            `json:"specversion"`
+	ID              string            `json:"id"`
+	Source          string            `json:"source"`
+	Type            string            `json:"type"`
+	DataContentType string            `json:"datacontenttype,omitempty"`
+	DataSchema      string            `json:"dataschema,omitempty"`
+	Subject         string            `json:"subject,omitempty"`
+	Time            string            `json:"time,omitempty"`
+	Extensions      map[string]string `json:"-"`
+}
+
+var knownAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true,
+	"datacontenttype": true, "dataschema": true, "subject": true, "time": true,
+	"data": true, "data_base64": true,
+}
+
+func (a Attributes) Validate() error {
+	var missing []string
+	if a.SpecVersion == "" {
+		missing = append(missing, "specversion")
+	}
+	if a.ID == "" {
+		missing = append(missing, "id")
+	}
+	if a.Source == "" {
+		missing = append(missing, "source")
+	}
+	if a.Type == "" {
+		missing = append(missing, "type")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("cloudevent missing required attributes: %s", strings.Join(missing, ", "))
+	}
+	if a.SpecVersion != SpecVersion {
+		return fmt.Errorf("unsupported cloudevents specversion %q (want %s)", a.SpecVersion, SpecVersion)
+	}
+	return nil
+}
+
+// MarshalJSON flattens extensions into the top-level object as the spec
+// requires.
+func (a Attributes) MarshalJSON() ([]byte, error) {
+	type plain Attributes
+	b, err := json.Marshal(plain(a))
+	if err != nil || len(a.Extensions) == 0 {
+		return b, err
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range a.Extensions {
+		m[k] = v
+	}
+	return json.Marshal(m)
+}
+
+func (a *Attributes) UnmarshalJSON(b []byte) error {
+	type plain Attributes
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	for k, v := range raw {
+		if knownAttributes[k] {
+			continue
+		}
+		if p.Extensions == nil {
+			p.Extensions = map[string]string{}
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			p.Extensions[k] = s
+		} else {
+			p.Extensions[k] = string(v)
+		}
+	}
+	*a = Attributes(p)
+	return nil
+}
+
+// Pairs returns all attributes as sorted key=value strings, for logs and
+// output records.
+func (a Attributes) Pairs() []string {
+	out := []string{"ce_id=" + a.ID, "ce_source=" + a.Source, "ce_type=" + a.Type, "ce_specversion=" + a.SpecVersion}
+	opt := []struct{ k, v string }{
+		{"ce_datacontenttype", a.DataContentType},
+		{"ce_dataschema", a.DataSchema},
+		{"ce_subject", a.Subject},
+		{"ce_time", a.Time},
+	}
+	for _, o := range opt {
+		if o.v != "" {
+			out = append(out, o.k+"="+o.v)
+		}
+	}
+	keys := make([]string, 0, len(a.Extensions))
+	for k := range a.Extensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, "ce_"+k+"="+a.Extensions[k])
+	}
+	return out
+}
+
+type Event struct {
+	Attributes
+	Data []byte
+}
+
+func isJSONContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
+
+// MarshalStructured encodes e in structured content mode. JSON data is
+// embedded as-is, anything else as data_base64.
+func (e Event) MarshalStructured() ([]byte, error) {
+	attrs, err := json.Marshal(e.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(attrs, &m); err != nil {
+		return nil, err
+	}
+	if len(e.Data) > 0 {
+		ct := e.DataContentType
+		if ct == "" || isJSONContentType(ct) {
+			if json.Valid(e.Data) {
+				m["data"] = e.Data
+			} else {
+				s, _ := json.Marshal(string(e.Data))
+				m["data"] = s
+			}
+		} else {
+			s, _ := json.Marshal(base64.StdEncoding.EncodeToString(e.Data))
+			m["data_base64"] = s
+		}
+	}
+	return json.Marshal(m)
+}
+
+// RequestMode reports which content mode r uses, based on headers only.
+func RequestMode(r *http.Request) Mode {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == StructuredContentType {
+		return ModeStructured
+	}
+	if r.Header.Get("Ce-Specversion") != "" {
+		return ModeBinary
+	}
+	return ModeNone
+}
+
+var errNotCloudEvent = errors.New("request is not a cloudevent")
+
+// FromRequest decodes a structured or binary mode event from r and its
+// already-read body.
+func FromRequest(r *http.Request, body []byte) (Event, error) {
+	switch RequestMode(r) {
+	case ModeStructured:
+		return parseStructured(body)
+	case ModeBinary:
+		return parseBinary(r.Header, body)
+	}
+	return Event{}, errNotCloudEvent
+}
+
+func parseStructured(body []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(body, &e.Attributes); err != nil {
+		return Event{}, fmt.Errorf("invalid structured cloudevent: %w", err)
+	}
+	var data struct {
+		Data       json.RawMessage `json:"data"`
+		DataBase64 string          `json:"data_base64"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return Event{}, fmt.Errorf("invalid structured cloudevent: %w", err)
+	}
+	switch {
+	case data.DataBase64 != "":
+		b, err := base64.StdEncoding.DecodeString(data.DataBase64)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid data_base64: %w", err)
+		}
+		e.Data = b
+	case len(data.Data) > 0:
+		// A JSON string is unwrapped so plain-text data round-trips.
+		var s string
+		if err := json.Unmarshal(data.Data, &s); err == nil {
+			e.Data = []byte(s)
+		} else {
+			e.Data = data.Data
+		}
+		if e.DataContentType == "" {
+			e.DataContentType = "application/json"
+		}
+	}
+	return e, e.Validate()
+}
+
+func parseBinary(h http.Header, body []byte) (Event, error) {
+	e := Event{Data: body}
+	e.DataContentType = h.Get("Content-Type")
+	for k, vs := range h {
+		lk := strings.ToLower(k)
+		if !strings.HasPrefix(lk, "ce-") || len(vs) == 0 {
+			continue
+		}
+		name, v := strings.TrimPrefix(lk, "ce-"), vs[0]
+		switch name {
+		case "specversion":
+			e.SpecVersion = v
+		case "id":
+			e.ID = v
+		case "source":
+			e.Source = v
+		case "type":
+			e.Type = v
+		case "dataschema":
+			e.DataSchema = v
+		case "subject":
+			e.Subject = v
+		case "time":
+			e.Time = v
+		default:
+			if e.Extensions == nil {
+				e.Extensions = map[string]string{}
+			}
+			e.Extensions[name] = v
+		}
+	}
+	return e, e.Validate()
+}
+
+// Write sends e as the HTTP response in the given mode.
+func Write(w http.ResponseWriter, mode Mode, status int, e Event) error {
+	if mode == ModeBinary {
+		h := w.Header()
+		h.Set("Ce-Specversion", e.SpecVersion)
+		h.Set("Ce-Id", e.ID)
+		h.Set("Ce-Source", e.Source)
+		h.Set("Ce-Type", e.Type)
+		if e.Subject != "" {
+			h.Set("Ce-Subject", e.Subject)
+		}
+		if e.Time != "" {
+			h.Set("Ce-Time", e.Time)
+		}
+		for k, v := range e.Extensions {
+			h.Set("Ce-"+k, v)
+		}
+		if e.DataContentType != "" {
+			h.Set("Content-Type", e.DataContentType)
+		}
+		w.WriteHeader(status)
+		_, err := w.Write(e.Data)
+		return err
+	}
+	b, err := e.MarshalStructured()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", StructuredContentType)
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,129 @@
+package cloudevents
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromRequestStructured(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		data     string
+		ct       string
+		ext      map[string]string
+		errMatch string
+	}{
+		{
+			name: "json data",
+			body: `{"specversion":"1.0","id":"1","source":"/s","type":"t","data":{"a":1}}`,
+			data: `{"a":1}`, ct: "application/json",
+		},
+		{
+			name: "string data unwrapped",
+			body: `{"specversion":"1.0","id":"1","source":"/s","type":"t","datacontenttype":"text/plain","data":"hello"}`,
+			data: "hello", ct: "text/plain",
+		},
+		{
+			name: "base64 data",
+			body: `{"specversion":"1.0","id":"1","source":"/s","type":"t","datacontenttype":"application/octet-stream","data_base64":"AAEC"}`,
+			data: "\x00\x01\x02", ct: "application/octet-stream",
+		},
+		{
+			name: "extensions",
+			body: `{"specversion":"1.0","id":"1","source":"/s","type":"t","traceparent":"00-x","count":3}`,
+			ext:  map[string]string{"traceparent": "00-x", "count": "3"},
+		},
+		{name: "missing attributes", body: `{"specversion":"1.0","id":"1"}`, errMatch: "missing required attributes: source, type"},
+		{name: "wrong specversion", body: `{"specversion":"0.3","id":"1","source":"/s","type":"t"}`, errMatch: "unsupported cloudevents specversion"},
+		{name: "bad base64", body: `{"specversion":"1.0","id":"1","source":"/s","type":"t","data_base64":"!!"}`, errMatch: "invalid data_base64"},
+		{name: "not json", body: `nope`, errMatch: "invalid structured cloudevent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/enqueue", nil)
+			r.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+			e, err := FromRequest(r, []byte(tt.body))
+			if tt.errMatch != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMatch) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.errMatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(e.Data) != tt.data || e.DataContentType != tt.ct {
+				t.Errorf("data %q (%s), want %q (%s)", e.Data, e.DataContentType, tt.data, tt.ct)
+			}
+			if !reflect.DeepEqual(e.Extensions, tt.ext) {
+				t.Errorf("extensions %v, want %v", e.Extensions, tt.ext)
+			}
+		})
+	}
+}
+
+func TestFromRequestBinary(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/enqueue", nil)
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Ce-Specversion", "1.0")
+	r.Header.Set("Ce-Id", "42")
+	r.Header.Set("Ce-Source", "/orders")
+	r.Header.Set("Ce-Type", "order.paid")
+	r.Header.Set("Ce-Subject", "order-7")
+	r.Header.Set("Ce-Tenant", "acme")
+	e, err := FromRequest(r, []byte("paid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Attributes{SpecVersion: "1.0", ID: "42", Source: "/orders", Type: "order.paid", Subject: "order-7", DataContentType: "text/plain", Extensions: map[string]string{"tenant": "acme"}}
+	if !reflect.DeepEqual(e.Attributes, want) || string(e.Data) != "paid" {
+		t.Errorf("event %+v %q, want %+v", e.Attributes, e.Data, want)
+	}
+
+	r.Header.Del("Ce-Type")
+	if _, err := FromRequest(r, nil); err == nil || !strings.Contains(err.Error(), "type") {
+		t.Errorf("missing ce-type: err = %v", err)
+	}
+}
+
+func TestFromRequestNotCloudEvent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/enqueue", nil)
+	r.Header.Set("Content-Type", "application/json")
+	if RequestMode(r) != ModeNone {
+		t.Error("plain JSON taken for a cloudevent")
+	}
+	if _, err := FromRequest(r, []byte(`{}`)); err != errNotCloudEvent {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	for _, e := range []Event{
+		{Attributes: Attributes{SpecVersion: "1.0", ID: "1", Source: "/s", Type: "t", DataContentType: "application/json", Extensions: map[string]string{"x": "y"}}, Data: []byte(`{"a":[1,2]}`)},
+		{Attributes: Attributes{SpecVersion: "1.0", ID: "2", Source: "/s", Type: "t", DataContentType: "application/octet-stream"}, Data: []byte{0, 255, 7}},
+	} {
+		b, err := e.MarshalStructured()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseStructured(b)
+		if err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("round trip of %s\n got %+v\nwant %+v", b, got, e)
+		}
+	}
+}
+
+func TestPairs(t *testing.T) {
+	a := Attributes{SpecVersion: "1.0", ID: "1", Source: "/s", Type: "t", Subject: "sub", Extensions: map[string]string{"b": "2", "a": "1"}}
+	want := []string{"ce_id=1", "ce_source=/s", "ce_type=t", "ce_specversion=1.0", "ce_subject=sub", "ce_a=1", "ce_b=2"}
+	if got := a.Pairs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pairs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Package codec validates the binary payload content types the api accepts
+// and renders them as text, since logs and the worker's output file are
+// line-oriented.
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	MsgPack  = "application/msgpack"
+	Protobuf = "application/x-protobuf"
+)
+
+var aliases = map[string]string{
+	"application/msgpack":             MsgPack,
+	"application/x-msgpack":           MsgPack,
+	"application/vnd.msgpack":         MsgPack,
+	"application/x-protobuf":          Protobuf,
+	"application/protobuf":            Protobuf,
+	"application/vnd.google.protobuf": Protobuf,
+}
+
+// Binary returns the canonical binary content type for a Content-Type header
+// value, or "" if it isn't one of the binary types.
+func Binary(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return aliases[mt]
+}
+
+// Render returns a text form of payload: JSON for MessagePack, and for
+// protobuf (which has no schema here) a JSON object of field numbers to values
+// in the spirit of protoc --decode_raw. Other content types are returned
+// unchanged. An error means the payload isn't valid for its content type.
+func Render(contentType string, payload []byte) (string, error) {
+	switch Binary(contentType) {
+	case MsgPack:
+		return renderMsgPack(payload)
+	case Protobuf:
+		return renderProtobuf(payload)
+	}
+	return string(payload), nil
+}
+
+func renderMsgPack(payload []byte) (string, error) {
+	r := bytes.NewReader(payload)
+	v, err := msgpack.NewDecoder(r).DecodeInterface()
+	if err != nil {
+		return "", fmt.Errorf("invalid msgpack: %w", err)
+	}
+	if r.Len() > 0 {
+		return "", errors.New("invalid msgpack: trailing data after first value")
+	}
+	b, err := json.Marshal(jsonable(v))
+	if err != nil {
+		return "", fmt.Errorf("msgpack value not representable as JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+// jsonable converts maps with non-string keys, which msgpack allows but JSON
+// doesn't, and raw bytes into JSON-friendly values.
+func jsonable(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = jsonable(e)
+		}
+		return t
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = jsonable(e)
+		}
+		return m
+	case []any:
+		for i, e := range t {
+			t[i] = jsonable(e)
+		}
+		return t
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	}
+	return v
+}
+
+func renderProtobuf(payload []byte) (string, error) {
+	fields, err := parseRaw(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid protobuf: %w", err)
+	}
+	var b strings.Builder
+	writeFields(&b, fields)
+	return b.String(), nil
+}
+
+type rawField struct {
+	num    protowire.Number
+	values []any
+}
+
+func parseRaw(b []byte) ([]rawField, error) {
+	byNum := make(map[protowire.Number]*rawField)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v any
+		switch typ {
+		case protowire.VarintType:
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			v = x
+		case protowire.Fixed32Type:
+			var x uint32
+			x, n = protowire.ConsumeFixed32(b)
+			v = x
+		case protowire.Fixed64Type:
+			var x uint64
+			x, n = protowire.ConsumeFixed64(b)
+			v = x
+		case protowire.BytesType:
+			var x []byte
+			x, n = protowire.ConsumeBytes(b)
+			v = bytesValue(x)
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", typ)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := byNum[num]
+		if f == nil {
+			f = &rawField{num: num}
+			byNum[num] = f
+		}
+		f.values = append(f.values, v)
+	}
+	out := make([]rawField, 0, len(byNum))
+	for _, f := range byNum {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].num < out[j].num })
+	return out, nil
+}
+
+// bytesValue guesses what a length-delimited field holds: printable text, a
+// nested message, or opaque bytes (base64).
+func bytesValue(b []byte) any {
+	if utf8.Valid(b) && strings.IndexFunc(string(b), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) < 0 {
+		return string(b)
+	}
+	if nested, err := parseRaw(b); err == nil && len(nested) > 0 {
+		return nested
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func writeFields(b *strings.Builder, fields []rawField) {
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Quote(strconv.Itoa(int(f.num))))
+		b.WriteByte(':')
+		if len(f.values) == 1 {
+			writeValue(b, f.values[0])
+			continue
+		}
+		b.WriteByte('[')
+		for j, v := range f.values {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			writeValue(b, v)
+		}
+		b.WriteByte(']')
+	}
+	b.WriteByte('}')
+}
+
+func writeValue(b *strings.Builder, v any) {
+	if nested, ok := v.([]rawField); ok {
+		writeFields(b, nested)
+		return
+	}
+	enc, _ := json.Marshal(v)
+	b.Write(enc)
+}
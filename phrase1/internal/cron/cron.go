@@ -0,0 +1,175 @@
+// Package cron matches times against five-field cron expressions:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is *, a number, a range a-b, a step */n or a-b/n, or a
+// comma-separated list of those. Day of week runs from 0 (Sunday) to 6, and
+// 7 is Sunday too. As in cron, when both day fields are restricted a day
+// matching either one matches.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed expression.
+type Schedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses expr.
+func Parse(expr string) (Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("cron %q: want %d fields, got %d", expr, len(fields), len(parts))
+	}
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("cron %q: %s: %w", expr, fields[i].name, err)
+		}
+		bits[i] = b
+	}
+	dow := bits[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+	return Schedule{
+		expr:          expr,
+		minute:        bits[0],
+		hour:          bits[1],
+		dom:           bits[2],
+		month:         bits[3],
+		dow:           dow,
+		domRestricted: parts[2] != "*",
+		dowRestricted: parts[4] != "*",
+	}, nil
+}
+
+// parseField returns the values field allows as a bit set.
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepText)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		switch {
+		case span == "*":
+		case strings.Contains(span, "-"):
+			a, b, _ := strings.Cut(span, "-")
+			var err error
+			if lo, err = value(a, min, max); err != nil {
+				return 0, err
+			}
+			if hi, err = value(b, min, max); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q runs backwards", span)
+			}
+		default:
+			v, err := value(span, min, max)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func value(s string, min, max int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// Matches reports whether s matches the minute t falls in, in t's location.
+func (s Schedule) Matches(t time.Time) bool {
+	return s.minute&(1<<t.Minute()) != 0 && s.hour&(1<<t.Hour()) != 0 && s.dayMatches(t)
+}
+
+// dayMatches reports whether s matches the day t falls on.
+func (s Schedule) dayMatches(t time.Time) bool {
+	if s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first minute after t that s matches, in t's location,
+// looking up to five years ahead; ok is false if there's none, as for
+// "0 0 30 2 *". A minute a daylight saving change skips is skipped too, and
+// one it repeats can match on both passes.
+func (s Schedule) Next(t time.Time) (next time.Time, ok bool) {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !s.dayMatches(t):
+			// time.Date can resolve a midnight that doesn't happen to
+			// before t; the next hour gets past it.
+			if day := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc); day.After(t) {
+				t = day
+			} else {
+				t = nextHour(t)
+			}
+		case s.hour&(1<<t.Hour()) == 0:
+			t = nextHour(t)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextHour is the start of the hour on t's clock after t's, counted in
+// elapsed time so it's never earlier across a daylight saving change.
+func nextHour(t time.Time) time.Time {
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+func (s Schedule) String() string { return s.expr }
@@ -0,0 +1,99 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	// 2026-03-02 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 30, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(2, 12, 0), true},
+		{"* 22-23,0-5 * * *", at(2, 23, 59), true},
+		{"* 22-23,0-5 * * *", at(2, 6, 0), false},
+		{"*/15 * * * *", at(2, 9, 45), true},
+		{"*/15 * * * *", at(2, 9, 46), false},
+		{"0-30/10 9 * * *", at(2, 9, 20), true},
+		{"5/20 * * * *", at(2, 9, 45), true},
+		{"* * * * 1-5", at(2, 9, 0), true},
+		{"* * * * 0,6", at(2, 9, 0), false},
+		{"* * * * 7", at(1, 9, 0), true},
+		{"* * * 3 *", at(2, 9, 0), true},
+		{"* * * 4 *", at(2, 9, 0), false},
+		// Both day fields restricted: either one matching is enough.
+		{"* * 15 * 1", at(2, 9, 0), true},
+		{"* * 2 * 0", at(2, 9, 0), true},
+		{"* * 3 * 0", at(2, 9, 0), false},
+	} {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		if got := s.Matches(tc.t); got != tc.want {
+			t.Errorf("%q at %s: %t, want %t", tc.expr, tc.t.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"mon * * * *",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2026-03-02 is a Monday.
+	from := time.Date(2026, time.March, 2, 9, 7, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2026, time.March, 2, 9, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2026, time.March, 2, 9, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", from, time.Date(2026, time.March, 3, 9, 0, 0, 0, time.UTC)},
+		{"30 8 * * 1", from, time.Date(2026, time.March, 9, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", from, time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// 02:30 doesn't happen in New York on 2026-03-08.
+		{"30 2 * * *", time.Date(2026, time.March, 7, 3, 0, 0, 0, ny), time.Date(2026, time.March, 9, 2, 30, 0, 0, ny)},
+	} {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		got, ok := s.Next(tc.from)
+		if !ok || !got.Equal(tc.want) {
+			t.Errorf("%q after %s: %s %t, want %s", tc.expr, tc.from, got, ok, tc.want)
+		}
+	}
+
+	s, _ := Parse("0 0 30 2 *")
+	if got, ok := s.Next(from); ok {
+		t.Errorf("Feb 30 next at %s", got)
+	}
+}
@@ -0,0 +1,296 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// Event is the body POSTed to a subscription's endpoint.
+type Event struct {
+	// ID is the processed message's id, the same for every subscription.
+	ID          string      `json:"id"`
+	Type        events.Type `json:"type"`
+	Queue       string      `json:"queue"`
+	Message     string      `json:"message"`
+	Source      string      `json:"source,omitempty"`
+	Worker      string      `json:"worker,omitempty"`
+	ProcessedAt time.Time   `json:"processed_at"`
+}
+
+// Delivery is an event on its way to one subscription.
+type Delivery struct {
+	// ID is "<subscription>:<message id>", so fanning a message out twice
+	// doesn't deliver it twice while the first delivery is pending.
+	ID           string `json:"id"`
+	Subscription string `json:"subscription"`
+	Event        Event  `json:"event"`
+	// Attempts counts the failed tries so far, and LastError says what
+	// went wrong with the last one.
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+// NewDelivery is m's delivery to sub.
+func NewDelivery(sub Subscription, m queue.ArchivedMessage) Delivery {
+	return Delivery{
+		ID:           sub.ID + ":" + m.ID,
+		Subscription: sub.ID,
+		Event: Event{
+			ID: m.ID, Type: events.MessageProcessed, Queue: m.Queue, Message: m.Message,
+			Source: m.Source, Worker: m.Worker, ProcessedAt: m.ProcessedAt.UTC(),
+		},
+	}
+}
+
+// Retry is how failed deliveries are tried again.
+type Retry struct {
+	// MaxAttempts tries a delivery gets before it's dead-lettered.
+	MaxAttempts int
+	// Backoff is the wait after the first failure, doubled after each next
+	// one up to MaxBackoff.
+	Backoff, MaxBackoff time.Duration
+}
+
+// Delay is the wait after a delivery's attempt'th failure, with jitter so
+// deliveries that failed together don't all come back together.
+func (r Retry) Delay(attempt int) time.Duration {
+	d := max(r.Backoff, time.Millisecond)
+	for i := 1; i < attempt && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 {
+		d = min(d, r.MaxBackoff)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// deliveriesKey is the hash of pending and dead-lettered deliveries, by ID.
+func (s *Store) deliveriesKey() string {
+	return s.prefix + "deliveries"
+}
+
+// dueKey is the sorted set of pending delivery IDs, scored by when they're
+// next due in Unix ms.
+func (s *Store) dueKey() string {
+	return s.prefix + "due"
+}
+
+// deadKey is the list of subscription id's dead-lettered delivery IDs,
+// newest first.
+func (s *Store) deadKey(id string) string {
+	return s.prefix + "dlq:" + id
+}
+
+// scheduleScript adds the deliveries in ARGV (ID, JSON, due ms triples) to
+// the hash KEYS[1] and the due set KEYS[2], skipping those already there.
+var scheduleScript = redis.NewScript(`
+local n = 0
+for i = 1, #ARGV, 3 do
+	if redis.call("HSETNX", KEYS[1], ARGV[i], ARGV[i + 1]) == 1 then
+		redis.call("ZADD", KEYS[2], ARGV[i + 2], ARGV[i])
+		n = n + 1
+	end
+end
+return n
+`)
+
+// Schedule queues ds for delivery at at and returns how many were new; one
+// already pending or dead-lettered is left as it is.
+func (s *Store) Schedule(ctx context.Context, at time.Time, ds ...Delivery) (int, error) {
+	if len(ds) == 0 {
+		return 0, nil
+	}
+	args := make([]any, 0, 3*len(ds))
+	for _, d := range ds {
+		b, err := json.Marshal(d)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, d.ID, b, at.UnixMilli())
+	}
+	return scheduleScript.Run(ctx, s.client, []string{s.deliveriesKey(), s.dueKey()}, args...).Int()
+}
+
+// claimScript returns up to ARGV[3] deliveries from the hash KEYS[1] whose
+// due time in the set KEYS[2] is ARGV[1] or earlier, pushing them back to
+// ARGV[2] so no one else claims them meanwhile. IDs without a delivery are
+// dropped.
+var claimScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+local out = {}
+for _, id in ipairs(ids) do
+	local d = redis.call("HGET", KEYS[1], id)
+	if d then
+		redis.call("ZADD", KEYS[2], ARGV[2], id)
+		table.insert(out, d)
+	else
+		redis.call("ZREM", KEYS[2], id)
+	end
+end
+return out
+`)
+
+// Claim takes up to n deliveries due at now. Each is hidden for lease, after
+// which it's due again unless the claimer has settled it (Delivered, Failed,
+// or Drop), so a dispatcher that dies mid-delivery loses nothing.
+func (s *Store) Claim(ctx context.Context, now time.Time, lease time.Duration, n int) ([]Delivery, error) {
+	raw, err := claimScript.Run(ctx, s.client, []string{s.deliveriesKey(), s.dueKey()},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), n).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Delivery, 0, len(raw))
+	for _, src := range raw {
+		var d Delivery
+		if err := json.Unmarshal([]byte(src), &d); err != nil {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Drop forgets a delivery, once it's delivered or its subscription is gone.
+func (s *Store) Drop(ctx context.Context, d Delivery) error {
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRem(ctx, s.dueKey(), d.ID)
+		p.HDel(ctx, s.deliveriesKey(), d.ID)
+		return nil
+	})
+	return err
+}
+
+// Failed records a failed attempt at d. It's dead-lettered if dead is set or
+// retry's attempts are used up, and due again after retry's delay otherwise.
+// Failed reports whether it was dead-lettered.
+func (s *Store) Failed(ctx context.Context, d Delivery, cause error, dead bool, retry Retry, now time.Time) (bool, error) {
+	d.Attempts++
+	d.LastError = cause.Error()
+	at := now.UTC()
+	d.LastAttemptAt = &at
+	dead = dead || d.Attempts >= retry.MaxAttempts
+	b, err := json.Marshal(d)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, s.deliveriesKey(), d.ID, b)
+		if dead {
+			p.ZRem(ctx, s.dueKey(), d.ID)
+			p.LPush(ctx, s.deadKey(d.Subscription), d.ID)
+		} else {
+			p.ZAdd(ctx, s.dueKey(), redis.Z{Score: float64(now.Add(retry.Delay(d.Attempts)).UnixMilli()), Member: d.ID})
+		}
+		return nil
+	})
+	return dead, err
+}
+
+// Pending is how many deliveries are waiting to be tried, including those
+// being tried right now.
+func (s *Store) Pending(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, s.dueKey()).Result()
+}
+
+// DeadLettered returns the count of subscription id's dead-lettered
+// deliveries and the newest limit of them.
+func (s *Store) DeadLettered(ctx context.Context, id string, limit int64) (int64, []Delivery, error) {
+	var count *redis.IntCmd
+	var ids *redis.StringSliceCmd
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		count = p.LLen(ctx, s.deadKey(id))
+		ids = p.LRange(ctx, s.deadKey(id), 0, limit-1)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	ds, err := s.deliveries(ctx, ids.Val())
+	return count.Val(), ds, err
+}
+
+// Redrive moves subscription id's dead-lettered deliveries back to pending,
+// due at now with their attempts reset, and returns how many it moved.
+func (s *Store) Redrive(ctx context.Context, id string, now time.Time) (int, error) {
+	ids, err := s.client.LRange(ctx, s.deadKey(id), 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	ds, err := s.deliveries(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, d := range ds {
+			d.Attempts = 0
+			b, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			p.HSet(ctx, s.deliveriesKey(), d.ID, b)
+			p.ZAdd(ctx, s.dueKey(), redis.Z{Score: float64(now.UnixMilli()), Member: d.ID})
+		}
+		// Only the IDs read above: one dead-lettered meanwhile stays.
+		for _, i := range ids {
+			p.LRem(ctx, s.deadKey(id), 1, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ds), nil
+}
+
+// Purge drops subscription id's dead-lettered deliveries and returns how
+// many there were.
+func (s *Store) Purge(ctx context.Context, id string) (int, error) {
+	ids, err := s.client.LRange(ctx, s.deadKey(id), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HDel(ctx, s.deliveriesKey(), ids...)
+		for _, i := range ids {
+			p.LRem(ctx, s.deadKey(id), 1, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// deliveries reads the deliveries ids, skipping any that are gone.
+func (s *Store) deliveries(ctx context.Context, ids []string) ([]Delivery, error) {
+	if len(ids) == 0 {
+		return []Delivery{}, nil
+	}
+	vals, err := s.client.HMGet(ctx, s.deliveriesKey(), ids...).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	out := make([]Delivery, 0, len(vals))
+	for _, v := range vals {
+		src, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var d Delivery
+		if err := json.Unmarshal([]byte(src), &d); err != nil {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
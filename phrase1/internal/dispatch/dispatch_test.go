@@ -0,0 +1,93 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/webhook"
+)
+
+func TestFilterMatches(t *testing.T) {
+	m := queue.ArchivedMessage{ID: "1", Queue: "orders", Source: "github", Message: "order shipped"}
+	cases := []struct {
+		name string
+		f    Filter
+		want bool
+	}{
+		{"empty", Filter{}, true},
+		{"queue", Filter{Queues: []string{"payments", "ord*"}}, true},
+		{"other queue", Filter{Queues: []string{"payments"}}, false},
+		{"source", Filter{Sources: []string{"github"}}, true},
+		{"other source", Filter{Sources: []string{"stripe"}}, false},
+		{"contains", Filter{Contains: "shipped"}, true},
+		{"missing text", Filter{Contains: "refunded"}, false},
+		{"all", Filter{Queues: []string{"orders"}, Sources: []string{"git*"}, Contains: "order"}, true},
+	}
+	for _, c := range cases {
+		if got := c.f.Matches(m); got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if err := (Filter{Queues: []string{"["}}).validate(); err == nil {
+		t.Error("validate accepted a malformed pattern")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		for range 20 {
+			if d := r.Delay(attempt); d < want/2 || d > want {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", attempt, d, want/2, want)
+			}
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	sub := Subscription{ID: "s1", Secret: "whsec_test"}
+	d := NewDelivery(sub, queue.ArchivedMessage{ID: "m1", Queue: "messages", Message: "hello", ProcessedAt: time.Unix(1700000000, 0)})
+	if d.ID != "s1:m1" || d.Event.Type != "message.processed" {
+		t.Fatalf("delivery = %+v", d)
+	}
+
+	status := http.StatusNoContent
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify([]byte("whsec_test"), r.Header, body, time.Now()); err != nil {
+			t.Errorf("signature: %v", err)
+		}
+		if id := r.Header.Get("Webhook-Id"); id != "s1:m1" {
+			t.Errorf("Webhook-Id = %q", id)
+		}
+		_ = json.Unmarshal(body, &got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	sub.URL = srv.URL
+
+	ctx := context.Background()
+	if _, err := Send(ctx, srv.Client(), sub, d, time.Now()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got.ID != "m1" || got.Message != "hello" || got.Queue != "messages" {
+		t.Errorf("event = %+v", got)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err := Send(ctx, srv.Client(), sub, d, time.Now()); err == nil || errors.Is(err, ErrGone) || code != 500 {
+		t.Errorf("500: code %d, err %v; want a retryable error", code, err)
+	}
+	status = http.StatusGone
+	if _, err := Send(ctx, srv.Client(), sub, d, time.Now()); !errors.Is(err, ErrGone) {
+		t.Errorf("410: err %v, want ErrGone", err)
+	}
+}
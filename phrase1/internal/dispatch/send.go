@@ -0,0 +1,51 @@
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"learn_k8s/phrase1/internal/webhook"
+)
+
+// ErrGone is returned by Send when the endpoint answers 410 Gone: it doesn't
+// want the delivery, now or later, so it's dead-lettered at once.
+var ErrGone = errors.New("endpoint is gone")
+
+// Send POSTs d to sub's endpoint as JSON, signed with sub's secret in the
+// Webhook-Signature header, and returns the status it was answered with (0
+// if none). Any 2xx is success; other answers are errors.
+func Send(ctx context.Context, client *http.Client, sub Subscription, d Delivery, now time.Time) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", d.ID)
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign([]byte(sub.Secret), body, now))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection can be reused; what it says is only kept
+	// for the error.
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, nil
+	case resp.StatusCode == http.StatusGone:
+		return resp.StatusCode, fmt.Errorf("%s: %w", sub.URL, ErrGone)
+	}
+	return resp.StatusCode, fmt.Errorf("%s answered %s: %s", sub.URL, resp.Status, bytes.TrimSpace(snippet))
+}
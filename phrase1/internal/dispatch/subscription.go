@@ -0,0 +1,204 @@
+// Package dispatch fans processed messages out to webhook subscriptions:
+// consumers register an endpoint, a signing secret, and a filter, and every
+// processed message matching the filter is POSTed to the endpoint, retried
+// with backoff, and dead-lettered per subscription once retries run out.
+// Subscriptions and pending deliveries live in Redis, so any number of
+// dispatchers share them.
+package dispatch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/queue"
+)
+
+var (
+	// ErrNotFound is returned for an unknown subscription ID.
+	ErrNotFound = errors.New("no such subscription")
+	// ErrInvalid wraps errors for subscriptions that can't be created as
+	// asked.
+	ErrInvalid = errors.New("invalid subscription")
+)
+
+// secretPrefix marks the signing secrets this package generates.
+const secretPrefix = "whsec_"
+
+// Filter selects the processed messages a subscription gets. Queues and
+// Sources are glob patterns (path.Match syntax); an empty list matches
+// anything, and Contains, if set, must occur in the message.
+type Filter struct {
+	Queues   []string `json:"queues,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+	Contains string   `json:"contains,omitempty"`
+}
+
+// validate reports the first malformed pattern in f.
+func (f Filter) validate() error {
+	for _, p := range append(append([]string(nil), f.Queues...), f.Sources...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether m passes the filter.
+func (f Filter) Matches(m queue.ArchivedMessage) bool {
+	return matchAny(f.Queues, m.Queue) && matchAny(f.Sources, m.Source) && strings.Contains(m.Message, f.Contains)
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription is a webhook endpoint and the messages it gets. Secret signs
+// each delivery; it's only shown when the subscription is created.
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Filter    Filter    `json:"filter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps subscriptions in Redis under prefix: the hash
+// <prefix>subscriptions, one field per subscription ID.
+type Store struct {
+	client *redis.Client
+	prefix string
+	now    func() time.Time
+}
+
+// NewStore returns a store keeping subscriptions and deliveries under prefix.
+func NewStore(client *redis.Client, prefix string) *Store {
+	return &Store{client: client, prefix: prefix, now: time.Now}
+}
+
+func (s *Store) subscriptionsKey() string {
+	return s.prefix + "subscriptions"
+}
+
+func newID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create registers a subscription for rawURL. An empty secret gets a
+// generated one; either way the returned subscription carries it.
+func (s *Store) Create(ctx context.Context, rawURL, secret string, f Filter) (Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	if err := f.validate(); err != nil {
+		return Subscription{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return Subscription{}, err
+		}
+		secret = secretPrefix + base64.RawURLEncoding.EncodeToString(b)
+	}
+	id, err := newID(8)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub := Subscription{ID: id, URL: u.String(), Secret: secret, Filter: f, CreatedAt: s.now().UTC()}
+	b, err := json.Marshal(sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if err := s.client.HSet(ctx, s.subscriptionsKey(), id, b).Err(); err != nil {
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Get returns the subscription id, with its secret.
+func (s *Store) Get(ctx context.Context, id string) (Subscription, error) {
+	src, err := s.client.HGet(ctx, s.subscriptionsKey(), id).Result()
+	if errors.Is(err, redis.Nil) {
+		return Subscription{}, ErrNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	var sub Subscription
+	if err := json.Unmarshal([]byte(src), &sub); err != nil {
+		return Subscription{}, fmt.Errorf("subscription %s: %w", id, err)
+	}
+	return sub, nil
+}
+
+// List returns every subscription, with its secret, oldest first.
+// Subscriptions that don't decode are skipped.
+func (s *Store) List(ctx context.Context) ([]Subscription, error) {
+	all, err := s.client.HGetAll(ctx, s.subscriptionsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Subscription, 0, len(all))
+	for id, src := range all {
+		var sub Subscription
+		if err := json.Unmarshal([]byte(src), &sub); err != nil || sub.ID != id {
+			continue
+		}
+		out = append(out, sub)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// Delete removes the subscription id with its dead letters. Deliveries still
+// pending for it are dropped when they come due.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	n, err := s.client.HDel(ctx, s.subscriptionsKey(), id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	ids, err := s.client.LRange(ctx, s.deadKey(id), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(ids) > 0 {
+			p.HDel(ctx, s.deliveriesKey(), ids...)
+		}
+		p.Del(ctx, s.deadKey(id))
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,112 @@
+package downstream
+
+import (
+	"sync"
+	"time"
+)
+
+// State is a circuit breaker's state.
+type State int
+
+const (
+	// Closed lets requests through, counting consecutive failures.
+	Closed State = iota
+	// HalfOpen lets one probe request through after the cooldown; its
+	// outcome closes the breaker or opens it again.
+	HalfOpen
+	// Open fails requests without sending them until the cooldown is over.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "closed"
+}
+
+// Breaker opens after a number of consecutive failures, so a host that's
+// down isn't sent requests that will fail anyway, and probes it with a
+// single request once a cooldown has passed. It's safe for concurrent use.
+type Breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       State
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewBreaker returns a breaker that opens after failures consecutive
+// failures and probes again after cooldown. failures <= 0 never opens.
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{failures: failures, cooldown: cooldown}
+}
+
+// State is the breaker's state at now: an open breaker whose cooldown is
+// over reports HalfOpen.
+func (b *Breaker) State(now time.Time) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && now.Sub(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent at now, and whether it's the
+// half-open probe, whose outcome must be recorded (or the probe canceled)
+// before another is let through.
+func (b *Breaker) allow(now time.Time) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && now.Sub(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+	}
+	switch b.state {
+	case Open:
+		return false, false
+	case HalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+	return true, false
+}
+
+// record adds a request's outcome and returns the state after it, and
+// whether the outcome changed it.
+func (b *Breaker) record(failed, probe bool, now time.Time) (State, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	before := b.state
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.consecutive = 0
+		if probe {
+			b.state = Closed
+		}
+	} else {
+		b.consecutive++
+		if probe || b.state == Closed && b.failures > 0 && b.consecutive >= b.failures {
+			b.state, b.openedAt = Open, now
+		}
+	}
+	return b.state, b.state != before
+}
+
+// cancel gives up a probe that was never sent, so the next request probes.
+func (b *Breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
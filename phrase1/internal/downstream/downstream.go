@@ -0,0 +1,222 @@
+// Package downstream protects the services the worker calls from the
+// worker fleet: a cap on in-flight requests and on the request rate per
+// host, shared by every worker through Redis, and a circuit breaker per host
+// in each worker.
+package downstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrOpen is returned for a request to a host whose circuit breaker is
+// open; it wasn't sent.
+var ErrOpen = errors.New("circuit breaker open")
+
+// Config is what a Guard enforces for each host.
+type Config struct {
+	// Concurrency caps the requests in flight to a host across all workers;
+	// 0 doesn't.
+	Concurrency int
+	// Lease is how long a worker holds a concurrency slot at most, so one
+	// that dies mid-request doesn't keep it forever. It should be longer
+	// than any request takes.
+	Lease time.Duration
+	// Rate caps the requests a second to a host across all workers; 0
+	// doesn't.
+	Rate int64
+	// BreakerFailures consecutive failures open a host's breaker for
+	// BreakerCooldown; 0 disables the breaker.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// Guard lets requests through to each host under Config.
+type Guard struct {
+	client *redis.Client
+	prefix string
+	cfg    Config
+	// OnState is called when a host's breaker changes state.
+	OnState func(host string, s State)
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// New returns a guard keeping its shared state in Redis under prefix.
+func New(client *redis.Client, prefix string, cfg Config) *Guard {
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	return &Guard{client: client, prefix: prefix, cfg: cfg, breakers: map[string]*Breaker{}}
+}
+
+// Breaker is host's circuit breaker.
+func (g *Guard) Breaker(host string) *Breaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.breakers[host]
+	if !ok {
+		b = NewBreaker(g.cfg.BreakerFailures, g.cfg.BreakerCooldown)
+		g.breakers[host] = b
+	}
+	return b
+}
+
+// Permit is a request let through by Acquire. Done must be called once it's
+// finished.
+type Permit struct {
+	// RateWait and SlotWait are how long Acquire waited for the rate limit
+	// and for a concurrency slot.
+	RateWait, SlotWait time.Duration
+
+	g     *Guard
+	host  string
+	token string
+	probe bool
+}
+
+// Acquire waits until a request to host is within the rate and concurrency
+// limits. It returns ErrOpen at once if host's breaker is open.
+func (g *Guard) Acquire(ctx context.Context, host string) (*Permit, error) {
+	b := g.Breaker(host)
+	ok, probe := b.allow(time.Now())
+	if !ok {
+		return nil, ErrOpen
+	}
+	if probe {
+		g.changed(host, HalfOpen)
+	}
+	p := &Permit{g: g, host: host, probe: probe}
+	var err error
+	if p.RateWait, err = g.waitRate(ctx, host); err == nil {
+		p.SlotWait, p.token, err = g.waitSlot(ctx, host)
+	}
+	if err != nil {
+		if probe {
+			b.cancel()
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// Done frees the permit's slot and records whether the request failed: it
+// couldn't be sent, or the host answered that it's failing or overloaded.
+func (p *Permit) Done(ctx context.Context, failed bool) {
+	if p.token != "" {
+		// A slot that can't be freed now expires after the lease.
+		_ = p.g.client.ZRem(context.WithoutCancel(ctx), p.g.slotsKey(p.host), p.token).Err()
+	}
+	if s, changed := p.g.Breaker(p.host).record(failed, p.probe, time.Now()); changed {
+		p.g.changed(p.host, s)
+	}
+}
+
+func (g *Guard) changed(host string, s State) {
+	if g.OnState != nil {
+		g.OnState(host, s)
+	}
+}
+
+func (g *Guard) rateKey(host string, at time.Time) string {
+	return g.prefix + host + ":rate:" + strconv.FormatInt(at.Unix(), 10)
+}
+
+func (g *Guard) slotsKey(host string) string {
+	return g.prefix + host + ":inflight"
+}
+
+// waitRate counts a request in host's current one-second window, waiting
+// for the next window while this one is full.
+func (g *Guard) waitRate(ctx context.Context, host string) (time.Duration, error) {
+	start := time.Now()
+	if g.cfg.Rate <= 0 {
+		return 0, nil
+	}
+	for {
+		now := time.Now()
+		key := g.rateKey(host, now)
+		var n *redis.IntCmd
+		_, err := g.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			n = p.Incr(ctx, key)
+			p.Expire(ctx, key, 2*time.Second)
+			return nil
+		})
+		if err != nil {
+			return time.Since(start), err
+		}
+		if n.Val() <= g.cfg.Rate {
+			return time.Since(start), nil
+		}
+		if !sleep(ctx, now.Truncate(time.Second).Add(time.Second).Sub(now)) {
+			return time.Since(start), ctx.Err()
+		}
+	}
+}
+
+// acquireScript takes a slot in the sorted set KEYS[1] of slot tokens, scored
+// by when they expire, if fewer than ARGV[2] unexpired ones are taken at
+// ARGV[1]: token ARGV[3], expiring at ARGV[4]. The set goes away ARGV[5] ms
+// after its last use.
+var acquireScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[4], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+
+// slotPoll bounds how long waitSlot waits between tries.
+const slotPoll = 250 * time.Millisecond
+
+// waitSlot takes one of host's concurrency slots, polling until one is
+// free, and returns its token.
+func (g *Guard) waitSlot(ctx context.Context, host string) (time.Duration, string, error) {
+	start := time.Now()
+	if g.cfg.Concurrency <= 0 {
+		return 0, "", nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return 0, "", err
+	}
+	token := hex.EncodeToString(b)
+	wait := 10 * time.Millisecond
+	for {
+		now := time.Now()
+		ok, err := acquireScript.Run(ctx, g.client, []string{g.slotsKey(host)},
+			now.UnixMilli(), g.cfg.Concurrency, token, now.Add(g.cfg.Lease).UnixMilli(), g.cfg.Lease.Milliseconds()).Bool()
+		if err != nil {
+			return time.Since(start), "", err
+		}
+		if ok {
+			return time.Since(start), token, nil
+		}
+		if !sleep(ctx, wait) {
+			return time.Since(start), "", ctx.Err()
+		}
+		wait = min(2*wait, slotPoll)
+	}
+}
+
+// sleep waits for d, and reports false if ctx was canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
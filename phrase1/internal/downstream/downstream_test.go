@@ -0,0 +1,74 @@
+package downstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(3, time.Minute)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		b.record(true, false, now)
+	}
+	b.record(false, false, now)
+	for i := 0; i < 2; i++ {
+		b.record(true, false, now)
+	}
+	if s := b.State(now); s != Closed {
+		t.Fatalf("%s after failures broken up by a success, want closed", s)
+	}
+	if s, changed := b.record(true, false, now); s != Open || !changed {
+		t.Fatalf("%s after 3 failures in a row, want open", s)
+	}
+	if ok, _ := b.allow(now.Add(time.Second)); ok {
+		t.Fatal("open breaker let a request through")
+	}
+
+	later := now.Add(time.Minute)
+	if ok, probe := b.allow(later); !ok || !probe {
+		t.Fatalf("after the cooldown: allowed %t, probe %t; want a probe", ok, probe)
+	}
+	if ok, _ := b.allow(later); ok {
+		t.Fatal("let a second request through while probing")
+	}
+	if s, _ := b.record(true, true, later); s != Open {
+		t.Fatalf("%s after a failed probe, want open again", s)
+	}
+	if ok, _ := b.allow(later.Add(time.Second)); ok {
+		t.Fatal("let a request through right after a failed probe")
+	}
+
+	again := later.Add(time.Minute)
+	_, probe := b.allow(again)
+	b.cancel()
+	if ok, probe2 := b.allow(again); !probe || !ok || !probe2 {
+		t.Fatal("a canceled probe didn't let the next request probe")
+	}
+	if s, _ := b.record(false, true, again); s != Closed {
+		t.Fatalf("%s after a successful probe, want closed", s)
+	}
+}
+
+func TestGuardBreakerOnly(t *testing.T) {
+	g := New(nil, "downstream:", Config{BreakerFailures: 1, BreakerCooldown: time.Hour})
+	var states []State
+	g.OnState = func(host string, s State) { states = append(states, s) }
+
+	p, err := g.Acquire(context.Background(), "api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Done(context.Background(), true)
+	if _, err := g.Acquire(context.Background(), "api.example.com"); !errors.Is(err, ErrOpen) {
+		t.Fatalf("err %v, want ErrOpen", err)
+	}
+	if _, err := g.Acquire(context.Background(), "other.example.com"); err != nil {
+		t.Fatalf("other host: %v", err)
+	}
+	if len(states) != 1 || states[0] != Open {
+		t.Errorf("state changes %v, want open", states)
+	}
+}
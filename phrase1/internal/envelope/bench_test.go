@@ -0,0 +1,40 @@
+package envelope
+
+import "testing"
+
+// BenchmarkEncode and BenchmarkDecode compare the two encodings on the golden
+// envelope; bytes/msg is the size each stores in Redis.
+func BenchmarkEncode(b *testing.B) {
+	e := full()
+	for _, enc := range []Encoding{EncodingJSON, EncodingProto} {
+		b.Run(string(enc), func(b *testing.B) {
+			var raw string
+			for i := 0; i < b.N; i++ {
+				var err error
+				if raw, err = e.EncodeAs(enc); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(raw)), "bytes/msg")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	e := full()
+	for _, enc := range []Encoding{EncodingJSON, EncodingProto} {
+		b.Run(string(enc), func(b *testing.B) {
+			raw, err := e.EncodeAs(enc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if Decode(raw).ID == "" {
+					b.Fatal("decoded as a bare payload")
+				}
+			}
+			b.ReportMetric(float64(len(raw)), "bytes/msg")
+		})
+	}
+}
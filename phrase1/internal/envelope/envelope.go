@@ -0,0 +1,168 @@
+// Package envelope defines the message envelope the api (and the other
+// producers) write to Redis and the worker reads back: its fields, its JSON
+// and protobuf encodings, and which versions of it each side understands.
+// Both binaries use this package, and its tests pin the wire format, so the
+// two can't drift apart silently.
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"learn_k8s/phrase1/internal/cloudevents"
+)
+
+// Version is the envelope version this build writes and the newest it reads.
+// Bump it on incompatible changes to Envelope, keeping MinVersion at the
+// oldest version still decoded.
+const (
+	Version    = 1
+	MinVersion = 1
+)
+
+// ErrUnsupportedVersion is returned by Check for envelopes newer than this
+// build understands.
+var ErrUnsupportedVersion = errors.New("envelope: unsupported version")
+
+// Envelope is what the api stores in Redis for each message: the payload plus
+// metadata the worker needs to process and report on it.
+type Envelope struct {
+	Version     int       `json:"v"`
+	ID          string    `json:"id"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	ContentType string    `json:"content_type,omitempty"`
+	// Source names the producer for messages that didn't come through
+	// /enqueue, e.g. the webhook provider.
+	Source   string            `json:"source,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// SchemaVersion is the payload's schema version as declared by the
+	// producer; 0 means unversioned, which the worker handles as-is.
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// BudgetMS is how long, in milliseconds, the producer's request was
+	// given: the api's route timeout or the client's shorter one. 0 means
+	// no limit. See Deadline.
+	BudgetMS int64 `json:"budget_ms,omitempty"`
+	// Payload holds raw bytes for binary content types; in JSON those are
+	// carried as payload_base64.
+	Payload string `json:"payload"`
+	// CloudEvent holds the context attributes when the message was submitted
+	// as a CloudEvent; its data is Payload.
+	CloudEvent *cloudevents.Attributes `json:"cloudevent,omitempty"`
+}
+
+// Deadline is when the producer's budget ran out, counted from EnqueuedAt.
+// ok is false for messages without a budget.
+func (e Envelope) Deadline() (deadline time.Time, ok bool) {
+	if e.BudgetMS <= 0 || e.EnqueuedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return e.EnqueuedAt.Add(time.Duration(e.BudgetMS) * time.Millisecond), true
+}
+
+// NewID returns a random RFC 4122 version 4 UUID.
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// New wraps payload with a fresh ID and the current time.
+func New(payload string) Envelope {
+	return Envelope{Version: Version, ID: NewID(), EnqueuedAt: time.Now().UTC(), Payload: payload}
+}
+
+type envelopeJSON Envelope
+
+// MarshalJSON writes payloads that aren't valid UTF-8 (msgpack, protobuf) as
+// payload_base64, since JSON strings would mangle them.
+func (e Envelope) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(e.Payload) {
+		return json.Marshal(envelopeJSON(e))
+	}
+	return json.Marshal(struct {
+		envelopeJSON
+		Payload       string `json:"payload,omitempty"`
+		PayloadBase64 string `json:"payload_base64"`
+	}{envelopeJSON: envelopeJSON(e), PayloadBase64: base64.StdEncoding.EncodeToString([]byte(e.Payload))})
+}
+
+func (e *Envelope) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*envelopeJSON)(e)); err != nil {
+		return err
+	}
+	var bin struct {
+		PayloadBase64 *string `json:"payload_base64"`
+	}
+	if err := json.Unmarshal(b, &bin); err != nil || bin.PayloadBase64 == nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(*bin.PayloadBase64)
+	if err != nil {
+		return fmt.Errorf("envelope payload_base64: %w", err)
+	}
+	e.Payload = string(raw)
+	return nil
+}
+
+// Encoding is how envelopes are serialized in Redis.
+type Encoding string
+
+const (
+	EncodingJSON Encoding = "json"
+	// EncodingProto is the protobuf form defined in
+	// proto/queue/v1/envelope.proto. It's smaller and cheaper to decode than
+	// JSON but unreadable in redis-cli.
+	EncodingProto Encoding = "proto"
+)
+
+func ParseEncoding(s string) (Encoding, error) {
+	switch enc := Encoding(strings.ToLower(s)); enc {
+	case EncodingJSON, EncodingProto:
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown envelope encoding %q (want json or proto)", s)
+}
+
+// Encode serializes e as JSON.
+func (e Envelope) Encode() (string, error) {
+	return e.EncodeAs(EncodingJSON)
+}
+
+func (e Envelope) EncodeAs(enc Encoding) (string, error) {
+	if enc == EncodingProto {
+		return string(e.marshalProto()), nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Decode parses a queued message in either encoding. Anything that isn't an
+// envelope, such as plain strings pushed by older api versions or by hand
+// with redis-cli, is treated as a bare payload so it still gets processed.
+// Decode doesn't reject versions it doesn't know; Check does.
+func Decode(raw string) Envelope {
+	switch {
+	case strings.HasPrefix(raw, "{"):
+		var e Envelope
+		if err := json.Unmarshal([]byte(raw), &e); err == nil && e.Version > 0 && e.ID != "" {
+			return e
+		}
+	// A protobuf envelope starts with the tag of its version field.
+	case strings.HasPrefix(raw, "\x08"):
+		if e, err := unmarshalProto([]byte(raw)); err == nil && e.Version > 0 && e.ID != "" {
+			return e
+		}
+	}
+	return Envelope{Payload: raw}
+}
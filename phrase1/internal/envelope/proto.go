@@ -0,0 +1,250 @@
+package envelope
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"learn_k8s/phrase1/internal/cloudevents"
+)
+
+// Protobuf wire codec for Envelope, matching proto/queue/v1/envelope.proto.
+// Zero values are omitted as proto3 does, except the version, which is
+// always written first so Decode can sniff the format.
+
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+	wireI32    = 5
+)
+
+var errBadProto = errors.New("envelope: malformed protobuf")
+
+func appendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendBytesField(b []byte, num int, v []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendStringField(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendBytesField(b, num, []byte(s))
+}
+
+func appendMapField(b []byte, num int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendStringField(nil, 1, k)
+		entry = appendStringField(entry, 2, m[k])
+		b = appendBytesField(b, num, entry)
+	}
+	return b
+}
+
+func (e Envelope) marshalProto() []byte {
+	b := binary.AppendUvarint(appendTag(nil, 1, wireVarint), uint64(e.Version))
+	b = appendStringField(b, 2, e.ID)
+	if !e.EnqueuedAt.IsZero() {
+		ts := appendVarintField(nil, 1, uint64(e.EnqueuedAt.Unix()))
+		ts = appendVarintField(ts, 2, uint64(e.EnqueuedAt.Nanosecond()))
+		b = appendBytesField(b, 3, ts)
+	}
+	b = appendStringField(b, 4, e.ContentType)
+	b = appendStringField(b, 5, e.Source)
+	b = appendMapField(b, 6, e.Metadata)
+	if e.Payload != "" {
+		b = appendBytesField(b, 7, []byte(e.Payload))
+	}
+	if a := e.CloudEvent; a != nil {
+		ce := appendStringField(nil, 1, a.SpecVersion)
+		ce = appendStringField(ce, 2, a.ID)
+		ce = appendStringField(ce, 3, a.Source)
+		ce = appendStringField(ce, 4, a.Type)
+		ce = appendStringField(ce, 5, a.DataContentType)
+		ce = appendStringField(ce, 6, a.DataSchema)
+		ce = appendStringField(ce, 7, a.Subject)
+		ce = appendStringField(ce, 8, a.Time)
+		ce = appendMapField(ce, 9, a.Extensions)
+		b = appendBytesField(b, 8, ce)
+	}
+	b = appendVarintField(b, 9, uint64(e.SchemaVersion))
+	b = appendVarintField(b, 10, uint64(e.BudgetMS))
+	return b
+}
+
+// walkFields calls fn for each field in a protobuf message. For varints v is
+// the value; for length-delimited fields data is the contents. Fixed-width
+// fields are skipped since the schema has none.
+func walkFields(b []byte, fn func(num, typ int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		b = b[n:]
+		num, typ := int(tag>>3), int(tag&7)
+		var (
+			v    uint64
+			data []byte
+		)
+		switch typ {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errBadProto
+			}
+			b = b[n:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errBadProto
+			}
+			data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case wireI64, wireI32:
+			width := 8
+			if typ == wireI32 {
+				width = 4
+			}
+			if len(b) < width {
+				return errBadProto
+			}
+			b = b[width:]
+			continue
+		default:
+			return errBadProto
+		}
+		if err := fn(num, typ, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readMapEntry(data []byte, m map[string]string) error {
+	var k, v string
+	err := walkFields(data, func(num, typ int, _ uint64, d []byte) error {
+		if typ != wireBytes {
+			return nil
+		}
+		switch num {
+		case 1:
+			k = string(d)
+		case 2:
+			v = string(d)
+		}
+		return nil
+	})
+	if err == nil {
+		m[k] = v
+	}
+	return err
+}
+
+func unmarshalProto(b []byte) (Envelope, error) {
+	var e Envelope
+	err := walkFields(b, func(num, typ int, v uint64, data []byte) error {
+		if typ == wireVarint {
+			switch num {
+			case 1:
+				e.Version = int(v)
+			case 9:
+				e.SchemaVersion = int(v)
+			case 10:
+				e.BudgetMS = int64(v)
+			}
+			return nil
+		}
+		switch num {
+		case 2:
+			e.ID = string(data)
+		case 3:
+			var sec, nsec uint64
+			if err := walkFields(data, func(num, _ int, v uint64, _ []byte) error {
+				switch num {
+				case 1:
+					sec = v
+				case 2:
+					nsec = v
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			e.EnqueuedAt = time.Unix(int64(sec), int64(nsec)).UTC()
+		case 4:
+			e.ContentType = string(data)
+		case 5:
+			e.Source = string(data)
+		case 6:
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string)
+			}
+			return readMapEntry(data, e.Metadata)
+		case 7:
+			e.Payload = string(data)
+		case 8:
+			a, err := unmarshalCloudEventProto(data)
+			if err != nil {
+				return err
+			}
+			e.CloudEvent = &a
+		}
+		return nil
+	})
+	return e, err
+}
+
+func unmarshalCloudEventProto(b []byte) (cloudevents.Attributes, error) {
+	var a cloudevents.Attributes
+	err := walkFields(b, func(num, typ int, _ uint64, data []byte) error {
+		if typ != wireBytes {
+			return nil
+		}
+		s := string(data)
+		switch num {
+		case 1:
+			a.SpecVersion = s
+		case 2:
+			a.ID = s
+		case 3:
+			a.Source = s
+		case 4:
+			a.Type = s
+		case 5:
+			a.DataContentType = s
+		case 6:
+			a.DataSchema = s
+		case 7:
+			a.Subject = s
+		case 8:
+			a.Time = s
+		case 9:
+			if a.Extensions == nil {
+				a.Extensions = make(map[string]string)
+			}
+			return readMapEntry(data, a.Extensions)
+		}
+		return nil
+	})
+	return a, err
+}
@@ -0,0 +1,5 @@
+$6f1c2f5e-8a3b-4c1d-9e2f-0a1b2c3d4e5fك:"application/json*webhook:github2
+tenantacme2F
+traceparent700-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01:{"order":"a","quantity":2}Be
+1.0ce-1/orders"com.example.order*application/json:aB2024-05-06T07:08:09ZJ
+partitionkeyaHP'
\ No newline at end of file
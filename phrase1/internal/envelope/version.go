@@ -0,0 +1,56 @@
+package envelope
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Check returns ErrUnsupportedVersion if e was written in a version this
+// build doesn't read, such as by a newer producer during a rollout. Bare
+// payloads (version 0) are always accepted.
+func (e Envelope) Check() error {
+	if e.Version != 0 && (e.Version < MinVersion || e.Version > Version) {
+		return fmt.Errorf("%w: v%d (this build reads v%d to v%d)", ErrUnsupportedVersion, e.Version, MinVersion, Version)
+	}
+	return nil
+}
+
+// Negotiate picks the version a producer should write, given the newest
+// version each reader understands: the oldest of those, so every reader can
+// decode it, clamped to what this build can write. A reader reporting 0
+// predates negotiation and reads version 1. ok is false if some reader can't
+// read any version this build writes.
+func Negotiate(readers []int) (v int, ok bool) {
+	v, ok = Version, true
+	for _, r := range readers {
+		if r == 0 {
+			r = 1
+		}
+		if r < MinVersion {
+			ok = false
+			continue
+		}
+		if r < v {
+			v = r
+		}
+	}
+	return v, ok
+}
+
+// WriteVersion is the version a producer writes, shared by every queue it
+// encodes for so a Negotiate result applies to all of them at once. The zero
+// value holds Version.
+type WriteVersion struct {
+	v atomic.Int64
+}
+
+func (w *WriteVersion) Load() int {
+	if n := w.v.Load(); n > 0 {
+		return int(n)
+	}
+	return Version
+}
+
+func (w *WriteVersion) Store(v int) {
+	w.v.Store(int64(v))
+}
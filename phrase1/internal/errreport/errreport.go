@@ -0,0 +1,156 @@
+// Package errreport sends failures to an error tracker. Reporter is the
+// extension point; the one implementation here speaks Sentry's store API,
+// which Sentry and compatible trackers (GlitchTip, Bugsink) accept, without
+// pulling in an SDK.
+package errreport
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity the tracker files an event under.
+type Level string
+
+const (
+	Error Level = "error"
+	Fatal Level = "fatal"
+)
+
+// Event is one failure. Err is required; the rest is context for grouping
+// and search.
+type Event struct {
+	Err error
+	// Message says what was being done, e.g. "enqueue failed"; the tracker
+	// groups by it together with the error type.
+	Message   string
+	Level     Level
+	MessageID string
+	TraceID   string
+	Tags      map[string]string
+	// Stack is set for recovered panics (runtime/debug.Stack output).
+	Stack []byte
+}
+
+// Reporter delivers events. Report must not block the caller; Flush waits up
+// to timeout for queued events to be sent, before the process exits.
+type Reporter interface {
+	Report(Event)
+	Flush(timeout time.Duration)
+}
+
+// Options are attached to every event.
+type Options struct {
+	Environment string
+	Release     string
+	ServerName  string
+	Tags        map[string]string
+	// OnError is called when an event can't be delivered or is dropped.
+	OnError func(error)
+}
+
+// New returns the reporter for dsn: a no-op one when dsn is empty, or a
+// Sentry reporter for an https://<key>@<host>/<project> DSN.
+func New(dsn string, opts Options) (Reporter, error) {
+	if strings.TrimSpace(dsn) == "" {
+		return Nop{}, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error reporter DSN: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return newSentry(u, opts)
+	default:
+		return nil, fmt.Errorf("error reporter DSN: unsupported scheme %q", u.Scheme)
+	}
+}
+
+// Nop discards events.
+type Nop struct{}
+
+func (Nop) Report(Event)        {}
+func (Nop) Flush(time.Duration) {}
+
+// TraceID returns the trace id of a W3C traceparent header value
+// ("00-<trace id>-<span id>-<flags>"), or "" if it isn't one.
+func TraceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	for _, c := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+	return parts[1]
+}
+
+// Recorded is an event as kept by Ring.
+type Recorded struct {
+	Time time.Time
+	Event
+}
+
+// Ring keeps the last events in memory, for status pages.
+type Ring struct {
+	mu     sync.Mutex
+	events []Recorded
+	next   int
+	full   bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{events: make([]Recorded, size)}
+}
+
+func (r *Ring) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[r.next] = Recorded{Time: time.Now(), Event: e}
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *Ring) Flush(time.Duration) {}
+
+// Recent returns the kept events, newest first.
+func (r *Ring) Recent() []Recorded {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.events)
+	}
+	out := make([]Recorded, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, r.events[(r.next-i+len(r.events))%len(r.events)])
+	}
+	return out
+}
+
+// Tee reports every event to each of rs.
+func Tee(rs ...Reporter) Reporter {
+	return tee(rs)
+}
+
+type tee []Reporter
+
+func (t tee) Report(e Event) {
+	for _, r := range t {
+		r.Report(e)
+	}
+}
+
+func (t tee) Flush(timeout time.Duration) {
+	for _, r := range t {
+		r.Flush(timeout)
+	}
+}
@@ -0,0 +1,168 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queueSize bounds the events waiting to be sent; beyond it events are
+// dropped rather than slowing down request handling.
+const queueSize = 100
+
+type sentry struct {
+	endpoint string
+	auth     string
+	opts     Options
+	client   *http.Client
+
+	events  chan Event
+	pending sync.WaitGroup
+}
+
+// newSentry parses a DSN of the form scheme://<public key>@<host>[/<path>]/<project id>.
+func newSentry(u *url.URL, opts Options) (*sentry, error) {
+	key := u.User.Username()
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if key == "" || i < 0 || path[i+1:] == "" {
+		return nil, errors.New("error reporter DSN: want scheme://<key>@<host>/<project>")
+	}
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], path[i+1:])
+	s := &sentry{
+		endpoint: endpoint,
+		auth:     "Sentry sentry_version=7, sentry_client=learn_k8s/1, sentry_key=" + key,
+		opts:     opts,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		events:   make(chan Event, queueSize),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *sentry) Report(e Event) {
+	if e.Err == nil {
+		return
+	}
+	s.pending.Add(1)
+	select {
+	case s.events <- e:
+	default:
+		s.pending.Done()
+		s.fail(errors.New("error reporter queue full, event dropped"))
+	}
+}
+
+func (s *sentry) Flush(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (s *sentry) loop() {
+	for e := range s.events {
+		if err := s.send(e); err != nil {
+			s.fail(err)
+		}
+		s.pending.Done()
+	}
+}
+
+func (s *sentry) fail(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (s *sentry) payload(e Event) map[string]any {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	level := e.Level
+	if level == "" {
+		level = Error
+	}
+	tags := make(map[string]string, len(s.opts.Tags)+len(e.Tags))
+	for k, v := range s.opts.Tags {
+		tags[k] = v
+	}
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	if e.MessageID != "" {
+		tags["message_id"] = e.MessageID
+	}
+	exc := sentryException{Type: fmt.Sprintf("%T", e.Err), Value: e.Err.Error()}
+	extra := map[string]string{}
+	if len(e.Stack) > 0 {
+		exc.Type = "panic"
+		extra["stack"] = string(e.Stack)
+	}
+	p := map[string]any{
+		"event_id":  hex.EncodeToString(id[:]),
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"level":     level,
+		"platform":  "go",
+		"logger":    "learn_k8s",
+		"message":   e.Message,
+		"exception": map[string]any{"values": []sentryException{exc}},
+		"tags":      tags,
+		"extra":     extra,
+	}
+	if e.TraceID != "" {
+		p["contexts"] = map[string]any{"trace": map[string]string{"trace_id": e.TraceID}}
+	}
+	if s.opts.Environment != "" {
+		p["environment"] = s.opts.Environment
+	}
+	if s.opts.Release != "" {
+		p["release"] = s.opts.Release
+	}
+	if s.opts.ServerName != "" {
+		p["server_name"] = s.opts.ServerName
+	}
+	return p
+}
+
+func (s *sentry) send(e Event) error {
+	body, err := json.Marshal(s.payload(e))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reporter: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error reporter: %s answered %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package events decouples observability from the core enqueue/process
+// logic: the api and worker publish typed lifecycle events on a Bus, and
+// subscribers (metrics, streaming endpoints, audit log, cross-process
+// forwarding) react to them independently.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	MessageEnqueued     Type = "message.enqueued"
+	MessageDequeued     Type = "message.dequeued"
+	MessageProcessed    Type = "message.processed"
+	MessageFailed       Type = "message.failed"
+	MessageDeadLettered Type = "message.dead_lettered"
+	MessageCancelled    Type = "message.cancelled"
+	WorkerStarted       Type = "worker.started"
+	WorkerStopped       Type = "worker.stopped"
+	// QueueDepthWarning, QueueDepthCritical, and QueueDepthNormal mark a
+	// queue's depth crossing its watermarks; Message says which.
+	QueueDepthWarning  Type = "queue.depth_warning"
+	QueueDepthCritical Type = "queue.depth_critical"
+	QueueDepthNormal   Type = "queue.depth_normal"
+)
+
+type Event struct {
+	Type    Type      `json:"type"`
+	Queue   string    `json:"queue"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source,omitempty"`
+	// Subject identifies the caller that caused the event, when known.
+	Subject string `json:"subject,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Duration is set on MessageProcessed/MessageFailed: time spent handling.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+}
+
+// Subscriber is a lifecycle hook. Handle runs on a goroutine owned by the
+// bus, one per subscriber, so a slow subscriber only delays itself.
+type Subscriber interface {
+	Handle(Event)
+}
+
+// SubscriberFunc adapts a function to Subscriber.
+type SubscriberFunc func(Event)
+
+func (f SubscriberFunc) Handle(e Event) { f(e) }
+
+// Bus is an in-process publish/subscribe hub. Publish doesn't block on
+// ordinary subscribers: one that falls behind misses events rather than
+// stalling producers. Subscribers attached with AttachBlocking are the
+// exception.
+type Bus struct {
+	mu sync.Mutex
+	// subs maps each subscriber's channel to whether Publish waits for
+	// room in it.
+	subs map[chan Event]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]bool)}
+}
+
+// Publish stamps e with the current time if unset and delivers it to every
+// subscriber.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, blocking := range b.subs {
+		if blocking {
+			ch <- e
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function that unsubscribes and
+// closes it.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	return b.subscribe(buffer, false)
+}
+
+func (b *Bus) subscribe(buffer int, blocking bool) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = blocking
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Attach runs s for every event until the returned detach function is
+// called. detach waits for s to finish the event it is handling.
+func (b *Bus) Attach(s Subscriber, buffer int) (detach func()) {
+	return b.attach(s, buffer, false)
+}
+
+// AttachBlocking is Attach for a subscriber that must see every event, like
+// the audit log: once its buffer is full, Publish waits for room instead of
+// dropping the event, so a subscriber that stays slow slows every
+// publisher.
+func (b *Bus) AttachBlocking(s Subscriber, buffer int) (detach func()) {
+	return b.attach(s, buffer, true)
+}
+
+func (b *Bus) attach(s Subscriber, buffer int, blocking bool) (detach func()) {
+	ch, unsubscribe := b.subscribe(buffer, blocking)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range ch {
+			s.Handle(e)
+		}
+	}()
+	return func() {
+		unsubscribe()
+		<-done
+	}
+}
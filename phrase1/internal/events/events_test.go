@@ -0,0 +1,38 @@
+package events
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttachBlockingSeesEveryEvent(t *testing.T) {
+	b := NewBus()
+	var all atomic.Int64
+	release := make(chan struct{})
+	detachAll := b.AttachBlocking(SubscriberFunc(func(Event) {
+		<-release
+		all.Add(1)
+	}), 1)
+
+	const n = 20
+	published := make(chan struct{})
+	go func() {
+		for range n {
+			b.Publish(Event{Type: MessageEnqueued})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish didn't wait for the blocking subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-published
+	detachAll()
+
+	if got := all.Load(); got != n {
+		t.Errorf("blocking subscriber saw %d events, want %d", got, n)
+	}
+}
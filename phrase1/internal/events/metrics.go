@@ -0,0 +1,24 @@
+package events
+
+import "learn_k8s/phrase1/internal/metrics"
+
+// Metrics is a Subscriber that counts events by type and records handling
+// durations.
+type Metrics struct {
+	events   *metrics.Counter
+	duration *metrics.Histogram
+}
+
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		events:   reg.NewCounter("queue_events_total", "Lifecycle events published by this process.", "queue", "type"),
+		duration: reg.NewHistogram("queue_message_handle_seconds", "Time spent handling a message, by outcome.", nil, "queue", "type"),
+	}
+}
+
+func (m *Metrics) Handle(e Event) {
+	m.events.Inc(e.Queue, string(e.Type))
+	if e.Duration > 0 {
+		m.duration.Observe(e.Duration.Seconds(), e.Queue, string(e.Type))
+	}
+}
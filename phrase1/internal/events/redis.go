@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisTransport carries events between processes over a Redis pub/sub
+// channel, so every api replica sees events from every worker.
+type RedisTransport struct {
+	client  *redis.Client
+	channel string
+	onError func(error)
+}
+
+// NewRedisTransport returns a transport on channel; onError (optional) is
+// called for publish and subscription errors.
+func NewRedisTransport(client *redis.Client, channel string, onError func(error)) *RedisTransport {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	return &RedisTransport{client: client, channel: channel, onError: onError}
+}
+
+// Handle makes the transport a Subscriber that forwards local events to
+// Redis.
+func (t *RedisTransport) Handle(e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.onError(err)
+		return
+	}
+	if err := t.client.Publish(ctx, t.channel, b).Err(); err != nil {
+		t.onError(err)
+	}
+}
+
+// Relay publishes events received on the channel to bus until ctx is
+// canceled, resubscribing after connection errors. Relay into a different bus
+// than the one the transport is attached to, or events will loop.
+func (t *RedisTransport) Relay(ctx context.Context, bus *Bus) {
+	for ctx.Err() == nil {
+		err := t.relayOnce(ctx, bus)
+		if ctx.Err() != nil {
+			return
+		}
+		t.onError(err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (t *RedisTransport) relayOnce(ctx context.Context, bus *Bus) error {
+	sub := t.client.Subscribe(ctx, t.channel)
+	defer sub.Close()
+	// ReceiveMessage keeps waiting for data after ctx is done; closing the
+	// subscription is what unblocks it.
+	stop := context.AfterFunc(ctx, func() { _ = sub.Close() })
+	defer stop()
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			continue
+		}
+		bus.Publish(e)
+	}
+}
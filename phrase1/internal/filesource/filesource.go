@@ -0,0 +1,213 @@
+// Package filesource turns files on a shared volume into queue messages, for
+// sidecar-style integrations where another container can only write files.
+package filesource
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Mode string
+
+const (
+	// ModeDrop enqueues each complete file and deletes it afterwards.
+	ModeDrop Mode = "drop"
+	// ModeTail follows files like `tail -F`, enqueueing each new line.
+	ModeTail Mode = "tail"
+)
+
+type Config struct {
+	Dir     string
+	Pattern string // glob matched against file names; "" means all files
+	Mode    Mode
+	Poll    time.Duration
+	// SplitLines enqueues one message per non-empty line in drop mode.
+	SplitLines bool
+}
+
+// EnqueueFunc publishes one message read from file.
+type EnqueueFunc func(ctx context.Context, payload, file string) error
+
+// Offsets persists how far each tailed file has been read so a restart
+// doesn't re-enqueue lines.
+type Offsets interface {
+	Get(ctx context.Context, path string) (int64, error)
+	Set(ctx context.Context, path string, offset int64) error
+}
+
+type Source struct {
+	cfg     Config
+	enqueue EnqueueFunc
+	offsets Offsets
+	logger  *log.Logger
+}
+
+func New(cfg Config, enqueue EnqueueFunc, offsets Offsets, logger *log.Logger) (*Source, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("filesource: no directory configured")
+	}
+	if cfg.Mode != ModeDrop && cfg.Mode != ModeTail {
+		return nil, fmt.Errorf("filesource: unknown mode %q (want drop or tail)", cfg.Mode)
+	}
+	if cfg.Mode == ModeTail && offsets == nil {
+		return nil, fmt.Errorf("filesource: tail mode needs an offset store")
+	}
+	if cfg.Pattern == "" {
+		cfg.Pattern = "*"
+	}
+	if _, err := filepath.Match(cfg.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("filesource: bad pattern %q: %w", cfg.Pattern, err)
+	}
+	if cfg.Poll <= 0 {
+		cfg.Poll = time.Second
+	}
+	return &Source{cfg: cfg, enqueue: enqueue, offsets: offsets, logger: logger}, nil
+}
+
+// Run polls the directory, creating it if needed, until ctx is canceled.
+func (s *Source) Run(ctx context.Context) error {
+	if err := os.MkdirAll(s.cfg.Dir, 0o755); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.cfg.Poll)
+	defer ticker.Stop()
+	for {
+		if err := s.scan(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Printf("file source scan error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// candidates lists matching regular files, oldest name first. Hidden files
+// and *.tmp are skipped so writers can write-then-rename atomically.
+func (s *Source) candidates() ([]string, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		if ok, _ := filepath.Match(s.cfg.Pattern, name); !ok {
+			continue
+		}
+		out = append(out, filepath.Join(s.cfg.Dir, name))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *Source) scan(ctx context.Context) error {
+	files, err := s.candidates()
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var err error
+		if s.cfg.Mode == ModeDrop {
+			err = s.drop(ctx, path)
+		} else {
+			err = s.tail(ctx, path)
+		}
+		if err != nil {
+			s.logger.Printf("file source %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func (s *Source) drop(ctx context.Context, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	if s.cfg.SplitLines {
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if err := s.enqueue(ctx, line, name); err != nil {
+				// Leave the file; it will be retried whole, duplicating the
+				// lines already sent (at-least-once).
+				return err
+			}
+		}
+	} else if payload := strings.TrimSpace(string(b)); payload != "" {
+		if err := s.enqueue(ctx, payload, name); err != nil {
+			return err
+		}
+	}
+	s.logger.Printf("file source consumed %s", name)
+	return os.Remove(path)
+}
+
+func (s *Source) tail(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset, err := s.offsets.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if st.Size() < offset {
+		// Truncated or replaced (log rotation): start over.
+		s.logger.Printf("file source %s shrank, rereading from start", filepath.Base(path))
+		offset = 0
+	}
+	if st.Size() == offset {
+		return nil
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// A partial last line stays unread until its newline arrives.
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		next := offset + int64(len(line))
+		if payload := strings.TrimSpace(line); payload != "" {
+			if err := s.enqueue(ctx, payload, name); err != nil {
+				return err
+			}
+		}
+		if err := s.offsets.Set(ctx, path, next); err != nil {
+			return err
+		}
+		offset = next
+	}
+}
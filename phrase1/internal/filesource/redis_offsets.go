@@ -0,0 +1,30 @@
+package filesource
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisOffsets stores tail offsets in a Redis hash keyed by file path.
+type RedisOffsets struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedisOffsets(client *redis.Client, key string) *RedisOffsets {
+	return &RedisOffsets{client: client, key: key}
+}
+
+func (o *RedisOffsets) Get(ctx context.Context, path string) (int64, error) {
+	n, err := o.client.HGet(ctx, o.key, path).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (o *RedisOffsets) Set(ctx context.Context, path string, offset int64) error {
+	return o.client.HSet(ctx, o.key, path, offset).Err()
+}
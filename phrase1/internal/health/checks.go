@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func RedisPing(client *redis.Client) Check {
+	return Check{Name: "redis", Run: func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}}
+}
+
+// RedisKeys checks that each key is either missing or of the wanted type
+// (list, hash, ...), so a key clobbered by hand is caught before commands
+// start failing with WRONGTYPE, and that probe can be written and deleted,
+// which catches ACL users without write access.
+func RedisKeys(client *redis.Client, want map[string]string, probe string) Check {
+	return Check{Name: "redis_keys", Run: func(ctx context.Context) error {
+		for key, typ := range want {
+			got, err := client.Type(ctx, key).Result()
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if got != "none" && got != typ {
+				return fmt.Errorf("%s is a %s, want %s", key, got, typ)
+			}
+		}
+		if err := client.Set(ctx, probe, "1", time.Minute).Err(); err != nil {
+			return fmt.Errorf("write %s: %w", probe, err)
+		}
+		if err := client.Del(ctx, probe).Err(); err != nil {
+			return fmt.Errorf("delete %s: %w", probe, err)
+		}
+		return nil
+	}}
+}
+
+// Writable checks that path can be opened for appending, creating its
+// directory if needed, without writing to it.
+func Writable(name, path string) Check {
+	return Check{Name: name, Run: func(context.Context) error {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}}
+}
+
+// Config fails while invalid returns any names: settings whose values
+// couldn't be parsed and were replaced by their defaults.
+func Config(invalid func() []string) Check {
+	return Check{Name: "config", Run: func(context.Context) error {
+		if bad := invalid(); len(bad) > 0 {
+			return fmt.Errorf("invalid value for %s (using the default)", strings.Join(bad, ", "))
+		}
+		return nil
+	}}
+}
+
+// QueueLag is an optional check that fails while the oldest waiting message
+// has waited longer than max, as reported by oldest. A max of 0 only reports
+// the check's latency.
+func QueueLag(oldest func(ctx context.Context) (time.Duration, error), max time.Duration) Check {
+	return Check{Name: "queue_lag", Optional: true, Run: func(ctx context.Context) error {
+		age, err := oldest(ctx)
+		if err != nil {
+			return err
+		}
+		if max > 0 && age > max {
+			return fmt.Errorf("oldest message waiting %s (max %s)", age.Round(time.Millisecond), max)
+		}
+		return nil
+	}}
+}
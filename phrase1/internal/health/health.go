@@ -0,0 +1,136 @@
+// Package health aggregates named checks into one report. Components
+// register their checks on a Registry (redis, sink, queue lag, ...) and the
+// binaries serve the report; each check runs concurrently under a shared
+// timeout and is timed.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOK Status = "ok"
+	// StatusDegraded means only optional checks failed.
+	StatusDegraded Status = "degraded"
+	StatusFailing  Status = "failing"
+)
+
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Optional checks don't fail the report, only degrade it.
+	Optional bool
+}
+
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Optional  bool    `json:"optional,omitempty"`
+}
+
+type Report struct {
+	Status    Status    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks"`
+}
+
+type Registry struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks []Check
+}
+
+// NewRegistry returns a registry whose reports give each run timeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout}
+}
+
+// Register adds checks; it can be called while the report is being served.
+func (r *Registry) Register(checks ...Check) {
+	r.mu.Lock()
+	r.checks = append(r.checks, checks...)
+	r.mu.Unlock()
+}
+
+// Run runs every check and reports them in registration order.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.Lock()
+	checks := append([]Check(nil), r.checks...)
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.Run(ctx)
+			res := Result{Name: c.Name, Status: StatusOK, Optional: c.Optional, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status = StatusFailing
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+
+	rep := Report{Status: StatusOK, CheckedAt: time.Now().UTC(), Checks: results}
+	for _, res := range results {
+		switch {
+		case res.Status == StatusOK:
+		case res.Optional:
+			if rep.Status == StatusOK {
+				rep.Status = StatusDegraded
+			}
+		default:
+			rep.Status = StatusFailing
+		}
+	}
+	return rep
+}
+
+// Handler serves the report as JSON, with 503 while it's failing.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rep := r.Run(req.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if rep.Status == StatusFailing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// TextHandler serves the report as one "ok <name>" or "fail <name>: <error>"
+// line per check, which reads well in probe failure events.
+func (r *Registry) TextHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rep := r.Run(req.Context())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if rep.Status == StatusFailing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		for _, res := range rep.Checks {
+			if res.Status == StatusOK {
+				fmt.Fprintf(w, "ok %s\n", res.Name)
+			} else {
+				fmt.Fprintf(w, "fail %s: %s\n", res.Name, res.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package idempotency remembers the Idempotency-Key of recent enqueues, so a
+// producer retrying a request whose response it never saw doesn't queue the
+// message twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TTL is how long a key is remembered. Retries later than that enqueue again.
+const TTL = 24 * time.Hour
+
+// Store keeps keys in Redis, shared by every api replica.
+type Store struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewStore keeps keys under prefix + ":" + scope + ":" + key.
+func NewStore(client *redis.Client, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+func (s *Store) key(scope, key string) string {
+	return s.prefix + ":" + scope + ":" + key
+}
+
+// Claim records key for scope as belonging to the message id. If the key was
+// already claimed it returns the id it was claimed for and false, and the
+// request is a duplicate.
+func (s *Store) Claim(ctx context.Context, scope, key, id string) (string, bool, error) {
+	k := s.key(scope, key)
+	ok, err := s.client.SetNX(ctx, k, id, TTL).Result()
+	if err != nil || ok {
+		return id, ok, err
+	}
+	prev, err := s.client.Get(ctx, k).Result()
+	if errors.Is(err, redis.Nil) {
+		// Expired in between; claim it again.
+		return s.Claim(ctx, scope, key, id)
+	}
+	return prev, false, err
+}
+
+// Release forgets a key whose message wasn't enqueued after all, so a retry
+// gets another go.
+func (s *Store) Release(ctx context.Context, scope, key, id string) error {
+	k := s.key(scope, key)
+	// Only delete the key if it's still ours.
+	return s.client.Watch(ctx, func(tx *redis.Tx) error {
+		cur, err := tx.Get(ctx, k).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil || cur != id {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Del(ctx, k)
+			return nil
+		})
+		return err
+	}, k)
+}
@@ -0,0 +1,122 @@
+// Package leader elects one replica to run a singleton task, using a Redis
+// key as a lease: the replica that sets it leads until it stops renewing it,
+// and another takes over within the lease's TTL after the leader dies.
+//
+// A lease isn't a fence. A leader that stalls for longer than the TTL can
+// overlap with its successor for a moment, so tasks run under it should be
+// safe to run twice at once, just wasteful.
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Elector campaigns for one lease on behalf of one replica.
+type Elector struct {
+	client   *redis.Client
+	key      string
+	id       string
+	ttl      time.Duration
+	leading  atomic.Bool
+	onChange func(leading bool)
+}
+
+// New returns an elector for the lease key, identifying this replica as id.
+// The lease is renewed every third of ttl.
+func New(client *redis.Client, key, id string, ttl time.Duration) *Elector {
+	return &Elector{client: client, key: key, id: id, ttl: ttl}
+}
+
+// OnChange sets fn to be called whenever this replica gains or loses the
+// lease. It must be set before Run.
+func (e *Elector) OnChange(fn func(leading bool)) {
+	e.onChange = fn
+}
+
+// Leading reports whether this replica currently holds the lease.
+func (e *Elector) Leading() bool {
+	return e.leading.Load()
+}
+
+func (e *Elector) setLeading(v bool) {
+	e.leading.Store(v)
+	if e.onChange != nil {
+		e.onChange(v)
+	}
+}
+
+// renewScript extends the lease only if this replica still holds it.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript drops the lease only if this replica still holds it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Run campaigns until ctx is canceled, calling lead each time this replica
+// wins the lease. lead's context is canceled when the lease is lost, and
+// lead must return then; the lease is released when lead returns, so the
+// next replica doesn't wait out the TTL.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	interval := e.ttl / 3
+	for {
+		won, err := e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+		if err == nil && won {
+			e.hold(ctx, interval, lead)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// hold runs lead while renewing the lease, until either ends.
+func (e *Elector) hold(ctx context.Context, interval time.Duration, lead func(context.Context)) {
+	e.setLeading(true)
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			// An error counts as lost too: without Redis the lease can't
+			// be vouched for, and it expires on its own anyway.
+			n, err := renewScript.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int()
+			if err != nil || n == 0 {
+				break loop
+			}
+		}
+	}
+	cancel()
+	<-done
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelRelease()
+	_ = releaseScript.Run(releaseCtx, e.client, []string{e.key}, e.id).Err()
+	e.setLeading(false)
+}
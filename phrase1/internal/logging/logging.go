@@ -0,0 +1,75 @@
+// Package logging adds a level that can be changed at runtime to the standard
+// library logger. Each *log.Logger is bound to one level and drops its output
+// while the switch is set above it, so call sites keep using Printf.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+)
+
+var names = []string{"debug", "info", "warn"}
+
+func (l Level) String() string {
+	if l < Debug || l > Warn {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return names[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, n := range names {
+		if s == n {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, or warn)", s)
+}
+
+// Switch holds the current minimum level.
+type Switch struct {
+	level atomic.Int32
+}
+
+func NewSwitch(l Level) *Switch {
+	s := &Switch{}
+	s.Set(l)
+	return s
+}
+
+func (s *Switch) Set(l Level)  { s.level.Store(int32(l)) }
+func (s *Switch) Level() Level { return Level(s.level.Load()) }
+
+// Enabled reports whether lines at l are currently written.
+func (s *Switch) Enabled(l Level) bool { return l >= s.Level() }
+
+// Logger returns a logger for lines at level l, writing to out while l is
+// enabled.
+func (s *Switch) Logger(l Level, out io.Writer, prefix string, flag int) *log.Logger {
+	return log.New(&gate{s: s, l: l, out: out}, prefix, flag)
+}
+
+type gate struct {
+	s   *Switch
+	l   Level
+	out io.Writer
+}
+
+func (g *gate) Write(p []byte) (int, error) {
+	if !g.s.Enabled(g.l) {
+		return len(p), nil
+	}
+	return g.out.Write(p)
+}
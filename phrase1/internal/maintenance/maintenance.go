@@ -0,0 +1,103 @@
+// Package maintenance holds the api's maintenance-mode switch. The state is
+// kept in Redis so toggling it on one replica applies to all of them; each
+// replica caches it and refreshes periodically so the enqueue path doesn't
+// pay a Redis round trip.
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type State struct {
+	Enabled bool `json:"enabled"`
+	// Message is returned to producers while enabled.
+	Message string `json:"message,omitempty"`
+	// FailHealth also makes /healthz fail, taking replicas out of Service
+	// endpoints. It's separate so clients can keep getting an explicit 503
+	// with Message instead of connection errors.
+	FailHealth bool       `json:"fail_health,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	By         string     `json:"by,omitempty"`
+}
+
+type Switch struct {
+	client *redis.Client
+	key    string
+
+	mu  sync.RWMutex
+	cur State
+}
+
+func NewSwitch(client *redis.Client, key string) *Switch {
+	return &Switch{client: client, key: key}
+}
+
+// State returns the cached state.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cur
+}
+
+// Set stores st for all replicas. Disabling deletes the key.
+func (s *Switch) Set(ctx context.Context, st State) error {
+	var err error
+	if st.Enabled {
+		var b []byte
+		if b, err = json.Marshal(st); err != nil {
+			return err
+		}
+		err = s.client.Set(ctx, s.key, b, 0).Err()
+	} else {
+		st = State{}
+		err = s.client.Del(ctx, s.key).Err()
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cur = st
+	s.mu.Unlock()
+	return nil
+}
+
+// Refresh reloads the state from Redis. On error the cached state is kept.
+func (s *Switch) Refresh(ctx context.Context) error {
+	b, err := s.client.Get(ctx, s.key).Bytes()
+	var st State
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(b, &st); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.cur = st
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes every interval until ctx is canceled.
+func (s *Switch) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Package metrics is a small Prometheus-compatible metrics registry. It
+// supports counters, gauges, and histograms with labels and renders the text
+// exposition format, which is all the demo needs without pulling in the full
+// client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []writer
+	names   map[string]bool
+	limits  map[string]*limit
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool), limits: make(map[string]*limit)}
+}
+
+// Other is the value a limited label takes for the values that don't get
+// their own series.
+const Other = "other"
+
+// limit bounds the values one label takes across every metric with it.
+type limit struct {
+	allow map[string]bool
+	max   int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// allows reports whether v gets its own series.
+func (l *limit) allows(v string) bool {
+	if l.allow[v] {
+		return true
+	}
+	if len(l.allow) > 0 && l.max == 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[v] {
+		return true
+	}
+	if l.max > 0 && len(l.seen) >= l.max {
+		return false
+	}
+	l.seen[v] = true
+	return true
+}
+
+// LimitLabel bounds the values of label in every metric that has it, so a
+// label fed from user input (a queue per tenant, say) can't grow the
+// series without bound. Values in allow always get their own series; other
+// values get one each until maxValues have, first come first served. After
+// that, counters and histograms fold them into an Other series, and gauges
+// drop them: the last write of many gauges isn't a value of any of them.
+// With allow set and maxValues 0, only allow's values get their own
+// series; with neither, the label is unbounded. It applies to metrics
+// created after it's called.
+func (r *Registry) LimitLabel(label string, allow []string, maxValues int) {
+	if len(allow) == 0 && maxValues <= 0 {
+		return
+	}
+	l := &limit{allow: make(map[string]bool), max: maxValues, seen: make(map[string]bool)}
+	for _, v := range allow {
+		l.allow[v] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits[label] = l
+}
+
+// DefaultMaxQueues is how many queues get their own series by default.
+const DefaultMaxQueues = 50
+
+// LimitQueues limits the queue label, which every component's metrics
+// carry: tenants and dispatch subscriptions multiply queues, so past
+// maxQueues of them, the rest share queue="other". Queues in allow always
+// get their own series.
+func (r *Registry) LimitQueues(allow []string, maxQueues int) {
+	r.LimitLabel("queue", allow, maxQueues)
+}
+
+// limitsFor returns the limit of each of labels, nil for the unlimited.
+func (r *Registry) limitsFor(labels []string) []*limit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []*limit
+	for i, name := range labels {
+		if l := r.limits[name]; l != nil {
+			if out == nil {
+				out = make([]*limit, len(labels))
+			}
+			out[i] = l
+		}
+	}
+	return out
+}
+
+type writer interface {
+	write(w io.Writer)
+}
+
+func (r *Registry) register(name string, m writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo renders all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	ms := append([]writer(nil), r.metrics...)
+	r.mu.Unlock()
+	cw := &countingWriter{w: w}
+	for _, m := range ms {
+		m.write(cw)
+	}
+	return cw.n, cw.err
+}
+
+// countingWriter gives WriteTo its io.WriterTo results; the metric writers
+// themselves ignore errors.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// family holds the label-keyed series of one metric.
+type family struct {
+	name   string
+	help   string
+	kind   kind
+	labels []string
+	limits []*limit
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+
+	// histogram only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) newFamily(name, help string, k kind, labels []string) *family {
+	return &family{name: name, help: help, kind: k, labels: labels, limits: r.limitsFor(labels), series: make(map[string]*series)}
+}
+
+// get returns the series for labelValues, or, for a gauge with a label
+// value past its limit, nil; f.mu must be held.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	if f.limits != nil {
+		limited := make([]string, len(labelValues))
+		for i, v := range labelValues {
+			if l := f.limits[i]; l != nil && !l.allows(v) {
+				if f.kind == kindGauge {
+					return nil
+				}
+				v = Other
+			}
+			limited[i] = v
+		}
+		labelValues = limited
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) sorted() []*series {
+	out := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labelValues, "\xff") < strings.Join(out[j].labelValues, "\xff")
+	})
+	return out
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header(w)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+// Counter is a monotonically increasing value per label set.
+type Counter struct{ f *family }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{f: r.newFamily(name, help, kindCounter, labels)}
+	r.register(name, c.f)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.f.mu.Lock()
+	c.f.get(labelValues).value += v
+	c.f.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down per label set.
+type Gauge struct{ f *family }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{f: r.newFamily(name, help, kindGauge, labels)}
+	r.register(name, g.f)
+	return g
+}
+
+// Set and Add drop writes to series a label limit folded away; see
+// LimitLabel.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	if s := g.f.get(labelValues); s != nil {
+		s.value = v
+	}
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	if s := g.f.get(labelValues); s != nil {
+		s.value += v
+	}
+	g.f.mu.Unlock()
+}
+
+// gaugeFunc is evaluated at scrape time.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations into cumulative buckets per label set.
+type Histogram struct {
+	f       *family
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{f: r.newFamily(name, help, kindHistogram, labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	f := h.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header(w)
+	for _, s := range f.sorted() {
+		for i, b := range h.buckets {
+			var c uint64
+			if s.counts != nil {
+				c = s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", formatFloat(b)), c)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.labelValues), s.count)
+	}
+}
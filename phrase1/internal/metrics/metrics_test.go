@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		max   int
+		want  []string
+	}{
+		{"first come", nil, 2, []string{`queue="a"`, `queue="b"`, `queue="other"`}},
+		{"allowlist only", []string{"c"}, 0, []string{`queue="c"`, `queue="other"`}},
+		{"allowlist and max", []string{"c"}, 1, []string{`queue="a"`, `queue="c"`, `queue="other"`}},
+		{"unbounded", nil, 0, []string{`queue="a"`, `queue="b"`, `queue="c"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistry()
+			reg.LimitLabel("queue", tt.allow, tt.max)
+			c := reg.NewCounter("enqueued_total", "", "queue", "result")
+			g := reg.NewGauge("depth", "", "queue")
+			for _, q := range []string{"a", "b", "c", "a"} {
+				c.Inc(q, "ok")
+				g.Set(1, q)
+			}
+
+			var b strings.Builder
+			reg.WriteTo(&b)
+			out := b.String()
+			for _, v := range tt.want {
+				if !strings.Contains(out, "enqueued_total{"+v+",") {
+					t.Errorf("no counter series with %s in\n%s", v, out)
+				}
+			}
+			if n := strings.Count(out, "\nenqueued_total{"); n != len(tt.want) {
+				t.Errorf("%d counter series, want %d", n, len(tt.want))
+			}
+			// Values share the limit across metrics, but gauges drop the
+			// folded ones rather than mixing them in one series.
+			gauges := 0
+			for _, v := range tt.want {
+				if v != `queue="other"` {
+					gauges++
+					if !strings.Contains(out, "depth{"+v+"}") {
+						t.Errorf("no depth series with %s in\n%s", v, out)
+					}
+				}
+			}
+			if n := strings.Count(out, "\ndepth{"); n != gauges {
+				t.Errorf("%d depth series, want %d", n, gauges)
+			}
+			if strings.Contains(out, `result="other"`) {
+				t.Error("an unlimited label was rewritten")
+			}
+		})
+	}
+}
+
+func TestLimitLabelQueueNamedOther(t *testing.T) {
+	reg := NewRegistry()
+	reg.LimitQueues(nil, 1)
+	g := reg.NewGauge("depth", "", "queue")
+	g.Set(3, Other)
+	g.Set(5, "b")
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	if out := b.String(); !strings.Contains(out, `depth{queue="other"} 3`) || strings.Contains(out, `queue="b"`) {
+		t.Errorf("a queue named other should keep its gauge and b's should be dropped:\n%s", out)
+	}
+}
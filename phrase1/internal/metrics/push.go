@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Push replaces the metrics of the group identified by job and grouping on
+// the Prometheus Pushgateway at baseURL with everything in r, for processes
+// that exit before they would be scraped.
+func (r *Registry) Push(ctx context.Context, baseURL, job string, grouping map[string]string) error {
+	var body bytes.Buffer
+	if _, err := r.WriteTo(&body); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL(baseURL, job, grouping), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pushURL builds /metrics/job/<job>/<label>/<value>..., base64-encoding
+// values the path can't carry as they are.
+func pushURL(baseURL, job string, grouping map[string]string) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(baseURL, "/"))
+	b.WriteString("/metrics")
+	segment := func(name, value string) {
+		if value == "" || strings.Contains(value, "/") {
+			fmt.Fprintf(&b, "/%s@base64/%s", name, base64.RawURLEncoding.EncodeToString([]byte(value)))
+			return
+		}
+		fmt.Fprintf(&b, "/%s/%s", name, url.PathEscape(value))
+	}
+	segment("job", job)
+	names := make([]string, 0, len(grouping))
+	for n := range grouping {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		segment(n, grouping[n])
+	}
+	return b.String()
+}
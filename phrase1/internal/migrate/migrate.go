@@ -0,0 +1,66 @@
+// Package migrate upgrades message payloads written for older schema versions
+// so consumers only handle the current shape, and producers and consumers can
+// be rolled out independently.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooNew is returned for versions newer than any the registry knows, i.e.
+// the producer is ahead of this consumer.
+var ErrTooNew = errors.New("migrate: payload schema version is newer than supported")
+
+// Func upgrades a payload from one version to the next.
+type Func func(payload string) (string, error)
+
+type Registry struct {
+	steps  map[int]Func
+	latest int
+}
+
+// NewRegistry returns a registry whose current version is 1 until steps are
+// registered.
+func NewRegistry() *Registry {
+	return &Registry{steps: make(map[int]Func), latest: 1}
+}
+
+// Register adds the step that upgrades payloads from version from to from+1.
+// Steps must be contiguous from version 1.
+func (r *Registry) Register(from int, fn Func) {
+	if from < 1 {
+		panic("migrate: versions start at 1")
+	}
+	if _, dup := r.steps[from]; dup {
+		panic(fmt.Sprintf("migrate: duplicate step from v%d", from))
+	}
+	r.steps[from] = fn
+	if from+1 > r.latest {
+		r.latest = from + 1
+	}
+}
+
+// Latest is the version consumers handle and Upgrade migrates to.
+func (r *Registry) Latest() int {
+	return r.latest
+}
+
+// Upgrade migrates payload from version to Latest. It returns the payload
+// unchanged for the latest version.
+func (r *Registry) Upgrade(version int, payload string) (string, error) {
+	if version > r.latest {
+		return "", fmt.Errorf("%w: v%d (latest v%d)", ErrTooNew, version, r.latest)
+	}
+	for v := version; v < r.latest; v++ {
+		step, ok := r.steps[v]
+		if !ok {
+			return "", fmt.Errorf("migrate: no step from v%d", v)
+		}
+		var err error
+		if payload, err = step(payload); err != nil {
+			return "", fmt.Errorf("migrate v%d to v%d: %w", v, v+1, err)
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limiter holds sends to perSec across every worker sharing key, since a
+// provider's rate limit is per account rather than per worker. Like the
+// api's tenant limiter it counts in one-second windows in Redis.
+type Limiter struct {
+	client *redis.Client
+	key    string
+	perSec int64
+}
+
+// NewLimiter returns a limiter allowing perSec sends a second; perSec <= 0
+// doesn't limit.
+func NewLimiter(client *redis.Client, key string, perSec int64) *Limiter {
+	return &Limiter{client: client, key: key, perSec: perSec}
+}
+
+// Wait blocks until a send fits in the rate, and returns how long it waited.
+func (l *Limiter) Wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if l.perSec <= 0 {
+		return 0, nil
+	}
+	for {
+		now := time.Now()
+		key := l.key + ":" + strconv.FormatInt(now.Unix(), 10)
+		var n *redis.IntCmd
+		_, err := l.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			n = p.Incr(ctx, key)
+			p.Expire(ctx, key, 2*time.Second)
+			return nil
+		})
+		if err != nil {
+			return time.Since(start), err
+		}
+		if n.Val() <= l.perSec {
+			return time.Since(start), nil
+		}
+		t := time.NewTimer(now.Truncate(time.Second).Add(time.Second).Sub(now))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return time.Since(start), ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package notify turns notification requests (a template name, a recipient,
+// and data for the template) into messages and sends them by SMTP or to a
+// webhook provider.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// ErrRejected is wrapped by errors that sending again won't fix: a malformed
+// request, an unknown template, or a recipient the provider refused.
+var ErrRejected = errors.New("notification rejected")
+
+func rejected(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrRejected, fmt.Sprintf(format, args...))
+}
+
+// Request is a message's payload: which template to send to whom.
+type Request struct {
+	To       string         `json:"to"`
+	Template string         `json:"template"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// ParseRequest decodes and checks a notification request.
+func ParseRequest(text string) (Request, error) {
+	var r Request
+	if err := json.Unmarshal([]byte(text), &r); err != nil {
+		return Request{}, rejected("not a notification request: %v", err)
+	}
+	if r.Template == "" {
+		return Request{}, rejected("no template")
+	}
+	if r.To == "" {
+		return Request{}, rejected("no recipient")
+	}
+	if _, err := mail.ParseAddress(r.To); err != nil {
+		return Request{}, rejected("recipient %q: %v", r.To, err)
+	}
+	return r, nil
+}
+
+// Notification is a rendered request, ready to send.
+type Notification struct {
+	// ID is the message id; providers use it to drop a resent duplicate.
+	ID      string
+	To      string
+	Subject string
+	Body    string
+}
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Templates are the notification templates by name. A template is a file
+// <name>.tmpl whose first line is "Subject: <subject>", then a blank line,
+// then the body; both are text/template with the request's data as dot.
+type Templates struct {
+	byName map[string]*template.Template
+}
+
+// DefaultTemplates are the examples shipped with the worker: welcome and
+// password_reset.
+func DefaultTemplates() (*Templates, error) {
+	sub, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return LoadTemplates(sub)
+}
+
+// LoadTemplates parses every *.tmpl file at the top of fsys.
+func LoadTemplates(fsys fs.FS) (*Templates, error) {
+	names, err := fs.Glob(fsys, "*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("notify: no *.tmpl templates")
+	}
+	t := &Templates{byName: map[string]*template.Template{}}
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(b, []byte("Subject:")) {
+			return nil, fmt.Errorf("notify: template %s doesn't start with a Subject: line", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("notify: %w", err)
+		}
+		t.byName[strings.TrimSuffix(path.Base(name), ".tmpl")] = tmpl
+	}
+	return t, nil
+}
+
+// Render fills in r's template. A template that doesn't exist or is missing
+// data rejects the request.
+func (t *Templates) Render(r Request) (Notification, error) {
+	tmpl, ok := t.byName[r.Template]
+	if !ok {
+		return Notification{}, rejected("unknown template %q", r.Template)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, r.Data); err != nil {
+		return Notification{}, rejected("template %s: %v", r.Template, err)
+	}
+	head, body, _ := strings.Cut(b.String(), "\n")
+	return Notification{
+		To:      r.To,
+		Subject: strings.TrimSpace(strings.TrimPrefix(head, "Subject:")),
+		Body:    strings.TrimLeft(body, "\n"),
+	}, nil
+}
+
+// Sender delivers a notification. Errors wrapping ErrRejected are permanent;
+// any other may go through on a later try.
+type Sender interface {
+	Send(ctx context.Context, n Notification) error
+}
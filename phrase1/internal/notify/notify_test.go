@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseRequest(t *testing.T) {
+	r, err := ParseRequest(`{"to":"ada@example.com","template":"welcome","data":{"name":"Ada"}}`)
+	if err != nil || r.To != "ada@example.com" || r.Data["name"] != "Ada" {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+	for _, text := range []string{
+		`hello`,
+		`{"to":"ada@example.com"}`,
+		`{"template":"welcome"}`,
+		`{"to":"not an address","template":"welcome"}`,
+	} {
+		if _, err := ParseRequest(text); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: err %v, want rejected", text, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tmpls, err := DefaultTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := tmpls.Render(Request{To: "ada@example.com", Template: "welcome", Data: map[string]any{"name": "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Subject != "Welcome, Ada!" || !strings.HasPrefix(n.Body, "Hi Ada,\n") {
+		t.Errorf("rendered %q / %q", n.Subject, n.Body)
+	}
+	if _, err := tmpls.Render(Request{To: "ada@example.com", Template: "password_reset", Data: map[string]any{"name": "Ada"}}); !errors.Is(err, ErrRejected) {
+		t.Errorf("missing link: err %v, want rejected", err)
+	}
+	if _, err := tmpls.Render(Request{To: "ada@example.com", Template: "nope"}); !errors.Is(err, ErrRejected) {
+		t.Errorf("unknown template: err %v, want rejected", err)
+	}
+	if _, err := LoadTemplates(fstest.MapFS{"bad.tmpl": {Data: []byte("no subject")}}); err == nil {
+		t.Error("loaded a template without a Subject: line")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Idempotency-Key") != "m1" || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("headers %v", r.Header)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	h := &Webhook{URL: srv.URL, Token: "tok", Client: srv.Client()}
+	for _, tc := range []struct {
+		status         int
+		fails, rejects bool
+	}{
+		{http.StatusAccepted, false, false},
+		{http.StatusTooManyRequests, true, false},
+		{http.StatusServiceUnavailable, true, false},
+		{http.StatusUnauthorized, true, false},
+		{http.StatusUnprocessableEntity, true, true},
+	} {
+		status = tc.status
+		err := h.Send(context.Background(), Notification{ID: "m1", To: "ada@example.com", Subject: "s", Body: "b"})
+		if (err != nil) != tc.fails || errors.Is(err, ErrRejected) != tc.rejects {
+			t.Errorf("%d: err %v, want failed=%t rejected=%t", tc.status, err, tc.fails, tc.rejects)
+		}
+	}
+}
+
+// smtpServer is a relay that accepts one message per connection, or answers
+// rcptReply to RCPT TO if set, and sends each message's data to msgs.
+func smtpServer(t *testing.T, rcptReply string, msgs chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+				reply("220 test ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line)[0]); {
+					case cmd == "EHLO" || cmd == "HELO":
+						reply("250 test")
+					case cmd == "RCPT" && rcptReply != "":
+						reply(rcptReply)
+					case cmd == "DATA":
+						reply("354 go ahead")
+						var data strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil || l == ".\r\n" {
+								break
+							}
+							data.WriteString(l)
+						}
+						msgs <- data.String()
+						reply("250 queued")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSMTP(t *testing.T) {
+	msgs := make(chan string, 1)
+	s := &SMTP{Addr: smtpServer(t, "", msgs), From: "worker@example.com"}
+	if err := s.Send(context.Background(), Notification{ID: "m1", To: "ada@example.com", Subject: "Héllo", Body: "line one\nline two\n"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	for _, want := range []string{"To: ada@example.com\r\n", "Subject: =?utf-8?q?H=C3=A9llo?=\r\n", "Message-ID: <m1@example.com>\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q lacks %q", msg, want)
+		}
+	}
+
+	s.Addr = smtpServer(t, "550 no such user", msgs)
+	if err := s.Send(context.Background(), Notification{To: "nobody@example.com"}); !errors.Is(err, ErrRejected) {
+		t.Errorf("550 to RCPT: err %v, want rejected", err)
+	}
+	s.Addr = smtpServer(t, "451 try again later", msgs)
+	if err := s.Send(context.Background(), Notification{To: "ada@example.com"}); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("451 to RCPT: err %v, want a temporary failure", err)
+	}
+}
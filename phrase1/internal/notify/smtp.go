@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SMTP sends notifications as plain-text mail through a relay.
+type SMTP struct {
+	// Addr is the relay's host:port.
+	Addr string
+	From string
+	// Username and Password authenticate with PLAIN if Username is set,
+	// which net/smtp only allows over TLS or to localhost.
+	Username, Password string
+}
+
+// smtpTimeout bounds a send whose context has no deadline.
+const smtpTimeout = 30 * time.Second
+
+func (s *SMTP) Send(ctx context.Context, n Notification) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("notify: smtp: %w", err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("notify: smtp: %w", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("notify: smtp: %w", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("notify: smtp starttls: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("notify: smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return fmt.Errorf("notify: smtp mail from: %w", err)
+	}
+	// From here a 5xx reply is about this message, not the relay's
+	// configuration, so it rejects the notification.
+	if err := c.Rcpt(n.To); err != nil {
+		return smtpError("rcpt to", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError("data", err)
+	}
+	if err := s.write(w, n); err != nil {
+		return fmt.Errorf("notify: smtp data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("data", err)
+	}
+	return c.Quit()
+}
+
+// write writes n as a message; the data writer turns its \n into \r\n.
+func (s *SMTP) write(w io.Writer, n Notification) error {
+	domain := "localhost"
+	if _, d, ok := strings.Cut(s.From, "@"); ok {
+		domain = strings.TrimSuffix(d, ">")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\n", s.From)
+	fmt.Fprintf(&b, "To: %s\n", n.To)
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	if n.ID != "" {
+		fmt.Fprintf(&b, "Message-ID: <%s@%s>\n", n.ID, domain)
+	}
+	b.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: 8bit\n\n")
+	b.WriteString(n.Body)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// smtpError rejects the notification if the relay answered with a permanent
+// (5xx) reply.
+func smtpError(step string, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return fmt.Errorf("%w: smtp %s: %v", ErrRejected, step, err)
+	}
+	return fmt.Errorf("notify: smtp %s: %w", step, err)
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Webhook sends notifications by POSTing them as JSON to a provider's API
+// ({"id", "to", "subject", "body"}), with the message id as Idempotency-Key
+// so the provider can drop a resent duplicate.
+type Webhook struct {
+	URL string
+	// Token is sent as a bearer token if set.
+	Token  string
+	Client *http.Client
+}
+
+func (h *Webhook) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(struct {
+		ID      string `json:"id,omitempty"`
+		To      string `json:"to"`
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+	}{n.ID, n.To, n.Subject, n.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.ID != "" {
+		req.Header.Set("Idempotency-Key", n.ID)
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch code := resp.StatusCode; {
+	case code/100 == 2:
+		return nil
+	case code == http.StatusTooManyRequests, code == http.StatusRequestTimeout, code >= 500,
+		// Bad credentials are the worker's configuration, not the
+		// message's fault; they're retried until someone fixes them.
+		code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return fmt.Errorf("notify: webhook %s answered %s: %s", h.URL, resp.Status, bytes.TrimSpace(msg))
+	default:
+		return rejected("webhook %s answered %s: %s", h.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+}
@@ -0,0 +1,153 @@
+// Package opensearch is a small client for the OpenSearch (and
+// Elasticsearch) REST API: bulk indexing and index templates, which is all
+// the worker's indexer needs.
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrTooManyRequests is returned when the cluster pushed back with 429; the
+// request should be retried after a backoff.
+var ErrTooManyRequests = errors.New("opensearch: too many requests")
+
+type Client struct {
+	// URL is the cluster's base URL, e.g. http://opensearch:9200.
+	URL string
+	// Username and Password are sent as basic auth if Username is set.
+	Username, Password string
+	HTTP               *http.Client
+}
+
+// New returns a client for the cluster at url.
+func New(url, username, password string) *Client {
+	return &Client{
+		URL:      strings.TrimRight(url, "/"),
+		Username: username,
+		Password: password,
+		HTTP:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Doc is one document to index. Indexing the same ID again replaces it, so
+// retrying a bulk request doesn't duplicate documents.
+type Doc struct {
+	Index string
+	ID    string
+	Body  any
+}
+
+// ItemError is a document the cluster rejected in a bulk request.
+type ItemError struct {
+	// Doc is the document's position in the request.
+	Doc    int
+	Status int
+	Reason string
+}
+
+// Retryable reports whether the document may go through on a later try: the
+// cluster was overloaded or unavailable rather than the document bad.
+func (e ItemError) Retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// Bulk indexes docs in one _bulk request. A nil error means the request was
+// processed; documents it rejected are returned as ItemErrors, the rest were
+// indexed. A 429 for the whole request returns ErrTooManyRequests.
+func (c *Client) Bulk(ctx context.Context, docs []Doc) ([]ItemError, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, d := range docs {
+		action := map[string]map[string]string{"index": {"_index": d.Index, "_id": d.ID}}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(d.Body); err != nil {
+			return nil, fmt.Errorf("opensearch: encode %s: %w", d.ID, err)
+		}
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+	var failed []ItemError
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status/100 == 2 {
+				continue
+			}
+			e := ItemError{Doc: i, Status: result.Status}
+			if result.Error != nil {
+				e.Reason = result.Error.Type + ": " + result.Error.Reason
+			}
+			failed = append(failed, e)
+		}
+	}
+	return failed, nil
+}
+
+// PutIndexTemplate creates or replaces the composable index template name,
+// which applies settings and mappings to indices created later.
+func (c *Client) PutIndexTemplate(ctx context.Context, name string, template any) error {
+	b, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPut, "/_index_template/"+name, "application/json", bytes.NewReader(b), nil)
+}
+
+// Ping checks that the cluster answers.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/", "", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("opensearch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return ErrTooManyRequests
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("opensearch: %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,68 @@
+package opensearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("request %s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if u, p, _ := r.BasicAuth(); u != "indexer" || p != "secret" {
+			t.Errorf("auth %q %q", u, p)
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		_, _ = w.Write([]byte(`{"errors":true,"items":[
+			{"index":{"_id":"a","status":201}},
+			{"index":{"_id":"b","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}},
+			{"index":{"_id":"c","status":400,"error":{"type":"mapper_parsing_exception","reason":"bad field"}}}
+		]}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "indexer", "secret")
+	failed, err := c.Bulk(context.Background(), []Doc{
+		{Index: "processed-x", ID: "a", Body: map[string]string{"message": "one"}},
+		{Index: "processed-x", ID: "b", Body: map[string]string{"message": "two"}},
+		{Index: "processed-x", ID: "c", Body: map[string]string{"message": "three"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 6 {
+		t.Fatalf("sent %d lines, want an action and a document for each of 3", len(lines))
+	}
+	var action map[string]map[string]string
+	if err := json.Unmarshal([]byte(lines[2]), &action); err != nil || action["index"]["_id"] != "b" || action["index"]["_index"] != "processed-x" {
+		t.Errorf("action %q (%v)", lines[2], err)
+	}
+	if len(failed) != 2 || failed[0].Doc != 1 || !failed[0].Retryable() || failed[1].Doc != 2 || failed[1].Retryable() {
+		t.Fatalf("failed %+v, want b retryable and c not", failed)
+	}
+	if failed[1].Reason != "mapper_parsing_exception: bad field" {
+		t.Errorf("reason %q", failed[1].Reason)
+	}
+}
+
+func TestBulkTooManyRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "", "").Bulk(context.Background(), []Doc{{Index: "i", ID: "a", Body: "x"}})
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("err %v, want ErrTooManyRequests", err)
+	}
+}
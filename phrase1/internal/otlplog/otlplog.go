@@ -0,0 +1,283 @@
+// Package otlplog exports log lines to an OpenTelemetry collector over
+// OTLP/HTTP with the JSON encoding. It plugs in as an io.Writer next to
+// stdout, so existing *log.Logger call sites are exported unchanged.
+package otlplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"learn_k8s/phrase1/internal/resource"
+)
+
+// Severity is an OpenTelemetry log severity number.
+type Severity int
+
+const (
+	Debug Severity = 5
+	Info  Severity = 9
+	Warn  Severity = 13
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	}
+	return "SEVERITY" + strconv.Itoa(int(s))
+}
+
+type Config struct {
+	// Endpoint is the full logs URL, e.g. http://collector:4318/v1/logs.
+	Endpoint string
+	Headers  map[string]string
+	Resource resource.Resource
+	// Scope names the instrumentation scope, e.g. the binary.
+	Scope string
+	// BatchSize and Interval bound how long a record waits to be sent.
+	BatchSize int
+	Interval  time.Duration
+	// OnError is told about failed exports, once per distinct error.
+	OnError func(error)
+}
+
+type record struct {
+	at       time.Time
+	severity Severity
+	body     string
+}
+
+// Exporter batches records in memory and posts them in the background. When
+// the collector can't keep up, records beyond the buffer are dropped; stdout
+// still has them.
+type Exporter struct {
+	cfg     Config
+	client  *http.Client
+	records chan record
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu      sync.Mutex
+	lastErr string
+	dropped int
+}
+
+const bufferSize = 4096
+
+func New(cfg Config) *Exporter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	e := &Exporter{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		records: make(chan record, bufferSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// Writer returns a writer for a *log.Logger created with prefix whose lines
+// are exported at severity. The prefix and the logger's date and time are
+// taken off the body; the time becomes the record's timestamp.
+func (e *Exporter) Writer(severity Severity, prefix string) io.Writer {
+	return &writer{e: e, severity: severity, prefix: prefix}
+}
+
+type writer struct {
+	e        *Exporter
+	severity Severity
+	prefix   string
+}
+
+// logTime matches log.LstdFlags|log.Lmicroseconds.
+const logTime = "2006/01/02 15:04:05.000000"
+
+func (w *writer) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	line = strings.TrimPrefix(line, w.prefix)
+	at := time.Now()
+	if len(line) > len(logTime) {
+		if t, err := time.ParseInLocation(logTime, line[:len(logTime)], time.Local); err == nil {
+			at = t
+			line = strings.TrimPrefix(line[len(logTime):], " ")
+		}
+	}
+	select {
+	case w.e.records <- record{at: at, severity: w.severity, body: line}:
+	default:
+		w.e.mu.Lock()
+		w.e.dropped++
+		w.e.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+func (e *Exporter) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	batch := make([]record, 0, e.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.report(e.send(batch))
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case r := <-e.records:
+			batch = append(batch, r)
+			if len(batch) >= e.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case r := <-e.records:
+					batch = append(batch, r)
+					if len(batch) >= e.cfg.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown sends what is buffered, waiting until ctx is done at most. Lines
+// written after it stay on stdout only.
+func (e *Exporter) Shutdown(ctx context.Context) {
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+// report passes err to OnError when it differs from the previous outcome, so
+// an unreachable collector is reported once rather than every interval.
+func (e *Exporter) report(err error) {
+	e.mu.Lock()
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	changed := msg != e.lastErr
+	e.lastErr = msg
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if e.cfg.OnError == nil {
+		return
+	}
+	if changed && err != nil {
+		e.cfg.OnError(err)
+	}
+	if dropped > 0 {
+		e.cfg.OnError(fmt.Errorf("otlp log buffer full, dropped %d records", dropped))
+	}
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type logRecord struct {
+	TimeUnixNano         string   `json:"timeUnixNano"`
+	ObservedTimeUnixNano string   `json:"observedTimeUnixNano"`
+	SeverityNumber       Severity `json:"severityNumber"`
+	SeverityText         string   `json:"severityText"`
+	Body                 anyValue `json:"body"`
+}
+
+func (e *Exporter) send(batch []record) error {
+	attrs := make([]keyValue, 0, len(e.cfg.Resource))
+	for _, a := range e.cfg.Resource {
+		attrs = append(attrs, keyValue{Key: a.Key, Value: anyValue{StringValue: a.Value}})
+	}
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	records := make([]logRecord, len(batch))
+	for i, r := range batch {
+		records[i] = logRecord{
+			TimeUnixNano:         strconv.FormatInt(r.at.UnixNano(), 10),
+			ObservedTimeUnixNano: now,
+			SeverityNumber:       r.severity,
+			SeverityText:         r.severity.String(),
+			Body:                 anyValue{StringValue: r.body},
+		}
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceLogs": []any{map[string]any{
+			"resource": map[string]any{"attributes": attrs},
+			"scopeLogs": []any{map[string]any{
+				"scope":      map[string]string{"name": e.cfg.Scope},
+				"logRecords": records,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp log export: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp log export: %s answered %s", e.cfg.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// ParseHeaders reads headers in the OTEL_EXPORTER_OTLP_HEADERS format,
+// "key=value,key=value".
+func ParseHeaders(s string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); ok && k != "" {
+			out[k] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+// Package outbox implements the transactional outbox pattern on Postgres.
+// A producer writes its business rows and an outbox row for each message in
+// the same transaction, so either both happen or neither does; a relay then
+// moves outbox rows to the Redis queue. A relay that dies between enqueueing
+// and marking a row published sends that row again, so delivery is
+// at-least-once, and consumers dedupe on the envelope ID, which is the outbox
+// row's ID.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const schemaSQL = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id           TEXT PRIMARY KEY,
+	queue        TEXT NOT NULL,
+	payload      BYTEA NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	published_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (created_at) WHERE published_at IS NULL;
+`
+
+// Migrate creates the outbox table if it doesn't exist.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, schemaSQL)
+	return err
+}
+
+// Add records an encoded envelope for queue inside tx. id should be the
+// envelope's ID.
+func Add(ctx context.Context, tx pgx.Tx, id, queue, payload string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO outbox (id, queue, payload) VALUES ($1, $2, $3)`, id, queue, []byte(payload))
+	return err
+}
+
+type Counts struct {
+	Pending   int64 `json:"pending"`
+	Published int64 `json:"published"`
+}
+
+func Count(ctx context.Context, pool *pgxpool.Pool) (Counts, error) {
+	var c Counts
+	err := pool.QueryRow(ctx, `SELECT count(*) FILTER (WHERE published_at IS NULL), count(*) FILTER (WHERE published_at IS NOT NULL) FROM outbox`).Scan(&c.Pending, &c.Published)
+	return c, err
+}
+
+type Relay struct {
+	pool  *pgxpool.Pool
+	batch int
+	// send enqueues one outbox row's payload on its queue.
+	send func(ctx context.Context, queue, payload string) error
+}
+
+func NewRelay(pool *pgxpool.Pool, batch int, send func(ctx context.Context, queue, payload string) error) *Relay {
+	return &Relay{pool: pool, batch: batch, send: send}
+}
+
+// PublishBatch sends up to one batch of pending rows, oldest first, and marks
+// the sent ones published. Rows are locked with SKIP LOCKED, so several relay
+// replicas share the work instead of sending rows twice.
+func (r *Relay) PublishBatch(ctx context.Context) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id, queue, payload FROM outbox WHERE published_at IS NULL ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED`, r.batch)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id, queue string
+		payload   []byte
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.queue, &p.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var sent []string
+	var sendErr error
+	for _, p := range batch {
+		if sendErr = r.send(ctx, p.queue, string(p.payload)); sendErr != nil {
+			break
+		}
+		sent = append(sent, p.id)
+	}
+	if len(sent) > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE outbox SET published_at = now() WHERE id = ANY($1)`, sent); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return len(sent), sendErr
+}
+
+// Run publishes pending rows until ctx is canceled, polling every interval
+// and going again right away after a full batch.
+func (r *Relay) Run(ctx context.Context, interval time.Duration, onPublished func(n int), onError func(error)) {
+	for {
+		n, err := r.PublishBatch(ctx)
+		if n > 0 {
+			onPublished(n)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			onError(err)
+		}
+		if n == r.batch && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Prune deletes rows published more than retention ago.
+func Prune(ctx context.Context, pool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	tag, err := pool.Exec(ctx, `DELETE FROM outbox WHERE published_at < $1`, time.Now().Add(-retention))
+	return tag.RowsAffected(), err
+}
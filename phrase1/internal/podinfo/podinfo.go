@@ -0,0 +1,58 @@
+// Package podinfo carries the pod identity Kubernetes hands a container
+// through the downward API, so logs, metrics, heartbeats, and output records
+// can say which replica on which node did the work. Outside Kubernetes every
+// field is empty and nothing changes.
+package podinfo
+
+import (
+	"strings"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+type Identity struct {
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+}
+
+func (id Identity) Empty() bool {
+	return id == Identity{}
+}
+
+// LogPrefix extends a log.Logger prefix such as "api " to
+// "api [namespace/pod@node] ", leaving it alone outside Kubernetes.
+func (id Identity) LogPrefix(prefix string) string {
+	if id.Empty() {
+		return prefix
+	}
+	name := id.Pod
+	if id.Namespace != "" {
+		name = id.Namespace + "/" + name
+	}
+	if id.Node != "" {
+		name += "@" + id.Node
+	}
+	return strings.TrimRight(prefix, " ") + " [" + name + "] "
+}
+
+// Pairs returns key=value pairs for the set fields, for log-style records.
+func (id Identity) Pairs() []string {
+	var out []string
+	for _, kv := range [][2]string{{"pod", id.Pod}, {"namespace", id.Namespace}, {"node", id.Node}} {
+		if kv[1] != "" {
+			out = append(out, kv[0]+"="+kv[1])
+		}
+	}
+	return out
+}
+
+// Register exports the identity as the constant pod_info gauge, which can be
+// joined onto other series by pod. Nothing is registered outside Kubernetes.
+func Register(reg *metrics.Registry, id Identity) {
+	if id.Empty() {
+		return
+	}
+	reg.NewGauge("pod_info", "Pod identity from the downward API; always 1.", "pod", "namespace", "node").
+		Set(1, id.Pod, id.Namespace, id.Node)
+}
@@ -0,0 +1,256 @@
+// Package policy reads per-queue policies from a YAML document, usually a
+// mounted ConfigMap, and reloads it when the file changes:
+//
+//	default:
+//	  max_attempts: 3
+//	  backoff: 2s
+//	queues:
+//	  messages:
+//	    ttl: 1h
+//	    max_depth: 10000
+//	    dlq: messages:failed
+//	    priorities: [critical, high]
+//	  reports:
+//	    quiet_hours: ["* 8-17 * * 1-5"]
+//
+// A queue's policy is the default with the queue's non-zero fields laid
+// over it. The zero Policy changes nothing about how queues behave.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"learn_k8s/phrase1/internal/cron"
+)
+
+// Policy is how one queue is run.
+type Policy struct {
+	// MaxAttempts is how many times the worker handles a message whose
+	// handler fails before dead-lettering it; 0 and 1 mean no retries.
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the wait before the first retry, doubled for each next
+	// one (default 1s).
+	Backoff time.Duration `yaml:"backoff"`
+	// TTL is how long a message may wait before the worker dead-letters it
+	// unhandled as expired; 0 is forever.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxDepth is the queue length at which the api refuses enqueues; 0 is
+	// unbounded.
+	MaxDepth int64 `yaml:"max_depth"`
+	// DLQ is the list failed messages go to instead of <queue>:dlq.
+	DLQ string `yaml:"dlq"`
+	// Priorities are levels producers can enqueue at, highest first, all
+	// of them above the queue's normal messages.
+	Priorities []string `yaml:"priorities"`
+	// QuietHours are cron expressions for the minutes during which workers
+	// don't take messages from the queue, in the worker's time zone.
+	QuietHours []string `yaml:"quiet_hours"`
+
+	quiet []cron.Schedule
+}
+
+// DefaultBackoff is the first retry's wait when Backoff isn't set.
+const DefaultBackoff = time.Second
+
+// RetryDelay is the wait before handling a message again after its
+// attempt'th attempt failed.
+func (p Policy) RetryDelay(attempt int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = DefaultBackoff
+	}
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	return min(d, time.Hour)
+}
+
+// HasPriority reports whether level is one of p's priority levels.
+func (p Policy) HasPriority(level string) bool {
+	for _, l := range p.Priorities {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// QuietAt returns the quiet hours expression t falls in, if any.
+func (p Policy) QuietAt(t time.Time) (string, bool) {
+	for _, s := range p.quiet {
+		if s.Matches(t) {
+			return s.String(), true
+		}
+	}
+	return "", false
+}
+
+// over returns p with the non-zero fields of o laid over it.
+func (p Policy) over(o Policy) Policy {
+	if o.MaxAttempts != 0 {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.Backoff != 0 {
+		p.Backoff = o.Backoff
+	}
+	if o.TTL != 0 {
+		p.TTL = o.TTL
+	}
+	if o.MaxDepth != 0 {
+		p.MaxDepth = o.MaxDepth
+	}
+	if o.DLQ != "" {
+		p.DLQ = o.DLQ
+	}
+	if o.Priorities != nil {
+		p.Priorities = o.Priorities
+	}
+	if o.QuietHours != nil {
+		p.QuietHours, p.quiet = o.QuietHours, o.quiet
+	}
+	return p
+}
+
+// compile validates p and parses its quiet hours.
+func (p Policy) compile() (Policy, error) {
+	switch {
+	case p.MaxAttempts < 0:
+		return p, fmt.Errorf("max_attempts %d is negative", p.MaxAttempts)
+	case p.Backoff < 0:
+		return p, fmt.Errorf("backoff %s is negative", p.Backoff)
+	case p.TTL < 0:
+		return p, fmt.Errorf("ttl %s is negative", p.TTL)
+	case p.MaxDepth < 0:
+		return p, fmt.Errorf("max_depth %d is negative", p.MaxDepth)
+	}
+	seen := map[string]bool{}
+	for _, l := range p.Priorities {
+		if l == "" || strings.ContainsAny(l, ": \t") {
+			return p, fmt.Errorf("priority %q must be a non-empty name without spaces or colons", l)
+		}
+		if seen[l] {
+			return p, fmt.Errorf("priority %q is listed twice", l)
+		}
+		seen[l] = true
+	}
+	p.quiet = nil
+	for _, expr := range p.QuietHours {
+		s, err := cron.Parse(expr)
+		if err != nil {
+			return p, fmt.Errorf("quiet_hours: %w", err)
+		}
+		p.quiet = append(p.quiet, s)
+	}
+	return p, nil
+}
+
+// Document is a policies file.
+type Document struct {
+	Default Policy            `yaml:"default"`
+	Queues  map[string]Policy `yaml:"queues"`
+}
+
+// Parse reads and validates a policies document. Unknown fields are errors,
+// so a typo doesn't silently leave a queue unbounded.
+func Parse(b []byte) (Document, error) {
+	var d Document
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&d); err != nil && !errors.Is(err, io.EOF) {
+		return Document{}, err
+	}
+	var err error
+	if d.Default, err = d.Default.compile(); err != nil {
+		return Document{}, fmt.Errorf("default: %w", err)
+	}
+	for name, p := range d.Queues {
+		if d.Queues[name], err = p.compile(); err != nil {
+			return Document{}, fmt.Errorf("queue %s: %w", name, err)
+		}
+	}
+	return d, nil
+}
+
+// Store holds the policies in effect. A nil *Store has no policies: every
+// queue gets the zero Policy.
+type Store struct {
+	path string
+	doc  atomic.Pointer[Document]
+
+	mu   sync.Mutex
+	last []byte
+}
+
+// Load reads the policies file at path.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// For returns queue's policy.
+func (s *Store) For(queue string) Policy {
+	if s == nil {
+		return Policy{}
+	}
+	d := s.doc.Load()
+	return d.Default.over(d.Queues[queue])
+}
+
+// Reload reads the file again and reports whether the policies changed. An
+// invalid file is an error, once, and leaves the policies in effect as they
+// were.
+func (s *Store) Reload() (bool, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last != nil && bytes.Equal(b, s.last) {
+		return false, nil
+	}
+	s.last = b
+	d, err := Parse(b)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.doc.Store(&d)
+	return true, nil
+}
+
+// Run reloads the file every interval until ctx is canceled, calling
+// onReload after each change. Reading the file rather than watching it
+// picks up ConfigMap updates, which swap a symlink.
+func (s *Store) Run(ctx context.Context, interval time.Duration, onReload func(), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.Reload()
+		if err != nil {
+			onError(err)
+			continue
+		}
+		if changed {
+			onReload()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const doc = `
+default:
+  max_attempts: 3
+  backoff: 2s
+queues:
+  messages:
+    ttl: 1h
+    max_depth: 100
+    dlq: messages:failed
+    priorities: [critical, high]
+  once:
+    max_attempts: 1
+`
+
+func TestFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Policy{MaxAttempts: 3, Backoff: 2 * time.Second, TTL: time.Hour, MaxDepth: 100, DLQ: "messages:failed", Priorities: []string{"critical", "high"}}
+	if got := s.For("messages"); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages: %+v, want %+v", got, want)
+	}
+	if got := s.For("once"); got.MaxAttempts != 1 || got.Backoff != 2*time.Second {
+		t.Errorf("once: %+v, want the default backoff and 1 attempt", got)
+	}
+	if got := s.For("other"); got.MaxAttempts != 3 || got.TTL != 0 {
+		t.Errorf("other: %+v, want the default", got)
+	}
+
+	var none *Store
+	if got := none.For("messages"); !reflect.DeepEqual(got, Policy{}) {
+		t.Errorf("nil store: %+v", got)
+	}
+}
+
+func TestReloadKeepsPoliciesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); changed || err != nil {
+		t.Errorf("unchanged file: changed=%t err=%v", changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte("default:\n  max_attempt: 5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reload(); err == nil || !strings.Contains(err.Error(), "max_attempt") {
+		t.Errorf("typo'd field: %v, want an error naming it", err)
+	}
+	if _, err := s.Reload(); err != nil {
+		t.Errorf("same invalid file reported again: %v", err)
+	}
+	if got := s.For("messages").MaxDepth; got != 100 {
+		t.Errorf("max_depth %d after a bad reload, want the previous 100", got)
+	}
+
+	if err := os.WriteFile(path, []byte("queues:\n  messages:\n    max_depth: 5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Reload(); !changed || err != nil {
+		t.Fatalf("fixed file: changed=%t err=%v", changed, err)
+	}
+	if got := s.For("messages"); got.MaxDepth != 5 || got.MaxAttempts != 0 {
+		t.Errorf("after reload %+v, want only max_depth 5", got)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, bad := range []string{
+		"default:\n  max_attempts: -1\n",
+		"queues:\n  q:\n    priorities: [high, high]\n",
+		"queues:\n  q:\n    priorities: [\"a:b\"]\n",
+		"queues:\n  q:\n    ttl: forever\n",
+		"queues:\n  q:\n    quiet_hours: [\"* 25 * * *\"]\n",
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestQuietAt(t *testing.T) {
+	d, err := Parse([]byte("default:\n  quiet_hours: [\"* 22-23,0-5 * * *\"]\nqueues:\n  reports:\n    quiet_hours: [\"* 9-16 * * 1-5\"]\n  always:\n    quiet_hours: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-03-02 is a Monday.
+	night := time.Date(2026, time.March, 2, 23, 0, 0, 0, time.UTC)
+	office := time.Date(2026, time.March, 2, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		queue string
+		t     time.Time
+		want  string
+	}{
+		{"messages", night, "* 22-23,0-5 * * *"},
+		{"messages", office, ""},
+		{"reports", night, ""},
+		{"reports", office, "* 9-16 * * 1-5"},
+		{"always", night, ""},
+	} {
+		p := d.Default.over(d.Queues[tc.queue])
+		if got, _ := p.QuietAt(tc.t); got != tc.want {
+			t.Errorf("%s at %s: %q, want %q", tc.queue, tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := Policy{Backoff: 500 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{1: 500 * time.Millisecond, 2: time.Second, 4: 4 * time.Second, 40: time.Hour} {
+		if got := p.RetryDelay(attempt); got != want {
+			t.Errorf("attempt %d: %s, want %s", attempt, got, want)
+		}
+	}
+	if got := (Policy{}).RetryDelay(1); got != DefaultBackoff {
+		t.Errorf("no backoff: %s, want %s", got, DefaultBackoff)
+	}
+}
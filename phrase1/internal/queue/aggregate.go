@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AggregateBucket is how much time one aggregation hash covers. Rolling
+// counts over a window add up the buckets in it.
+const AggregateBucket = time.Minute
+
+// aggregateMessages is the hash field counting messages in a bucket; the
+// word counter never yields a word with a #.
+const aggregateMessages = "#messages"
+
+// aggregateKey is the hash of word counts for the bucket holding at.
+func (q *RedisQueue) aggregateKey(at time.Time) string {
+	return q.name + ":agg:" + strconv.FormatInt(at.Truncate(AggregateBucket).Unix(), 10)
+}
+
+// aggregateSeenKey marks a message as counted, so a redelivery isn't.
+func (q *RedisQueue) aggregateSeenKey(id string) string {
+	return q.name + ":agg:seen:" + id
+}
+
+// countScript adds the word counts in ARGV[3:] (word, count pairs) to the
+// bucket KEYS[2], unless the message was counted already: KEYS[1] is set for
+// ARGV[1] ms the first time. The bucket expires ARGV[2] s after its last
+// update.
+var countScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], "1", "NX", "PX", ARGV[1]) then
+	return 0
+end
+redis.call("HINCRBY", KEYS[2], "` + aggregateMessages + `", 1)
+for i = 3, #ARGV, 2 do
+	redis.call("HINCRBY", KEYS[2], ARGV[i], ARGV[i + 1])
+end
+redis.call("EXPIRE", KEYS[2], ARGV[2])
+return 1
+`)
+
+// CountWords adds message id's word counts to the bucket for at, keeping
+// the bucket for retention. A message already counted within retention
+// isn't counted again; CountWords reports whether this call counted it.
+func (q *RedisQueue) CountWords(ctx context.Context, id string, counts map[string]int64, at time.Time, retention time.Duration) (bool, error) {
+	args := []any{retention.Milliseconds(), int64((retention + AggregateBucket).Seconds())}
+	for w, n := range counts {
+		args = append(args, w, n)
+	}
+	return countScript.Run(ctx, q.client, []string{q.aggregateSeenKey(id), q.aggregateKey(at)}, args...).Bool()
+}
+
+// WordCount is how often a word occurred.
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int64  `json:"count"`
+}
+
+// Aggregation is the word counts over a window of recent buckets.
+type Aggregation struct {
+	Queue string `json:"queue"`
+	// Since is the start of the oldest bucket in the window.
+	Since time.Time `json:"since"`
+	// Messages is how many messages were counted, Words how many words
+	// they had between them, and Distinct how many different ones.
+	Messages int64 `json:"messages"`
+	Words    int64 `json:"words"`
+	Distinct int   `json:"distinct"`
+	// Top is the most frequent words, most frequent first.
+	Top []WordCount `json:"top"`
+}
+
+// Aggregations adds up the buckets for the window ending at now and returns
+// the top limit words, or only those in words if any are given.
+func (q *RedisQueue) Aggregations(ctx context.Context, window time.Duration, limit int, words []string, now time.Time) (Aggregation, error) {
+	end := now.Truncate(AggregateBucket)
+	since := end.Add(-window + AggregateBucket)
+	if since.After(end) {
+		since = end
+	}
+	var cmds []*redis.MapStringStringCmd
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for t := since; !t.After(end); t = t.Add(AggregateBucket) {
+			cmds = append(cmds, p.HGetAll(ctx, q.aggregateKey(t)))
+		}
+		return nil
+	})
+	if err != nil {
+		return Aggregation{}, err
+	}
+	agg := Aggregation{Queue: q.name, Since: since.UTC(), Top: []WordCount{}}
+	totals := map[string]int64{}
+	for _, cmd := range cmds {
+		for w, v := range cmd.Val() {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			if w == aggregateMessages {
+				agg.Messages += n
+				continue
+			}
+			totals[w] += n
+			agg.Words += n
+		}
+	}
+	agg.Distinct = len(totals)
+	if len(words) > 0 {
+		for _, w := range words {
+			agg.Top = append(agg.Top, WordCount{Word: strings.ToLower(w), Count: totals[strings.ToLower(w)]})
+		}
+	} else {
+		for w, n := range totals {
+			agg.Top = append(agg.Top, WordCount{Word: w, Count: n})
+		}
+	}
+	slices.SortFunc(agg.Top, func(a, b WordCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Word, b.Word)
+	})
+	if limit > 0 && len(agg.Top) > limit {
+		agg.Top = agg.Top[:limit]
+	}
+	return agg, nil
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ArchivedMessage is a processed message as kept in the queue's archive
+// stream.
+type ArchivedMessage struct {
+	// StreamID is the entry's id in the stream, "<ms>-<seq>", usable as a
+	// cursor.
+	StreamID    string    `json:"stream_id"`
+	ID          string    `json:"id"`
+	Queue       string    `json:"queue"`
+	Message     string    `json:"message"`
+	Source      string    `json:"source,omitempty"`
+	Worker      string    `json:"worker,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// ArchiveKey is the stream processed messages are archived to.
+func (q *RedisQueue) ArchiveKey() string {
+	return "processed:" + q.name
+}
+
+// SetArchive makes Archive keep about maxLen processed messages in the
+// archive stream, trimming the oldest; 0, the default, archives nothing.
+func (q *RedisQueue) SetArchive(maxLen int64) {
+	q.archiveMaxLen = maxLen
+}
+
+// Archive appends m to the archive stream, if the queue has one.
+func (q *RedisQueue) Archive(ctx context.Context, m ArchivedMessage) error {
+	if q.archiveMaxLen <= 0 {
+		return nil
+	}
+	return q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.ArchiveKey(),
+		MaxLen: q.archiveMaxLen,
+		Approx: true,
+		Values: map[string]any{
+			"id":           m.ID,
+			"message":      m.Message,
+			"source":       m.Source,
+			"worker":       m.Worker,
+			"duration_ms":  m.DurationMS,
+			"processed_at": m.ProcessedAt.UTC().Format(time.RFC3339Nano),
+		},
+	}).Err()
+}
+
+// ArchiveQuery selects archived messages. Stream ids are timestamps, so a
+// time works as a bound too (see ArchiveCursor).
+type ArchiveQuery struct {
+	// After and Before are exclusive stream id bounds; empty means
+	// unbounded.
+	After, Before string
+	// Limit caps the messages returned; it defaults to 100.
+	Limit int64
+	// Oldest returns the earliest messages in the range first instead of
+	// the latest.
+	Oldest bool
+}
+
+// ArchiveCursor is the stream id bound for t, for an ArchiveQuery.
+func ArchiveCursor(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Archived returns the archived messages aq selects, newest first unless
+// aq.Oldest is set.
+func (q *RedisQueue) Archived(ctx context.Context, aq ArchiveQuery) ([]ArchivedMessage, error) {
+	if aq.Limit <= 0 {
+		aq.Limit = 100
+	}
+	lo, hi := "-", "+"
+	if aq.After != "" {
+		lo = "(" + aq.After
+	}
+	if aq.Before != "" {
+		hi = "(" + aq.Before
+	}
+	var entries []redis.XMessage
+	var err error
+	if aq.Oldest {
+		entries, err = q.client.XRangeN(ctx, q.ArchiveKey(), lo, hi, aq.Limit).Result()
+	} else {
+		entries, err = q.client.XRevRangeN(ctx, q.ArchiveKey(), hi, lo, aq.Limit).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ArchivedMessage, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, q.archived(e))
+	}
+	return out, nil
+}
+
+func (q *RedisQueue) archived(e redis.XMessage) ArchivedMessage {
+	str := func(k string) string {
+		s, _ := e.Values[k].(string)
+		return s
+	}
+	m := ArchivedMessage{
+		StreamID: e.ID,
+		ID:       str("id"),
+		Queue:    q.name,
+		Message:  str("message"),
+		Source:   str("source"),
+		Worker:   str("worker"),
+	}
+	m.DurationMS, _ = strconv.ParseInt(str("duration_ms"), 10, 64)
+	m.ProcessedAt, _ = time.Parse(time.RFC3339Nano, str("processed_at"))
+	return m
+}
+
+// TrimArchive drops archived messages processed before cutoff and returns how
+// many went and roughly how much memory that freed. Redis trims whole stream
+// nodes only, so a few older entries can outlive cutoff until the next trim.
+func (q *RedisQueue) TrimArchive(ctx context.Context, cutoff time.Time) (trimmed, freed int64, err error) {
+	before, err := q.archiveMemory(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	trimmed, err = q.client.XTrimMinIDApprox(ctx, q.ArchiveKey(), ArchiveCursor(cutoff), 0).Result()
+	if err != nil || trimmed == 0 {
+		return trimmed, 0, err
+	}
+	after, err := q.archiveMemory(ctx)
+	if err != nil {
+		return trimmed, 0, err
+	}
+	return trimmed, max(before-after, 0), nil
+}
+
+// archiveMemory is the archive stream's memory footprint, 0 if it's missing.
+func (q *RedisQueue) archiveMemory(ctx context.Context) (int64, error) {
+	n, err := q.client.MemoryUsage(ctx, q.ArchiveKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// ArchiveReader reads the archive stream as a member of a consumer group, so
+// a consumer such as a search indexer gets every archived message at least
+// once however many replicas it runs, and picks up where it left off.
+type ArchiveReader struct {
+	q        *RedisQueue
+	group    string
+	consumer string
+	// claimed is set once the consumer's own pending entries, left by a
+	// previous run, have been read again.
+	claimed bool
+}
+
+// ArchiveReader joins group as consumer, creating the group at the start of
+// the stream if it doesn't exist yet.
+func (q *RedisQueue) ArchiveReader(ctx context.Context, group, consumer string) (*ArchiveReader, error) {
+	err := q.client.XGroupCreateMkStream(ctx, q.ArchiveKey(), group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+	return &ArchiveReader{q: q, group: group, consumer: consumer}, nil
+}
+
+// Read returns up to count archived messages not yet acknowledged in the
+// group, waiting up to block for new ones. It first returns what this
+// consumer read before without acknowledging, then entries other consumers
+// left pending for longer than minIdle, and then new entries. An empty result
+// means nothing arrived in time.
+func (r *ArchiveReader) Read(ctx context.Context, count int64, block, minIdle time.Duration) ([]ArchivedMessage, error) {
+	if !r.claimed {
+		streams, err := r.q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group: r.group, Consumer: r.consumer, Streams: []string{r.q.ArchiveKey(), "0"}, Count: count,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if msgs := r.messages(streams); len(msgs) > 0 {
+			return msgs, nil
+		}
+		r.claimed = true
+	}
+	if minIdle > 0 {
+		entries, _, err := r.q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream: r.q.ArchiveKey(), Group: r.group, Consumer: r.consumer, MinIdle: minIdle, Start: "0", Count: count,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return r.messages([]redis.XStream{{Messages: entries}}), nil
+		}
+	}
+	streams, err := r.q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: r.group, Consumer: r.consumer, Streams: []string{r.q.ArchiveKey(), ">"}, Count: count, Block: block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.messages(streams), nil
+}
+
+// Ack marks archived messages, by stream id, as done for the group.
+func (r *ArchiveReader) Ack(ctx context.Context, streamIDs ...string) error {
+	if len(streamIDs) == 0 {
+		return nil
+	}
+	return r.q.client.XAck(ctx, r.q.ArchiveKey(), r.group, streamIDs...).Err()
+}
+
+func (r *ArchiveReader) messages(streams []redis.XStream) []ArchivedMessage {
+	var out []ArchivedMessage
+	for _, s := range streams {
+		for _, e := range s.Messages {
+			out = append(out, r.q.archived(e))
+		}
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BatchOptions configures a Batcher.
+type BatchOptions struct {
+	// MaxSize is the most enqueues sent in one pipeline.
+	MaxSize int
+	// Window is how long the first enqueue of a batch waits for others to
+	// join it. With 0 a batch is whatever arrived while the previous one was
+	// in flight, which adds no latency when the api is idle.
+	Window time.Duration
+	// Timeout bounds each pipeline round trip.
+	Timeout time.Duration
+	// OnBatch, if set, is called with the number of enqueues in each
+	// pipeline sent.
+	OnBatch func(n int)
+}
+
+type batchItem struct {
+	queue       *RedisQueue
+	payload     string
+	replication Replication
+	done        chan error
+}
+
+// Batcher merges concurrent Enqueue calls on the queues attached to it into
+// one MULTI/EXEC pipeline per batch, so a busy api makes one Redis round trip
+// for many messages instead of one each. Every message in a batch succeeds or
+// fails together, and each caller gets that result. A batch with enqueues to
+// be replicated (see SetReplication) waits once, for the most replicas any of
+// them asks for.
+type Batcher struct {
+	client *redis.Client
+	opts   BatchOptions
+	// items is unbuffered, so an enqueue is either taken by Run or, once
+	// Run has returned, sent directly; none is left behind.
+	items chan batchItem
+	// stopped is closed when Run returns; enqueues after that go straight
+	// to Redis.
+	stopped chan struct{}
+}
+
+func NewBatcher(client *redis.Client, opts BatchOptions) *Batcher {
+	if opts.MaxSize < 1 {
+		opts.MaxSize = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return &Batcher{
+		client:  client,
+		opts:    opts,
+		items:   make(chan batchItem),
+		stopped: make(chan struct{}),
+	}
+}
+
+// SetBatcher routes the queue's Enqueue calls through b. b must use the same
+// Redis client as the queue.
+func (q *RedisQueue) SetBatcher(b *Batcher) {
+	q.batcher = b
+}
+
+// enqueue hands payload to the batch loop and waits for its batch to be
+// written. Once accepted the message is written even if ctx ends first, so
+// the caller always learns whether it was enqueued.
+func (b *Batcher) enqueue(ctx context.Context, q *RedisQueue, payload string) error {
+	done := make(chan error, 1)
+	r, _ := q.replicationFor(ctx)
+	select {
+	case b.items <- batchItem{queue: q, payload: payload, replication: r, done: done}:
+	case <-b.stopped:
+		return q.enqueueDirect(ctx, payload)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-done
+}
+
+// Run collects enqueues into batches and writes them until ctx is canceled.
+// A batch already collected is still written; enqueues after that bypass the
+// batcher. Stop it only after the handlers that enqueue have drained.
+func (b *Batcher) Run(ctx context.Context) {
+	defer close(b.stopped)
+	batch := make([]batchItem, 0, b.opts.MaxSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case it := <-b.items:
+			batch = append(batch[:0], it)
+		}
+		batch = b.collect(ctx, batch)
+		b.flush(ctx, batch)
+	}
+}
+
+// collect adds to batch until it's full or the window has passed.
+func (b *Batcher) collect(ctx context.Context, batch []batchItem) []batchItem {
+	var window <-chan time.Time
+	if b.opts.Window > 0 {
+		t := time.NewTimer(b.opts.Window)
+		defer t.Stop()
+		window = t.C
+	}
+	for len(batch) < b.opts.MaxSize {
+		if window == nil {
+			select {
+			case it := <-b.items:
+				batch = append(batch, it)
+				continue
+			default:
+				return batch
+			}
+		}
+		select {
+		case it := <-b.items:
+			batch = append(batch, it)
+		case <-window:
+			return batch
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+func (b *Batcher) flush(ctx context.Context, batch []batchItem) {
+	var wait Replication
+	for _, it := range batch {
+		wait.Replicas = max(wait.Replicas, it.replication.Replicas)
+		if it.replication.Replicas > 0 {
+			wait.Timeout = max(wait.Timeout, it.replication.timeout())
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.opts.Timeout+wait.Timeout)
+	defer cancel()
+	counts := map[string]int64{}
+	write := func(p redis.Pipeliner) error {
+		for _, it := range batch {
+			p.LPush(ctx, it.queue.name, it.payload)
+			counts[it.queue.statsKey()]++
+		}
+		for key, n := range counts {
+			p.HIncrBy(ctx, key, statEnqueued, n)
+		}
+		return nil
+	}
+	if b.opts.OnBatch != nil {
+		defer b.opts.OnBatch(len(batch))
+	}
+	if wait.Replicas == 0 {
+		_, err := b.client.TxPipelined(ctx, write)
+		for _, it := range batch {
+			it.done <- err
+		}
+		return
+	}
+
+	// WAIT has to follow the write on the same connection.
+	conn := b.client.Conn()
+	defer conn.Close()
+	if _, err := conn.TxPipelined(ctx, write); err != nil {
+		for _, it := range batch {
+			it.done <- err
+		}
+		return
+	}
+	acked, err := conn.Wait(ctx, wait.Replicas, wait.Timeout).Result()
+	for _, it := range batch {
+		if it.replication.Replicas == 0 {
+			it.done <- nil
+			continue
+		}
+		it.done <- it.replication.check(acked, err)
+	}
+}
@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// The benchmarks need a Redis to talk to: BENCH_REDIS_ADDR, default
+// localhost:6379. They skip if it isn't reachable, and only touch keys under
+// bench:. Numbers include the network round trip, so compare runs against the
+// same Redis.
+//
+//	make bench
+//	go test -run '^$' -bench . -benchmem ./internal/queue/
+
+func benchQueue(b *testing.B) *RedisQueue {
+	b.Helper()
+	addr := os.Getenv("BENCH_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		_ = rdb.Close()
+		b.Skipf("no redis at %s: %v", addr, err)
+	}
+	q := NewRedisQueue(rdb, "bench:"+strings.ReplaceAll(b.Name(), "/", ":"))
+	clear := func() {
+		keys := []string{q.name + ":stream", q.name + ":processing"}
+		for key := range q.KeyTypes() {
+			keys = append(keys, key)
+		}
+		_ = rdb.Del(context.Background(), keys...).Err()
+	}
+	clear()
+	b.Cleanup(func() {
+		clear()
+		_ = rdb.Close()
+	})
+	return q
+}
+
+func benchPayload(b *testing.B) string {
+	b.Helper()
+	raw, err := envelope.New(strings.Repeat("x", 256)).Encode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return raw
+}
+
+// BenchmarkEnqueue compares one round trip per message (what /enqueue does)
+// with sending the same LPUSH and counter update for several messages in one
+// pipeline. ns/op is per message in both.
+func BenchmarkEnqueue(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("single", func(b *testing.B) {
+		q := benchQueue(b)
+		raw := benchPayload(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := q.Enqueue(ctx, raw); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, size := range []int{10, 100} {
+		b.Run(fmt.Sprintf("pipelined-%d", size), func(b *testing.B) {
+			q := benchQueue(b)
+			raw := benchPayload(b)
+			b.ResetTimer()
+			for done := 0; done < b.N; done += size {
+				n := min(size, b.N-done)
+				_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+					for j := 0; j < n; j++ {
+						p.LPush(ctx, q.name, raw)
+					}
+					p.HIncrBy(ctx, q.statsKey(), statEnqueued, int64(n))
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEnqueueParallel has many goroutines enqueue at once, as concurrent
+// requests to the api do, with and without a Batcher merging them into
+// pipelines.
+func BenchmarkEnqueueParallel(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range []int{0, 100} {
+		name := "direct"
+		if size > 0 {
+			name = fmt.Sprintf("batched-%d", size)
+		}
+		b.Run(name, func(b *testing.B) {
+			q := benchQueue(b)
+			raw := benchPayload(b)
+			if size > 0 {
+				batches, batched := 0, 0
+				batcher := NewBatcher(q.client, BatchOptions{MaxSize: size, OnBatch: func(n int) {
+					batches++
+					batched += n
+				}})
+				runCtx, stop := context.WithCancel(ctx)
+				done := make(chan struct{})
+				go func() {
+					batcher.Run(runCtx)
+					close(done)
+				}()
+				defer func() {
+					stop()
+					<-done
+					if batches > 0 {
+						b.ReportMetric(float64(batched)/float64(batches), "msgs/batch")
+					}
+				}()
+				q.SetBatcher(batcher)
+			}
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := q.Enqueue(ctx, raw); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// fill pushes n copies of raw onto key, outside the benchmark timer.
+func fill(b *testing.B, q *RedisQueue, key, raw string, n int) {
+	b.Helper()
+	b.StopTimer()
+	defer b.StartTimer()
+	ctx := context.Background()
+	for done := 0; done < n; done += 1000 {
+		_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for j := 0; j < min(1000, n-done); j++ {
+				p.LPush(ctx, key, raw)
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDequeue compares the ways a worker could take messages off a
+// prefilled queue: BRPOP as the worker does today (at most once), BLMOVE onto
+// a processing list plus the LREM that acknowledges it (at least once), and a
+// Streams consumer group with XREADGROUP and XACK.
+func BenchmarkDequeue(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("brpop", func(b *testing.B) {
+		q := benchQueue(b)
+		fill(b, q, q.name, benchPayload(b), b.N)
+		for i := 0; i < b.N; i++ {
+			if _, err := q.DequeueWithin(ctx, time.Second); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("blmove", func(b *testing.B) {
+		q := benchQueue(b)
+		processing := q.name + ":processing"
+		fill(b, q, q.name, benchPayload(b), b.N)
+		for i := 0; i < b.N; i++ {
+			raw, err := q.client.BLMove(ctx, q.name, processing, "RIGHT", "LEFT", time.Second).Result()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := q.client.LRem(ctx, processing, 1, raw).Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("streams", func(b *testing.B) {
+		q := benchQueue(b)
+		stream := q.name + ":stream"
+		raw := benchPayload(b)
+		b.StopTimer()
+		if err := q.client.XGroupCreateMkStream(ctx, stream, "workers", "0").Err(); err != nil {
+			b.Fatal(err)
+		}
+		for done := 0; done < b.N; done += 1000 {
+			_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+				for j := 0; j < min(1000, b.N-done); j++ {
+					p.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: []string{"m", raw}})
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		for i := 0; i < b.N; i++ {
+			res, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group: "workers", Consumer: "bench", Streams: []string{stream, ">"}, Count: 1, Block: time.Second,
+			}).Result()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := q.client.XAck(ctx, stream, "workers", res[0].Messages[0].ID).Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+func (q *RedisQueue) cancelKey(id string) string {
+	return q.name + ":cancel:" + id
+}
+
+// Cancel asks workers to drop message id, for ttl: one that takes it
+// afterwards drops it unhandled, and one handling it cancels its handler's
+// context.
+func (q *RedisQueue) Cancel(ctx context.Context, id string, ttl time.Duration) error {
+	return q.client.Set(ctx, q.cancelKey(id), time.Now().UTC().Format(time.RFC3339), ttl).Err()
+}
+
+// Cancelled reports whether message id has been cancelled.
+func (q *RedisQueue) Cancelled(ctx context.Context, id string) (bool, error) {
+	n, err := q.client.Exists(ctx, q.cancelKey(id)).Result()
+	return n > 0, err
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func (q *RedisQueue) pausedKey() string {
+	return q.name + ":paused"
+}
+
+// Pause asks workers to stop taking messages until Resume. Enqueues still
+// succeed, so the backlog grows while paused.
+func (q *RedisQueue) Pause(ctx context.Context) error {
+	return q.client.Set(ctx, q.pausedKey(), "1", 0).Err()
+}
+
+func (q *RedisQueue) Resume(ctx context.Context) error {
+	return q.client.Del(ctx, q.pausedKey()).Err()
+}
+
+func (q *RedisQueue) Paused(ctx context.Context) (bool, error) {
+	n, err := q.client.Exists(ctx, q.pausedKey()).Result()
+	return n > 0, err
+}
+
+// Purge drops every waiting message, and the dead-letter queue too if dlq is
+// set, returning how many were removed. Counters in Stats are kept.
+func (q *RedisQueue) Purge(ctx context.Context, dlq bool) (int64, error) {
+	keys := q.dequeueKeys()
+	if dlq {
+		keys = append(keys, q.DLQName())
+	}
+	lens := make([]*redis.IntCmd, len(keys))
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			lens[i] = p.LLen(ctx, k)
+		}
+		p.Del(ctx, keys...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, l := range lens {
+		n += l.Val()
+	}
+	return n, nil
+}
+
+// KeyTypes maps each Redis key the queue uses to its Redis type, for
+// checking that none was overwritten with something else.
+func (q *RedisQueue) KeyTypes() map[string]string {
+	types := map[string]string{
+		q.name:         "list",
+		q.DLQName():    "list",
+		q.delayedKey(): "zset",
+		q.statsKey():   "hash",
+		q.recentKey():  "list",
+		q.slowKey():    "list",
+		q.workersKey(): "set",
+		q.pausedKey():  "string",
+	}
+	for _, k := range q.dequeueKeys() {
+		types[k] = "list"
+	}
+	return types
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// delayedKey is the sorted set holding messages not yet due, scored by the
+// Unix time in milliseconds they're due at.
+func (q *RedisQueue) delayedKey() string {
+	return q.name + ":delayed"
+}
+
+// EnqueueAt enqueues payload to be handed out no earlier than at. It waits
+// in the delayed set until a mover promotes it with PromoteDue, so it counts
+// as enqueued right away but isn't in Depth until then. It doesn't go
+// through a Batcher.
+func (q *RedisQueue) EnqueueAt(ctx context.Context, payload string, at time.Time) error {
+	return q.txPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZAdd(ctx, q.delayedKey(), redis.Z{Score: float64(at.UnixMilli()), Member: payload})
+		p.HIncrBy(ctx, q.statsKey(), statEnqueued, 1)
+		return nil
+	})
+}
+
+// promoteScript moves up to ARGV[2] messages due by ARGV[1] from the delayed
+// set to the list, earliest first, so they're dequeued in due order after
+// what's already waiting.
+var promoteScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, m in ipairs(due) do
+	redis.call("LPUSH", KEYS[2], m)
+end
+if #due > 0 then
+	redis.call("ZREM", KEYS[1], unpack(due))
+end
+return #due
+`)
+
+// PromoteDue moves up to limit messages due by now onto the queue and
+// returns how many it moved. It's atomic, so concurrent movers can't promote
+// a message twice, but one mover at a time is all it takes.
+func (q *RedisQueue) PromoteDue(ctx context.Context, now time.Time, limit int) (int, error) {
+	return promoteScript.Run(ctx, q.client, []string{q.delayedKey(), q.name}, now.UnixMilli(), limit).Int()
+}
+
+// Delayed is the number of messages in the delayed set and how overdue the
+// earliest of them is at now, or 0 if none is due yet.
+func (q *RedisQueue) Delayed(ctx context.Context, now time.Time) (int64, time.Duration, error) {
+	var count *redis.IntCmd
+	var first *redis.ZSliceCmd
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		count = p.ZCard(ctx, q.delayedKey())
+		first = p.ZRangeWithScores(ctx, q.delayedKey(), 0, 0)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var lag time.Duration
+	if z := first.Val(); len(z) == 1 {
+		if due := time.UnixMilli(int64(z[0].Score)); now.After(due) {
+			lag = now.Sub(due)
+		}
+	}
+	return count.Val(), lag, nil
+}
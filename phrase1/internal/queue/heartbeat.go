@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Heartbeat is what a worker periodically reports about itself.
+type Heartbeat struct {
+	ID string `json:"id"`
+	// Pod, Namespace, and Node come from the downward API when running in
+	// Kubernetes.
+	Pod       string    `json:"pod,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Node      string    `json:"node,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Processed int64     `json:"processed"`
+	// Current is the id of the message the worker is handling, if any.
+	Current string `json:"current,omitempty"`
+	// EnvelopeVersion is the newest envelope version the worker reads, for
+	// producers to negotiate what they write; 0 from workers that predate it.
+	EnvelopeVersion int `json:"envelope_version,omitempty"`
+}
+
+func (q *RedisQueue) workersKey() string {
+	return q.name + ":workers"
+}
+
+func (q *RedisQueue) heartbeatKey(id string) string {
+	return q.name + ":worker:" + id
+}
+
+// Heartbeat records hb with a TTL; a worker that stops sending heartbeats
+// disappears from Workers once the TTL expires.
+func (q *RedisQueue) Heartbeat(ctx context.Context, hb Heartbeat, ttl time.Duration) error {
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, q.heartbeatKey(hb.ID), b, ttl)
+		p.SAdd(ctx, q.workersKey(), hb.ID)
+		return nil
+	})
+	return err
+}
+
+// RemoveHeartbeat deregisters a worker on clean shutdown.
+func (q *RedisQueue) RemoveHeartbeat(ctx context.Context, id string) error {
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, q.heartbeatKey(id))
+		p.SRem(ctx, q.workersKey(), id)
+		return nil
+	})
+	return err
+}
+
+// Workers returns the live workers, pruning registrations whose heartbeat
+// has expired.
+func (q *RedisQueue) Workers(ctx context.Context) ([]Heartbeat, error) {
+	ids, err := q.client.SMembers(ctx, q.workersKey()).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = q.heartbeatKey(id)
+	}
+	vals, err := q.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Heartbeat
+	var stale []any
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			stale = append(stale, ids[i])
+			continue
+		}
+		var hb Heartbeat
+		if err := json.Unmarshal([]byte(s), &hb); err != nil {
+			continue
+		}
+		out = append(out, hb)
+	}
+	if len(stale) > 0 {
+		_ = q.client.SRem(ctx, q.workersKey(), stale...).Err()
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// inFlightTTL bounds how long a message held by a worker that never came
+// back, and a message's strikes, are kept.
+const inFlightTTL = 24 * time.Hour
+
+func (q *RedisQueue) inFlightKey(worker string) string {
+	return q.name + ":inflight:" + worker
+}
+
+func (q *RedisQueue) strikesKey(id string) string {
+	return q.name + ":strikes:" + id
+}
+
+// Hold records raw as the message worker is handling, until Release. A
+// worker that crashes leaves it behind for Held to find when it restarts
+// under the same name.
+func (q *RedisQueue) Hold(ctx context.Context, worker, raw string) error {
+	return q.client.Set(ctx, q.inFlightKey(worker), raw, inFlightTTL).Err()
+}
+
+// Held returns the message worker held when it stopped without releasing
+// it, or "" if there's none.
+func (q *RedisQueue) Held(ctx context.Context, worker string) (string, error) {
+	raw, err := q.client.Get(ctx, q.inFlightKey(worker)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return raw, err
+}
+
+// Release drops worker's held message.
+func (q *RedisQueue) Release(ctx context.Context, worker string) error {
+	return q.client.Del(ctx, q.inFlightKey(worker)).Err()
+}
+
+// Strike counts one more crash or timeout against message id and returns
+// its strikes so far, by any worker.
+func (q *RedisQueue) Strike(ctx context.Context, id string) (int, error) {
+	var n *redis.IntCmd
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		n = p.Incr(ctx, q.strikesKey(id))
+		p.Expire(ctx, q.strikesKey(id), inFlightTTL)
+		return nil
+	})
+	return int(n.Val()), err
+}
+
+// Forget clears message id's strikes once it's been handled.
+func (q *RedisQueue) Forget(ctx context.Context, id string) error {
+	return q.client.Del(ctx, q.strikesKey(id)).Err()
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/policy"
+)
+
+// ApplyPolicy sets the parts of p the queue itself enforces: its DLQ name
+// and priority levels. It's safe to call while the queue is in use, as
+// policies reload.
+func (q *RedisQueue) ApplyPolicy(p policy.Policy) {
+	q.dlq.Store(&p.DLQ)
+	levels := append([]string(nil), p.Priorities...)
+	q.priorities.Store(&levels)
+}
+
+func (q *RedisQueue) priorityKey(level string) string {
+	return q.name + ":priority:" + level
+}
+
+// dequeueKeys are the lists Dequeue takes from, in order: one per priority
+// level, highest first, then the queue itself.
+func (q *RedisQueue) dequeueKeys() []string {
+	levels := q.priorities.Load()
+	if levels == nil || len(*levels) == 0 {
+		return []string{q.name}
+	}
+	keys := make([]string, 0, len(*levels)+1)
+	for _, l := range *levels {
+		keys = append(keys, q.priorityKey(l))
+	}
+	return append(keys, q.name)
+}
+
+// ErrUnknownPriority is returned by EnqueuePriority for a level the queue's
+// policy doesn't define.
+var ErrUnknownPriority = errors.New("queue: unknown priority")
+
+// EnqueuePriority enqueues payload at priority level, ahead of every message
+// at a lower level or at none. It doesn't go through a Batcher.
+func (q *RedisQueue) EnqueuePriority(ctx context.Context, payload, level string) error {
+	levels := q.priorities.Load()
+	if levels == nil || !slices.Contains(*levels, level) {
+		return fmt.Errorf("%w %q", ErrUnknownPriority, level)
+	}
+	return q.txPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, q.priorityKey(level), payload)
+		p.HIncrBy(ctx, q.statsKey(), statEnqueued, 1)
+		return nil
+	})
+}
+
+// RetryAt puts a message whose handling failed back in the delayed set, to
+// be handled again at at. Unlike EnqueueAt it isn't counted as enqueued.
+func (q *RedisQueue) RetryAt(ctx context.Context, payload string, at time.Time) error {
+	return q.client.ZAdd(ctx, q.delayedKey(), redis.Z{Score: float64(at.UnixMilli()), Member: payload}).Err()
+}
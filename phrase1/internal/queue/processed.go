@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ProcessedEvent records a message the worker finished.
+type ProcessedEvent struct {
+	Queue       string    `json:"queue"`
+	Message     string    `json:"message"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// EventsChannel is the pub/sub channel lifecycle events are published on.
+func (q *RedisQueue) EventsChannel() string {
+	return q.name + ":events"
+}
+
+// recentLimit is how many processed messages RecentProcessed can return.
+const recentLimit = 100
+
+func (q *RedisQueue) recentKey() string {
+	return q.name + ":recent"
+}
+
+// RecordProcessed counts a finished message and keeps it in the capped
+// recent list.
+func (q *RedisQueue) RecordProcessed(ctx context.Context, msg string, at time.Time) error {
+	b, err := json.Marshal(ProcessedEvent{Queue: q.name, Message: msg, ProcessedAt: at})
+	if err != nil {
+		return err
+	}
+	_, err = q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.HIncrBy(ctx, q.statsKey(), statProcessed, 1)
+		p.LPush(ctx, q.recentKey(), b)
+		p.LTrim(ctx, q.recentKey(), 0, recentLimit-1)
+		return nil
+	})
+	return err
+}
+
+// RecentProcessed returns up to n of the most recently processed messages,
+// newest first.
+func (q *RedisQueue) RecentProcessed(ctx context.Context, n int) ([]ProcessedEvent, error) {
+	if n <= 0 || n > recentLimit {
+		n = recentLimit
+	}
+	raw, err := q.client.LRange(ctx, q.recentKey(), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ProcessedEvent, 0, len(raw))
+	for _, r := range raw {
+		var e ProcessedEvent
+		if err := json.Unmarshal([]byte(r), &e); err == nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
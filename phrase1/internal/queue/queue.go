@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// Queue is one queue's message flow as the api handlers and the worker use
+// it. RedisQueue is the implementation; queuetest.Queue is an in-memory fake
+// for tests that shouldn't need Redis.
+type Queue interface {
+	Name() string
+	DLQName() string
+	Encode(e envelope.Envelope) (string, error)
+
+	Enqueue(ctx context.Context, payload string) error
+	Dequeue(ctx context.Context) (string, error)
+	DequeueWithin(ctx context.Context, wait time.Duration) (string, error)
+	Requeue(ctx context.Context, payload string) error
+	DeadLetter(ctx context.Context, payload string) error
+
+	Depth(ctx context.Context) (int64, error)
+	Stats(ctx context.Context) (Stats, error)
+	OldestAge(ctx context.Context) (time.Duration, error)
+
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Paused(ctx context.Context) (bool, error)
+
+	RecordProcessed(ctx context.Context, msg string, at time.Time) error
+	RecordSlow(ctx context.Context, m SlowMessage) error
+	Heartbeat(ctx context.Context, hb Heartbeat, ttl time.Duration) error
+	RemoveHeartbeat(ctx context.Context, id string) error
+}
+
+var _ Queue = (*RedisQueue)(nil)
@@ -0,0 +1,79 @@
+package queuetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+func payloads(envs []envelope.Envelope) []string {
+	out := make([]string, len(envs))
+	for i, e := range envs {
+		out[i] = e.Payload
+	}
+	return out
+}
+
+// AssertDepth fails t unless want messages are waiting.
+func AssertDepth(t testing.TB, q *Queue, want int) {
+	t.Helper()
+	if got := len(q.Messages()); got != want {
+		t.Errorf("queue %s: depth %d, want %d", q.Name(), got, want)
+	}
+}
+
+// AssertWaiting fails t unless the waiting payloads are exactly want, next
+// to be dequeued first.
+func AssertWaiting(t testing.TB, q *Queue, want ...string) {
+	t.Helper()
+	if got := payloads(q.Messages()); !slices.Equal(got, want) {
+		t.Errorf("queue %s: waiting %q, want %q", q.Name(), got, want)
+	}
+}
+
+// AssertDeadLettered fails t unless the dead-lettered payloads are exactly
+// want, in the order they were dead-lettered.
+func AssertDeadLettered(t testing.TB, q *Queue, want ...string) {
+	t.Helper()
+	if got := payloads(q.DeadLetters()); !slices.Equal(got, want) {
+		t.Errorf("queue %s: dead-lettered %q, want %q", q.Name(), got, want)
+	}
+}
+
+// AssertProcessed fails t unless the recorded processed messages are exactly
+// want, in the order they were processed.
+func AssertProcessed(t testing.TB, q *Queue, want ...string) {
+	t.Helper()
+	var got []string
+	for _, p := range q.Processed() {
+		got = append(got, p.Message)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("queue %s: processed %q, want %q", q.Name(), got, want)
+	}
+}
+
+// AssertPaused fails t unless the queue's pause state is want.
+func AssertPaused(t testing.TB, q *Queue, want bool) {
+	t.Helper()
+	q.mu.Lock()
+	got := q.paused
+	q.mu.Unlock()
+	if got != want {
+		t.Errorf("queue %s: paused %t, want %t", q.Name(), got, want)
+	}
+}
+
+// MustDequeue takes the next message, failing t now if none arrives within
+// a second.
+func MustDequeue(t testing.TB, q *Queue) envelope.Envelope {
+	t.Helper()
+	raw, err := q.DequeueWithin(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("queue %s: dequeue: %v", q.Name(), err)
+	}
+	return envelope.Decode(raw)
+}
@@ -0,0 +1,390 @@
+// Package queuetest provides an in-memory queue.Queue, a manually advanced
+// clock, and assertion helpers, so api handlers and the worker can be tested
+// without Redis.
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// Epoch is where a Clock created with a zero time starts.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a clock that only moves when told to.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock reading start, or Epoch if start is zero.
+func NewClock(start time.Time) *Clock {
+	if start.IsZero() {
+		start = Epoch
+	}
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+type worker struct {
+	hb      queue.Heartbeat
+	expires time.Time
+}
+
+// Queue is an in-memory queue.Queue. It behaves like RedisQueue as far as
+// callers can tell: FIFO order, Requeue to the head, counters in Stats, and
+// heartbeats that expire, with every time taken from its Clock. Dequeue and
+// DequeueWithin block in real time, so tests should give them a short wait or
+// a canceled context rather than advancing the clock.
+type Queue struct {
+	clock *Clock
+	name  string
+
+	mu        sync.Mutex
+	encoding  envelope.Encoding
+	err       error
+	items     []string // next to be dequeued first
+	dlq       []string // oldest first
+	paused    bool
+	stats     queue.Stats
+	processed []queue.ProcessedEvent // oldest first
+	slow      []queue.SlowMessage
+	workers   map[string]worker
+	ids       int
+	// arrived is closed and replaced whenever a message is pushed, waking
+	// blocked Dequeues.
+	arrived chan struct{}
+}
+
+var _ queue.Queue = (*Queue)(nil)
+
+// New returns an empty queue named name that reads time from clock, or from a
+// new clock at Epoch if clock is nil.
+func New(name string, clock *Clock) *Queue {
+	if clock == nil {
+		clock = NewClock(time.Time{})
+	}
+	return &Queue{
+		clock:    clock,
+		name:     name,
+		encoding: envelope.EncodingJSON,
+		stats:    queue.Stats{Queue: name},
+		workers:  map[string]worker{},
+		arrived:  make(chan struct{}),
+	}
+}
+
+// Clock is the clock the queue reads time from.
+func (q *Queue) Clock() *Clock {
+	return q.clock
+}
+
+// SetEncoding changes how Encode serializes envelopes, as on RedisQueue.
+func (q *Queue) SetEncoding(enc envelope.Encoding) {
+	q.mu.Lock()
+	q.encoding = enc
+	q.mu.Unlock()
+}
+
+// ErrUnavailable is a stand-in for a Redis outage to pass to SetError.
+var ErrUnavailable = errors.New("queuetest: unavailable")
+
+// SetError makes every operation that would reach Redis fail with err until
+// it's called again with nil, for testing outage handling.
+func (q *Queue) SetError(err error) {
+	q.mu.Lock()
+	q.err = err
+	q.mu.Unlock()
+}
+
+// NewEnvelope is envelope.New with a sequential ID and the clock's time,
+// so tests can predict both.
+func (q *Queue) NewEnvelope(payload string) envelope.Envelope {
+	q.mu.Lock()
+	q.ids++
+	n := q.ids
+	q.mu.Unlock()
+	e := envelope.New(payload)
+	e.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+	e.EnqueuedAt = q.clock.Now().UTC()
+	return e
+}
+
+func (q *Queue) Name() string {
+	return q.name
+}
+
+func (q *Queue) DLQName() string {
+	return q.name + ":dlq"
+}
+
+func (q *Queue) Encode(e envelope.Envelope) (string, error) {
+	q.mu.Lock()
+	enc := q.encoding
+	q.mu.Unlock()
+	return e.EncodeAs(enc)
+}
+
+// push adds raw at the tail, or at the head if head is set. The caller holds
+// q.mu.
+func (q *Queue) push(raw string, head bool) {
+	if head {
+		q.items = append([]string{raw}, q.items...)
+	} else {
+		q.items = append(q.items, raw)
+	}
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+}
+
+func (q *Queue) Enqueue(ctx context.Context, payload string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.push(payload, false)
+	q.stats.EnqueuedTotal++
+	return nil
+}
+
+func (q *Queue) Requeue(ctx context.Context, payload string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.push(payload, true)
+	return nil
+}
+
+func (q *Queue) DeadLetter(ctx context.Context, payload string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.dlq = append(q.dlq, payload)
+	q.stats.DeadLetteredTotal++
+	return nil
+}
+
+func (q *Queue) Dequeue(ctx context.Context) (string, error) {
+	return q.dequeue(ctx, nil)
+}
+
+func (q *Queue) DequeueWithin(ctx context.Context, wait time.Duration) (string, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	return q.dequeue(ctx, timer.C)
+}
+
+func (q *Queue) dequeue(ctx context.Context, timeout <-chan time.Time) (string, error) {
+	for {
+		q.mu.Lock()
+		if q.err != nil {
+			err := q.err
+			q.mu.Unlock()
+			return "", err
+		}
+		if len(q.items) > 0 {
+			raw := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return raw, nil
+		}
+		arrived := q.arrived
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", queue.ErrEmpty
+		case <-arrived:
+		}
+	}
+}
+
+func (q *Queue) Depth(ctx context.Context) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return 0, q.err
+	}
+	return int64(len(q.items)), nil
+}
+
+func (q *Queue) Stats(ctx context.Context) (queue.Stats, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return queue.Stats{}, q.err
+	}
+	s := q.stats
+	s.Depth = int64(len(q.items))
+	s.DLQDepth = int64(len(q.dlq))
+	return s, nil
+}
+
+func (q *Queue) OldestAge(ctx context.Context) (time.Duration, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return 0, q.err
+	}
+	if len(q.items) == 0 {
+		return 0, nil
+	}
+	e := envelope.Decode(q.items[0])
+	if e.EnqueuedAt.IsZero() {
+		return 0, nil
+	}
+	return q.clock.Now().Sub(e.EnqueuedAt), nil
+}
+
+func (q *Queue) Pause(ctx context.Context) error {
+	return q.setPaused(true)
+}
+
+func (q *Queue) Resume(ctx context.Context) error {
+	return q.setPaused(false)
+}
+
+func (q *Queue) setPaused(p bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.paused = p
+	return nil
+}
+
+func (q *Queue) Paused(ctx context.Context) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return false, q.err
+	}
+	return q.paused, nil
+}
+
+func (q *Queue) RecordProcessed(ctx context.Context, msg string, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.processed = append(q.processed, queue.ProcessedEvent{Queue: q.name, Message: msg, ProcessedAt: at})
+	q.stats.ProcessedTotal++
+	return nil
+}
+
+func (q *Queue) RecordSlow(ctx context.Context, m queue.SlowMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.slow = append(q.slow, m)
+	return nil
+}
+
+func (q *Queue) Heartbeat(ctx context.Context, hb queue.Heartbeat, ttl time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	q.workers[hb.ID] = worker{hb: hb, expires: q.clock.Now().Add(ttl)}
+	return nil
+}
+
+func (q *Queue) RemoveHeartbeat(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	delete(q.workers, id)
+	return nil
+}
+
+// Messages returns the waiting messages, next to be dequeued first.
+func (q *Queue) Messages() []envelope.Envelope {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return decodeAll(q.items)
+}
+
+// DeadLetters returns the dead-lettered messages in the order they were
+// dead-lettered.
+func (q *Queue) DeadLetters() []envelope.Envelope {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return decodeAll(q.dlq)
+}
+
+// Processed returns what RecordProcessed was called with, oldest first.
+func (q *Queue) Processed() []queue.ProcessedEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queue.ProcessedEvent(nil), q.processed...)
+}
+
+// Slow returns what RecordSlow was called with, oldest first.
+func (q *Queue) Slow() []queue.SlowMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]queue.SlowMessage(nil), q.slow...)
+}
+
+// Workers returns the heartbeats that haven't expired by the clock, by ID.
+func (q *Queue) Workers() []queue.Heartbeat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	out := make([]queue.Heartbeat, 0, len(q.workers))
+	for _, w := range q.workers {
+		if now.Before(w.expires) {
+			out = append(out, w.hb)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func decodeAll(raw []string) []envelope.Envelope {
+	out := make([]envelope.Envelope, len(raw))
+	for i, r := range raw {
+		out[i] = envelope.Decode(r)
+	}
+	return out
+}
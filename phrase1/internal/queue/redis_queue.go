@@ -3,22 +3,152 @@ package queue
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
 )
 
 type RedisQueue struct {
-	client *redis.Client
-	name   string
+	client   *redis.Client
+	name     string
+	encoding envelope.Encoding
+	version  *envelope.WriteVersion
+	format   Format
+	batcher  *Batcher
+	// replication is what enqueues wait for by default; see SetReplication.
+	replication Replication
+	// archiveMaxLen caps the archive stream; see SetArchive.
+	archiveMaxLen int64
+
+	// dlq and priorities come from the queue's policy and can change while
+	// the queue is in use.
+	dlq        atomic.Pointer[string]
+	priorities atomic.Pointer[[]string]
+}
+
+// Format writes envelopes in another system's message format, for a queue
+// that system's consumers read.
+type Format interface {
+	Encode(e envelope.Envelope, queue string) (string, error)
 }
 
 func NewRedisQueue(client *redis.Client, name string) *RedisQueue {
-	return &RedisQueue{client: client, name: name}
+	return &RedisQueue{client: client, name: name, encoding: envelope.EncodingJSON}
+}
+
+// SetEncoding changes how Encode serializes envelopes for this queue. Readers
+// accept both encodings, so producers can be switched one at a time.
+func (q *RedisQueue) SetEncoding(enc envelope.Encoding) {
+	q.encoding = enc
+}
+
+// SetWriteVersion makes Encode stamp envelopes with v's version, for a
+// producer that negotiates it with the workers. Without it envelopes keep
+// the version they were created with.
+func (q *RedisQueue) SetWriteVersion(v *envelope.WriteVersion) {
+	q.version = v
+}
+
+// SetFormat makes Encode write f's format instead of envelopes.
+func (q *RedisQueue) SetFormat(f Format) {
+	q.format = f
+}
+
+// Encode serializes e in the queue's envelope encoding, or its format if it
+// has one, ready for Enqueue.
+func (q *RedisQueue) Encode(e envelope.Envelope) (string, error) {
+	if q.format != nil {
+		return q.format.Encode(e, q.name)
+	}
+	if q.version != nil {
+		e.Version = q.version.Load()
+	}
+	return e.EncodeAs(q.encoding)
+}
+
+// Name is the Redis list the queue reads from and writes to.
+func (q *RedisQueue) Name() string {
+	return q.name
+}
+
+// DLQName is the list messages are parked on when processing fails:
+// <queue>:dlq unless the queue's policy names another.
+func (q *RedisQueue) DLQName() string {
+	if dlq := q.dlq.Load(); dlq != nil && *dlq != "" {
+		return *dlq
+	}
+	return q.name + ":dlq"
 }
 
 func (q *RedisQueue) Enqueue(ctx context.Context, payload string) error {
-	return q.client.LPush(ctx, q.name, payload).Err()
+	if q.batcher != nil {
+		return q.batcher.enqueue(ctx, q, payload)
+	}
+	return q.enqueueDirect(ctx, payload)
+}
+
+func (q *RedisQueue) enqueueDirect(ctx context.Context, payload string) error {
+	return q.txPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, q.name, payload)
+		p.HIncrBy(ctx, q.statsKey(), statEnqueued, 1)
+		return nil
+	})
+}
+
+// EnqueueMany enqueues payloads in order in one round trip and one
+// transaction, so either all of them are enqueued or none is. It doesn't go
+// through a Batcher.
+func (q *RedisQueue) EnqueueMany(ctx context.Context, payloads []string) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+	values := make([]any, len(payloads))
+	for i, p := range payloads {
+		values[i] = p
+	}
+	return q.txPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, q.name, values...)
+		p.HIncrBy(ctx, q.statsKey(), statEnqueued, int64(len(payloads)))
+		return nil
+	})
+}
+
+// DeadLetter parks a message that could not be processed so it can be
+// inspected or replayed instead of being dropped.
+func (q *RedisQueue) DeadLetter(ctx context.Context, payload string) error {
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, q.DLQName(), payload)
+		p.HIncrBy(ctx, q.statsKey(), statDeadLettered, 1)
+		return nil
+	})
+	return err
+}
+
+// Requeue puts a dequeued message back at the head of the queue, so it's the
+// next one handed out. It doesn't count as a new enqueue.
+func (q *RedisQueue) Requeue(ctx context.Context, payload string) error {
+	return q.client.RPush(ctx, q.name, payload).Err()
+}
+
+// ErrEmpty is returned by DequeueWithin when no message arrived in time.
+var ErrEmpty = errors.New("queue: empty")
+
+// DequeueWithin is Dequeue giving up with ErrEmpty after wait.
+func (q *RedisQueue) DequeueWithin(ctx context.Context, wait time.Duration) (string, error) {
+	res, err := q.client.BRPop(ctx, wait, q.dequeueKeys()...).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrEmpty
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", errors.New("unexpected BRPOP response")
+	}
+	return res[1], nil
 }
 
 // Dequeue blocks until a message is available or ctx is canceled.
@@ -31,7 +161,7 @@ func (q *RedisQueue) Dequeue(ctx context.Context) (string, error) {
 		}
 
 		// Use a finite timeout so we can react to ctx cancellation.
-		res, err := q.client.BRPop(ctx, 5*time.Second, q.name).Result()
+		res, err := q.client.BRPop(ctx, 5*time.Second, q.dequeueKeys()...).Result()
 		if err == nil {
 			// BRPOP returns [queueName, payload]
 			if len(res) == 2 {
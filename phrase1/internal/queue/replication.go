@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotReplicated is returned by an enqueue that was written to the primary
+// but not acknowledged by as many replicas as asked for in time. The message
+// is enqueued all the same and may still reach the replicas.
+var ErrNotReplicated = errors.New("queue: write not replicated")
+
+// Replication asks an enqueue to wait, after its write, until Replicas
+// replicas have acknowledged it or Timeout has passed (Redis WAIT), so a
+// failover of the primary can't lose a message that was acknowledged.
+// Timeout defaults to a second.
+type Replication struct {
+	Replicas int
+	Timeout  time.Duration
+}
+
+type replicationKey struct{}
+
+// WithReplication makes the enqueues done with the returned context wait for
+// r, instead of what the queue was given with SetReplication. A zero r
+// doesn't wait at all.
+func WithReplication(ctx context.Context, r Replication) context.Context {
+	return context.WithValue(ctx, replicationKey{}, r)
+}
+
+// SetReplication makes every enqueue on the queue wait for r, unless its
+// context says otherwise.
+func (q *RedisQueue) SetReplication(r Replication) {
+	q.replication = r
+}
+
+// replicationFor returns what an enqueue with ctx waits for, and whether it
+// waits at all.
+func (q *RedisQueue) replicationFor(ctx context.Context) (Replication, bool) {
+	r, ok := ctx.Value(replicationKey{}).(Replication)
+	if !ok {
+		r = q.replication
+	}
+	return r, r.Replicas > 0
+}
+
+func (r Replication) timeout() time.Duration {
+	// WAIT 0 would block until enough replicas ack, however long that
+	// takes.
+	if r.Timeout <= 0 {
+		return time.Second
+	}
+	return r.Timeout
+}
+
+// check turns WAIT's answer into ErrNotReplicated if it falls short of r.
+func (r Replication) check(acked int64, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotReplicated, err)
+	}
+	if int(acked) < r.Replicas {
+		return fmt.Errorf("%w: %d of %d replicas acknowledged within %s", ErrNotReplicated, acked, r.Replicas, r.timeout())
+	}
+	return nil
+}
+
+// txPipelined runs fn as one MULTI/EXEC and, if the enqueue is to be
+// replicated, waits for that on the same connection.
+func (q *RedisQueue) txPipelined(ctx context.Context, fn func(redis.Pipeliner) error) error {
+	r, ok := q.replicationFor(ctx)
+	if !ok {
+		_, err := q.client.TxPipelined(ctx, fn)
+		return err
+	}
+	conn := q.client.Conn()
+	defer conn.Close()
+	if _, err := conn.TxPipelined(ctx, fn); err != nil {
+		return err
+	}
+	return r.check(conn.Wait(ctx, r.Replicas, r.timeout()).Result())
+}
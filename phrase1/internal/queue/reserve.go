@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoReservation is returned for a reservation token that was never
+// issued or has expired.
+var ErrNoReservation = errors.New("queue: no such reservation")
+
+// Reservation is a message held back by Reserve until it's committed.
+type Reservation struct {
+	// ID is the message's envelope ID.
+	ID string
+	// Payload is the encoded message Commit enqueues.
+	Payload string
+	// Message is its text, for logs and events.
+	Message string
+	// Charge is what reserving the message cost the producer, opaque to
+	// the queue; Lapsed hands it back if the reservation expires
+	// uncommitted, for it to be refunded.
+	Charge string
+}
+
+// reservedKey is the hash holding the reservation issued as token, until it
+// expires.
+func (q *RedisQueue) reservedKey(token string) string {
+	return q.name + ":reserved:" + token
+}
+
+// pendingKey is the sorted set of uncommitted reservation tokens, scored
+// by when they expire (unix ms).
+func (q *RedisQueue) pendingKey() string {
+	return q.name + ":reserved:pending"
+}
+
+// chargesKey is the hash of uncommitted reservations' charges, by token.
+func (q *RedisQueue) chargesKey() string {
+	return q.name + ":reserved:charges"
+}
+
+// Reserve holds r as token for ttl. It isn't enqueued, or counted as
+// enqueued, until Commit; if that doesn't come in time, it's dropped, and
+// Lapsed reports it.
+func (q *RedisQueue) Reserve(ctx context.Context, token string, r Reservation, ttl time.Duration) error {
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, q.reservedKey(token), "id", r.ID, "payload", r.Payload, "message", r.Message)
+		p.PExpire(ctx, q.reservedKey(token), ttl)
+		p.ZAdd(ctx, q.pendingKey(), redis.Z{Score: float64(time.Now().Add(ttl).UnixMilli()), Member: token})
+		if r.Charge != "" {
+			p.HSet(ctx, q.chargesKey(), token, r.Charge)
+		}
+		return nil
+	})
+	return err
+}
+
+// Reserved returns the reservation issued as token. Once it's committed,
+// it has no Payload.
+func (q *RedisQueue) Reserved(ctx context.Context, token string) (Reservation, error) {
+	fields, err := q.client.HGetAll(ctx, q.reservedKey(token)).Result()
+	if err != nil {
+		return Reservation{}, err
+	}
+	if len(fields) == 0 {
+		return Reservation{}, ErrNoReservation
+	}
+	return Reservation{ID: fields["id"], Payload: fields["payload"], Message: fields["message"]}, nil
+}
+
+// commitScript enqueues the payload reserved in KEYS[1] onto KEYS[2],
+// counting it in the stats hash KEYS[3], and marks the reservation
+// committed until it expires. Its token, ARGV[2], is taken out of the
+// pending set KEYS[4] and the charges KEYS[5], so it never lapses. It
+// returns 1 if it enqueued, 0 if the reservation was already committed, and
+// -1 if there's none.
+var commitScript = redis.NewScript(`
+local r = redis.call("HMGET", KEYS[1], "payload", "committed")
+if r[2] then
+	return 0
+end
+if not r[1] then
+	return -1
+end
+redis.call("LPUSH", KEYS[2], r[1])
+redis.call("HINCRBY", KEYS[3], ARGV[1], 1)
+redis.call("HDEL", KEYS[1], "payload")
+redis.call("HSET", KEYS[1], "committed", "1")
+redis.call("ZREM", KEYS[4], ARGV[2])
+redis.call("HDEL", KEYS[5], ARGV[2])
+return 1
+`)
+
+// Commit enqueues the message reserved as token. It's atomic, so a
+// reservation is enqueued once however many times it's committed; Commit
+// reports whether this call was the one that did. Once the reservation has
+// expired, Commit returns ErrNoReservation.
+func (q *RedisQueue) Commit(ctx context.Context, token string) (bool, error) {
+	n, err := commitScript.Run(ctx, q.client, []string{q.reservedKey(token), q.name, q.statsKey(), q.pendingKey(), q.chargesKey()}, statEnqueued, token).Int()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, ErrNoReservation
+	}
+	return n == 1, nil
+}
+
+// lapsedScript takes up to ARGV[2] tokens due by ARGV[1] (unix ms) out of
+// the pending set KEYS[1], and returns their charges from KEYS[2]. A token
+// whose reservation, ARGV[3] followed by the token, is still there is left
+// for a later call: its deadline and its key's TTL can be a few ms apart.
+var lapsedScript = redis.NewScript(`
+local out = {}
+for _, token in ipairs(redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])) do
+	if redis.call("EXISTS", ARGV[3] .. token) == 0 then
+		redis.call("ZREM", KEYS[1], token)
+		out[#out + 1] = redis.call("HGET", KEYS[2], token) or ""
+		redis.call("HDEL", KEYS[2], token)
+	end
+end
+return out
+`)
+
+// Lapsed returns the charges of up to limit reservations that expired by
+// now without being committed. Each is returned once, to whichever caller
+// gets it first, so every api replica can call it.
+func (q *RedisQueue) Lapsed(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return lapsedScript.Run(ctx, q.client, []string{q.pendingKey(), q.chargesKey()}, now.UnixMilli(), limit, q.reservedKey("")).StringSlice()
+}
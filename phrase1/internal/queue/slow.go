@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SlowMessage records a message whose processing took longer than the
+// worker's slow threshold.
+type SlowMessage struct {
+	ID          string    `json:"id"`
+	Queue       string    `json:"queue"`
+	Handler     string    `json:"handler"`
+	Worker      string    `json:"worker"`
+	DurationMS  int64     `json:"duration_ms"`
+	Message     string    `json:"message"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// slowKeep is how many recent slow messages are kept to pick the slowest from.
+const slowKeep = 500
+
+func (q *RedisQueue) slowKey() string {
+	return q.name + ":slow"
+}
+
+// RecordSlow adds m to the capped list of recent slow messages.
+func (q *RedisQueue) RecordSlow(ctx context.Context, m SlowMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, q.slowKey(), b)
+		p.LTrim(ctx, q.slowKey(), 0, slowKeep-1)
+		return nil
+	})
+	return err
+}
+
+// SlowestRecent returns the n slowest of the recently recorded slow
+// messages, slowest first.
+func (q *RedisQueue) SlowestRecent(ctx context.Context, n int) ([]SlowMessage, error) {
+	raw, err := q.client.LRange(ctx, q.slowKey(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SlowMessage, 0, len(raw))
+	for _, r := range raw {
+		var m SlowMessage
+		if err := json.Unmarshal([]byte(r), &m); err == nil {
+			out = append(out, m)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].DurationMS > out[j].DurationMS })
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out, nil
+}
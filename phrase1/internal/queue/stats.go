@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// Counter fields in the stats hash. They are cumulative since the hash was
+// created; rates are derived by sampling them over time.
+const (
+	statEnqueued     = "enqueued"
+	statProcessed    = "processed"
+	statDeadLettered = "dead_lettered"
+)
+
+type Stats struct {
+	Queue             string `json:"queue"`
+	Depth             int64  `json:"depth"`
+	DLQDepth          int64  `json:"dlq_depth"`
+	EnqueuedTotal     int64  `json:"enqueued_total"`
+	ProcessedTotal    int64  `json:"processed_total"`
+	DeadLetteredTotal int64  `json:"dead_lettered_total"`
+}
+
+func (q *RedisQueue) statsKey() string {
+	return q.name + ":stats"
+}
+
+// Depth is the number of messages waiting, at any priority.
+func (q *RedisQueue) Depth(ctx context.Context) (int64, error) {
+	keys := q.dequeueKeys()
+	if len(keys) == 1 {
+		return q.client.LLen(ctx, q.name).Result()
+	}
+	lens := make([]*redis.IntCmd, len(keys))
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			lens[i] = p.LLen(ctx, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, l := range lens {
+		n += l.Val()
+	}
+	return n, nil
+}
+
+func (q *RedisQueue) Stats(ctx context.Context) (Stats, error) {
+	var dlqDepth *redis.IntCmd
+	var depths []*redis.IntCmd
+	var counters *redis.MapStringStringCmd
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range q.dequeueKeys() {
+			depths = append(depths, p.LLen(ctx, k))
+		}
+		dlqDepth = p.LLen(ctx, q.DLQName())
+		counters = p.HGetAll(ctx, q.statsKey())
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var depth int64
+	for _, d := range depths {
+		depth += d.Val()
+	}
+	c := counters.Val()
+	parse := func(field string) int64 {
+		n, _ := strconv.ParseInt(c[field], 10, 64)
+		return n
+	}
+	return Stats{
+		Queue:             q.name,
+		Depth:             depth,
+		DLQDepth:          dlqDepth.Val(),
+		EnqueuedTotal:     parse(statEnqueued),
+		ProcessedTotal:    parse(statProcessed),
+		DeadLetteredTotal: parse(statDeadLettered),
+	}, nil
+}
+
+// DeadLettered returns up to n messages from the DLQ, newest first.
+func (q *RedisQueue) DeadLettered(ctx context.Context, n int) ([]string, error) {
+	if n <= 0 {
+		n = 50
+	}
+	return q.client.LRange(ctx, q.DLQName(), 0, int64(n-1)).Result()
+}
+
+// OldestAge is how long the next message to be dequeued has been waiting, or
+// 0 if the queue is empty or the message predates envelopes.
+func (q *RedisQueue) OldestAge(ctx context.Context) (time.Duration, error) {
+	raw, err := q.client.LIndex(ctx, q.name, -1).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	e := envelope.Decode(raw)
+	if e.EnqueuedAt.IsZero() {
+		return 0, nil
+	}
+	return time.Since(e.EnqueuedAt), nil
+}
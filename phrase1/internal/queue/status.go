@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoStatus is returned for a message with no status record: one no
+// worker has taken yet, or whose record has expired.
+var ErrNoStatus = errors.New("queue: no status for message")
+
+// States a MessageStatus can be in.
+const (
+	StateProcessing   = "processing"
+	StateProcessed    = "processed"
+	StateRetrying     = "retrying"
+	StateDeadLettered = "dead_lettered"
+	StateCancelled    = "cancelled"
+)
+
+// MessageStatus is where a message is in processing, as the worker handling
+// it last recorded.
+type MessageStatus struct {
+	ID      string `json:"id"`
+	Queue   string `json:"queue"`
+	State   string `json:"state"`
+	Attempt int    `json:"attempt"`
+	Worker  string `json:"worker,omitempty"`
+	Handler string `json:"handler,omitempty"`
+	// Percent and Stage are the progress the handler last reported; a
+	// processed message is at 100.
+	Percent float64 `json:"percent"`
+	Stage   string  `json:"stage,omitempty"`
+	// Error is why the last attempt failed, for retrying and dead-lettered
+	// messages, or why a cancelled one stopped.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (q *RedisQueue) statusKey(id string) string {
+	return q.name + ":status:" + id
+}
+
+// SetStatus records st as its message's status for ttl, replacing what was
+// recorded before.
+func (q *RedisQueue) SetStatus(ctx context.Context, st MessageStatus, ttl time.Duration) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return q.client.Set(ctx, q.statusKey(st.ID), b, ttl).Err()
+}
+
+// Status returns the status recorded for message id.
+func (q *RedisQueue) Status(ctx context.Context, id string) (MessageStatus, error) {
+	b, err := q.client.Get(ctx, q.statusKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return MessageStatus{}, ErrNoStatus
+	}
+	if err != nil {
+		return MessageStatus{}, err
+	}
+	var st MessageStatus
+	err = json.Unmarshal(b, &st)
+	return st, err
+}
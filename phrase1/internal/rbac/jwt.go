@@ -0,0 +1,98 @@
+package rbac
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// leeway absorbs clock skew between the token issuer and the api.
+const leeway = 30 * time.Second
+
+type claims struct {
+	Subject   string       `json:"sub"`
+	Role      string       `json:"role"`
+	Roles     []string     `json:"roles"`
+	ExpiresAt *json.Number `json:"exp"`
+	NotBefore *json.Number `json:"nbf"`
+}
+
+// verifyJWT checks an HS256 compact JWT, signed with either of keys, and
+// maps its role claim, or the highest of its roles claim, to a principal.
+func verifyJWT(token string, keys *jwtSecrets) (Principal, error) {
+	parts := strings.Split(token, ".")
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed token header", ErrUnauthenticated)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return Principal{}, fmt.Errorf("%w: token must be signed with HS256", ErrUnauthenticated)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed token signature", ErrUnauthenticated)
+	}
+	if !signedWith(parts[0]+"."+parts[1], sig, keys.current) && !signedWith(parts[0]+"."+parts[1], sig, keys.previous) {
+		return Principal{}, fmt.Errorf("%w: bad token signature", ErrUnauthenticated)
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed token claims", ErrUnauthenticated)
+	}
+	var c claims
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed token claims", ErrUnauthenticated)
+	}
+	now := time.Now()
+	if t, ok := unixTime(c.ExpiresAt); ok && now.After(t.Add(leeway)) {
+		return Principal{}, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	if t, ok := unixTime(c.NotBefore); ok && now.Add(leeway).Before(t) {
+		return Principal{}, fmt.Errorf("%w: token not valid yet", ErrUnauthenticated)
+	}
+
+	p := Principal{Name: c.Subject, Token: true}
+	for _, s := range append([]string{c.Role}, c.Roles...) {
+		if r, err := ParseRole(s); err == nil && rank[r] > rank[p.Role] {
+			p.Role = r
+		}
+	}
+	if p.Role == "" {
+		return Principal{}, fmt.Errorf("%w: token has no known role", ErrForbidden)
+	}
+	return p, nil
+}
+
+// signedWith reports whether sig is the HS256 signature of signed with
+// secret.
+func signedWith(signed string, sig, secret []byte) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+func unixTime(n *json.Number) (time.Time, bool) {
+	if n == nil {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
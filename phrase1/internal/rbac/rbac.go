@@ -0,0 +1,169 @@
+// Package rbac attaches roles to API keys and JWTs and checks them per route.
+// Roles are ordered: admin can do everything operator can, and operator
+// everything producer can.
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type Role string
+
+const (
+	// Producer may enqueue.
+	Producer Role = "producer"
+	// Operator may also read message contents, stats, and the audit log, and
+	// toggle maintenance mode.
+	Operator Role = "operator"
+	// Admin may also change schemas and other configuration.
+	Admin Role = "admin"
+)
+
+var rank = map[Role]int{Producer: 1, Operator: 2, Admin: 3}
+
+// ParseRole accepts the role names case-insensitively.
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := rank[r]; !ok {
+		return "", fmt.Errorf("unknown role %q (want producer, operator, or admin)", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether r includes the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return rank[r] > 0 && rank[r] >= rank[required]
+}
+
+var (
+	// ErrUnauthenticated means no usable credential was presented.
+	ErrUnauthenticated = errors.New("a valid API key or token is required")
+	// ErrForbidden means the credential's role is too low for the route.
+	ErrForbidden = errors.New("insufficient role")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name string   `json:"name"`
+	Role Role     `json:"role"`
+	Keys []string `json:"keys,omitempty"`
+	// Token is set for principals authenticated by a JWT, whose Name is the
+	// token's subject.
+	Token bool `json:"-"`
+}
+
+type Authorizer struct {
+	byKey   map[[sha256.Size]byte]Principal
+	jwt     atomic.Pointer[jwtSecrets]
+	lookups []func(key string) (Principal, bool)
+}
+
+// jwtSecrets are the JWT secret and, after a rotation, the one before it.
+type jwtSecrets struct {
+	current, previous []byte
+}
+
+// LoadFile reads a JSON array of principals with their keys.
+func LoadFile(path string) ([]Principal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ps []Principal
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ps, nil
+}
+
+// NewAuthorizer accepts the keys of principals and, if jwtSecret is set,
+// HS256 JWTs signed with it that carry a role claim.
+func NewAuthorizer(principals []Principal, jwtSecret []byte) (*Authorizer, error) {
+	a := &Authorizer{byKey: make(map[[sha256.Size]byte]Principal)}
+	a.jwt.Store(&jwtSecrets{current: jwtSecret})
+	for i, p := range principals {
+		if p.Name == "" {
+			return nil, fmt.Errorf("principal %d has no name", i)
+		}
+		role, err := ParseRole(string(p.Role))
+		if err != nil {
+			return nil, fmt.Errorf("principal %q: %w", p.Name, err)
+		}
+		p.Role = role
+		for _, k := range p.Keys {
+			sum := sha256.Sum256([]byte(k))
+			if other, dup := a.byKey[sum]; dup {
+				return nil, fmt.Errorf("principals %q and %q share an API key", other.Name, p.Name)
+			}
+			a.byKey[sum] = Principal{Name: p.Name, Role: role}
+		}
+	}
+	return a, nil
+}
+
+// SetJWTSecret rotates the JWT secret. Tokens signed with the one it
+// replaces are still accepted until the next rotation, so clients have time
+// to get new ones.
+func (a *Authorizer) SetJWTSecret(secret []byte) {
+	if old := a.jwt.Load(); !bytes.Equal(old.current, secret) {
+		a.jwt.Store(&jwtSecrets{current: secret, previous: old.current})
+	}
+}
+
+// AddLookup consults fn for keys that aren't in the file, e.g. to let tenant
+// keys act as producers.
+func (a *Authorizer) AddLookup(fn func(key string) (Principal, bool)) {
+	a.lookups = append(a.lookups, fn)
+}
+
+// Authenticate resolves a credential: a JWT if it looks like one and a
+// secret is configured, an API key otherwise.
+func (a *Authorizer) Authenticate(credential string) (Principal, error) {
+	if credential == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	if keys := a.jwt.Load(); len(keys.current) > 0 && strings.Count(credential, ".") == 2 {
+		return verifyJWT(credential, keys)
+	}
+	if p, ok := a.byKey[sha256.Sum256([]byte(credential))]; ok {
+		return p, nil
+	}
+	for _, fn := range a.lookups {
+		if p, ok := fn(credential); ok {
+			return p, nil
+		}
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+// Authorize authenticates credential and checks it holds required.
+func (a *Authorizer) Authorize(credential string, required Role) (Principal, error) {
+	p, err := a.Authenticate(credential)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !p.Role.Allows(required) {
+		return p, fmt.Errorf("%w: %q is %s, this needs %s", ErrForbidden, p.Name, p.Role, required)
+	}
+	return p, nil
+}
+
+type ctxKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal a request was authorized as.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
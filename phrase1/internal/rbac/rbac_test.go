@@ -0,0 +1,150 @@
+package rbac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var secret = []byte("s3cret")
+
+// token builds a compact JWT with header alg, claims, signed with key.
+func token(alg string, claims map[string]any, key []byte) string {
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "typ": "JWT"}) + "." + enc(claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name  string
+		token string
+		role  Role
+		err   error
+	}{
+		{"role claim", token("HS256", map[string]any{"sub": "ci", "role": "operator"}, secret), Operator, nil},
+		{"highest of roles", token("HS256", map[string]any{"sub": "ci", "roles": []string{"producer", "ADMIN", "operator"}}, secret), Admin, nil},
+		{"role and roles", token("HS256", map[string]any{"role": "producer", "roles": []string{"operator"}}, secret), Operator, nil},
+		{"unknown roles skipped", token("HS256", map[string]any{"roles": []string{"root", "producer"}}, secret), Producer, nil},
+		{"no known role", token("HS256", map[string]any{"sub": "ci", "roles": []string{"root"}}, secret), "", ErrForbidden},
+		{"no role", token("HS256", map[string]any{"sub": "ci"}, secret), "", ErrForbidden},
+		{"alg none", token("none", map[string]any{"role": "admin"}, secret), "", ErrUnauthenticated},
+		{"alg RS256", token("RS256", map[string]any{"role": "admin"}, secret), "", ErrUnauthenticated},
+		{"bad signature", token("HS256", map[string]any{"role": "admin"}, []byte("other")), "", ErrUnauthenticated},
+		{"malformed header", "!!.e30.sig", "", ErrUnauthenticated},
+		{"malformed signature", token("HS256", map[string]any{"role": "admin"}, secret)[:20] + ".e30.!!", "", ErrUnauthenticated},
+		{"expired", token("HS256", map[string]any{"role": "admin", "exp": now - 60}, secret), "", ErrUnauthenticated},
+		{"expired within leeway", token("HS256", map[string]any{"role": "admin", "exp": now - 10}, secret), Admin, nil},
+		{"not valid yet", token("HS256", map[string]any{"role": "admin", "nbf": now + 60}, secret), "", ErrUnauthenticated},
+		{"not valid yet within leeway", token("HS256", map[string]any{"role": "admin", "nbf": now + 10}, secret), Admin, nil},
+		{"valid window", token("HS256", map[string]any{"role": "admin", "nbf": now - 60, "exp": now + 60}, secret), Admin, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := verifyJWT(tt.token, &jwtSecrets{current: secret})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Role != tt.role || !p.Token {
+				t.Errorf("principal %+v, want role %s from a token", p, tt.role)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTAfterRotation(t *testing.T) {
+	a, err := NewAuthorizer(nil, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := token("HS256", map[string]any{"role": "producer"}, secret)
+	a.SetJWTSecret([]byte("next"))
+	if _, err := a.Authenticate(old); err != nil {
+		t.Errorf("token signed with the previous secret: %v", err)
+	}
+	a.SetJWTSecret([]byte("after"))
+	if _, err := a.Authenticate(old); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("token signed two secrets ago: err = %v", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a, err := NewAuthorizer([]Principal{
+		{Name: "ops", Role: "Operator", Keys: []string{"ops-key"}},
+		{Name: "ci", Role: "producer", Keys: []string{"ci-key"}},
+	}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tenant keys, consulted for keys the file doesn't have.
+	a.AddLookup(func(key string) (Principal, bool) {
+		if key == "tenant-key" || key == "ops-key" {
+			return Principal{Name: "tenant:acme", Role: Producer}, true
+		}
+		return Principal{}, false
+	})
+
+	tests := []struct {
+		name       string
+		credential string
+		required   Role
+		principal  string
+		err        error
+	}{
+		{"file key", "ops-key", Operator, "ops", nil},
+		{"file key below its role", "ops-key", Producer, "ops", nil},
+		{"file key above its role", "ci-key", Operator, "", ErrForbidden},
+		{"lookup fallback", "tenant-key", Producer, "tenant:acme", nil},
+		{"lookup fallback above its role", "tenant-key", Admin, "", ErrForbidden},
+		{"unknown key", "nope", Producer, "", ErrUnauthenticated},
+		{"no credential", "", Producer, "", ErrUnauthenticated},
+		{"token", token("HS256", map[string]any{"sub": "deploy", "role": "admin"}, secret), Admin, "deploy", nil},
+		{"token below the route", token("HS256", map[string]any{"sub": "deploy", "role": "producer"}, secret), Operator, "", ErrForbidden},
+		{"bad token", token("HS256", map[string]any{"role": "admin"}, []byte("x")), Producer, "", ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.Authorize(tt.credential, tt.required)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != tt.principal {
+				t.Errorf("authorized as %q, want %q", p.Name, tt.principal)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizerRejects(t *testing.T) {
+	for name, ps := range map[string][]Principal{
+		"no name":      {{Role: Producer}},
+		"unknown role": {{Name: "x", Role: "root"}},
+		"shared key":   {{Name: "a", Role: Producer, Keys: []string{"k"}}, {Name: "b", Role: Admin, Keys: []string{"k"}}},
+	} {
+		if _, err := NewAuthorizer(ps, nil); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
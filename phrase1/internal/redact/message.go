@@ -0,0 +1,138 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces redacted parts of messages.
+const Placeholder = "[REDACTED]"
+
+// PII is a kind of personal data that can be found in message text. The
+// patterns favour catching the common formats over exactness; cards are
+// Luhn-checked to keep order numbers from tripping them.
+type PII struct {
+	Name string
+	// What describes the kind in a sentence, e.g. "an email address".
+	What  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var piiKinds = []PII{
+	{"email", "an email address", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), nil},
+	{"card", "a payment card number", regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), luhn},
+	{"ssn", "a US social security number", regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), nil},
+	{"phone", "a phone number", regexp.MustCompile(`\+\d[\d ()-]{7,18}\d`), nil},
+}
+
+// LookupPII returns the kind called name: email, card, ssn, or phone.
+func LookupPII(name string) (PII, error) {
+	for _, k := range piiKinds {
+		if k.Name == name {
+			return k, nil
+		}
+	}
+	return PII{}, fmt.Errorf("unknown pii kind %q (want email, card, ssn, or phone)", name)
+}
+
+// In reports whether text contains k.
+func (k PII) In(text string) bool {
+	for _, m := range k.re.FindAllString(text, -1) {
+		if k.valid == nil || k.valid(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k PII) replace(text string) string {
+	return k.re.ReplaceAllStringFunc(text, func(m string) string {
+		if k.valid == nil || k.valid(m) {
+			return Placeholder
+		}
+		return m
+	})
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Messages hides personal data in message text before it's logged or
+// written out. A nil *Messages leaves text as it is.
+type Messages struct {
+	pii     []PII
+	pattern *regexp.Regexp
+	fields  *regexp.Regexp
+}
+
+// NewMessages redacts the pii kinds, matches of pattern, and the values of
+// JSON fields with any of the names in fields, at any depth and in any case.
+// Only string, number, boolean, and null field values are replaced; an object
+// or array under a listed field is left for its own fields and the patterns.
+// It returns nil if there's nothing to redact.
+func NewMessages(pii []string, pattern string, fields []string) (*Messages, error) {
+	m := &Messages{}
+	for _, name := range pii {
+		k, err := LookupPII(name)
+		if err != nil {
+			return nil, err
+		}
+		m.pii = append(m.pii, k)
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern: %w", err)
+		}
+		m.pattern = re
+	}
+	if len(fields) > 0 {
+		quoted := make([]string, len(fields))
+		for i, f := range fields {
+			quoted[i] = regexp.QuoteMeta(f)
+		}
+		// The key, the colon, and a scalar value, which is what gets
+		// replaced.
+		m.fields = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"|-?\d[\d.eE+-]*|true|false|null)`)
+	}
+	if m.pii == nil && m.pattern == nil && m.fields == nil {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Text returns s with everything m redacts replaced by Placeholder.
+func (m *Messages) Text(s string) string {
+	if m == nil {
+		return s
+	}
+	if m.fields != nil {
+		s = m.fields.ReplaceAllString(s, `${1}"`+Placeholder+`"`)
+	}
+	for _, k := range m.pii {
+		s = k.replace(s)
+	}
+	if m.pattern != nil {
+		s = m.pattern.ReplaceAllString(s, Placeholder)
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package redact
+
+import "testing"
+
+func TestMessagesText(t *testing.T) {
+	m, err := NewMessages([]string{"email", "card"}, `\bACCT-\d+\b`, []string{"name", "dob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"mail ada@example.com now":                            "mail [REDACTED] now",
+		"card 4111 1111 1111 1111, order 4111 1111 1111 1112": "card [REDACTED], order 4111 1111 1111 1112",
+		"ref ACCT-1234":                                       "ref [REDACTED]",
+		`{"Name": "Ada \"L\"", "qty": 2}`:                     `{"Name": "[REDACTED]", "qty": 2}`,
+		`{"customer":{"dob":19151210,"name":null}}`:           `{"customer":{"dob":"[REDACTED]","name":"[REDACTED]"}}`,
+		`{"name": {"first": "Ada"}}`:                          `{"name": {"first": "Ada"}}`,
+		"nothing to hide":                                     "nothing to hide",
+	} {
+		if got := m.Text(in); got != want {
+			t.Errorf("Text(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if m, err := NewMessages(nil, "", nil); m != nil || err != nil {
+		t.Errorf("nothing configured: %v, %v; want nil", m, err)
+	}
+	var none *Messages
+	if got := none.Text("ada@example.com"); got != "ada@example.com" {
+		t.Errorf("nil Messages changed the text: %q", got)
+	}
+	if _, err := NewMessages([]string{"passport"}, "", nil); err == nil {
+		t.Error("unknown pii kind accepted")
+	}
+	if _, err := NewMessages(nil, "(", nil); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
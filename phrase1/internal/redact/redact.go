@@ -0,0 +1,32 @@
+// Package redact hides secrets in configuration values before they are
+// shown on status pages or in dumps.
+package redact
+
+import (
+	"net/url"
+	"strings"
+)
+
+// secretWords mark variables whose values are never shown.
+var secretWords = []string{"PASSWORD", "SECRET", "TOKEN", "DSN", "CREDENTIAL"}
+
+// Value returns the value of variable key fit for display: "[redacted]" for
+// secrets, URLs with their password replaced, anything else unchanged.
+func Value(key, value string) string {
+	if value == "" {
+		return value
+	}
+	upper := strings.ToUpper(key)
+	for _, w := range secretWords {
+		if strings.Contains(upper, w) {
+			return "[redacted]"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+			return u.String()
+		}
+	}
+	return value
+}
@@ -0,0 +1,241 @@
+// Package redisfailover switches a go-redis client between a prioritized
+// list of Redis addresses by health, for setups with a standby Redis in
+// another zone but no Sentinel to promote it. It's a hook: every new
+// connection dials the active address, and connections to one that's no
+// longer active fail their next command, so go-redis retries it on a fresh
+// connection.
+package redisfailover
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// ParseAddrs splits a comma-separated REDIS_ADDR into addresses, highest
+// priority first. It fails if there are none.
+func ParseAddrs(s string) ([]string, error) {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("REDIS_ADDR has no addresses")
+	}
+	return out, nil
+}
+
+// endpoint is one address's health as of the last check.
+type endpoint struct {
+	addr    string
+	healthy bool
+	// since is when healthy last changed.
+	since time.Time
+	probe *redis.Client
+}
+
+// Switch implements redis.Hook. Every check it pings each address; the
+// active one is abandoned for the first healthy address once it has failed
+// checks for the failover delay, and an address higher in the list takes
+// over again once it has passed them for as long.
+type Switch struct {
+	after time.Duration
+	// endpoints' health is only touched by Run.
+	endpoints []*endpoint
+	active    atomic.Int32
+	// gen counts switches; connections dialed before the last one are stale.
+	gen       atomic.Uint64
+	failovers *metrics.Counter
+	up        *metrics.Gauge
+	current   *metrics.Gauge
+	logger    *log.Logger
+}
+
+// New returns a Switch over addrs, starting on the first. Health checks
+// use opts, with each address in turn, so they authenticate the way the
+// client does.
+func New(addrs []string, opts *redis.Options, after time.Duration, reg *metrics.Registry, logger *log.Logger) *Switch {
+	s := &Switch{
+		after:     after,
+		failovers: reg.NewCounter("redis_failovers_total", "Switches between Redis addresses, by the address left and the one taken.", "from", "to"),
+		up:        reg.NewGauge("redis_endpoint_up", "1 if the Redis address passed its last health check.", "addr"),
+		current:   reg.NewGauge("redis_endpoint_active", "1 for the Redis address new connections go to.", "addr"),
+		logger:    logger,
+	}
+	now := time.Now()
+	for _, addr := range addrs {
+		o := *opts
+		o.Addr = addr
+		o.PoolSize = 1
+		o.MaxRetries = -1
+		// The first address is assumed healthy until a check says
+		// otherwise; the rest have to prove it.
+		s.endpoints = append(s.endpoints, &endpoint{addr: addr, healthy: len(s.endpoints) == 0, since: now, probe: redis.NewClient(&o)})
+		s.current.Set(0, addr)
+	}
+	s.current.Set(1, addrs[0])
+	return s
+}
+
+// Active returns the address new connections go to.
+func (s *Switch) Active() string {
+	return s.endpoints[s.active.Load()].addr
+}
+
+// Run checks every address each interval until ctx is canceled.
+func (s *Switch) Run(ctx context.Context, interval time.Duration) {
+	defer func() {
+		for _, e := range s.endpoints {
+			e.probe.Close()
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.check(ctx, interval)
+	}
+}
+
+// check pings every address at once, each for at most timeout, then
+// switches if the results call for it.
+func (s *Switch) check(ctx context.Context, timeout time.Duration) {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(s.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range s.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = e.probe.Ping(pingCtx).Err()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	for i, e := range s.endpoints {
+		healthy := errs[i] == nil
+		if healthy != e.healthy {
+			e.healthy, e.since = healthy, now
+			if !healthy {
+				s.logger.Printf("redis %s failed its health check: %v", e.addr, errs[i])
+			} else {
+				s.logger.Printf("redis %s passed its health check again", e.addr)
+			}
+		}
+		up := 0.0
+		if healthy {
+			up = 1
+		}
+		s.up.Set(up, e.addr)
+	}
+	from := int(s.active.Load())
+	to := choose(s.endpoints, from, now, s.after)
+	if to == from {
+		return
+	}
+	old, next := s.endpoints[from], s.endpoints[to]
+	s.active.Store(int32(to))
+	s.gen.Add(1)
+	s.failovers.Inc(old.addr, next.addr)
+	s.current.Set(0, old.addr)
+	s.current.Set(1, next.addr)
+	if to < from {
+		s.logger.Printf("redis failing back from %s to %s, healthy for %s", old.addr, next.addr, now.Sub(next.since).Round(time.Second))
+	} else {
+		s.logger.Printf("redis failing over from %s to %s: unhealthy for %s", old.addr, next.addr, now.Sub(old.since).Round(time.Second))
+	}
+}
+
+// choose returns the index of the endpoint to use: the highest-priority one
+// above active that has been healthy for after, or, if active has been
+// unhealthy for after, the highest-priority healthy one. Otherwise active
+// stays, even unhealthy, since there's nothing better to go to.
+func choose(endpoints []*endpoint, active int, now time.Time, after time.Duration) int {
+	for i := 0; i < active; i++ {
+		if e := endpoints[i]; e.healthy && now.Sub(e.since) >= after {
+			return i
+		}
+	}
+	if e := endpoints[active]; e.healthy || now.Sub(e.since) < after {
+		return active
+	}
+	for i, e := range endpoints {
+		if e.healthy {
+			return i
+		}
+	}
+	return active
+}
+
+func (s *Switch) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		// Read gen first: a switch in between leaves the connection stale,
+		// never a stale one current.
+		gen := s.gen.Load()
+		c, err := next(ctx, network, s.Active())
+		if err != nil {
+			return nil, err
+		}
+		sc := &conn{Conn: c, gen: gen, s: s}
+		if _, ok := c.(syscall.Conn); ok {
+			return rawConn{sc}, nil
+		}
+		return sc, nil
+	}
+}
+
+func (s *Switch) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (s *Switch) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// conn is a connection dialed while gen was current. Once it isn't, the
+// connection closes on its next write, before sending anything, and
+// reports io.EOF, which go-redis retries on a new connection.
+type conn struct {
+	net.Conn
+	gen uint64
+	s   *Switch
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.s.gen.Load() != c.gen {
+		c.Conn.Close()
+		return 0, io.EOF
+	}
+	return c.Conn.Write(b)
+}
+
+// rawConn is a conn over a plain TCP connection. Passing SyscallConn
+// through lets go-redis check idle connections for a closed peer; a TLS
+// connection has no such check, wrapped or not.
+type rawConn struct{ *conn }
+
+func (c rawConn) SyscallConn() (syscall.RawConn, error) {
+	return c.Conn.(syscall.Conn).SyscallConn()
+}
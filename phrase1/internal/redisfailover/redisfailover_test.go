@@ -0,0 +1,46 @@
+package redisfailover
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChoose(t *testing.T) {
+	now := time.Now()
+	after := 10 * time.Second
+	state := func(healthy bool, age time.Duration) *endpoint {
+		return &endpoint{healthy: healthy, since: now.Add(-age)}
+	}
+	cases := []struct {
+		name      string
+		endpoints []*endpoint
+		active    int
+		want      int
+	}{
+		{"healthy primary stays", []*endpoint{state(true, time.Minute), state(true, time.Minute)}, 0, 0},
+		{"primary down briefly", []*endpoint{state(false, 5*time.Second), state(true, time.Minute)}, 0, 0},
+		{"primary down long enough", []*endpoint{state(false, after), state(true, time.Minute)}, 0, 1},
+		{"skips unhealthy standbys", []*endpoint{state(false, time.Minute), state(false, time.Minute), state(true, 0)}, 0, 2},
+		{"nowhere to go", []*endpoint{state(false, time.Minute), state(false, time.Minute)}, 0, 0},
+		{"primary back briefly", []*endpoint{state(true, 5*time.Second), state(true, time.Minute)}, 1, 1},
+		{"fails back", []*endpoint{state(true, after), state(true, time.Minute)}, 1, 0},
+		{"fails back one step", []*endpoint{state(false, time.Minute), state(true, time.Minute), state(false, time.Minute)}, 2, 1},
+	}
+	for _, c := range cases {
+		if got := choose(c.endpoints, c.active, now, after); got != c.want {
+			t.Errorf("%s: chose %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseAddrs(t *testing.T) {
+	got, err := ParseAddrs(" redis-a:6379, ,redis-b:6379 ")
+	if err != nil || len(got) != 2 || got[0] != "redis-a:6379" || got[1] != "redis-b:6379" {
+		t.Errorf("ParseAddrs = %q, %v", got, err)
+	}
+	for _, s := range []string{"", ",", " , "} {
+		if _, err := ParseAddrs(s); err == nil {
+			t.Errorf("ParseAddrs(%q) found addresses", s)
+		}
+	}
+}
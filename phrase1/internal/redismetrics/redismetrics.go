@@ -0,0 +1,94 @@
+// Package redismetrics is a go-redis hook that records command latency and
+// errors, so Redis trouble shows up as metrics rather than only as log lines.
+package redismetrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// buckets reach below the millisecond a healthy local Redis answers in.
+var buckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Hook implements redis.Hook. Pipelines are recorded once, as command
+// "pipeline" (or "multi" for transactions), and failed connection attempts as
+// command "dial".
+type Hook struct {
+	duration *metrics.Histogram
+	errors   *metrics.Counter
+}
+
+// Instrument registers the metrics on reg and adds the hook to client.
+func Instrument(client *redis.Client, reg *metrics.Registry) *Hook {
+	h := &Hook{
+		duration: reg.NewHistogram("redis_command_duration_seconds", "Redis command latency, including blocking time for BLPOP and friends.", buckets, "command"),
+		errors:   reg.NewCounter("redis_command_errors_total", "Redis commands that failed, by command and kind (timeout, network, server).", "command", "kind"),
+	}
+	client.AddHook(h)
+	return h
+}
+
+func (h *Hook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		h.fail("dial", err)
+		return conn, err
+	}
+}
+
+func (h *Hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		name := strings.ToLower(cmd.Name())
+		h.duration.Observe(time.Since(start).Seconds(), name)
+		h.fail(name, err)
+		return err
+	}
+}
+
+func (h *Hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		name := "pipeline"
+		if len(cmds) > 0 && cmds[0].Name() == "multi" {
+			name = "multi"
+		}
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.duration.Observe(time.Since(start).Seconds(), name)
+		h.fail(name, err)
+		return err
+	}
+}
+
+// fail counts err unless it isn't a failure: a nil reply (empty BLPOP, missing
+// key) or the caller giving up.
+func (h *Hook) fail(name string, err error) {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return
+	}
+	h.errors.Inc(name, kind(err))
+}
+
+func kind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr), errors.Is(err, redis.ErrClosed), errors.Is(err, net.ErrClosed):
+		return "network"
+	default:
+		var redisErr redis.Error
+		if errors.As(err, &redisErr) {
+			return "server"
+		}
+		return "network"
+	}
+}
@@ -0,0 +1,80 @@
+// Package resource describes this process the way OpenTelemetry resources
+// do (service.name, k8s.pod.name, ...), so every signal it exports carries
+// the same identity and a backend can join logs and metrics on it.
+package resource
+
+import (
+	"sort"
+	"strings"
+
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/podinfo"
+)
+
+// Attribute is one resource attribute using OpenTelemetry semantic
+// convention keys.
+type Attribute struct {
+	Key, Value string
+}
+
+type Resource []Attribute
+
+// New builds the resource of a service. extra holds attributes in the
+// OTEL_RESOURCE_ATTRIBUTES format ("key=value,key=value") and overrides the
+// derived ones.
+func New(service, version, instance string, pod podinfo.Identity, extra string) Resource {
+	attrs := map[string]string{
+		"service.name":        service,
+		"service.version":     version,
+		"service.instance.id": instance,
+		"k8s.pod.name":        pod.Pod,
+		"k8s.namespace.name":  pod.Namespace,
+		"k8s.node.name":       pod.Node,
+	}
+	for _, kv := range strings.Split(extra, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); ok && k != "" {
+			attrs[k] = strings.TrimSpace(v)
+		}
+	}
+	var r Resource
+	for k, v := range attrs {
+		if v != "" {
+			r = append(r, Attribute{Key: k, Value: v})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Key < r[j].Key })
+	return r
+}
+
+// Get returns the value of key, or "".
+func (r Resource) Get(key string) string {
+	for _, a := range r {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Register exports r as the constant target_info gauge, which is how
+// OpenTelemetry maps resource attributes onto Prometheus metrics (dots become
+// underscores).
+func Register(reg *metrics.Registry, r Resource) {
+	names := make([]string, len(r))
+	values := make([]string, len(r))
+	for i, a := range r {
+		names[i] = labelName(a.Key)
+		values[i] = a.Value
+	}
+	reg.NewGauge("target_info", "Resource attributes of this process; always 1.", names...).Set(1, values...)
+}
+
+func labelName(key string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,160 @@
+// Package rotate rotates, compresses, and prunes an append-only file that
+// other processes keep writing to. Writers are expected to notice the file
+// being moved and open a new one at the same path; a rotated file is only
+// compressed once it has gone unwritten for a while, so a writer that still
+// had it open doesn't lose lines.
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stampLayout is the suffix a rotated file gets, sortable by time.
+const stampLayout = "20060102T150405Z"
+
+// Policy says when the file is rotated and how much of it is kept.
+type Policy struct {
+	// MaxBytes rotates the file once it's this big; 0 never rotates.
+	MaxBytes int64
+	// Settle is how long a rotated file must go unwritten before it's
+	// compressed.
+	Settle time.Duration
+	// Keep is how many rotated files are kept, newest first; 0 keeps all.
+	Keep int
+}
+
+// Result reports what a pass did.
+type Result struct {
+	// Rotated is where the file was moved, if it was.
+	Rotated string
+	// Compressed files saved SavedBytes; Removed files freed RemovedBytes.
+	Compressed   int
+	SavedBytes   int64
+	Removed      int
+	RemovedBytes int64
+}
+
+// Reclaimed is the disk space the pass freed.
+func (r Result) Reclaimed() int64 {
+	return r.SavedBytes + r.RemovedBytes
+}
+
+// Run makes one pass over path and its rotated files: it compresses the ones
+// that have settled, rotates path if it's due, and removes the oldest beyond
+// p.Keep. It stops at the first error, returning what it did until then.
+func Run(path string, p Policy, now time.Time) (Result, error) {
+	var res Result
+	rotated, err := list(path)
+	if err != nil {
+		return res, err
+	}
+	for i, name := range rotated {
+		if strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return res, err
+		}
+		if now.Sub(info.ModTime()) < p.Settle {
+			continue
+		}
+		size, err := compress(name)
+		if err != nil {
+			return res, err
+		}
+		res.Compressed++
+		res.SavedBytes += info.Size() - size
+		rotated[i] = name + ".gz"
+	}
+
+	if info, err := os.Stat(path); err == nil && p.MaxBytes > 0 && info.Size() >= p.MaxBytes {
+		res.Rotated = path + "." + now.UTC().Format(stampLayout)
+		if err := os.Rename(path, res.Rotated); err != nil {
+			res.Rotated = ""
+			return res, err
+		}
+		rotated = append(rotated, res.Rotated)
+	} else if err != nil && !os.IsNotExist(err) {
+		return res, err
+	}
+
+	if p.Keep <= 0 || len(rotated) <= p.Keep {
+		return res, nil
+	}
+	for _, name := range rotated[:len(rotated)-p.Keep] {
+		info, err := os.Stat(name)
+		if err != nil {
+			return res, err
+		}
+		if err := os.Remove(name); err != nil {
+			return res, err
+		}
+		res.Removed++
+		res.RemovedBytes += info.Size()
+	}
+	return res, nil
+}
+
+// list returns path's rotated files, compressed or not, oldest first.
+func list(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		if _, err := time.Parse(stampLayout, stamp); err == nil {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// compress replaces name with name.gz and returns the compressed size.
+func compress(name string) (int64, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp := name + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, name+".gz"); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Remove(name)
+}
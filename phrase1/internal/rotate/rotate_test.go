@@ -0,0 +1,124 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processed.log")
+	start := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+	p := Policy{MaxBytes: 100, Settle: time.Minute, Keep: 2}
+	line := strings.Repeat("x", 63) + "\n"
+
+	write := func(n int) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for i := 0; i < n; i++ {
+			if _, err := f.WriteString(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Under MaxBytes: nothing to do.
+	write(1)
+	res, err := Run(path, p, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{}) {
+		t.Fatalf("small file: %+v", res)
+	}
+
+	// Over it: rotated, but not compressed before it settles.
+	write(9)
+	res, err = Run(path, p, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := path + ".20260302T090000Z"; res.Rotated != want {
+		t.Fatalf("rotated to %q, want %q", res.Rotated, want)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("live file still there: %v", err)
+	}
+	if res.Compressed != 0 {
+		t.Fatalf("compressed before settling: %+v", res)
+	}
+
+	// Once it has settled, it's compressed.
+	old := start.Add(-time.Hour)
+	if err := os.Chtimes(res.Rotated, old, old); err != nil {
+		t.Fatal(err)
+	}
+	res, err = Run(path, p, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Compressed != 1 || res.SavedBytes <= 0 || res.Rotated != "" {
+		t.Fatalf("settled: %+v", res)
+	}
+	f, err := os.Open(path + ".20260302T090000Z.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat(line, 10); string(got) != want {
+		t.Fatalf("decompressed %d bytes, want %d", len(got), len(want))
+	}
+
+	// Two more rotations leave three rotated files; the oldest goes.
+	for i := 1; i <= 2; i++ {
+		write(2)
+		if res, err = Run(path, p, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res.Removed != 1 || res.RemovedBytes <= 0 {
+		t.Fatalf("prune: %+v", res)
+	}
+	rotated, err := list(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path + ".20260302T100000Z", path + ".20260302T110000Z"}
+	if strings.Join(rotated, " ") != strings.Join(want, " ") {
+		t.Fatalf("kept %v, want %v", rotated, want)
+	}
+}
+
+func TestListIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processed.log")
+	for _, name := range []string{"processed.log.bak", "processed.log.20260302T090000Z.gz.tmp", "processed.log.20260302T090000Z"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := list(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 || rotated[0] != path+".20260302T090000Z" {
+		t.Fatalf("list: %v", rotated)
+	}
+}
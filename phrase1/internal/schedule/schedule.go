@@ -0,0 +1,423 @@
+// Package schedule keeps recurring enqueues: a message and a cron
+// expression, enqueued each time the expression comes round. Schedules live
+// in Redis, where the api's /schedules changes them at runtime and the worker
+// holding the queue's scheduler lease fires them.
+package schedule
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"learn_k8s/phrase1/internal/cron"
+)
+
+var (
+	// ErrNotFound is returned for an unknown schedule ID.
+	ErrNotFound = errors.New("no such schedule")
+	// ErrInvalid wraps errors for schedules that can't be saved as asked.
+	ErrInvalid = errors.New("invalid schedule")
+)
+
+// What a schedule does about runs it missed by more than the scheduler's
+// grace, because no scheduler was running or Redis was down.
+const (
+	// MisfireFireOnce enqueues one message for all the missed runs, then
+	// carries on from the next one. It's the default.
+	MisfireFireOnce = "fire_once"
+	// MisfireSkip enqueues nothing for them.
+	MisfireSkip = "skip"
+	// MisfireCatchUpAll enqueues a message for each of them, oldest first,
+	// up to the scheduler's catch-up limit.
+	MisfireCatchUpAll = "catch_up_all"
+)
+
+// MetaScheduledAt is the envelope metadata key holding the time, in
+// RFC 3339, of the run a scheduled message was enqueued for, so handlers can
+// tell caught-up runs apart.
+const MetaScheduledAt = "scheduled_at"
+
+// Schedule is a recurring enqueue.
+type Schedule struct {
+	ID string `json:"id"`
+	// Cron is a five-field expression (see internal/cron), read in
+	// Timezone, an IANA name; UTC if empty.
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"`
+	// Message is the payload enqueued on each run.
+	Message string `json:"message"`
+	Misfire string `json:"misfire,omitempty"`
+	// Paused schedules keep their definition but don't run.
+	Paused    bool      `json:"paused,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// NextRun and LastRun are filled in when a schedule is read. NextRun is
+	// nil while it's paused, or if its expression never comes round again.
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *Run       `json:"last_run,omitempty"`
+}
+
+// Run is what the scheduler did when a schedule came round.
+type Run struct {
+	// At is when the run was due, and FiredAt when the scheduler got to it.
+	At      time.Time `json:"at"`
+	FiredAt time.Time `json:"fired_at"`
+	// MessageID is the envelope ID of the message enqueued for the latest
+	// run; empty if nothing was enqueued.
+	MessageID string `json:"message_id,omitempty"`
+	// Missed counts the runs that came due after At while it waited, and
+	// Skipped says At was missed too and, as MisfireSkip asks, nothing was
+	// enqueued. CaughtUp counts the messages MisfireCatchUpAll enqueued
+	// beyond the first, one per missed run.
+	Missed   int    `json:"missed,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	CaughtUp int    `json:"caught_up,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Spec parses s's expression and time zone.
+func (s Schedule) Spec() (cron.Schedule, *time.Location, error) {
+	spec, err := cron.Parse(s.Cron)
+	if err != nil {
+		return cron.Schedule{}, nil, err
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return cron.Schedule{}, nil, fmt.Errorf("timezone %q: %w", s.Timezone, err)
+	}
+	return spec, loc, nil
+}
+
+// Next is when s next runs after t; ok is false if it never does.
+func (s Schedule) Next(t time.Time) (next time.Time, ok bool) {
+	spec, loc, err := s.Spec()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return spec.Next(t.In(loc))
+}
+
+// Missed returns the runs of s due after at and by now, oldest first, up to
+// limit.
+func (s Schedule) Missed(at, now time.Time, limit int) []time.Time {
+	var runs []time.Time
+	for len(runs) < limit {
+		next, ok := s.Next(at)
+		if !ok || next.After(now) {
+			break
+		}
+		runs = append(runs, next.UTC())
+		at = next
+	}
+	return runs
+}
+
+func (s Schedule) validate() error {
+	if strings.TrimSpace(s.Message) == "" {
+		return fmt.Errorf("%w: message is required", ErrInvalid)
+	}
+	if _, _, err := s.Spec(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	switch s.Misfire {
+	case MisfireFireOnce, MisfireSkip, MisfireCatchUpAll:
+	default:
+		return fmt.Errorf("%w: misfire must be %s, %s, or %s", ErrInvalid, MisfireFireOnce, MisfireSkip, MisfireCatchUpAll)
+	}
+	return nil
+}
+
+// Due is a schedule whose next run has come.
+type Due struct {
+	ID string
+	At time.Time
+}
+
+// Store keeps the schedules of one queue in Redis: definitions in the hash
+// <queue>:schedules, one field per ID, next runs in the sorted set
+// <queue>:schedules:due, and last runs in the hash <queue>:schedules:runs.
+type Store struct {
+	client *redis.Client
+	prefix string
+	now    func() time.Time
+}
+
+// NewStore returns the store for queue's schedules.
+func NewStore(client *redis.Client, queue string) *Store {
+	return &Store{client: client, prefix: queue + ":schedules", now: time.Now}
+}
+
+func (s *Store) dueKey() string  { return s.prefix + ":due" }
+func (s *Store) runsKey() string { return s.prefix + ":runs" }
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create saves sch as a new schedule, with an ID of its own, and returns it
+// with its next run.
+func (s *Store) Create(ctx context.Context, sch Schedule) (Schedule, error) {
+	id, err := newID()
+	if err != nil {
+		return Schedule{}, err
+	}
+	sch.ID = id
+	sch.CreatedAt = s.now().UTC()
+	return s.save(ctx, sch)
+}
+
+// Update replaces schedule id's definition with sch, keeping its ID and
+// creation time. Its next run is worked out afresh from now, so runs
+// missed before the update aren't made up.
+func (s *Store) Update(ctx context.Context, id string, sch Schedule) (Schedule, error) {
+	old, err := s.Get(ctx, id)
+	if err != nil {
+		return Schedule{}, err
+	}
+	sch.ID, sch.CreatedAt = old.ID, old.CreatedAt
+	sch.LastRun = old.LastRun
+	return s.save(ctx, sch)
+}
+
+func (s *Store) save(ctx context.Context, sch Schedule) (Schedule, error) {
+	if sch.Misfire == "" {
+		sch.Misfire = MisfireFireOnce
+	}
+	if err := sch.validate(); err != nil {
+		return Schedule{}, err
+	}
+	now := s.now()
+	sch.UpdatedAt = now.UTC()
+	sch.NextRun = nil
+	if next, ok := sch.Next(now); ok && !sch.Paused {
+		sch.NextRun = &next
+	}
+	def := sch
+	def.NextRun, def.LastRun = nil, nil
+	b, err := json.Marshal(def)
+	if err != nil {
+		return Schedule{}, err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, s.prefix, sch.ID, b)
+		if sch.NextRun != nil {
+			p.ZAdd(ctx, s.dueKey(), redis.Z{Score: float64(sch.NextRun.UnixMilli()), Member: sch.ID})
+		} else {
+			p.ZRem(ctx, s.dueKey(), sch.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Schedule{}, err
+	}
+	return sch, nil
+}
+
+// Get returns schedule id.
+func (s *Store) Get(ctx context.Context, id string) (Schedule, error) {
+	var def *redis.StringCmd
+	var next *redis.FloatCmd
+	var run *redis.StringCmd
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		def = p.HGet(ctx, s.prefix, id)
+		next = p.ZScore(ctx, s.dueKey(), id)
+		run = p.HGet(ctx, s.runsKey(), id)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return Schedule{}, err
+	}
+	if errors.Is(def.Err(), redis.Nil) {
+		return Schedule{}, ErrNotFound
+	}
+	var sch Schedule
+	if err := json.Unmarshal([]byte(def.Val()), &sch); err != nil {
+		return Schedule{}, fmt.Errorf("schedule %s: %w", id, err)
+	}
+	if next.Err() == nil {
+		at := time.UnixMilli(int64(next.Val())).UTC()
+		sch.NextRun = &at
+	}
+	if run.Err() == nil {
+		var r Run
+		if json.Unmarshal([]byte(run.Val()), &r) == nil {
+			sch.LastRun = &r
+		}
+	}
+	return sch, nil
+}
+
+// List returns every schedule, oldest first. Schedules that don't decode
+// are skipped.
+func (s *Store) List(ctx context.Context) ([]Schedule, error) {
+	var defs *redis.MapStringStringCmd
+	var due *redis.ZSliceCmd
+	var runs *redis.MapStringStringCmd
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		defs = p.HGetAll(ctx, s.prefix)
+		due = p.ZRangeWithScores(ctx, s.dueKey(), 0, -1)
+		runs = p.HGetAll(ctx, s.runsKey())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	next := make(map[string]time.Time, len(due.Val()))
+	for _, z := range due.Val() {
+		if id, ok := z.Member.(string); ok {
+			next[id] = time.UnixMilli(int64(z.Score)).UTC()
+		}
+	}
+	out := make([]Schedule, 0, len(defs.Val()))
+	for id, src := range defs.Val() {
+		var sch Schedule
+		if err := json.Unmarshal([]byte(src), &sch); err != nil || sch.ID != id {
+			continue
+		}
+		if at, ok := next[id]; ok {
+			sch.NextRun = &at
+		}
+		var r Run
+		if src, ok := runs.Val()[id]; ok && json.Unmarshal([]byte(src), &r) == nil {
+			sch.LastRun = &r
+		}
+		out = append(out, sch)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// Delete removes schedule id. A run the scheduler has already claimed may
+// still be enqueued.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	var n *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		n = p.HDel(ctx, s.prefix, id)
+		p.ZRem(ctx, s.dueKey(), id)
+		p.HDel(ctx, s.runsKey(), id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Due returns up to limit schedules whose next run is due by now, earliest
+// first.
+func (s *Store) Due(ctx context.Context, now time.Time, limit int) ([]Due, error) {
+	zs, err := s.client.ZRangeByScoreWithScores(ctx, s.dueKey(), &redis.ZRangeBy{
+		Min: "-inf", Max: fmt.Sprint(now.UnixMilli()), Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Due, 0, len(zs))
+	for _, z := range zs {
+		if id, ok := z.Member.(string); ok {
+			out = append(out, Due{ID: id, At: time.UnixMilli(int64(z.Score)).UTC()})
+		}
+	}
+	return out, nil
+}
+
+// claimScript moves schedule ARGV[1]'s next run in KEYS[1] from ARGV[2] to
+// ARGV[3], or drops it if ARGV[3] is empty, provided it's still due at
+// ARGV[2]. It returns 1 if it moved it.
+var claimScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+if ARGV[3] == "" then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+else
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// Claim takes the run of d, moving the schedule on to next, or to no
+// next run if ok is false. It reports false if the run isn't d's any more:
+// another scheduler claimed it, or the schedule was changed or deleted
+// meanwhile. It's atomic, so a run is claimed once however many schedulers
+// overlap.
+func (s *Store) Claim(ctx context.Context, d Due, next time.Time, ok bool) (bool, error) {
+	nextArg := ""
+	if ok {
+		nextArg = fmt.Sprint(next.UnixMilli())
+	}
+	n, err := claimScript.Run(ctx, s.client, []string{s.dueKey()}, d.ID, d.At.UnixMilli(), nextArg).Int()
+	return n == 1, err
+}
+
+// releaseScript puts schedule ARGV[1]'s next run in KEYS[2] back at
+// ARGV[2], undoing a claim that moved it on to ARGV[3] (or dropped it, if
+// ARGV[3] is empty), provided it's still where the claim left it and the
+// schedule is still defined in KEYS[1]. It returns 1 if it put it back.
+var releaseScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local score = redis.call("ZSCORE", KEYS[2], ARGV[1])
+if ARGV[3] == "" then
+	if score then
+		return 0
+	end
+elseif not score or tonumber(score) ~= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// Release undoes Claim(ctx, d, next, ok), so d's run is due again and
+// claimed on the next tick: for a run whose enqueue failed. It reports
+// false if the schedule was changed or deleted since the claim, leaving
+// the run to the change.
+func (s *Store) Release(ctx context.Context, d Due, next time.Time, ok bool) (bool, error) {
+	nextArg := ""
+	if ok {
+		nextArg = fmt.Sprint(next.UnixMilli())
+	}
+	n, err := releaseScript.Run(ctx, s.client, []string{s.prefix, s.dueKey()}, d.ID, d.At.UnixMilli(), nextArg).Int()
+	return n == 1, err
+}
+
+// recordScript sets schedule ARGV[1]'s last run in KEYS[2] to ARGV[2] if
+// it's still defined in KEYS[1].
+var recordScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+end
+return 0
+`)
+
+// Record saves r as schedule id's last run.
+func (s *Store) Record(ctx context.Context, id string, r Run) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return recordScript.Run(ctx, s.client, []string{s.prefix, s.runsKey()}, id, b).Err()
+}
@@ -0,0 +1,64 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		sch Schedule
+		ok  bool
+	}{
+		{Schedule{Cron: "*/5 * * * *", Message: "tick", Misfire: MisfireFireOnce}, true},
+		{Schedule{Cron: "0 9 * * 1-5", Timezone: "Europe/Berlin", Message: "report", Misfire: MisfireSkip}, true},
+		{Schedule{Cron: "*/5 * * * *", Message: " ", Misfire: MisfireFireOnce}, false},
+		{Schedule{Cron: "*/5 * * *", Message: "tick", Misfire: MisfireFireOnce}, false},
+		{Schedule{Cron: "*/5 * * * *", Timezone: "Mars/Olympus", Message: "tick", Misfire: MisfireFireOnce}, false},
+		{Schedule{Cron: "*/5 * * * *", Message: "tick", Misfire: MisfireCatchUpAll}, true},
+		{Schedule{Cron: "*/5 * * * *", Message: "tick", Misfire: "all"}, false},
+	} {
+		err := tc.sch.validate()
+		if tc.ok && err != nil {
+			t.Errorf("%+v: %v", tc.sch, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: got %v, want ErrInvalid", tc.sch, err)
+		}
+	}
+}
+
+func TestNextInTimezone(t *testing.T) {
+	sch := Schedule{Cron: "0 9 * * *", Timezone: "Asia/Tokyo"}
+	if _, _, err := sch.Spec(); err != nil {
+		t.Skip(err)
+	}
+	from := time.Date(2026, time.March, 2, 0, 30, 0, 0, time.UTC) // 09:30 in Tokyo
+	got, ok := sch.Next(from)
+	if want := time.Date(2026, time.March, 3, 0, 0, 0, 0, time.UTC); !ok || !got.Equal(want) {
+		t.Errorf("next %s %t, want %s", got, ok, want)
+	}
+}
+
+func TestMissed(t *testing.T) {
+	sch := Schedule{Cron: "*/10 * * * *"}
+	at := time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		now  time.Time
+		want int
+	}{
+		{at.Add(5 * time.Minute), 0},
+		{at.Add(10 * time.Minute), 1},
+		{at.Add(35 * time.Minute), 3},
+		{at.Add(24 * time.Hour), 5},
+	} {
+		if got := sch.Missed(at, tc.now, 5); len(got) != tc.want {
+			t.Errorf("missed by %s: %q, want %d", tc.now.Sub(at), got, tc.want)
+		}
+	}
+	got := sch.Missed(at, at.Add(25*time.Minute), 5)
+	if len(got) != 2 || !got[0].Equal(at.Add(10*time.Minute)) || !got[1].Equal(at.Add(20*time.Minute)) {
+		t.Errorf("missed runs %q", got)
+	}
+}
@@ -0,0 +1,248 @@
+// Package schema validates message payloads against JSON Schemas registered
+// per queue, either from a config file at startup or at runtime through the
+// api. Runtime registrations live in a Redis hash so every api replica sees
+// them.
+package schema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ErrInvalidSchema wraps errors for schemas that don't compile.
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// FieldError is one reason a payload failed validation. Path is a JSON
+// pointer into the payload ("" for the payload itself).
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Validate when a payload doesn't match.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Path + ": " + f.Message
+	}
+	return "payload does not match schema: " + strings.Join(parts, "; ")
+}
+
+type entry struct {
+	source json.RawMessage
+	schema *jsonschema.Schema
+}
+
+type Registry struct {
+	client *redis.Client
+	key    string
+
+	mu sync.RWMutex
+	// file holds schemas from LoadFile; registered ones from Redis take
+	// precedence over them.
+	file       map[string]entry
+	registered map[string]entry
+}
+
+// NewRegistry keeps runtime registrations in the Redis hash key, one field
+// per queue.
+func NewRegistry(client *redis.Client, key string) *Registry {
+	return &Registry{client: client, key: key, file: map[string]entry{}, registered: map[string]entry{}}
+}
+
+func compile(queue string, src []byte) (entry, error) {
+	c := jsonschema.NewCompiler()
+	url := "queue://" + queue + ".json"
+	if err := c.AddResource(url, bytes.NewReader(src)); err != nil {
+		return entry{}, fmt.Errorf("%w for %s: %v", ErrInvalidSchema, queue, err)
+	}
+	s, err := c.Compile(url)
+	if err != nil {
+		return entry{}, fmt.Errorf("%w for %s: %v", ErrInvalidSchema, queue, err)
+	}
+	return entry{source: json.RawMessage(src), schema: s}, nil
+}
+
+// LoadFile reads a JSON object mapping queue names to schemas.
+func (r *Registry) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var byQueue map[string]json.RawMessage
+	if err := json.Unmarshal(b, &byQueue); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	file := make(map[string]entry, len(byQueue))
+	for queue, src := range byQueue {
+		e, err := compile(queue, src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		file[queue] = e
+	}
+	r.mu.Lock()
+	r.file = file
+	r.mu.Unlock()
+	return nil
+}
+
+// Put compiles and registers the schema for queue. An invalid schema is
+// rejected without replacing the current one.
+func (r *Registry) Put(ctx context.Context, queue string, src []byte) error {
+	e, err := compile(queue, src)
+	if err != nil {
+		return err
+	}
+	if err := r.client.HSet(ctx, r.key, queue, string(src)).Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.registered[queue] = e
+	r.mu.Unlock()
+	return nil
+}
+
+// Delete removes the runtime registration for queue, falling back to the
+// file schema if there is one. It reports whether anything was removed.
+func (r *Registry) Delete(ctx context.Context, queue string) (bool, error) {
+	n, err := r.client.HDel(ctx, r.key, queue).Result()
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	delete(r.registered, queue)
+	r.mu.Unlock()
+	return n > 0, nil
+}
+
+func (r *Registry) lookup(queue string) (entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e, ok := r.registered[queue]; ok {
+		return e, true
+	}
+	e, ok := r.file[queue]
+	return e, ok
+}
+
+// Get returns the schema source in effect for queue.
+func (r *Registry) Get(queue string) (json.RawMessage, bool) {
+	e, ok := r.lookup(queue)
+	return e.source, ok
+}
+
+// All returns the schema sources in effect, by queue.
+func (r *Registry) All() map[string]json.RawMessage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]json.RawMessage, len(r.file)+len(r.registered))
+	for q, e := range r.file {
+		out[q] = e.source
+	}
+	for q, e := range r.registered {
+		out[q] = e.source
+	}
+	return out
+}
+
+// Refresh reloads runtime registrations from Redis, picking up changes made
+// through other replicas. Schemas that no longer compile are skipped and
+// reported in the returned error.
+func (r *Registry) Refresh(ctx context.Context) error {
+	all, err := r.client.HGetAll(ctx, r.key).Result()
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]entry, len(all))
+	var bad []string
+	for queue, src := range all {
+		e, err := compile(queue, []byte(src))
+		if err != nil {
+			bad = append(bad, err.Error())
+			continue
+		}
+		registered[queue] = e
+	}
+	r.mu.Lock()
+	r.registered = registered
+	r.mu.Unlock()
+	if len(bad) > 0 {
+		return fmt.Errorf("skipped invalid schemas: %s", strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// Run refreshes every interval until ctx is canceled.
+func (r *Registry) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Validate checks payload against the schema for queue. Queues without a
+// schema accept anything. A mismatch returns a *ValidationError.
+func (r *Registry) Validate(queue string, payload []byte) error {
+	e, ok := r.lookup(queue)
+	if !ok {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		msg := "payload is not valid JSON"
+		if err != nil {
+			msg += ": " + err.Error()
+		}
+		return &ValidationError{Fields: []FieldError{{Path: "", Message: msg}}}
+	}
+	err := e.schema.Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+	var fields []FieldError
+	collectLeaves(ve, &fields)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return &ValidationError{Fields: fields}
+}
+
+// collectLeaves flattens the error tree to its most specific causes, which
+// are the ones that point at a field.
+func collectLeaves(ve *jsonschema.ValidationError, out *[]FieldError) {
+	if len(ve.Causes) == 0 {
+		*out = append(*out, FieldError{Path: ve.InstanceLocation, Message: ve.Message})
+		return
+	}
+	for _, c := range ve.Causes {
+		collectLeaves(c, out)
+	}
+}
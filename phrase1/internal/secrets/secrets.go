@@ -0,0 +1,114 @@
+// Package secrets keeps credentials and signing keys current while they
+// rotate. A secret's value comes from, in rising precedence, the
+// environment, a file (typically a mounted Kubernetes Secret kept up to date
+// by External Secrets or the Secrets Store CSI driver), and HashiCorp
+// Vault. The Store reads them again periodically and reports which changed.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds the current value of each secret.
+type Store struct {
+	static map[string]string
+	files  map[string]string
+	vault  *Vault
+
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// New returns a Store of the secrets named in static, with their values
+// there as the fallback. files maps names to files whose contents, minus a
+// trailing newline, override them; a Vault secret's fields of the same names
+// override both. vault may be nil. Call Refresh before using it.
+func New(static, files map[string]string, vault *Vault) *Store {
+	return &Store{static: static, files: files, vault: vault, values: map[string]string{}}
+}
+
+// Get returns the current value of name.
+func (s *Store) Get(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[name]
+}
+
+// RedisCredentials returns REDIS_USERNAME and REDIS_PASSWORD; it fits
+// redis.Options.CredentialsProviderContext, so every new connection
+// authenticates with the current credentials.
+func (s *Store) RedisCredentials(context.Context) (string, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values["REDIS_USERNAME"], s.values["REDIS_PASSWORD"], nil
+}
+
+// Refresh reads every source again and returns the names of the secrets
+// whose values changed, sorted. If any source fails, the values stay as
+// they were.
+func (s *Store) Refresh(ctx context.Context) ([]string, error) {
+	next := make(map[string]string, len(s.static))
+	for name, v := range s.static {
+		next[name] = v
+	}
+	for name, path := range s.files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		next[name] = strings.TrimRight(string(b), "\r\n")
+	}
+	if s.vault != nil {
+		fields, err := s.vault.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for name := range s.static {
+			if v, ok := fields[name]; ok {
+				next[name] = v
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []string
+	for name, v := range next {
+		if s.values[name] != v {
+			changed = append(changed, name)
+		}
+	}
+	s.values = next
+	slices.Sort(changed)
+	return changed, nil
+}
+
+// Run refreshes every interval until ctx is canceled, calling onRotate with
+// the secrets that changed.
+func (s *Store) Run(ctx context.Context, interval time.Duration, onRotate func(names []string), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.Refresh(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				onError(err)
+			}
+			continue
+		}
+		if len(changed) > 0 {
+			onRotate(changed)
+		}
+	}
+}
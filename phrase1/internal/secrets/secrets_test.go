@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRefreshFiles(t *testing.T) {
+	dir := t.TempDir()
+	password := filepath.Join(dir, "password")
+	if err := os.WriteFile(password, []byte("one\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := New(map[string]string{"REDIS_USERNAME": "app", "REDIS_PASSWORD": "from-env"}, map[string]string{"REDIS_PASSWORD": password}, nil)
+	ctx := context.Background()
+	if changed, err := s.Refresh(ctx); err != nil || !reflect.DeepEqual(changed, []string{"REDIS_PASSWORD", "REDIS_USERNAME"}) {
+		t.Fatalf("first refresh: %v, %v", changed, err)
+	}
+	if user, pass, _ := s.RedisCredentials(ctx); user != "app" || pass != "one" {
+		t.Errorf("credentials %q %q, want the env username and the file's password", user, pass)
+	}
+
+	if changed, err := s.Refresh(ctx); err != nil || changed != nil {
+		t.Errorf("unchanged refresh: %v, %v", changed, err)
+	}
+	if err := os.WriteFile(password, []byte("two"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Refresh(ctx); err != nil || !reflect.DeepEqual(changed, []string{"REDIS_PASSWORD"}) {
+		t.Errorf("rotated refresh: %v, %v", changed, err)
+	}
+	if got := s.Get("REDIS_PASSWORD"); got != "two" {
+		t.Errorf("password %q after rotation, want two", got)
+	}
+
+	if err := os.Remove(password); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(ctx); err == nil {
+		t.Error("refresh with the file gone succeeded")
+	}
+	if got := s.Get("REDIS_PASSWORD"); got != "two" {
+		t.Errorf("password %q after a failed refresh, want the last one", got)
+	}
+}
+
+func TestVaultKubernetesLogin(t *testing.T) {
+	jwt := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwt, []byte("sa-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var logins atomic.Int32
+	var valid atomic.Value
+	password := "from-vault"
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var req map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req["role"] != "queue" || req["jwt"] != "sa-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			token := fmt.Sprintf("t%d", logins.Add(1))
+			valid.Store(token)
+			_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 3600}})
+		case "/v1/secret/data/queue":
+			if r.Header.Get("X-Vault-Token") != valid.Load() {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data":     map[string]any{"REDIS_PASSWORD": password, "version": 3},
+				"metadata": map[string]any{"version": 3},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	v := &Vault{Addr: vault.URL, Path: "secret/data/queue", Role: "queue", JWTFile: jwt}
+	s := New(map[string]string{"REDIS_PASSWORD": "from-env"}, nil, v)
+	ctx := context.Background()
+	if _, err := s.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Get("REDIS_PASSWORD"); got != "from-vault" {
+		t.Errorf("password %q, want from-vault", got)
+	}
+
+	// Vault revokes the login and the secret rotates.
+	valid.Store("revoked")
+	password = "rotated"
+	changed, err := s.Refresh(ctx)
+	if err != nil || !reflect.DeepEqual(changed, []string{"REDIS_PASSWORD"}) {
+		t.Fatalf("refresh after revocation: %v, %v", changed, err)
+	}
+	if n := logins.Load(); n != 2 {
+		t.Errorf("%d logins, want one more after the token was refused", n)
+	}
+	if got := s.Get("REDIS_PASSWORD"); got != "rotated" {
+		t.Errorf("password %q, want rotated", got)
+	}
+}
@@ -0,0 +1,175 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWTFile is where Kubernetes mounts a pod's service account token.
+const DefaultJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Vault reads secrets from one Vault KV secret over Vault's HTTP API. It
+// authenticates with Token or, if Role is set, by logging in with the pod's
+// service account token through the Kubernetes auth method, and logs in
+// again when that token expires or is refused.
+type Vault struct {
+	// Addr is Vault's address, e.g. https://vault.vault:8200.
+	Addr string
+	// Path is the secret's API path without /v1/: mount/data/name for KV
+	// version 2, mount/name for version 1.
+	Path string
+	// Token is a Vault token to use as is.
+	Token string
+	// Role is the Kubernetes auth role to log in as; AuthMount is where
+	// that auth method is mounted (default "kubernetes"), JWTFile the
+	// service account token (default DefaultJWTFile).
+	Role      string
+	AuthMount string
+	JWTFile   string
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// errDenied is Vault refusing a token.
+var errDenied = errors.New("permission denied")
+
+// Read returns the string fields of the secret.
+func (v *Vault) Read(ctx context.Context) (map[string]string, error) {
+	token, err := v.login(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := v.read(ctx, token)
+	if errors.Is(err, errDenied) && v.Role != "" {
+		// A revoked or expired login; one more try with a new one.
+		if token, err = v.login(ctx, true); err != nil {
+			return nil, err
+		}
+		fields, err = v.read(ctx, token)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: %w", v.Path, err)
+	}
+	return fields, nil
+}
+
+func (v *Vault) read(ctx context.Context, token string) (map[string]string, error) {
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, v.Path, token, nil, &body); err != nil {
+		return nil, err
+	}
+	// KV version 2 wraps the fields in data.data, next to data.metadata.
+	data := body.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, v2 := data["metadata"]; v2 {
+			data = inner
+		}
+	}
+	out := make(map[string]string, len(data))
+	for k, val := range data {
+		if s, ok := val.(string); ok {
+			out[k] = s
+		}
+	}
+	return out, nil
+}
+
+// login returns the token to use, logging in with the Kubernetes auth
+// method when there's no static token and the last login is expiring, or
+// when force is set.
+func (v *Vault) login(ctx context.Context, force bool) (string, error) {
+	if v.Role == "" {
+		return v.Token, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !force && v.token != "" && time.Now().Before(v.expires) {
+		return v.token, nil
+	}
+	jwtFile := v.JWTFile
+	if jwtFile == "" {
+		jwtFile = DefaultJWTFile
+	}
+	jwt, err := os.ReadFile(jwtFile)
+	if err != nil {
+		return "", fmt.Errorf("vault login: %w", err)
+	}
+	mount := v.AuthMount
+	if mount == "" {
+		mount = "kubernetes"
+	}
+	req := map[string]string{"role": v.Role, "jwt": strings.TrimSpace(string(jwt))}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.call(ctx, http.MethodPost, "auth/"+mount+"/login", "", req, &resp); err != nil {
+		return "", fmt.Errorf("vault login as %s: %w", v.Role, err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login as %s: no token in the response", v.Role)
+	}
+	// Log in again once most of the lease is used up, rather than
+	// renewing, so a changed role takes effect too.
+	lease := time.Duration(resp.Auth.LeaseDuration) * time.Second
+	v.token, v.expires = resp.Auth.ClientToken, time.Now().Add(lease*4/5)
+	return v.token, nil
+}
+
+func (v *Vault) call(ctx context.Context, method, path, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(v.Addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		if resp.StatusCode == http.StatusForbidden {
+			return errDenied
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.Join(e.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,62 @@
+// Package slo measures end-to-end message latency, from enqueue to the end
+// of processing, against a latency objective.
+package slo
+
+import (
+	"sort"
+	"time"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// latencyBuckets span queueing delays as well as handling time, in seconds.
+var latencyBuckets = []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// Latency records end-to-end latencies. Messages slower than the objective
+// are also counted separately, so the error ratio for burn-rate alerts is
+// breaches / histogram count without depending on bucket boundaries.
+type Latency struct {
+	objective time.Duration
+	hist      *metrics.Histogram
+	breaches  *metrics.Counter
+}
+
+// NewLatency registers the latency metrics. The objective is added to the
+// histogram buckets if it isn't one of them, and is exported along with the
+// target ratio so alert rules don't hardcode either.
+func NewLatency(reg *metrics.Registry, objective time.Duration, target float64) *Latency {
+	buckets := latencyBuckets
+	if s := objective.Seconds(); s > 0 {
+		i := sort.SearchFloat64s(buckets, s)
+		if i == len(buckets) || buckets[i] != s {
+			buckets = append(append(append([]float64(nil), buckets[:i]...), s), buckets[i:]...)
+		}
+	}
+	reg.NewGaugeFunc("queue_latency_slo_objective_seconds", "End-to-end latency objective.", objective.Seconds)
+	reg.NewGaugeFunc("queue_latency_slo_target_ratio", "Share of messages that should meet the latency objective.", func() float64 { return target })
+	return &Latency{
+		objective: objective,
+		hist:      reg.NewHistogram("queue_end_to_end_latency_seconds", "Time from enqueue to the end of processing.", buckets, "queue"),
+		breaches:  reg.NewCounter("queue_latency_slo_breaches_total", "Processed messages slower than the latency objective.", "queue"),
+	}
+}
+
+// Observe records a message enqueued at enqueuedAt that finished at done.
+// Messages without an enqueue time (raw legacy payloads) are ignored; clock
+// skew between producer and worker is clamped to zero.
+func (l *Latency) Observe(queue string, enqueuedAt, done time.Time) {
+	if l == nil || enqueuedAt.IsZero() {
+		return
+	}
+	d := done.Sub(enqueuedAt)
+	if d < 0 {
+		d = 0
+	}
+	l.hist.Observe(d.Seconds(), queue)
+	if l.objective > 0 && d > l.objective {
+		l.breaches.Inc(queue)
+	} else {
+		// Export the series at zero so rate() works before the first breach.
+		l.breaches.Add(0, queue)
+	}
+}
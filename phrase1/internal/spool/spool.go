@@ -0,0 +1,233 @@
+// Package spool is a bounded on-disk buffer for messages that couldn't be
+// enqueued because Redis was unreachable. Records are appended to one file and
+// replayed in order once Redis is back; the replay position is saved after
+// every record, so a restart mid-replay resends at most one message.
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFull is returned by Append when the spool is at its size limit.
+var ErrFull = errors.New("spool is full")
+
+type Record struct {
+	Queue   string
+	Payload string
+}
+
+type Spool struct {
+	maxBytes   int64
+	path       string
+	offsetPath string
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64 // bytes in the file
+	offset int64 // bytes already replayed
+	count  int64 // records not yet replayed
+}
+
+// Open opens or creates the spool in dir, picking up records left by a
+// previous run. maxBytes caps the file size; 0 means no cap.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		maxBytes:   maxBytes,
+		path:       filepath.Join(dir, "spool.log"),
+		offsetPath: filepath.Join(dir, "spool.offset"),
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	if b, err := os.ReadFile(s.offsetPath); err == nil {
+		s.offset, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if err := s.scan(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// scan counts the records past the replay offset.
+func (s *Spool) scan() error {
+	st, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = st.Size()
+	if s.offset > s.size {
+		s.offset = 0
+	}
+	r := bufio.NewReader(io.NewSectionReader(s.f, s.offset, s.size-s.offset))
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) != "" {
+			s.count++
+		}
+	}
+}
+
+func encodeRecord(r Record) string {
+	return base64.StdEncoding.EncodeToString([]byte(r.Queue)) + " " + base64.StdEncoding.EncodeToString([]byte(r.Payload)) + "\n"
+}
+
+func decodeRecord(line string) (Record, error) {
+	q, p, ok := strings.Cut(strings.TrimSpace(line), " ")
+	if !ok {
+		return Record{}, errors.New("malformed spool record")
+	}
+	qb, err := base64.StdEncoding.DecodeString(q)
+	if err != nil {
+		return Record{}, fmt.Errorf("malformed spool record: %w", err)
+	}
+	pb, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return Record{}, fmt.Errorf("malformed spool record: %w", err)
+	}
+	return Record{Queue: string(qb), Payload: string(pb)}, nil
+}
+
+// Append adds r to the end of the spool and syncs it to disk.
+func (s *Spool) Append(r Record) error {
+	line := encodeRecord(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.size+int64(len(line)) > s.maxBytes {
+		return ErrFull
+	}
+	if _, err := s.f.WriteString(line); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(line))
+	s.count++
+	return nil
+}
+
+// Len is the number of records waiting to be replayed.
+func (s *Spool) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Bytes is the size of the spool file.
+func (s *Spool) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// next reads the record at the replay offset, returning its encoded length,
+// or 0 at the end.
+func (s *Spool) next() (Record, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offset >= s.size {
+		return Record{}, 0, nil
+	}
+	line, err := bufio.NewReader(io.NewSectionReader(s.f, s.offset, s.size-s.offset)).ReadString('\n')
+	if err != nil {
+		return Record{}, 0, err
+	}
+	r, err := decodeRecord(line)
+	return r, int64(len(line)), err
+}
+
+// advance moves the replay offset past n bytes, and empties the file once
+// everything is replayed.
+func (s *Spool) advance(n int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += n
+	s.count--
+	if s.offset >= s.size {
+		if err := s.f.Truncate(0); err != nil {
+			return err
+		}
+		s.offset, s.size, s.count = 0, 0, 0
+	}
+	return os.WriteFile(s.offsetPath, []byte(strconv.FormatInt(s.offset, 10)), 0o644)
+}
+
+// Replay sends waiting records in order until the spool is empty or send
+// fails, and returns how many were sent. A failed record stays first in line.
+// A record that can't be decoded is skipped and reported.
+func (s *Spool) Replay(ctx context.Context, send func(ctx context.Context, r Record) error) (int, error) {
+	sent := 0
+	for ctx.Err() == nil {
+		r, n, err := s.next()
+		if n == 0 {
+			return sent, err
+		}
+		if err == nil {
+			err = send(ctx, r)
+			if err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		if aerr := s.advance(n); aerr != nil {
+			return sent, aerr
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, ctx.Err()
+}
+
+// Run replays the spool every interval until ctx is canceled. onReplayed is
+// called after records were sent, onError when a replay stopped early.
+func (s *Spool) Run(ctx context.Context, interval time.Duration, send func(ctx context.Context, r Record) error, onReplayed func(n int), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if s.Len() == 0 {
+			continue
+		}
+		n, err := s.Replay(ctx, send)
+		if n > 0 {
+			onReplayed(n)
+		}
+		if err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+	}
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,89 @@
+// Package statedump writes a snapshot of a process's own state when it gets
+// SIGQUIT, ahead of the goroutine dump the Go runtime prints for that signal,
+// so a stuck process explains what it was doing as well as where.
+package statedump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Dumper collects named sections. Each section func is called at dump time
+// and its result rendered as JSON.
+type Dumper struct {
+	mu       sync.Mutex
+	names    []string
+	sections map[string]func(ctx context.Context) any
+}
+
+func New() *Dumper {
+	return &Dumper{sections: make(map[string]func(ctx context.Context) any)}
+}
+
+// Add registers a section; sections are written in the order added.
+func (d *Dumper) Add(name string, fn func(ctx context.Context) any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.sections[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.sections[name] = fn
+}
+
+// sectionTimeout bounds every section together, since the thing that is
+// stuck (Redis, say) may be what a section asks.
+const sectionTimeout = 2 * time.Second
+
+// Write renders every section to w.
+func (d *Dumper) Write(w io.Writer) {
+	d.mu.Lock()
+	names := append([]string(nil), d.names...)
+	sections := make(map[string]func(ctx context.Context) any, len(d.sections))
+	for k, v := range d.sections {
+		sections[k] = v
+	}
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sectionTimeout)
+	defer cancel()
+	fmt.Fprintf(w, "=== state dump pid=%d at %s ===\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339Nano))
+	for _, name := range names {
+		b, err := json.MarshalIndent(safeCall(ctx, sections[name]), "", "  ")
+		if err != nil {
+			b = []byte(fmt.Sprintf("%q", "unrenderable: "+err.Error()))
+		}
+		fmt.Fprintf(w, "--- %s ---\n%s\n", name, b)
+	}
+	fmt.Fprintf(w, "=== end state dump ===\n")
+}
+
+// safeCall keeps a panicking section from taking the dump down with it.
+func safeCall(ctx context.Context, fn func(ctx context.Context) any) (v any) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			v = fmt.Sprintf("panic: %v", rec)
+		}
+	}()
+	return fn(ctx)
+}
+
+// OnSIGQUIT writes the dump to w on the first SIGQUIT, then hands the signal
+// back to the runtime, which prints all goroutine stacks and exits with
+// status 2 as it would have without this.
+func (d *Dumper) OnSIGQUIT(w io.Writer) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+	go func() {
+		<-quit
+		d.Write(w)
+		signal.Reset(syscall.SIGQUIT)
+		_ = syscall.Kill(os.Getpid(), syscall.SIGQUIT)
+	}()
+}
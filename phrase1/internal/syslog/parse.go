@@ -0,0 +1,122 @@
+// Package syslog parses the header of RFC 5424 and RFC 3164 (BSD) syslog
+// messages well enough to tag queued lines with where they came from.
+package syslog
+
+import (
+	"strconv"
+	"strings"
+)
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+type Message struct {
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	Format   string // "rfc5424", "rfc3164", or "" when there was no header
+	Text     string
+}
+
+func (m Message) SeverityName() string {
+	if m.Severity >= 0 && m.Severity < len(severities) {
+		return severities[m.Severity]
+	}
+	return ""
+}
+
+// Parse never fails: a line without a valid <PRI> header is returned as-is
+// in Text with Format "".
+func Parse(line string) Message {
+	m := Message{Facility: -1, Severity: -1, Text: line}
+	if !strings.HasPrefix(line, "<") {
+		return m
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return m
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return m
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+	rest := line[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parse5424(m, rest[2:])
+	}
+	return parse3164(m, rest)
+}
+
+// parse5424 handles "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG".
+func parse5424(m Message, rest string) Message {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		m.Text = rest
+		return m
+	}
+	m.Format = "rfc5424"
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+	m.Text = skipStructuredData(fields[5])
+	return m
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// skipStructuredData drops "-" or "[id k="v"]..." from the front of s.
+func skipStructuredData(s string) string {
+	if strings.HasPrefix(s, "- ") || s == "-" {
+		return strings.TrimPrefix(strings.TrimPrefix(s, "-"), " ")
+	}
+	depth, inQuote := 0, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && inQuote:
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case c == '[' && !inQuote:
+			depth++
+		case c == ']' && !inQuote:
+			depth--
+			if depth == 0 && (i+1 == len(s) || s[i+1] == ' ') {
+				return strings.TrimPrefix(s[i+1:], " ")
+			}
+		}
+	}
+	return s
+}
+
+// parse3164 handles "Mmm dd hh:mm:ss HOSTNAME TAG: MSG".
+func parse3164(m Message, rest string) Message {
+	const stampLen = len("Jan _2 15:04:05")
+	if len(rest) < stampLen+1 || rest[3] != ' ' || rest[stampLen] != ' ' {
+		m.Text = rest
+		return m
+	}
+	m.Format = "rfc3164"
+	rest = rest[stampLen+1:]
+	host, msg, ok := strings.Cut(rest, " ")
+	if !ok {
+		m.Text = rest
+		return m
+	}
+	m.Hostname = host
+	if tag, text, ok := strings.Cut(msg, ": "); ok && !strings.Contains(tag, " ") {
+		if i := strings.IndexByte(tag, '['); i > 0 {
+			tag = tag[:i]
+		}
+		m.AppName = tag
+		msg = text
+	}
+	m.Text = msg
+	return m
+}
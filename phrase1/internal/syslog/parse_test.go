@@ -0,0 +1,78 @@
+package syslog
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Message
+	}{
+		{
+			"rfc5424",
+			`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`,
+			Message{Facility: 4, Severity: 2, Hostname: "mymachine.example.com", AppName: "su", Format: "rfc5424", Text: "'su root' failed"},
+		},
+		{
+			"rfc5424 structured data",
+			`<165>1 2003-10-11T22:14:15.003Z host app 1234 ID [ex@32473 iut="3" src="a \"]\" b"][other k="v"] hello there`,
+			Message{Facility: 20, Severity: 5, Hostname: "host", AppName: "app", Format: "rfc5424", Text: "hello there"},
+		},
+		{
+			"rfc5424 nil values",
+			`<14>1 - - - - - - msg`,
+			Message{Facility: 1, Severity: 6, Format: "rfc5424", Text: "msg"},
+		},
+		{
+			"rfc5424 no message",
+			`<14>1 2003-10-11T22:14:15Z h a p m -`,
+			Message{Facility: 1, Severity: 6, Hostname: "h", AppName: "a", Format: "rfc5424", Text: ""},
+		},
+		{
+			"rfc5424 short",
+			`<14>1 2003-10-11T22:14:15Z h a`,
+			Message{Facility: 1, Severity: 6, Text: "2003-10-11T22:14:15Z h a"},
+		},
+		{
+			"rfc3164",
+			`<13>Feb  5 17:32:18 10.0.0.99 myapp[123]: Use the BFG!`,
+			Message{Facility: 1, Severity: 5, Hostname: "10.0.0.99", AppName: "myapp", Format: "rfc3164", Text: "Use the BFG!"},
+		},
+		{
+			"rfc3164 no tag",
+			`<13>Feb 15 17:32:18 host just some text`,
+			Message{Facility: 1, Severity: 5, Hostname: "host", Format: "rfc3164", Text: "just some text"},
+		},
+		{
+			"rfc3164 colon in text",
+			`<13>Feb 15 17:32:18 host not a tag: here`,
+			Message{Facility: 1, Severity: 5, Hostname: "host", Format: "rfc3164", Text: "not a tag: here"},
+		},
+		{
+			"pri without header",
+			`<13>hello`,
+			Message{Facility: 1, Severity: 5, Text: "hello"},
+		},
+		{"no pri", "plain line", Message{Facility: -1, Severity: -1, Text: "plain line"}},
+		{"pri too high", "<192>1 - - - - - - x", Message{Facility: -1, Severity: -1, Text: "<192>1 - - - - - - x"}},
+		{"pri not a number", "<ab>x", Message{Facility: -1, Severity: -1, Text: "<ab>x"}},
+		{"empty pri", "<>x", Message{Facility: -1, Severity: -1, Text: "<>x"}},
+		{"pri too long", "<1234>x", Message{Facility: -1, Severity: -1, Text: "<1234>x"}},
+		{"unclosed pri", "<13 x", Message{Facility: -1, Severity: -1, Text: "<13 x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.line); got != tt.want {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeverityName(t *testing.T) {
+	for sev, want := range map[int]string{0: "emerg", 3: "err", 7: "debug", -1: "", 8: ""} {
+		if got := (Message{Severity: sev}).SeverityName(); got != want {
+			t.Errorf("severity %d is %q, want %q", sev, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package tenant
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiter counts enqueues per tenant in one-second windows shared by all
+// api replicas. Fixed windows can let up to twice the limit through across a
+// window boundary, which is fine for keeping a noisy tenant in check.
+type RateLimiter struct {
+	client *redis.Client
+}
+
+func NewRateLimiter(client *redis.Client) *RateLimiter {
+	return &RateLimiter{client: client}
+}
+
+// Allow records one enqueue for tenant id and reports whether it is within
+// perSec. perSec <= 0 always allows.
+func (l *RateLimiter) Allow(ctx context.Context, id string, perSec int64) (bool, error) {
+	if perSec <= 0 {
+		return true, nil
+	}
+	key := "tenant:" + id + ":rate:" + strconv.FormatInt(time.Now().Unix(), 10)
+	var n *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		n = p.Incr(ctx, key)
+		p.Expire(ctx, key, 2*time.Second)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return n.Val() <= perSec, nil
+}
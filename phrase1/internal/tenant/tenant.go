@@ -0,0 +1,105 @@
+// Package tenant maps API keys to tenants and enforces per-tenant limits.
+// Each tenant gets its own namespaced queue, so tenants can't see or starve
+// each other's messages.
+package tenant
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type Tenant struct {
+	ID   string   `json:"id"`
+	Keys []string `json:"keys,omitempty"`
+	// MaxDepth caps the number of messages waiting in the tenant's queue;
+	// 0 means no cap.
+	MaxDepth int64 `json:"max_depth,omitempty"`
+	// RatePerSec caps enqueues per second across all api replicas; 0 means
+	// no limit.
+	RatePerSec int64 `json:"rate_per_sec,omitempty"`
+}
+
+// QueueName is the namespaced queue for tenant id, e.g.
+// tenant:acme:messages for base "messages".
+func QueueName(id, base string) string {
+	return "tenant:" + id + ":" + base
+}
+
+type Directory struct {
+	byKey   map[[sha256.Size]byte]Tenant
+	tenants []Tenant
+}
+
+// LoadFile reads a JSON array of tenants.
+func LoadFile(path string) (*Directory, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(b, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewDirectory(tenants)
+}
+
+func NewDirectory(tenants []Tenant) (*Directory, error) {
+	d := &Directory{byKey: make(map[[sha256.Size]byte]Tenant)}
+	seen := make(map[string]bool)
+	for i, t := range tenants {
+		if t.ID == "" {
+			return nil, fmt.Errorf("tenant %d has no id", i)
+		}
+		if seen[t.ID] {
+			return nil, fmt.Errorf("duplicate tenant %q", t.ID)
+		}
+		seen[t.ID] = true
+		for _, k := range t.Keys {
+			sum := sha256.Sum256([]byte(k))
+			if other, dup := d.byKey[sum]; dup {
+				return nil, fmt.Errorf("tenants %q and %q share an API key", other.ID, t.ID)
+			}
+			d.byKey[sum] = t
+		}
+	}
+	// Sort a copy: the caller's slice is left alone.
+	d.tenants = append([]Tenant(nil), tenants...)
+	sort.Slice(d.tenants, func(i, j int) bool { return d.tenants[i].ID < d.tenants[j].ID })
+	return d, nil
+}
+
+// Lookup returns the tenant owning key. Keys are compared by digest, so the
+// lookup time doesn't depend on how much of a guessed key is right.
+func (d *Directory) Lookup(key string) (Tenant, bool) {
+	if key == "" {
+		return Tenant{}, false
+	}
+	t, ok := d.byKey[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Tenant{}, false
+	}
+	return t, true
+}
+
+// Get returns the tenant with id.
+func (d *Directory) Get(id string) (Tenant, bool) {
+	for _, t := range d.tenants {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// Tenants lists all tenants by id, without their keys.
+func (d *Directory) Tenants() []Tenant {
+	out := make([]Tenant, len(d.tenants))
+	for i, t := range d.tenants {
+		t.Keys = nil
+		out[i] = t
+	}
+	return out
+}
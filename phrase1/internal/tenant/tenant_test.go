@@ -0,0 +1,60 @@
+package tenant
+
+import "testing"
+
+func TestDirectoryLookup(t *testing.T) {
+	// Not sorted by id, so sorting the directory reorders them.
+	tenants := []Tenant{
+		{ID: "zeta", Keys: []string{"zeta-key"}, MaxDepth: 10},
+		{ID: "acme", Keys: []string{"acme-key", "acme-key-2"}, RatePerSec: 5},
+		{ID: "mid", Keys: []string{"mid-key"}},
+	}
+	d, err := NewDirectory(tenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"zeta-key": "zeta", "acme-key": "acme", "acme-key-2": "acme", "mid-key": "mid"} {
+		got, ok := d.Lookup(key)
+		if !ok || got.ID != want {
+			t.Errorf("Lookup(%q) = %q, %t; want %q", key, got.ID, ok, want)
+		}
+	}
+	if got, _ := d.Lookup("zeta-key"); got.MaxDepth != 10 {
+		t.Errorf("zeta has max depth %d, want 10", got.MaxDepth)
+	}
+	if _, ok := d.Lookup("nope"); ok {
+		t.Error("unknown key resolved")
+	}
+	if _, ok := d.Lookup(""); ok {
+		t.Error("empty key resolved")
+	}
+	if tenants[0].ID != "zeta" {
+		t.Error("NewDirectory reordered its argument")
+	}
+
+	var ids []string
+	for _, tn := range d.Tenants() {
+		ids = append(ids, tn.ID)
+		if tn.Keys != nil {
+			t.Errorf("Tenants() exposes %s's keys", tn.ID)
+		}
+	}
+	if len(ids) != 3 || ids[0] != "acme" || ids[1] != "mid" || ids[2] != "zeta" {
+		t.Errorf("Tenants() = %v, want sorted by id", ids)
+	}
+	if got, ok := d.Get("mid"); !ok || got.ID != "mid" {
+		t.Errorf("Get(mid) = %+v, %t", got, ok)
+	}
+}
+
+func TestNewDirectoryRejects(t *testing.T) {
+	for name, tenants := range map[string][]Tenant{
+		"no id":      {{Keys: []string{"k"}}},
+		"duplicate":  {{ID: "a"}, {ID: "a"}},
+		"shared key": {{ID: "a", Keys: []string{"k"}}, {ID: "b", Keys: []string{"k"}}},
+	} {
+		if _, err := NewDirectory(tenants); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package thumbnail scales images down to fit a bounding box. It's plain CPU
+// work: decoding, resampling, and encoding, with no I/O of its own.
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decoded, and written out as JPEG
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrUnsupported is returned for data that isn't an image this package
+// decodes, or is larger than allowed; trying again won't help.
+var ErrUnsupported = errors.New("unsupported image")
+
+// Size is a bounding box in pixels.
+type Size struct {
+	Width, Height int
+}
+
+func (s Size) String() string {
+	return strconv.Itoa(s.Width) + "x" + strconv.Itoa(s.Height)
+}
+
+// ParseSize parses "<width>x<height>", e.g. "256x256".
+func ParseSize(v string) (Size, error) {
+	w, h, ok := strings.Cut(v, "x")
+	width, werr := strconv.Atoi(w)
+	height, herr := strconv.Atoi(h)
+	if !ok || werr != nil || herr != nil || width <= 0 || height <= 0 {
+		return Size{}, fmt.Errorf("thumbnail size %q: want <width>x<height>", v)
+	}
+	return Size{width, height}, nil
+}
+
+// Options bound what Make does.
+type Options struct {
+	// MaxPixels rejects images with more pixels than this before decoding
+	// them, so a small file that decompresses to a huge bitmap can't run
+	// the worker out of memory; 0 doesn't check.
+	MaxPixels int
+	// Quality is the JPEG quality, 1 to 100; 0 is jpeg.DefaultQuality.
+	Quality int
+}
+
+// Result is a thumbnail Make wrote.
+type Result struct {
+	// Format is "png" for PNG sources, "jpeg" for the rest.
+	Format string
+	// Source and Size are the original's dimensions and the thumbnail's.
+	Source, Size Size
+}
+
+// ContentType is the thumbnail's MIME type.
+func (r Result) ContentType() string {
+	return "image/" + r.Format
+}
+
+// Decode reads an image, checking its dimensions against opts first.
+func Decode(r io.ReadSeeker, opts Options) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if opts.MaxPixels > 0 && cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d is over %d pixels", ErrUnsupported, cfg.Width, cfg.Height, opts.MaxPixels)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, format, nil
+}
+
+// Make writes img, in format as Decode reported it, scaled down to fit box
+// with its aspect ratio kept. Images that already fit are re-encoded at
+// their own size rather than scaled up.
+func Make(w io.Writer, img image.Image, format string, box Size, opts Options) (Result, error) {
+	src := img.Bounds()
+	res := Result{Format: "jpeg", Source: Size{src.Dx(), src.Dy()}, Size: fit(Size{src.Dx(), src.Dy()}, box)}
+	dst := image.NewRGBA(image.Rect(0, 0, res.Size.Width, res.Size.Height))
+	// CatmullRom is the slowest of x/image's scalers and the sharpest; the
+	// point of the example is to spend CPU.
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	if format == "png" {
+		res.Format = "png"
+		return res, png.Encode(w, dst)
+	}
+	return res, jpeg.Encode(w, dst, &jpeg.Options{Quality: opts.Quality})
+}
+
+// fit is the largest size with src's aspect ratio within box, but no
+// larger than src.
+func fit(src, box Size) Size {
+	if src.Width <= box.Width && src.Height <= box.Height {
+		return src
+	}
+	// Compare box.W/src.W with box.H/src.H without dividing.
+	if box.Width*src.Height <= box.Height*src.Width {
+		return Size{box.Width, max(1, src.Height*box.Width/src.Width)}
+	}
+	return Size{max(1, src.Width*box.Height/src.Height), box.Height}
+}
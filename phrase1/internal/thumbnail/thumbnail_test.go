@@ -0,0 +1,80 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	for _, tc := range []struct {
+		src, box, want Size
+	}{
+		{Size{1000, 500}, Size{256, 256}, Size{256, 128}},
+		{Size{500, 1000}, Size{256, 256}, Size{128, 256}},
+		{Size{100, 50}, Size{256, 256}, Size{100, 50}},
+		{Size{4000, 10}, Size{256, 256}, Size{256, 1}},
+	} {
+		if got := fit(tc.src, tc.box); got != tc.want {
+			t.Errorf("fit(%s, %s) = %s, want %s", tc.src, tc.box, got, tc.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	if s, err := ParseSize("320x240"); err != nil || s != (Size{320, 240}) {
+		t.Errorf("got %s, %v", s, err)
+	}
+	for _, v := range []string{"", "320", "320x", "0x10", "ax b"} {
+		if _, err := ParseSize(v); err == nil {
+			t.Errorf("%q parsed", v)
+		}
+	}
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMake(t *testing.T) {
+	img, format, err := Decode(bytes.NewReader(encodePNG(t, 800, 600)), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	res, err := Make(&out, img, format, Size{200, 200}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Format != "png" || res.Source != (Size{800, 600}) || res.Size != (Size{200, 150}) {
+		t.Errorf("result %+v", res)
+	}
+	thumb, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 200 || b.Dy() != 150 {
+		t.Errorf("thumbnail is %dx%d, want 200x150", b.Dx(), b.Dy())
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	if _, _, err := Decode(bytes.NewReader([]byte("not an image")), Options{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("garbage: err %v, want ErrUnsupported", err)
+	}
+	if _, _, err := Decode(bytes.NewReader(encodePNG(t, 100, 100)), Options{MaxPixels: 5000}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("too many pixels: err %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package tracecontext carries W3C Trace Context (traceparent, tracestate)
+// and W3C Baggage from an HTTP request through the queue to the worker and
+// whatever it calls next, so a tracing backend can join the producer's trace
+// to the processing of its message. There's no tracing SDK here: the worker
+// just continues the trace with a child span id per message.
+package tracecontext
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Header and envelope metadata keys.
+const (
+	Traceparent = "traceparent"
+	Tracestate  = "tracestate"
+	Baggage     = "baggage"
+)
+
+// Limits from the specs; longer values are dropped rather than cut, since a
+// truncated list is invalid.
+const (
+	maxTracestate = 512
+	maxBaggage    = 8192
+	maxBaggageMem = 180
+)
+
+// SpanContext identifies a span and carries the vendor state and baggage
+// propagated with it. The zero value is no trace.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+	State   string
+	Baggage string
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// Traceparent formats sc as a version 00 traceparent value.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + sc.Flags
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// New starts a sampled trace.
+func New() SpanContext {
+	return SpanContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "01"}
+}
+
+// Child returns a new span in sc's trace, or a new trace if sc is invalid.
+func (sc SpanContext) Child() SpanContext {
+	if !sc.IsValid() {
+		n := New()
+		n.Baggage = sc.Baggage
+		return n
+	}
+	sc.SpanID = randomHex(8)
+	return sc
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse reads a traceparent value. Future versions are accepted as long as
+// their first four fields parse, as the spec asks.
+func Parse(traceparent string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}
+	if !isLowerHex(sc.TraceID, 32) || !isLowerHex(sc.SpanID, 16) || !isLowerHex(sc.Flags, 2) ||
+		sc.TraceID == strings.Repeat("0", 32) || sc.SpanID == strings.Repeat("0", 16) {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// validTracestate accepts a list of up to 32 key=value members within the
+// length limit; the keys and values themselves are vendors' business.
+func validTracestate(s string) bool {
+	if s == "" || len(s) > maxTracestate {
+		return false
+	}
+	members := strings.Split(s, ",")
+	if len(members) > 32 {
+		return false
+	}
+	for _, m := range members {
+		if k, _, ok := strings.Cut(strings.TrimSpace(m), "="); !ok || k == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidBaggage accepts a W3C baggage list: key=value members with optional
+// ;properties, within the size limits, in printable ASCII.
+func ValidBaggage(s string) bool {
+	if s == "" || len(s) > maxBaggage {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	members := strings.Split(s, ",")
+	if len(members) > maxBaggageMem {
+		return false
+	}
+	for _, m := range members {
+		kv, _, _ := strings.Cut(m, ";")
+		if k, _, ok := strings.Cut(kv, "="); !ok || strings.TrimSpace(k) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns sc from three lookups of the keys above. Invalid values are
+// ignored, each on its own: baggage survives a bad traceparent.
+func get(lookup func(string) string) SpanContext {
+	sc, ok := Parse(lookup(Traceparent))
+	if ok {
+		if ts := strings.TrimSpace(lookup(Tracestate)); validTracestate(ts) {
+			sc.State = ts
+		}
+	}
+	if b := strings.TrimSpace(lookup(Baggage)); ValidBaggage(b) {
+		sc.Baggage = b
+	}
+	return sc
+}
+
+// FromHeader reads the trace context and baggage of an HTTP request.
+func FromHeader(h http.Header) SpanContext {
+	return get(h.Get)
+}
+
+// Inject sets sc's headers on an outgoing request.
+func (sc SpanContext) Inject(h http.Header) {
+	if sc.IsValid() {
+		h.Set(Traceparent, sc.Traceparent())
+		if sc.State != "" {
+			h.Set(Tracestate, sc.State)
+		}
+	}
+	if sc.Baggage != "" {
+		h.Set(Baggage, sc.Baggage)
+	}
+}
+
+// FromMetadata reads the trace context an envelope was stamped with.
+func FromMetadata(m map[string]string) SpanContext {
+	return get(func(k string) string { return m[k] })
+}
+
+// SetMetadata stamps sc into an envelope's metadata, creating the map if
+// needed, and returns it.
+func (sc SpanContext) SetMetadata(m map[string]string) map[string]string {
+	if !sc.IsValid() && sc.Baggage == "" {
+		return m
+	}
+	if m == nil {
+		m = map[string]string{}
+	}
+	if sc.IsValid() {
+		m[Traceparent] = sc.Traceparent()
+		if sc.State != "" {
+			m[Tracestate] = sc.State
+		}
+	}
+	if sc.Baggage != "" {
+		m[Baggage] = sc.Baggage
+	}
+	return m
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying sc.
+func NewContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the SpanContext in ctx, or the zero value.
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
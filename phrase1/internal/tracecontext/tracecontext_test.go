@@ -0,0 +1,67 @@
+package tracecontext
+
+import (
+	"net/http"
+	"testing"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		ok bool
+	}{
+		{parent, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	} {
+		if _, ok := Parse(tc.in); ok != tc.ok {
+			t.Errorf("Parse(%q) ok = %v, want %v", tc.in, ok, tc.ok)
+		}
+	}
+}
+
+func TestHeaderToMetadataToHeader(t *testing.T) {
+	in := http.Header{}
+	in.Set(Traceparent, parent)
+	in.Set(Tracestate, "congo=t61rcWkgMzE")
+	in.Set(Baggage, "userId=alice,isProduction=false;p=1")
+
+	span := FromHeader(in).Child()
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.SpanID == "00f067aa0ba902b7" {
+		t.Fatalf("child %+v, want the same trace with a new span", span)
+	}
+	md := span.SetMetadata(nil)
+
+	out := http.Header{}
+	got := FromMetadata(md)
+	got.Inject(out)
+	if out.Get(Traceparent) != span.Traceparent() || out.Get(Tracestate) != "congo=t61rcWkgMzE" || out.Get(Baggage) != "userId=alice,isProduction=false;p=1" {
+		t.Errorf("propagated headers %v", out)
+	}
+}
+
+func TestInvalidPartsDropped(t *testing.T) {
+	h := http.Header{}
+	h.Set(Traceparent, "garbage")
+	h.Set(Tracestate, "congo=t61rcWkgMzE")
+	h.Set(Baggage, "no-equals-sign")
+	if sc := FromHeader(h); sc != (SpanContext{}) {
+		t.Errorf("FromHeader = %+v, want zero", sc)
+	}
+
+	h.Set(Baggage, "tenant=acme")
+	sc := FromHeader(h)
+	if sc.IsValid() || sc.State != "" || sc.Baggage != "tenant=acme" {
+		t.Errorf("FromHeader = %+v, want only the baggage", sc)
+	}
+	if child := sc.Child(); !child.IsValid() || child.Baggage != "tenant=acme" {
+		t.Errorf("Child of untraced = %+v, want a new trace keeping baggage", child)
+	}
+}
@@ -0,0 +1,179 @@
+// Package usage accounts enqueues per API key per UTC day in Redis and
+// enforces daily quotas on them. Keys are identified by their fingerprint,
+// never in clear.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrQuotaExceeded is returned by Consume when an enqueue would go over the
+// caller's quota.
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// Quota limits a key per day; zero fields are unlimited.
+type Quota struct {
+	Messages int64 `json:"daily_messages,omitempty"`
+	Bytes    int64 `json:"daily_bytes,omitempty"`
+}
+
+type Usage struct {
+	Subject  string `json:"subject"`
+	Day      string `json:"day"`
+	Messages int64  `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	Quota    *Quota `json:"quota,omitempty"`
+}
+
+// Quotas resolves the quota of a subject: an override if there is one, the
+// default otherwise.
+type Quotas struct {
+	Default   Quota
+	Overrides map[string]Quota
+}
+
+// LoadOverrides reads a JSON object of subject fingerprints to quotas.
+func LoadOverrides(path string) (map[string]Quota, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]Quota
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+func (q Quotas) For(subject string) Quota {
+	if o, ok := q.Overrides[subject]; ok {
+		return o
+	}
+	return q.Default
+}
+
+// retention is how long daily usage hashes are kept.
+const retention = 35 * 24 * time.Hour
+
+type Tracker struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewTracker stores usage in one hash per day, named prefix:YYYY-MM-DD.
+func NewTracker(client *redis.Client, prefix string) *Tracker {
+	return &Tracker{client: client, prefix: prefix}
+}
+
+// Day formats t as the UTC day usage is bucketed by.
+func Day(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+func (t *Tracker) key(day string) string {
+	return t.prefix + ":" + day
+}
+
+// Consume counts one message of size bytes against subject for today. If
+// that would exceed quota nothing is counted and ErrQuotaExceeded is returned
+// along with the usage so far.
+func (t *Tracker) Consume(ctx context.Context, subject string, size int64, quota Quota) (Usage, error) {
+	return t.ConsumeN(ctx, subject, 1, size, quota)
+}
+
+// ConsumeN is Consume for n messages of size bytes in total, all counted or
+// none.
+func (t *Tracker) ConsumeN(ctx context.Context, subject string, n, size int64, quota Quota) (Usage, error) {
+	day := Day(time.Now())
+	key := t.key(day)
+	var msgs, bytes *redis.IntCmd
+	_, err := t.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		msgs = p.HIncrBy(ctx, key, subject+"|messages", n)
+		bytes = p.HIncrBy(ctx, key, subject+"|bytes", size)
+		p.Expire(ctx, key, retention)
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	u := Usage{Subject: subject, Day: day, Messages: msgs.Val(), Bytes: bytes.Val()}
+	if (quota.Messages > 0 && u.Messages > quota.Messages) || (quota.Bytes > 0 && u.Bytes > quota.Bytes) {
+		if err := t.RefundN(ctx, subject, n, size); err != nil {
+			return Usage{}, err
+		}
+		u.Messages -= n
+		u.Bytes -= size
+		return u, ErrQuotaExceeded
+	}
+	return u, nil
+}
+
+// Refund takes back a Consume whose enqueue didn't happen.
+func (t *Tracker) Refund(ctx context.Context, subject string, size int64) error {
+	return t.RefundN(ctx, subject, 1, size)
+}
+
+// RefundN takes back a ConsumeN.
+func (t *Tracker) RefundN(ctx context.Context, subject string, n, size int64) error {
+	return t.RefundDay(ctx, Day(time.Now()), subject, n, size)
+}
+
+// RefundDay takes back a ConsumeN made on day, for a refund that can come
+// after the day has turned.
+func (t *Tracker) RefundDay(ctx context.Context, day, subject string, n, size int64) error {
+	key := t.key(day)
+	_, err := t.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HIncrBy(ctx, key, subject+"|messages", -n)
+		p.HIncrBy(ctx, key, subject+"|bytes", -size)
+		return nil
+	})
+	return err
+}
+
+// ForDay returns every subject's usage on day, busiest first.
+func (t *Tracker) ForDay(ctx context.Context, day string) ([]Usage, error) {
+	fields, err := t.client.HGetAll(ctx, t.key(day)).Result()
+	if err != nil {
+		return nil, err
+	}
+	bySubject := make(map[string]*Usage)
+	for field, v := range fields {
+		subject, kind, ok := strings.Cut(field, "|")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(v, 10, 64)
+		u := bySubject[subject]
+		if u == nil {
+			u = &Usage{Subject: subject, Day: day}
+			bySubject[subject] = u
+		}
+		switch kind {
+		case "messages":
+			u.Messages = n
+		case "bytes":
+			u.Bytes = n
+		}
+	}
+	out := make([]Usage, 0, len(bySubject))
+	for _, u := range bySubject {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Messages != out[j].Messages {
+			return out[i].Messages > out[j].Messages
+		}
+		return out[i].Subject < out[j].Subject
+	})
+	return out, nil
+}
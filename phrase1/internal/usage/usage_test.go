@@ -0,0 +1,62 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotasFor(t *testing.T) {
+	q := Quotas{
+		Default:   Quota{Messages: 1000},
+		Overrides: map[string]Quota{"key:abc": {Messages: 10, Bytes: 500}, "key:free": {}},
+	}
+	for subject, want := range map[string]Quota{
+		"key:abc":   {Messages: 10, Bytes: 500},
+		"key:free":  {},
+		"key:other": {Messages: 1000},
+		"anonymous": {Messages: 1000},
+	} {
+		if got := q.For(subject); got != want {
+			t.Errorf("For(%q) = %+v, want %+v", subject, got, want)
+		}
+	}
+	if got := (Quotas{}).For("x"); got != (Quota{}) {
+		t.Errorf("no quotas: %+v, want unlimited", got)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "quotas.json")
+	if err := os.WriteFile(good, []byte(`{"key:abc": {"daily_messages": 5, "daily_bytes": 100}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadOverrides(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := got["key:abc"]; q != (Quota{Messages: 5, Bytes: 100}) || len(got) != 1 {
+		t.Errorf("overrides %+v", got)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`["key:abc"]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOverrides(bad); err == nil {
+		t.Error("array of keys accepted")
+	}
+	if _, err := LoadOverrides(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestDayIsUTC(t *testing.T) {
+	// 23:30 on the 1st in New York is already the 2nd in UTC.
+	ny := time.FixedZone("EST", -5*60*60)
+	if got := Day(time.Date(2024, 3, 1, 23, 30, 0, 0, ny)); got != "2024-03-02" {
+		t.Errorf("Day = %s, want 2024-03-02", got)
+	}
+}
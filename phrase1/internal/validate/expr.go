@@ -0,0 +1,948 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Expr is a compiled expression in a subset of CEL, the Common Expression
+// Language: enough to write predicates over a message without pulling in a
+// full CEL runtime. It supports
+//
+//   - literals: ints, doubles, 'single' or "double" quoted strings, true,
+//     false, null, and lists [a, b];
+//   - operators, loosest first as in CEL: ?:, ||, &&, the comparisons
+//     == != < <= > >= and in, + and -, multiplication * / %, and unary
+//     ! and -;
+//   - field selection a.b, indexing a[0] and a["b"], and has(a.b);
+//   - size(x), int(x), double(x), string(x), and the string methods
+//     contains, startsWith, endsWith, matches (RE2), and lowerAscii.
+//
+// Unlike CEL, ints and doubles compare and mix freely, since JSON doesn't
+// tell them apart, and nothing is type checked before evaluation. As in CEL,
+// || and && ignore an error on one side if the other side decides the
+// result, so has(a.b) && a.b > 1 is safe, and int arithmetic that overflows
+// is an error rather than wrapping around.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses src.
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, fmt.Errorf("expr %q: %w", src, err)
+	}
+	root, err := p.parseExpr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expr %q: %w", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates e with vars as its variables. Values are nil, bool, int64,
+// float64, string, []any, or map[string]any, as from DecodeJSON; other ints
+// and floats are converted.
+func (e *Expr) Eval(vars map[string]any) (any, error) {
+	return e.root.eval(vars)
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+// puncts are the operators, longest first so "<=" isn't read as "<".
+var puncts = []string{"==", "!=", "<=", ">=", "&&", "||", "(", ")", "[", "]", ".", ",", "?", ":", "!", "<", ">", "+", "-", "*", "/", "%"}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			p.toks = append(p.toks, token{tokIdent, s[i:j], i})
+			i = j
+		case c >= '0' && c <= '9':
+			j, kind := i, tokInt
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				(s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				if s[j] == '.' || s[j] == 'e' || s[j] == 'E' {
+					kind = tokFloat
+				}
+				j++
+			}
+			p.toks = append(p.toks, token{kind, s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(s[j])
+					}
+					continue
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("at %d: unterminated string", i)
+			}
+			p.toks = append(p.toks, token{tokString, b.String(), i})
+			i = j + 1
+		default:
+			matched := false
+			for _, op := range puncts {
+				if strings.HasPrefix(s[i:], op) {
+					p.toks = append(p.toks, token{tokPunct, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("at %d: unexpected character %q", i, c)
+			}
+		}
+	}
+	p.toks = append(p.toks, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it's the operator or keyword text.
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("want %q, got %s", text, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	c, err := p.parseBinary(0)
+	if err != nil || !p.accept("?") {
+		return c, err
+	}
+	t, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return condNode{c, t, f}, nil
+}
+
+// levels are the binary operators by increasing precedence.
+var levels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(levels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range levels[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op, left, right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return unaryNode{op, x}, nil
+		}
+	}
+	return p.parseMember()
+}
+
+func (p *parser) parseMember() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf("want a field or method name, got %s", name)
+			}
+			if p.accept("(") {
+				args, err := p.parseArgs(")")
+				if err != nil {
+					return nil, err
+				}
+				if x, err = newCall(name.text, x, args); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			x = selectNode{x, name.text}
+		case p.accept("["):
+			i, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = indexNode{x, i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parseArgs(end string) ([]node, error) {
+	var args []node
+	if p.accept(end) {
+		return args, nil
+	}
+	for {
+		a, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.accept(end) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("at %d: bad int %s", t.pos, t.text)
+		}
+		return litNode{n}, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("at %d: bad double %s", t.pos, t.text)
+		}
+		return litNode{f}, nil
+	case tokString:
+		return litNode{t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return litNode{true}, nil
+		case "false":
+			return litNode{false}, nil
+		case "null":
+			return litNode{nil}, nil
+		}
+		if !p.accept("(") {
+			return identNode{t.text}, nil
+		}
+		args, err := p.parseArgs(")")
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "has" {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("at %d: has takes one field selection", t.pos)
+			}
+			sel, ok := args[0].(selectNode)
+			if !ok {
+				return nil, fmt.Errorf("at %d: has takes a field selection like has(a.b)", t.pos)
+			}
+			return hasNode{sel}, nil
+		}
+		return newCall(t.text, nil, args)
+	case tokPunct:
+		switch t.text {
+		case "(":
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			elems, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return listNode{elems}, nil
+		}
+	}
+	return nil, fmt.Errorf("at %d: unexpected %s", t.pos, t)
+}
+
+// functions are the callable names, with how many arguments each takes
+// including the receiver of a method.
+var functions = map[string]int{
+	"size": 1, "int": 1, "double": 1, "string": 1,
+	"contains": 2, "startsWith": 2, "endsWith": 2, "matches": 2, "lowerAscii": 1,
+}
+
+func newCall(name string, target node, args []node) (node, error) {
+	if target != nil {
+		args = append([]node{target}, args...)
+	}
+	want, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("%s takes %d argument(s), got %d", name, want, len(args))
+	}
+	call := callNode{name: name, args: args}
+	if name == "matches" {
+		if lit, ok := args[1].(litNode); ok {
+			if s, ok := lit.v.(string); ok {
+				re, err := regexp.Compile(s)
+				if err != nil {
+					return nil, fmt.Errorf("matches: %w", err)
+				}
+				call.re = re
+			}
+		}
+	}
+	return call, nil
+}
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type (
+	litNode    struct{ v any }
+	identNode  struct{ name string }
+	selectNode struct {
+		x     node
+		field string
+	}
+	indexNode struct{ x, i node }
+	hasNode   struct{ sel selectNode }
+	listNode  struct{ elems []node }
+	unaryNode struct {
+		op string
+		x  node
+	}
+	binaryNode struct {
+		op          string
+		left, right node
+	}
+	condNode struct{ c, t, f node }
+	callNode struct {
+		name string
+		args []node
+		// re is matches' pattern, if it's a literal.
+		re *regexp.Regexp
+	}
+)
+
+func (n litNode) eval(map[string]any) (any, error) { return n.v, nil }
+
+func (n identNode) eval(vars map[string]any) (any, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared reference to %s", n.name)
+	}
+	return normalize(v), nil
+}
+
+func (n selectNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("can't select .%s on %s", n.field, typeName(x))
+	}
+	v, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.field)
+	}
+	return normalize(v), nil
+}
+
+func (n hasNode) eval(vars map[string]any) (any, error) {
+	x, err := n.sel.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("can't test has(.%s) on %s", n.sel.field, typeName(x))
+	}
+	_, ok = m[n.sel.field]
+	return ok, nil
+}
+
+func (n indexNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.i.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case []any:
+		k, ok := asInt(i)
+		if !ok {
+			return nil, fmt.Errorf("list index must be an int, not %s", typeName(i))
+		}
+		if k < 0 || k >= int64(len(x)) {
+			return nil, fmt.Errorf("index %d out of range [0, %d)", k, len(x))
+		}
+		return normalize(x[k]), nil
+	case map[string]any:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, not %s", typeName(i))
+		}
+		v, ok := x[k]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", k)
+		}
+		return normalize(v), nil
+	}
+	return nil, fmt.Errorf("can't index %s", typeName(x))
+}
+
+func (n listNode) eval(vars map[string]any) (any, error) {
+	out := make([]any, len(n.elems))
+	for i, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (n unaryNode) eval(vars map[string]any) (any, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		switch x := x.(type) {
+		case int64:
+			if x == math.MinInt64 {
+				return nil, errIntOverflow
+			}
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s%s", n.op, typeName(x))
+}
+
+func (n condNode) eval(vars map[string]any) (any, error) {
+	c, err := n.c.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not bool", typeName(c))
+	}
+	if b {
+		return n.t.eval(vars)
+	}
+	return n.f.eval(vars)
+}
+
+func (n binaryNode) eval(vars map[string]any) (any, error) {
+	if n.op == "&&" || n.op == "||" {
+		return n.logical(vars)
+	}
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []any:
+			for _, e := range r {
+				if equal(l, normalize(e)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			if k, ok := l.(string); ok {
+				_, found := r[k]
+				return found, nil
+			}
+		}
+	case "<", "<=", ">", ">=":
+		if c, ok := compare(l, r); ok {
+			switch n.op {
+			case "<":
+				return c < 0, nil
+			case "<=":
+				return c <= 0, nil
+			case ">":
+				return c > 0, nil
+			default:
+				return c >= 0, nil
+			}
+		}
+	case "+":
+		switch l := l.(type) {
+		case string:
+			if r, ok := r.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := r.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+		return arith(n.op, l, r)
+	default:
+		return arith(n.op, l, r)
+	}
+	return nil, fmt.Errorf("no such overload: %s %s %s", typeName(l), n.op, typeName(r))
+}
+
+// logical evaluates && and ||, where an error on one side is ignored if the
+// other side alone decides the result.
+func (n binaryNode) logical(vars map[string]any) (any, error) {
+	decides := n.op == "||"
+	side := func(x node) (bool, error) {
+		v, err := x.eval(vars)
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, fmt.Errorf("no such overload: %s %s", typeName(v), n.op)
+		}
+		return b, nil
+	}
+	l, lerr := side(n.left)
+	if lerr == nil && l == decides {
+		return decides, nil
+	}
+	r, rerr := side(n.right)
+	if rerr == nil && r == decides {
+		return decides, nil
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	if rerr != nil {
+		return nil, rerr
+	}
+	return !decides, nil
+}
+
+func (n callNode) eval(vars map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	x := args[0]
+	switch n.name {
+	case "size":
+		switch x := x.(type) {
+		case string:
+			return int64(utf8.RuneCountInString(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]any:
+			return int64(len(x)), nil
+		}
+	case "int":
+		switch x := x.(type) {
+		case int64:
+			return x, nil
+		case float64:
+			if math.IsNaN(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+				return nil, errors.New("int: double out of range")
+			}
+			return int64(x), nil
+		case string:
+			n, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int: can't convert %q", x)
+			}
+			return n, nil
+		}
+	case "double":
+		switch x := x.(type) {
+		case int64:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return nil, fmt.Errorf("double: can't convert %q", x)
+			}
+			return f, nil
+		}
+	case "string":
+		switch x := x.(type) {
+		case string:
+			return x, nil
+		case int64:
+			return strconv.FormatInt(x, 10), nil
+		case float64:
+			return strconv.FormatFloat(x, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+	case "lowerAscii":
+		if s, ok := x.(string); ok {
+			return strings.Map(func(r rune) rune {
+				if r >= 'A' && r <= 'Z' {
+					return r + 'a' - 'A'
+				}
+				return r
+			}, s), nil
+		}
+	default:
+		s, ok1 := x.(string)
+		arg, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			break
+		}
+		switch n.name {
+		case "contains":
+			return strings.Contains(s, arg), nil
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "matches":
+			// A pattern that isn't a literal may come from the message,
+			// so it's compiled every time rather than cached.
+			re := n.re
+			if re == nil {
+				var err error
+				if re, err = regexp.Compile(arg); err != nil {
+					return nil, fmt.Errorf("matches: %w", err)
+				}
+			}
+			return re.MatchString(s), nil
+		}
+	}
+	types := make([]string, len(args))
+	for i, a := range args {
+		types[i] = typeName(a)
+	}
+	return nil, fmt.Errorf("no such overload: %s(%s)", n.name, strings.Join(types, ", "))
+}
+
+// normalize converts the Go values variables may hold to the ones Eval
+// works with.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		out := make([]any, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]any, len(v))
+		for k, s := range v {
+			out[k] = s
+		}
+		return out
+	}
+	return v
+}
+
+func asInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+func asFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal compares numbers by value, inside lists and maps too, so [3] equals
+// the JSON [3.0].
+func equal(l, r any) bool {
+	if lf, ok := asFloat(l); ok {
+		rf, ok := asFloat(r)
+		return ok && lf == rf
+	}
+	switch l := l.(type) {
+	case []any:
+		r, ok := r.([]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !equal(normalize(l[i]), normalize(r[i])) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		r, ok := r.(map[string]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for k, lv := range l {
+			rv, ok := r[k]
+			if !ok || !equal(normalize(lv), normalize(rv)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func compare(l, r any) (int, bool) {
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		return strings.Compare(ls, rs), ok
+	}
+	li, lok := l.(int64)
+	ri, rok := r.(int64)
+	if lok && rok {
+		switch {
+		case li < ri:
+			return -1, true
+		case li > ri:
+			return 1, true
+		}
+		return 0, true
+	}
+	lf, lok := asFloat(l)
+	rf, rok := asFloat(r)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	}
+	return 0, true
+}
+
+var errIntOverflow = errors.New("int overflow")
+
+func arith(op string, l, r any) (any, error) {
+	li, lok := l.(int64)
+	ri, rok := r.(int64)
+	if lok && rok {
+		switch op {
+		case "+":
+			if (ri > 0 && li > math.MaxInt64-ri) || (ri < 0 && li < math.MinInt64-ri) {
+				return nil, errIntOverflow
+			}
+			return li + ri, nil
+		case "-":
+			if (ri < 0 && li > math.MaxInt64+ri) || (ri > 0 && li < math.MinInt64+ri) {
+				return nil, errIntOverflow
+			}
+			return li - ri, nil
+		case "*":
+			if li != 0 && ri != 0 && (li*ri/ri != li || (li == -1 && ri == math.MinInt64) || (ri == -1 && li == math.MinInt64)) {
+				return nil, errIntOverflow
+			}
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, errors.New("division by zero")
+			}
+			if ri == -1 && li == math.MinInt64 {
+				return nil, errIntOverflow
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := asFloat(l)
+	rf, rok := asFloat(r)
+	if lok && rok && op != "%" {
+		switch op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		case "/":
+			return lf / rf, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s %s %s", typeName(l), op, typeName(r))
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,407 @@
+package validate
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	vars := map[string]any{
+		"payload": "Order #42 for Ada",
+		"size":    17,
+		"json":    DecodeJSON(`{"qty": 3, "price": 2.5, "tags": ["rush", "gift"], "customer": {"name": "Ada"}}`),
+	}
+	for src, want := range map[string]any{
+		`1 + 2 * 3`:                         int64(7),
+		`(1 + 2) * 3 - -1`:                  int64(10),
+		`7 / 2 == 3 && 7 % 2 == 1`:          true,
+		`json.qty * json.price`:             7.5,
+		`json.qty > 2.5 && json.qty == 3.0`: true,
+		`size(payload) == size`:             true,
+		`payload.startsWith("Order") && payload.endsWith('Ada')`: true,
+		`payload.contains("#42")`:                                true,
+		`payload.matches("#[0-9]+")`:                             true,
+		`payload.lowerAscii().contains("ada")`:                   true,
+		`"gift" in json.tags && !("x" in json.tags)`:             true,
+		`json.tags[1] + "!"`:                                     "gift!",
+		`json["customer"].name`:                                  "Ada",
+		`"name" in json.customer`:                                true,
+		`has(json.qty) && !has(json.discount)`:                   true,
+		`has(json.discount) && json.discount > 0`:                false,
+		`json.discount > 0 || json.qty > 0`:                      true,
+		`json.qty > 1 ? "many" : "one"`:                          "many",
+		`size(json.tags) == 2 && json.tags == ["rush", "gift"]`:  true,
+		`int("12") + int(2.9) == 14 && double(1) == 1`:           true,
+		`string(json.qty) + string(true)`:                        "3true",
+		`null == null && json.customer != null`:                  true,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		got, err := e.Eval(vars)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", src, got, want)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	vars := map[string]any{"json": DecodeJSON(`{"qty": 3}`), "payload": "x"}
+	for _, src := range []string{
+		`json.discount > 0`,
+		`json.qty.value`,
+		`payload + 1`,
+		`1 / 0`,
+		`nope == 1`,
+		`json.qty ? 1 : 2`,
+		`payload < 1`,
+		`[1][3]`,
+	} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got, err := e.Eval(vars); err == nil {
+			t.Errorf("%s = %#v, want an error", src, got)
+		}
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`1 +`,
+		`(1`,
+		`"open`,
+		`a.b(`,
+		`unknown(1)`,
+		`size(1, 2)`,
+		`has(json)`,
+		`payload.matches("[")`,
+		`1 2`,
+		`a # b`,
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%q compiled", src)
+		}
+	}
+}
+
+// eval compiles and evaluates src, failing the test if it doesn't compile.
+func eval(t *testing.T, src string, vars map[string]any) (any, error) {
+	t.Helper()
+	e, err := Compile(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return e.Eval(vars)
+}
+
+func TestExprOperators(t *testing.T) {
+	vars := map[string]any{"json": DecodeJSON(`{"n": 3, "f": 3.0, "half": 0.5, "list": [1, 2.5, "a"], "obj": {"k": 3.0}}`)}
+	tests := []struct {
+		src  string
+		want any
+	}{
+		// + - * / % on ints stay ints; a double on either side makes a double.
+		{`2 + 3`, int64(5)},
+		{`2 - 3`, int64(-1)},
+		{`2 * 3`, int64(6)},
+		{`7 / 2`, int64(3)},
+		{`-7 / 2`, int64(-3)},
+		{`7 % 3`, int64(1)},
+		{`-7 % 3`, int64(-1)},
+		{`2 + 0.5`, 2.5},
+		{`2.5 - 1`, 1.5},
+		{`json.n * json.half`, 1.5},
+		{`7.0 / 2`, 3.5},
+		{`1.0 / 0`, math.Inf(1)},
+		{`"a" + "b"`, "ab"},
+		{`[1] + ["a"]`, []any{int64(1), "a"}},
+		// Unary operators bind tighter than binary ones.
+		{`-2 * 3`, int64(-6)},
+		{`--2`, int64(2)},
+		{`-json.half`, -0.5},
+		{`!true`, false},
+		{`!!false`, false},
+		// Comparisons, with ints and doubles mixed freely.
+		{`1 < 2`, true},
+		{`2 <= 2`, true},
+		{`3 > 2.5`, true},
+		{`2.5 >= 3`, false},
+		{`json.n == json.f`, true},
+		{`json.n != 3`, false},
+		{`"abc" < "abd"`, true},
+		{`"b" >= "a"`, true},
+		{`9007199254740993 > 9007199254740992`, true}, // ints compare exactly
+		{`[3] == [json.f]`, true},
+		{`json.obj.k == 3`, true},
+		{`[1, 2] == [1, 2, 3]`, false},
+		{`"1" == 1`, false},
+		{`null == 0`, false},
+		{`true == true`, true},
+		// in
+		{`2.5 in json.list`, true},
+		{`2 in json.list`, false},
+		{`"a" in json.list`, true},
+		{`"k" in json.obj`, true},
+		{`"x" in json.obj`, false},
+		{`1 in []`, false},
+		// Precedence and ?:
+		{`1 + 2 * 3 == 7 && 2 < 3 || false`, true},
+		{`true || false && false`, true},
+		{`1 < 2 ? "yes" : "no"`, "yes"},
+		{`false ? 1 : true ? 2 : 3`, int64(2)},
+		// Functions
+		{`size("héllo")`, int64(5)},
+		{`size(json.list)`, int64(3)},
+		{`size(json.obj)`, int64(1)},
+		{`int(-2.9)`, int64(-2)},
+		{`int("-5")`, int64(-5)},
+		{`double("2.5")`, 2.5},
+		{`string(2.5)`, "2.5"},
+		{`"ABC-def".lowerAscii()`, "abc-def"},
+		{`"abc".matches("^a.c$")`, true},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, vars)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExprErrorMessages(t *testing.T) {
+	vars := map[string]any{
+		"payload": "x",
+		"json":    DecodeJSON(`{"n": 3, "list": [1, 2], "obj": {"k": 1}, "re": "("}`),
+	}
+	tests := []struct {
+		src, want string
+	}{
+		// Operands of the wrong type
+		{`"a" - "b"`, "no such overload: string - string"},
+		{`"a" * 2`, "no such overload: string * int"},
+		{`1 + "a"`, "no such overload: int + string"},
+		{`[1] + 1`, "no such overload: list + int"},
+		{`2.5 % 2`, "no such overload: double % int"},
+		{`payload < 1`, "no such overload: string < int"},
+		{`json.list > 1`, "no such overload: list > int"},
+		{`null < 1`, "no such overload: null < int"},
+		{`1 in 2`, "no such overload: int in int"},
+		{`1 in json.obj`, "no such overload: int in map"},
+		{`-"a"`, "no such overload: -string"},
+		{`!1`, "no such overload: !int"},
+		{`1 && true`, "no such overload: int &&"},
+		{`true || "a" || false`, ""}, // decided before the string is looked at
+		{`false || "a"`, "no such overload: string ||"},
+		{`json.n ? 1 : 2`, "condition is int, not bool"},
+		{`size(1)`, "no such overload: size(int)"},
+		{`int(true)`, "no such overload: int(bool)"},
+		{`int("1.5")`, `int: can't convert "1.5"`},
+		{`double("x")`, `double: can't convert "x"`},
+		{`string(null)`, "no such overload: string(null)"},
+		{`payload.contains(1)`, "no such overload: contains(string, int)"},
+		{`payload.matches(json.re)`, "matches: error parsing regexp"},
+		// Selection and indexing
+		{`json.n.k`, "can't select .k on int"},
+		{`json.missing`, "no such key: missing"},
+		{`json["missing"]`, "no such key: missing"},
+		{`json[0]`, "map key must be a string, not int"},
+		{`json.list["a"]`, "list index must be an int, not string"},
+		{`payload[0]`, "can't index string"},
+		{`undefined`, "undeclared reference to undefined"},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, vars)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.src, err)
+		case tt.want != "" && err == nil:
+			t.Errorf("%s = %#v, want error %q", tt.src, got, tt.want)
+		case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+			t.Errorf("%s: error %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestExprHas(t *testing.T) {
+	vars := map[string]any{"json": DecodeJSON(`{"a": {"b": null}, "n": 1}`), "payload": "x"}
+	tests := []struct {
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		{`has(json.a)`, true, false},
+		{`has(json.a.b)`, true, false}, // present, even though null
+		{`has(json.a.c)`, false, false},
+		{`has(json.missing)`, false, false},
+		{`has(json.missing.b)`, false, true}, // only the last field may be missing
+		{`has(json.n.b)`, false, true},
+		{`has(payload.b)`, false, true},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.src, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+	for _, src := range []string{`has(json)`, `has(json["a"])`, `has()`, `has(json.a, json.n)`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%s compiled", src)
+		}
+	}
+}
+
+// TestExprShortCircuit checks that && and || ignore an error on whichever
+// side doesn't matter, as CEL does, and only report one that does.
+func TestExprShortCircuit(t *testing.T) {
+	vars := map[string]any{"json": DecodeJSON(`{}`)}
+	tests := []struct {
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		{`false && json.x`, false, false},
+		{`json.x && false`, false, false},
+		{`true || json.x`, true, false},
+		{`json.x || true`, true, false},
+		{`false && 1 / 0 == 1`, false, false},
+		{`true || [1][5] == 1`, true, false},
+		{`true && json.x`, false, true},
+		{`json.x && true`, false, true},
+		{`false || json.x`, false, true},
+		{`json.x || false`, false, true},
+		{`json.x || json.y`, false, true},
+		{`(json.x || true) && (false || json.y == 1 || true)`, true, false},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.src, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExprIndexing(t *testing.T) {
+	vars := map[string]any{"json": DecodeJSON(`{"list": ["a", "b"]}`), "tags": []string{"x"}}
+	tests := []struct {
+		src     string
+		want    any
+		wantErr string
+	}{
+		{`json.list[0]`, "a", ""},
+		{`json.list[1.0]`, "b", ""}, // a whole double is an index
+		{`tags[0]`, "x", ""},
+		{`json.list[2]`, nil, "index 2 out of range [0, 2)"},
+		{`json.list[-1]`, nil, "index -1 out of range [0, 2)"},
+		{`json.list[0.5]`, nil, "list index must be an int, not double"},
+		{`json.list[1e300]`, nil, "list index must be an int, not double"},
+		{`json.list[-1e300]`, nil, "list index must be an int, not double"},
+		{`[][0]`, nil, "index 0 out of range [0, 0)"},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, vars)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.src, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, %v; want %#v", tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestExprIntLimits(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    any
+		wantErr string
+	}{
+		{`9223372036854775807`, int64(math.MaxInt64), ""},
+		{`-9223372036854775807 - 1`, int64(math.MinInt64), ""},
+		{`9223372036854775807 + 1`, nil, "int overflow"},
+		{`1 + 9223372036854775807`, nil, "int overflow"},
+		{`-9223372036854775807 - 2`, nil, "int overflow"},
+		{`9223372036854775807 - -1`, nil, "int overflow"},
+		{`-9223372036854775807 + -2`, nil, "int overflow"},
+		{`4611686018427387904 * 2`, nil, "int overflow"},
+		{`-4611686018427387904 * 2`, int64(math.MinInt64), ""},
+		{`-4611686018427387905 * 2`, nil, "int overflow"},
+		{`3037000500 * 3037000500`, nil, "int overflow"},
+		{`(-9223372036854775807 - 1) * -1`, nil, "int overflow"},
+		{`-1 * (-9223372036854775807 - 1)`, nil, "int overflow"},
+		{`-(-9223372036854775807 - 1)`, nil, "int overflow"},
+		{`(-9223372036854775807 - 1) / -1`, nil, "int overflow"},
+		{`(-9223372036854775807 - 1) % -1`, nil, "int overflow"},
+		{`(-9223372036854775807 - 1) / 1`, int64(math.MinInt64), ""},
+		{`9223372036854775807 + 1.0`, 9223372036854775808.0, ""}, // doubles don't overflow
+		{`1 / 0`, nil, "division by zero"},
+		{`1 % 0`, nil, "division by zero"},
+		{`0 / 0`, nil, "division by zero"},
+		{`1 / 0.0`, math.Inf(1), ""},
+		{`-1 / 0.0`, math.Inf(-1), ""},
+		{`int(9.3e18)`, nil, "int: double out of range"},
+		{`int(-9.3e18)`, nil, "int: double out of range"},
+		{`int("9223372036854775808")`, nil, "int: can't convert"},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, nil)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s = %#v, %v; want error %q", tt.src, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, %v; want %#v", tt.src, got, err, tt.want)
+		}
+	}
+	if _, err := Compile(`9223372036854775808`); err == nil {
+		t.Error("an int literal past MaxInt64 compiled")
+	}
+}
+
+// TestExprDynamicPattern checks patterns that aren't literals, which may
+// come from the message itself.
+func TestExprDynamicPattern(t *testing.T) {
+	e, err := Compile(`json.code.matches(json.pattern)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		doc  string
+		want any
+	}{
+		{`{"code": "AB-12", "pattern": "^[A-Z]+-[0-9]+$"}`, true},
+		{`{"code": "AB-12", "pattern": "^[0-9]+$"}`, false},
+	} {
+		got, err := e.Eval(map[string]any{"json": DecodeJSON(tt.doc)})
+		if err != nil || got != tt.want {
+			t.Errorf("%s: %v, %v; want %v", tt.doc, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package validate runs rules over messages before the api enqueues them:
+// a size limit, a denied-word filter, a PII filter, and predicates written as
+// expressions (see Expr). Rules come from a YAML file:
+//
+//	rules:
+//	  - name: small
+//	    max_bytes: 65536
+//	  - name: no-pii
+//	    pii: [email, card]
+//	  - name: orders-have-quantity
+//	    queues: [orders]
+//	    expr: has(json.qty) && json.qty > 0
+//	    message: orders need a positive qty
+//
+// Other validators can be added to a Set in code through the Validator
+// interface.
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"learn_k8s/phrase1/internal/redact"
+)
+
+// Input is what rules see of a message.
+type Input struct {
+	Queue       string
+	ContentType string
+	// Text is the payload, rendered as text if it's binary.
+	Text string
+	// Size is the payload's size in bytes as it will be stored.
+	Size int
+}
+
+// Validator is one check. Validate returns why in should be rejected, or nil.
+type Validator interface {
+	Validate(in Input) error
+}
+
+// ValidatorFunc adapts a function to Validator.
+type ValidatorFunc func(in Input) error
+
+func (f ValidatorFunc) Validate(in Input) error { return f(in) }
+
+// Rule is a named Validator, applied to Queues or, if that's empty, to every
+// queue.
+type Rule struct {
+	Name      string
+	Queues    []string
+	Validator Validator
+}
+
+// Violation is one rule a message broke.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is returned by Check when a message breaks any rule.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.Rule + ": " + v.Message
+	}
+	return "message rejected: " + strings.Join(parts, "; ")
+}
+
+// Set is the rules in effect. A nil *Set accepts everything.
+type Set struct {
+	rules []Rule
+}
+
+// NewSet returns a Set of rules, checked in order.
+func NewSet(rules ...Rule) *Set {
+	return &Set{rules: rules}
+}
+
+// Add appends r to s.
+func (s *Set) Add(r Rule) {
+	s.rules = append(s.rules, r)
+}
+
+// Len is the number of rules.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Check runs every rule for in's queue and returns an *Error listing each one
+// it broke, or nil.
+func (s *Set) Check(in Input) error {
+	if s == nil {
+		return nil
+	}
+	var violations []Violation
+	for _, r := range s.rules {
+		if len(r.Queues) > 0 && !slices.Contains(r.Queues, in.Queue) {
+			continue
+		}
+		if err := r.Validator.Validate(in); err != nil {
+			violations = append(violations, Violation{Rule: r.Name, Message: err.Error()})
+		}
+	}
+	if violations == nil {
+		return nil
+	}
+	return &Error{Violations: violations}
+}
+
+// MaxSize rejects payloads over n bytes.
+func MaxSize(n int) Validator {
+	return ValidatorFunc(func(in Input) error {
+		if in.Size > n {
+			return fmt.Errorf("payload is %d bytes, over the %d byte limit", in.Size, n)
+		}
+		return nil
+	})
+}
+
+// DenyWords rejects messages containing any of words as a whole word, in
+// any case.
+func DenyWords(words []string) (Validator, error) {
+	if len(words) == 0 {
+		return nil, errors.New("deny_words is empty")
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		if strings.TrimSpace(w) == "" {
+			return nil, errors.New("deny_words has an empty word")
+		}
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return ValidatorFunc(func(in Input) error {
+		if re.MatchString(in.Text) {
+			return errors.New("contains a denied word")
+		}
+		return nil
+	}), nil
+}
+
+// PII rejects messages containing the kinds of personal data listed, out
+// of email, card, ssn, and phone (see redact.PII). The violation names the
+// kind, not the data.
+func PII(kinds []string) (Validator, error) {
+	if len(kinds) == 0 {
+		return nil, errors.New("pii lists no kinds (want email, card, ssn, or phone)")
+	}
+	pii := make([]redact.PII, len(kinds))
+	for i, name := range kinds {
+		k, err := redact.LookupPII(name)
+		if err != nil {
+			return nil, err
+		}
+		pii[i] = k
+	}
+	return ValidatorFunc(func(in Input) error {
+		for _, k := range pii {
+			if k.In(in.Text) {
+				return fmt.Errorf("contains %s", k.What)
+			}
+		}
+		return nil
+	}), nil
+}
+
+// Predicate rejects messages for which e isn't true, with message or, if
+// that's empty, one quoting e. e sees the variables queue, content_type,
+// payload (the text), size (bytes), and json: the payload decoded, or null
+// if it isn't JSON.
+func Predicate(e *Expr, message string) Validator {
+	if message == "" {
+		message = "does not satisfy " + e.String()
+	}
+	return ValidatorFunc(func(in Input) error {
+		v, err := e.Eval(map[string]any{
+			"queue":        in.Queue,
+			"content_type": in.ContentType,
+			"payload":      in.Text,
+			"size":         int64(in.Size),
+			"json":         DecodeJSON(in.Text),
+		})
+		if err != nil {
+			return fmt.Errorf("%s (%v)", message, err)
+		}
+		if ok, _ := v.(bool); !ok {
+			return errors.New(message)
+		}
+		return nil
+	})
+}
+
+// DecodeJSON decodes s into the values Expr works with, or returns nil if s
+// isn't JSON. Whole numbers become int64 and others float64.
+func DecodeJSON(s string) any {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil
+	}
+	return fromJSON(v)
+}
+
+func fromJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = fromJSON(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = fromJSON(v[k])
+		}
+	}
+	return v
+}
+
+// ruleConfig is one rule in a rules file; exactly one of its checks is set.
+type ruleConfig struct {
+	Name      string   `yaml:"name"`
+	Queues    []string `yaml:"queues"`
+	MaxBytes  int      `yaml:"max_bytes"`
+	DenyWords []string `yaml:"deny_words"`
+	PII       []string `yaml:"pii"`
+	Expr      string   `yaml:"expr"`
+	Message   string   `yaml:"message"`
+}
+
+func (c ruleConfig) rule() (Rule, error) {
+	if c.Name == "" {
+		return Rule{}, errors.New("rule has no name")
+	}
+	r := Rule{Name: c.Name, Queues: c.Queues}
+	checks := 0
+	var err error
+	if c.MaxBytes != 0 {
+		checks++
+		if c.MaxBytes < 0 {
+			return Rule{}, fmt.Errorf("rule %s: max_bytes %d is negative", c.Name, c.MaxBytes)
+		}
+		r.Validator = MaxSize(c.MaxBytes)
+	}
+	if c.DenyWords != nil {
+		checks++
+		r.Validator, err = DenyWords(c.DenyWords)
+	}
+	if c.PII != nil && err == nil {
+		checks++
+		r.Validator, err = PII(c.PII)
+	}
+	if c.Expr != "" && err == nil {
+		checks++
+		var e *Expr
+		if e, err = Compile(c.Expr); err == nil {
+			r.Validator = Predicate(e, c.Message)
+		}
+	}
+	switch {
+	case err != nil:
+		return Rule{}, fmt.Errorf("rule %s: %w", c.Name, err)
+	case checks != 1:
+		return Rule{}, fmt.Errorf("rule %s: want exactly one of max_bytes, deny_words, pii, or expr", c.Name)
+	}
+	return r, nil
+}
+
+// Parse reads a rules document.
+func Parse(b []byte) (*Set, error) {
+	var doc struct {
+		Rules []ruleConfig `yaml:"rules"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	s := NewSet()
+	seen := map[string]bool{}
+	for _, c := range doc.Rules {
+		r, err := c.rule()
+		if err != nil {
+			return nil, err
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", r.Name)
+		}
+		seen[r.Name] = true
+		s.Add(r)
+	}
+	return s, nil
+}
+
+// LoadFile reads the rules file at path.
+func LoadFile(path string) (*Set, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,91 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const rules = `
+rules:
+  - name: small
+    max_bytes: 64
+  - name: polite
+    deny_words: [darn, "heck"]
+  - name: no-pii
+    pii: [email, card]
+  - name: orders-have-quantity
+    queues: [orders]
+    expr: has(json.qty) && json.qty > 0
+    message: orders need a positive qty
+`
+
+func TestCheck(t *testing.T) {
+	s, err := Parse([]byte(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(queue, text string) []Violation {
+		err := s.Check(Input{Queue: queue, Text: text, Size: len(text)})
+		if err == nil {
+			return nil
+		}
+		var ve *Error
+		if !errors.As(err, &ve) {
+			t.Fatalf("Check error %T, want *Error", err)
+		}
+		return ve.Violations
+	}
+
+	for _, ok := range []struct{ queue, text string }{
+		{"messages", "hello"},
+		{"messages", "the checkered flag"},
+		{"messages", "order 4111 1111 1111 1112"},
+		{"orders", `{"qty": 2}`},
+	} {
+		if v := check(ok.queue, ok.text); v != nil {
+			t.Errorf("%s %q: %v, want accepted", ok.queue, ok.text, v)
+		}
+	}
+
+	if got, want := check("messages", "Darn, mail ada@example.com"), []Violation{
+		{Rule: "polite", Message: "contains a denied word"},
+		{Rule: "no-pii", Message: "contains an email address"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("violations %+v, want %+v", got, want)
+	}
+	if got := check("messages", "card 4111 1111 1111 1111"); len(got) != 1 || got[0].Message != "contains a payment card number" {
+		t.Errorf("card: %+v", got)
+	}
+	if got := check("messages", string(make([]byte, 65))); len(got) != 1 || got[0].Rule != "small" {
+		t.Errorf("oversized: %+v", got)
+	}
+	if got := check("orders", `{"qty": 0}`); len(got) != 1 || got[0].Message != "orders need a positive qty" {
+		t.Errorf("orders qty 0: %+v", got)
+	}
+	if got := check("orders", "not json"); len(got) != 1 || got[0].Rule != "orders-have-quantity" {
+		t.Errorf("orders not json: %+v", got)
+	}
+
+	var none *Set
+	if err := none.Check(Input{Text: "darn"}); err != nil {
+		t.Errorf("nil set: %v", err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, bad := range []string{
+		"rules:\n  - max_bytes: 1\n",
+		"rules:\n  - name: a\n",
+		"rules:\n  - name: a\n    max_bytes: 1\n    expr: 'true'\n",
+		"rules:\n  - name: a\n    pii: [passport]\n",
+		"rules:\n  - name: a\n    deny_words: []\n",
+		"rules:\n  - name: a\n    expr: 'json.'\n",
+		"rules:\n  - name: a\n    max_byte: 1\n",
+		"rules:\n  - name: a\n    max_bytes: 1\n  - name: a\n    max_bytes: 2\n",
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("whsec")
+	body := []byte(`{"event":"paid"}`)
+	now := time.Unix(1700000000, 0)
+	hexSig := hex.EncodeToString(sign(secret, body))
+	stripe := func(at time.Time, sigs ...string) string {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		v := "t=" + ts
+		for _, s := range sigs {
+			v += ",v1=" + s
+		}
+		return v
+	}
+	stripeSig := func(at time.Time, key []byte) string {
+		return hex.EncodeToString(sign(key, append([]byte(strconv.FormatInt(at.Unix(), 10)+"."), body...)))
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		err    error
+	}{
+		{"github", "X-Hub-Signature-256", "sha256=" + hexSig, nil},
+		{"github wrong secret", "X-Hub-Signature-256", "sha256=" + hex.EncodeToString(sign([]byte("x"), body)), ErrBadSignature},
+		{"github no prefix", "X-Hub-Signature-256", hexSig, ErrBadSignature},
+		{"github not hex", "X-Hub-Signature-256", "sha256=zz", ErrBadSignature},
+		{"generic", "X-Signature", "sha256=" + hexSig, nil},
+		{"generic sha1", "X-Signature", "sha1=" + hexSig, ErrBadSignature},
+		{"stripe", "Stripe-Signature", stripe(now, stripeSig(now, secret)), nil},
+		{"stripe rolling secret", "Stripe-Signature", stripe(now, stripeSig(now, []byte("old")), stripeSig(now, secret)), nil},
+		{"stripe with spaces", "Stripe-Signature", "t=" + strconv.FormatInt(now.Unix(), 10) + ", v1=" + stripeSig(now, secret), nil},
+		{"stripe wrong secret", "Stripe-Signature", stripe(now, stripeSig(now, []byte("x"))), ErrBadSignature},
+		{"stripe stale", "Stripe-Signature", stripe(now.Add(-6*time.Minute), stripeSig(now.Add(-6*time.Minute), secret)), ErrStale},
+		{"stripe from the future", "Stripe-Signature", stripe(now.Add(6*time.Minute), stripeSig(now.Add(6*time.Minute), secret)), ErrStale},
+		{"stripe within tolerance", "Stripe-Signature", stripe(now.Add(-4*time.Minute), stripeSig(now.Add(-4*time.Minute), secret)), nil},
+		{"stripe no timestamp", "Stripe-Signature", "v1=" + stripeSig(now, secret), ErrBadSignature},
+		{"stripe bad timestamp", "Stripe-Signature", "t=soon,v1=" + stripeSig(now, secret), ErrBadSignature},
+		{"stripe no v1", "Stripe-Signature", stripe(now), ErrBadSignature},
+		{"stripe garbage", "Stripe-Signature", ",,=,", ErrBadSignature},
+		{"dispatcher", SignatureHeader, Sign(secret, body, now), nil},
+		{"dispatcher stale", SignatureHeader, Sign(secret, body, now.Add(-time.Hour)), ErrStale},
+		{"none", "", "", ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.header != "" {
+				h.Set(tt.header, tt.value)
+			}
+			if err := Verify(secret, h, body, now); !errors.Is(err, tt.err) {
+				t.Errorf("Verify = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedBody(t *testing.T) {
+	secret := []byte("whsec")
+	now := time.Unix(1700000000, 0)
+	h := http.Header{}
+	h.Set(SignatureHeader, Sign(secret, []byte("original"), now))
+	if err := Verify(secret, h, []byte("tampered"), now); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify = %v, want ErrBadSignature", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package wordcount splits message text into the keywords the aggregation
+// handler counts.
+package wordcount
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultStopwords are common English words too frequent to be worth
+// counting.
+var DefaultStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "from", "has", "have",
+	"i", "in", "is", "it", "its", "of", "on", "or", "that", "the", "this", "to", "was",
+	"we", "were", "will", "with", "you",
+}
+
+// Counter counts the words in a text.
+type Counter struct {
+	// MinLen skips words shorter than this many letters.
+	MinLen int
+	// MaxLen skips longer words, which are rarely words (hashes, base64).
+	MaxLen    int
+	stopwords map[string]bool
+}
+
+// New returns a counter that skips stopwords and words outside
+// [minLen, maxLen] runes; maxLen <= 0 doesn't cap.
+func New(stopwords []string, minLen, maxLen int) *Counter {
+	c := &Counter{MinLen: minLen, MaxLen: maxLen, stopwords: map[string]bool{}}
+	for _, w := range stopwords {
+		c.stopwords[strings.ToLower(w)] = true
+	}
+	return c
+}
+
+// Count returns how many times each word occurs in text. Words are runs of
+// letters and digits, lowercased; a word of digits alone isn't counted.
+func (c *Counter) Count(text string) map[string]int64 {
+	counts := map[string]int64{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		n := utf8.RuneCountInString(w)
+		if n < c.MinLen || c.MaxLen > 0 && n > c.MaxLen || c.stopwords[w] || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		counts[w]++
+	}
+	return counts
+}
@@ -0,0 +1,18 @@
+package wordcount
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	c := New(DefaultStopwords, 2, 10)
+	got := c.Count("The order #42 shipped; ORDER 43 is late. Ünïcode déjà-vu x supercalifragilistic")
+	want := map[string]int64{"order": 2, "shipped": 1, "late": 1, "ünïcode": 1, "déjà": 1, "vu": 1}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := New(nil, 0, 0).Count(""); len(got) != 0 {
+		t.Errorf("empty text: %v", got)
+	}
+}
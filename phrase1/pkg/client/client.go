@@ -0,0 +1,358 @@
+// Package client is a Go SDK for producing messages through the api, for
+// services that would otherwise hand-roll HTTP calls to /v1/enqueue. It
+// retries what's safe to retry, attaches an idempotency key to every single
+// enqueue so those retries can't queue a message twice, and streams batches
+// through /v1/enqueue/batch.
+//
+// Only HTTP is spoken today; the Client methods are transport-neutral so a
+// gRPC transport can be added behind them.
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one api. It is safe for concurrent use.
+type Client struct {
+	base       string
+	apiKey     string
+	http       *http.Client
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends key as X-API-Key on every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient replaces the default http.Client, e.g. to set a transport
+// or an overall timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times a failed request is retried (default 3).
+// Zero disables retries.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithBackoff sets the first and the longest wait between retries (default
+// 100ms and 5s). Waits double per attempt, with jitter, unless the api sent
+// a Retry-After.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) { c.minBackoff, c.maxBackoff = min, max }
+}
+
+// New returns a Client for the api at baseURL, e.g. "http://api:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		base:       strings.TrimRight(baseURL, "/"),
+		http:       &http.Client{Timeout: 30 * time.Second},
+		retries:    3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Message is one message to enqueue.
+type Message struct {
+	// Body is the payload: a string is queued as is, anything else as its
+	// JSON encoding.
+	Body any
+	// SchemaVersion declares the payload's schema version; 0 is
+	// unversioned.
+	SchemaVersion int
+	// IdempotencyKey identifies the message across retries. Enqueue makes
+	// one up when it's empty; set it to stay idempotent across process
+	// restarts too, e.g. to a database row id. Batches don't use it.
+	IdempotencyKey string
+}
+
+// Result is the api's answer to an enqueue.
+type Result struct {
+	ID    string `json:"id"`
+	Queue string `json:"queue"`
+	// Spooled means the api kept the message on disk while Redis was down;
+	// it will be enqueued once Redis is back.
+	Spooled bool `json:"spooled,omitempty"`
+	// Duplicate means the idempotency key was already used; ID is the
+	// message enqueued the first time.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// Error is an error response from the api.
+type Error struct {
+	Status int
+	// Type is the problem type URI, such as /problems/queue_full; Code is
+	// its last segment.
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id"`
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("api: %d %s: %s", e.Status, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Temporary reports whether the same request may succeed later: rate limits,
+// maintenance, and Redis outages.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status == http.StatusServiceUnavailable ||
+		e.Status == http.StatusBadGateway || e.Status == http.StatusGatewayTimeout
+}
+
+type enqueueRequest struct {
+	Message       json.RawMessage `json:"message"`
+	SchemaVersion int             `json:"schema_version,omitempty"`
+}
+
+func encodeMessage(m Message) ([]byte, error) {
+	body, err := json.Marshal(m.Body)
+	if err != nil {
+		return nil, fmt.Errorf("client: encode body: %w", err)
+	}
+	return json.Marshal(enqueueRequest{Message: body, SchemaVersion: m.SchemaVersion})
+}
+
+// Enqueue queues a text message.
+func (c *Client) Enqueue(ctx context.Context, text string) (Result, error) {
+	return c.EnqueueMessage(ctx, Message{Body: text})
+}
+
+// EnqueueMessage queues m, retrying temporary failures with the same
+// idempotency key.
+func (c *Client) EnqueueMessage(ctx context.Context, m Message) (Result, error) {
+	body, err := encodeMessage(m)
+	if err != nil {
+		return Result{}, err
+	}
+	key := m.IdempotencyKey
+	if key == "" {
+		key = newKey()
+	}
+	var res Result
+	err = c.retry(ctx, func() error {
+		resp, err := c.post(ctx, "/v1/enqueue", "application/json", bytes.NewReader(body), map[string]string{"Idempotency-Key": key})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+		return json.NewDecoder(resp.Body).Decode(&res)
+	})
+	return res, err
+}
+
+// BatchResult is the outcome of one message of a batch.
+type BatchResult struct {
+	// Index is the message's position in the batch.
+	Index    int
+	Enqueued bool
+	ID       string
+	// Err says why the message wasn't enqueued.
+	Err string
+}
+
+// EnqueueBatch streams msgs to the api in one request, where they're written
+// a chunk at a time, and returns one result per message. The request is only
+// retried if the api refused it outright with a temporary error. Past that
+// point a retry could duplicate messages, so a failure returns the results
+// so far with the error, and the caller resumes from the first message
+// missing from them.
+func (c *Client) EnqueueBatch(ctx context.Context, msgs []Message) ([]BatchResult, error) {
+	var results []BatchResult
+	err := c.retry(ctx, func() error {
+		pr, pw := io.Pipe()
+		go func() {
+			enc := json.NewEncoder(pw)
+			for _, m := range msgs {
+				body, err := encodeMessage(m)
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if err := enc.Encode(json.RawMessage(body)); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			pw.Close()
+		}()
+		resp, err := c.post(ctx, "/v1/enqueue/batch", "application/x-ndjson", pr, nil)
+		if err != nil {
+			// Part of the upload may have been enqueued already.
+			return permanent(err)
+		}
+		defer resp.Body.Close()
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+		results, err = readBatch(resp.Body)
+		return permanent(err)
+	})
+	return results, err
+}
+
+// readBatch collects the per-line results and the summary of a batch
+// response. Blank lines aren't sent, so line n is message n-1.
+func readBatch(r io.Reader) ([]BatchResult, error) {
+	var results []BatchResult
+	dec := json.NewDecoder(r)
+	for {
+		// The summary line reuses "enqueued" for a count, so it's told
+		// apart by "done" first.
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return results, fmt.Errorf("client: batch response: %w", err)
+		}
+		var summary struct {
+			Done  bool   `json:"done"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			return results, fmt.Errorf("client: batch response: %w", err)
+		}
+		if summary.Done {
+			if summary.Error != "" {
+				return results, fmt.Errorf("client: batch stopped: %s", summary.Error)
+			}
+			return results, nil
+		}
+		var line struct {
+			Line     int    `json:"line"`
+			Enqueued bool   `json:"enqueued"`
+			ID       string `json:"id"`
+			Error    string `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &line); err != nil {
+			return results, fmt.Errorf("client: batch response: %w", err)
+		}
+		results = append(results, BatchResult{Index: line.Line - 1, Enqueued: line.Enqueued, ID: line.ID, Err: line.Error})
+	}
+}
+
+func (c *Client) post(ctx context.Context, path, contentType string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+path, body)
+	if err != nil {
+		return nil, permanent(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return c.http.Do(req)
+}
+
+// checkResponse turns a non-2xx response into an *Error.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	e := &Error{Status: resp.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(b, e) != nil || e.Code == "" {
+		e.Code = strings.ReplaceAll(strings.ToLower(http.StatusText(resp.StatusCode)), " ", "_")
+		e.Message = strings.TrimSpace(string(b))
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// permanentError marks an error retry must not retry.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// retry calls attempt until it succeeds, fails permanently, or the retries
+// or ctx run out. Transport errors and temporary api errors are retried.
+func (c *Client) retry(ctx context.Context, attempt func() error) error {
+	backoff := c.minBackoff
+	for n := 0; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		wait := backoff/2 + rand.N(backoff/2+1)
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			if !apiErr.Temporary() {
+				return err
+			}
+			if apiErr.RetryAfter > 0 {
+				wait = apiErr.RetryAfter
+			}
+		}
+		if n >= c.retries || ctx.Err() != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff = min(backoff*2, c.maxBackoff)
+	}
+}
+
+// newKey returns a random idempotency key.
+func newKey() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnqueueRetriesWithSameKey(t *testing.T) {
+	var calls atomic.Int32
+	keys := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Idempotency-Key")
+		if calls.Add(1) < 3 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"code":"unavailable","message":"enqueue failed"}`)
+			return
+		}
+		fmt.Fprint(w, `{"enqueued":true,"queue":"messages","id":"m-1"}`)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBackoff(time.Millisecond, time.Millisecond))
+	res, err := c.Enqueue(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if res.ID != "m-1" || res.Queue != "messages" {
+		t.Errorf("result = %+v", res)
+	}
+	close(keys)
+	first := <-keys
+	for k := range keys {
+		if k == "" || k != first {
+			t.Errorf("idempotency keys differ across retries: %q, %q", first, k)
+		}
+	}
+}
+
+func TestEnqueueDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"code":"schema_mismatch","message":"payload does not match schema","request_id":"r-1"}`)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL).EnqueueMessage(context.Background(), Message{Body: map[string]int{"qty": 0}})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "schema_mismatch" || apiErr.RequestID != "r-1" {
+		t.Fatalf("err = %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		enc := json.NewEncoder(w)
+		scanner := bufio.NewScanner(r.Body)
+		line, ok := 0, 0
+		for scanner.Scan() {
+			line++
+			var req enqueueRequest
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+				t.Errorf("line %d: %v", line, err)
+			}
+			if string(req.Message) == `"bad"` {
+				enc.Encode(map[string]any{"line": line, "enqueued": false, "error": "message is required"})
+				continue
+			}
+			ok++
+			enc.Encode(map[string]any{"line": line, "enqueued": true, "id": fmt.Sprint("m-", line)})
+		}
+		enc.Encode(map[string]any{"done": true, "queue": "messages", "enqueued": ok, "failed": line - ok})
+	}))
+	defer srv.Close()
+
+	results, err := New(srv.URL).EnqueueBatch(context.Background(), []Message{{Body: "a"}, {Body: "bad"}, {Body: map[string]int{"n": 3}}})
+	if err != nil {
+		t.Fatalf("EnqueueBatch: %v", err)
+	}
+	want := []BatchResult{
+		{Index: 0, Enqueued: true, ID: "m-1"},
+		{Index: 1, Err: "message is required"},
+		{Index: 2, Enqueued: true, ID: "m-3"},
+	}
+	if fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+}
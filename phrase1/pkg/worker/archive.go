@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// archiver is a queue that keeps processed messages in an archive, as
+// queue.RedisQueue does once its archive is set up.
+type archiver interface {
+	Archive(ctx context.Context, m queue.ArchivedMessage) error
+}
+
+// archive adds a processed message to the queue's archive, if it keeps one.
+// The message text is redacted like everything else the worker writes out.
+func (w *Worker) archive(ctx context.Context, envlp envelope.Envelope, msg string, took time.Duration) {
+	a, ok := w.q.(archiver)
+	if !ok {
+		return
+	}
+	err := a.Archive(ctx, queue.ArchivedMessage{
+		ID:          envlp.ID,
+		Queue:       w.q.Name(),
+		Message:     w.redactor.Text(msg),
+		Source:      envlp.Source,
+		Worker:      w.hostname,
+		DurationMS:  took.Milliseconds(),
+		ProcessedAt: time.Now(),
+	})
+	if err != nil {
+		w.logger.Printf("archive error: %v", err)
+	}
+}
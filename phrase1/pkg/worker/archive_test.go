@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+)
+
+// archivingQueue is a memQueue that keeps an archive.
+type archivingQueue struct {
+	*memQueue
+	archived []queue.ArchivedMessage
+}
+
+func (q *archivingQueue) Archive(_ context.Context, m queue.ArchivedMessage) error {
+	q.archived = append(q.archived, m)
+	return nil
+}
+
+func TestArchivesProcessedMessages(t *testing.T) {
+	ok := envelope.New("mail bob@example.com")
+	ok.Source = "file:in.txt"
+	redactor, err := redact.NewMessages([]string{"email"}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &archivingQueue{memQueue: newMemQueue(t, ok, envelope.New("fail"))}
+	handler := HandlerFunc(func(ctx context.Context, m Message) error {
+		if m.Text == "fail" {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	w := New(q, handler, WithDrainIdle(time.Second), WithHostname("w1"), WithRedaction(redactor), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if len(q.archived) != 1 {
+		t.Fatalf("archived %+v, want only the processed message", q.archived)
+	}
+	got := q.archived[0]
+	if got.ID != ok.ID || got.Queue != "test" || got.Source != "file:in.txt" || got.Worker != "w1" || got.ProcessedAt.IsZero() {
+		t.Errorf("archived %+v, want id %s from file:in.txt by w1", got, ok.ID)
+	}
+	if got.Message == ok.Payload {
+		t.Errorf("archived message %q, want it redacted", got.Message)
+	}
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FailureBudget is how many of its recent messages a worker may fail before
+// it slows down. Once the handler's failure rate over the last window
+// messages goes over the budget, Run waits before taking each next message,
+// doubling the wait up to a maximum, so a broken downstream doesn't drain
+// the queue into the dead-letter queue. Messages are still taken one at a
+// time at that pace; their successes bring the rate back under budget and
+// the worker back to full speed.
+//
+// Only handler errors count: undecodable messages say nothing about the
+// downstream. The methods are safe on a nil *FailureBudget, which never runs
+// out.
+type FailureBudget struct {
+	ratio      float64
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	outcomes []bool // ring of the last len(outcomes) results, true if failed
+	n        int    // results in the ring
+	next     int
+	failed   int
+	backoff  time.Duration
+}
+
+// minSamples is how many results a budget needs before it can run out, so
+// a worker's first failure isn't a 100% failure rate.
+const minSamples = 10
+
+// minBackoff is the first wait once the budget is exhausted.
+const minBackoff = 100 * time.Millisecond
+
+// NewFailureBudget allows ratio (0 to 1) of the last window messages to fail
+// before slowing down, waiting at most maxBackoff between messages.
+func NewFailureBudget(ratio float64, window int, maxBackoff time.Duration) *FailureBudget {
+	return &FailureBudget{ratio: ratio, maxBackoff: max(maxBackoff, minBackoff), outcomes: make([]bool, max(window, minSamples))}
+}
+
+// record adds a handler result.
+func (b *FailureBudget) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.n == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failed--
+		}
+	} else {
+		b.n++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failed++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+}
+
+// FailureRate is the share of the recent messages whose handler failed.
+func (b *FailureBudget) FailureRate() float64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate()
+}
+
+func (b *FailureBudget) rate() float64 {
+	if b.n == 0 {
+		return 0
+	}
+	return float64(b.failed) / float64(b.n)
+}
+
+func (b *FailureBudget) exhausted() bool {
+	return b.n >= minSamples && b.rate() > b.ratio
+}
+
+// Exhausted reports whether the failure rate is over budget.
+func (b *FailureBudget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exhausted()
+}
+
+// wait returns how long to wait before the next message: 0 within budget,
+// otherwise the current backoff, which doubles on each call.
+func (b *FailureBudget) wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.exhausted() {
+		b.backoff = 0
+		return 0
+	}
+	b.backoff = min(max(b.backoff*2, minBackoff), b.maxBackoff)
+	return b.backoff
+}
+
+// Check is a health check failing while the budget is exhausted; register
+// it as optional so the worker reports degraded rather than down.
+func (b *FailureBudget) Check(context.Context) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exhausted() {
+		return fmt.Errorf("%.0f%% of the last %d messages failed, over the %.0f%% budget", b.rate()*100, b.n, b.ratio*100)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFailureBudget(t *testing.T) {
+	b := NewFailureBudget(0.5, 20, time.Second)
+	for i := 0; i < minSamples-1; i++ {
+		b.record(true)
+	}
+	if b.Exhausted() {
+		t.Fatalf("exhausted after %d results, want at least %d first", minSamples-1, minSamples)
+	}
+	b.record(true)
+	if !b.Exhausted() || b.Check(context.Background()) == nil {
+		t.Fatal("not exhausted at 100% failing")
+	}
+
+	var waits []time.Duration
+	for i := 0; i < 6; i++ {
+		waits = append(waits, b.wait())
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("waits %v, want %v", waits, want)
+		}
+	}
+
+	// Twenty successes push every failure out of the window.
+	for i := 0; i < 20; i++ {
+		b.record(false)
+	}
+	if b.FailureRate() != 0 || b.wait() != 0 {
+		t.Errorf("rate %v after recovering, want 0 and no wait", b.FailureRate())
+	}
+	b.record(true)
+	if got := b.wait(); got != 0 {
+		t.Errorf("wait %s at 5%% failing, want 0", got)
+	}
+}
+
+func TestNilFailureBudget(t *testing.T) {
+	var b *FailureBudget
+	b.record(true)
+	if b.Exhausted() || b.wait() != 0 || b.Check(context.Background()) != nil {
+		t.Error("nil budget ran out")
+	}
+}
@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// CancelStore says which messages have been cancelled, by the api's
+// POST /messages/{id}/cancel. queue.RedisQueue implements it.
+type CancelStore interface {
+	Cancelled(ctx context.Context, id string) (bool, error)
+}
+
+// ErrCancelled is the cause of a handler's context canceled because its
+// message was cancelled; context.Cause returns it.
+var ErrCancelled = errors.New("message cancelled")
+
+// WithCancellation drops messages cancelled in store. A message already
+// cancelled when it's taken is dropped without calling the handler; one
+// cancelled while it's handled, checked every interval, has its handler's
+// context canceled with ErrCancelled. A handler that returns an error then
+// aborted: the message is dropped rather than retried or dead-lettered.
+// One that finishes anyway has processed it. Either way a dropped message
+// is recorded as cancelled (see WithStatus).
+func WithCancellation(store CancelStore, interval time.Duration) Option {
+	return func(w *Worker) { w.cancels, w.cancelInterval = store, interval }
+}
+
+// cancelled reports whether envlp was cancelled. A failed check is logged
+// and taken as no.
+func (w *Worker) cancelled(ctx context.Context, envlp envelope.Envelope) bool {
+	if w.cancels == nil || envlp.ID == "" {
+		return false
+	}
+	c, err := w.cancels.Cancelled(ctx, envlp.ID)
+	if err != nil {
+		w.logger.Printf("cancel check error: %v", err)
+		return false
+	}
+	return c
+}
+
+// watchCancel returns ctx canceled with ErrCancelled once envlp is
+// cancelled, and a function to stop watching that the caller must call.
+func (w *Worker) watchCancel(ctx context.Context, envlp envelope.Envelope) (context.Context, func()) {
+	if w.cancels == nil || w.cancelInterval <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(w.cancelInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if w.cancelled(ctx, envlp) {
+					cancel(ErrCancelled)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel(nil)
+	}
+}
+
+// drop records envlp, taken at start, as cancelled: before its handler ran,
+// or while it did, with cause as its handler's error.
+func (w *Worker) drop(ctx context.Context, envlp envelope.Envelope, msg string, cause error, start time.Time) {
+	if cause == nil {
+		w.logger.Printf("cancelled message id=%s dropped before handling", envlp.ID)
+		cause = ErrCancelled
+	} else {
+		w.logger.Printf("%s handler aborted cancelled message id=%s: %v", w.name, envlp.ID, cause)
+	}
+	w.setStatus(ctx, envlp, queue.StateCancelled, cause, start)
+	w.emit(events.MessageCancelled, msg, cause, time.Since(start))
+}
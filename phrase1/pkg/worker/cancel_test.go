@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// memCancels is a CancelStore in memory.
+type memCancels struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (c *memCancels) cancel(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids[id] = true
+}
+
+func (c *memCancels) Cancelled(_ context.Context, id string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ids[id], nil
+}
+
+func TestCancelledMessageIsDroppedUnhandled(t *testing.T) {
+	cancelled, kept := envelope.New("cancelled"), envelope.New("kept")
+	q := newMemQueue(t, cancelled, kept)
+	cancels := &memCancels{ids: map[string]bool{cancelled.ID: true}}
+	var handled []string
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		handled = append(handled, m.Text)
+		return nil
+	})
+	store := &memStatus{}
+	w := New(q, h, WithCancellation(cancels, time.Second), WithStatus(store, time.Hour), WithDrainIdle(time.Second), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if len(handled) != 1 || handled[0] != "kept" {
+		t.Errorf("handled %q, want only the message not cancelled", handled)
+	}
+	if len(q.dlq) != 0 {
+		t.Errorf("dlq %q, want empty", q.dlq)
+	}
+	if st := store.writes[0]; st.ID != cancelled.ID || st.State != queue.StateCancelled {
+		t.Errorf("first status %+v, want the cancelled message", st)
+	}
+}
+
+func TestCancelAbortsRunningHandler(t *testing.T) {
+	envlp := envelope.New("long")
+	q := &retryQueue{memQueue: newMemQueue(t, envlp)}
+	cancels := &memCancels{ids: map[string]bool{}}
+	var cause error
+	h := HandlerFunc(func(ctx context.Context, m Message) error {
+		cancels.cancel(m.Envelope.ID)
+		select {
+		case <-ctx.Done():
+			cause = context.Cause(ctx)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+	store := &memStatus{}
+	p := policy.Policy{MaxAttempts: 3}
+	w := New(q, h, WithCancellation(cancels, 10*time.Millisecond), WithStatus(store, time.Hour), WithDrainIdle(time.Second),
+		WithPolicy(func() policy.Policy { return p }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if !errors.Is(cause, ErrCancelled) {
+		t.Errorf("handler context cause %v, want ErrCancelled", cause)
+	}
+	if len(q.dlq) != 0 || len(q.delays) != 0 || w.Processed() != 0 {
+		t.Errorf("dlq %q, retries %v, processed %d; want the message dropped", q.dlq, q.delays, w.Processed())
+	}
+	if got := store.trail(); got != "processing 0 , cancelled 0 " {
+		t.Errorf("status writes %q", got)
+	}
+}
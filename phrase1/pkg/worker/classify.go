@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+// Class is what kind of failure a handler error is, so a dashboard can tell
+// the worker's own bugs from another service's outage.
+type Class string
+
+const (
+	// ClassTimeout is the handler, or a call it made, running out of time.
+	ClassTimeout Class = "timeout"
+	// ClassDownstream is a service the handler calls failing: a 5xx, a
+	// refused connection, an open circuit breaker.
+	ClassDownstream Class = "downstream"
+	// ClassValidation is the message itself being wrong, which retrying
+	// won't fix. Permanent errors are in this class unless classified
+	// otherwise.
+	ClassValidation Class = "validation"
+	// ClassPanic is the handler crashing the worker. It's counted when a
+	// restarted worker finds the message it was handling (see
+	// WithPoisonDetection).
+	ClassPanic Class = "panic"
+	// ClassSink is writing the result out failing: the output file, a
+	// topic, a store.
+	ClassSink Class = "sink"
+	// ClassUnknown is any other error.
+	ClassUnknown Class = "unknown"
+)
+
+// classified is an error with a Class; it reads as the error it wraps.
+type classified struct {
+	class Class
+	err   error
+}
+
+func (e *classified) Error() string { return e.err.Error() }
+func (e *classified) Unwrap() error { return e.err }
+
+// Classify marks err as a failure of class, without changing its message.
+// A nil err stays nil.
+func Classify(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classified{class: class, err: err}
+}
+
+// ClassOf is err's class. Running out of time anywhere in the chain is a
+// timeout, whatever else it was marked; then the class Classify gave it
+// wins; then a Permanent error is a validation failure.
+func ClassOf(err error) Class {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+	var c *classified
+	if errors.As(err, &c) {
+		return c.class
+	}
+	if errors.Is(err, ErrPermanent) {
+		return ClassValidation
+	}
+	return ClassUnknown
+}
+
+// WithFailureClasses counts each failed attempt at a message in count,
+// labeled queue, handler, and class (see ClassOf). A message that can't be
+// decoded or migrated counts as a validation failure.
+func WithFailureClasses(count *metrics.Counter) Option {
+	return func(w *Worker) { w.failures = count }
+}
+
+// countFailure counts a failed attempt of class.
+func (w *Worker) countFailure(class Class) {
+	if w.failures != nil {
+		w.failures.Inc(w.q.Name(), w.name, string(class))
+	}
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/metrics"
+)
+
+func TestClassOf(t *testing.T) {
+	cases := []struct {
+		err  error
+		want Class
+	}{
+		{errors.New("boom"), ClassUnknown},
+		{Permanent(errors.New("bad input")), ClassValidation},
+		{Classify(ClassSink, errors.New("disk full")), ClassSink},
+		{fmt.Errorf("forward: %w", Classify(ClassDownstream, errors.New("502"))), ClassDownstream},
+		{Permanent(Classify(ClassDownstream, errors.New("410"))), ClassDownstream},
+		{Classify(ClassDownstream, fmt.Errorf("post: %w", context.DeadlineExceeded)), ClassTimeout},
+	}
+	for _, c := range cases {
+		if got := ClassOf(c.err); got != c.want {
+			t.Errorf("ClassOf(%v) = %s, want %s", c.err, got, c.want)
+		}
+	}
+	if Classify(ClassSink, nil) != nil {
+		t.Error("Classify(nil) isn't nil")
+	}
+	if err := Classify(ClassSink, errors.New("disk full")); err.Error() != "disk full" {
+		t.Errorf("Classify changed the message to %q", err)
+	}
+}
+
+func TestCountsFailuresByClass(t *testing.T) {
+	q := newMemQueue(t, envelope.New("sink"), envelope.New("bad"), envelope.New("other"))
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		switch m.Text {
+		case "sink":
+			return Classify(ClassSink, errors.New("disk full"))
+		case "bad":
+			return Permanent(errors.New("no such recipient"))
+		}
+		return errors.New("boom")
+	})
+	reg := metrics.NewRegistry()
+	count := reg.NewCounter("failures_total", "", "queue", "handler", "class")
+	w := New(q, h, WithHandlerName("test"), WithFailureClasses(count), WithDrainIdle(time.Second), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	var out strings.Builder
+	_, _ = reg.WriteTo(&out)
+	for _, class := range []Class{ClassSink, ClassValidation, ClassUnknown} {
+		if want := fmt.Sprintf(`handler="test",class="%s"} 1`, class); !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// WithProducerDeadlines holds messages to the budget their producer's
+// request was given (envelope.Envelope.Deadline): one dequeued after its
+// deadline is dead-lettered unhandled as ErrExpired, and otherwise the
+// handler's context ends at the deadline. Messages without a budget are
+// handled as before.
+func WithProducerDeadlines() Option {
+	return func(w *Worker) { w.producerDeadlines = true }
+}
+
+// pastDeadline reports whether envlp's producer deadline has passed, and by
+// how much, if the worker honors producer deadlines.
+func (w *Worker) pastDeadline(envlp envelope.Envelope) (time.Duration, bool) {
+	deadline, ok := envlp.Deadline()
+	if !w.producerDeadlines || !ok {
+		return 0, false
+	}
+	late := time.Since(deadline)
+	return late, late > 0
+}
+
+// expireLate dead-letters a message dequeued late after its producer's
+// deadline.
+func (w *Worker) expireLate(ctx context.Context, raw string, envlp envelope.Envelope, late time.Duration, start time.Time) {
+	cause := fmt.Errorf("%w: %s past the producer's %dms budget", ErrExpired, late.Round(time.Millisecond), envlp.BudgetMS)
+	w.logger.Printf("expired message id=%s: %v", envlp.ID, cause)
+	w.deadLetterWithReason(ctx, raw, envlp, envlp.Payload, cause, start)
+}
+
+// withDeadline bounds ctx by envlp's producer deadline, if the worker
+// honors them and it has one.
+func (w *Worker) withDeadline(ctx context.Context, envlp envelope.Envelope) (context.Context, context.CancelFunc) {
+	deadline, ok := envlp.Deadline()
+	if !w.producerDeadlines || !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
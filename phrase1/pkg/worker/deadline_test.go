@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+func TestProducerDeadlines(t *testing.T) {
+	late := envelope.New("late")
+	late.EnqueuedAt, late.BudgetMS = time.Now().Add(-time.Hour), 5000
+	slow := envelope.New("slow")
+	slow.BudgetMS = 50
+	handler := func(handled *[]string) Handler {
+		return HandlerFunc(func(ctx context.Context, m Message) error {
+			if m.Text == "slow" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			*handled = append(*handled, m.Text)
+			return nil
+		})
+	}
+
+	q := newMemQueue(t, late, slow, envelope.New("unbounded"))
+	var handled []string
+	w := New(q, handler(&handled), WithDrainIdle(time.Second), WithProducerDeadlines(), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if len(handled) != 1 || handled[0] != "unbounded" {
+		t.Errorf("handled %q, want only unbounded", handled)
+	}
+	if len(q.dlq) != 2 {
+		t.Fatalf("dlq %q, want late and slow", q.dlq)
+	}
+	for _, raw := range q.dlq {
+		if reason := envelope.Decode(raw).Metadata[MetaDeadLetterReason]; !strings.HasPrefix(reason, "expired: ") || !strings.Contains(reason, "past the producer's") {
+			t.Errorf("dead-letter reason %q, want expired past the producer's budget", reason)
+		}
+	}
+
+	// Without the option the budget is only information.
+	q = newMemQueue(t, late)
+	handled = nil
+	w = New(q, handler(&handled), WithDrainIdle(time.Second), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+	if len(handled) != 1 {
+		t.Errorf("handled %q, want late handled anyway", handled)
+	}
+}
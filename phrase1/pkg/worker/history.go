@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"encoding/json"
+	"maps"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// MetaHistory is the envelope metadata key holding a message's failed
+// attempts as a JSON list of Attempt, oldest first. It rides along with
+// retries and requeues, so a dead-lettered message says why each attempt
+// failed.
+const MetaHistory = "attempt_history"
+
+// Attempt is one failed attempt at a message.
+type Attempt struct {
+	// Attempt is the attempt number, as Message.Attempt; a requeue after a
+	// crash or timeout doesn't advance it.
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	Worker     string    `json:"worker,omitempty"`
+	Handler    string    `json:"handler,omitempty"`
+	Error      string    `json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// maxHistory bounds the attempts kept, the latest ones, and maxErrorLen each
+// error, so a message retried many times doesn't grow without limit.
+const (
+	maxHistory  = 20
+	maxErrorLen = 1024
+)
+
+// History is envlp's attempt history, nil if it has none or it doesn't
+// decode.
+func History(envlp envelope.Envelope) []Attempt {
+	src, ok := envlp.Metadata[MetaHistory]
+	if !ok {
+		return nil
+	}
+	var h []Attempt
+	if json.Unmarshal([]byte(src), &h) != nil {
+		return nil
+	}
+	return h
+}
+
+// withAttempt returns envlp with the attempt that started at start and
+// failed with cause added to its history, and its metadata copied so it
+// can be changed.
+func (w *Worker) withAttempt(envlp envelope.Envelope, cause error, start time.Time) envelope.Envelope {
+	msg := cause.Error()
+	if len(msg) > maxErrorLen {
+		msg = msg[:maxErrorLen] + "..."
+	}
+	h := append(History(envlp), Attempt{
+		Attempt:    attempt(envlp),
+		At:         start.UTC(),
+		Worker:     w.hostname,
+		Handler:    w.name,
+		Error:      msg,
+		DurationMS: time.Since(start).Milliseconds(),
+	})
+	if len(h) > maxHistory {
+		h = h[len(h)-maxHistory:]
+	}
+	envlp.Metadata = maps.Clone(envlp.Metadata)
+	if envlp.Metadata == nil {
+		envlp.Metadata = map[string]string{}
+	}
+	if b, err := json.Marshal(h); err == nil {
+		envlp.Metadata[MetaHistory] = string(b)
+	}
+	return envlp
+}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// PoisonStore remembers, across worker restarts, which message each worker
+// is handling and how many times each message crashed or timed out a
+// worker. queue.RedisQueue implements it.
+type PoisonStore interface {
+	Hold(ctx context.Context, worker, raw string) error
+	Held(ctx context.Context, worker string) (string, error)
+	Release(ctx context.Context, worker string) error
+	Strike(ctx context.Context, id string) (int, error)
+	Forget(ctx context.Context, id string) error
+}
+
+// ErrPoison is the cause of a message quarantined as a poison pill.
+var ErrPoison = errors.New("poison pill")
+
+// MetaDeadLetterReason is the envelope metadata key saying why a message
+// was dead-lettered, when it wasn't for a handler error.
+const MetaDeadLetterReason = "dead_letter_reason"
+
+// WithPoisonDetection quarantines messages that crash or time out the
+// handler maxStrikes times. The message being handled is held in store; a
+// worker restarting after a crash finds it there, counts a strike, and puts
+// it back on the queue, or dead-letters it with a reason once it has
+// maxStrikes. A handler timeout (see WithHandlerTimeout) counts a strike
+// and requeues the message the same way, instead of dead-lettering it on
+// the first one. count, labeled queue and reason ("crash" or "timeout"),
+// counts quarantined messages. Workers must keep their hostname across
+// restarts, as a restarted container in the same pod does.
+func WithPoisonDetection(store PoisonStore, maxStrikes int, count *metrics.Counter) Option {
+	return func(w *Worker) { w.poison, w.maxStrikes, w.poisonCount = store, maxStrikes, count }
+}
+
+// WithHandlerTimeout cancels the handler's context after d.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(w *Worker) { w.handlerTimeout = d }
+}
+
+// recoverHeld deals with a message this worker left in flight when it last
+// stopped, which means it crashed handling it.
+func (w *Worker) recoverHeld(ctx context.Context) {
+	if w.poison == nil {
+		return
+	}
+	raw, err := w.poison.Held(ctx, w.hostname)
+	if err != nil {
+		w.logger.Printf("poison detection: read held message error: %v", err)
+		return
+	}
+	if raw == "" {
+		return
+	}
+	envlp := envelope.Decode(raw)
+	w.logger.Printf("found message id=%s in flight from before a crash", envlp.ID)
+	w.countFailure(ClassPanic)
+	w.strike(ctx, raw, envlp, envlp.Payload, "crash", errors.New("crashed the worker"), time.Now())
+}
+
+// strike counts a crash or timeout, what, against a message, then requeues
+// it or, at the limit, quarantines it. It releases this worker's hold either
+// way.
+func (w *Worker) strike(ctx context.Context, raw string, envlp envelope.Envelope, msg, reason string, what error, start time.Time) {
+	defer w.release(ctx)
+	n, err := w.poison.Strike(ctx, envlp.ID)
+	if err != nil {
+		// Without a count, the message is dealt with as if there were no
+		// detection.
+		w.logger.Printf("poison detection: strike error: %v", err)
+		n = w.maxStrikes
+	}
+	if n < w.maxStrikes {
+		requeued := raw
+		if envlp.Check() == nil {
+			if stamped, err := w.q.Encode(w.withAttempt(envlp, what, start)); err == nil {
+				requeued = stamped
+			}
+		}
+		if err := w.q.Requeue(ctx, requeued); err != nil {
+			w.logger.Printf("requeue error: %v", err)
+			w.deadLetter(ctx, raw, envlp, msg, what, start)
+			return
+		}
+		w.logger.Printf("message id=%s %v, requeued (strike %d of %d)", envlp.ID, what, n, w.maxStrikes)
+		w.setStatus(ctx, envlp, queue.StateRetrying, what, start)
+		w.emit(events.MessageFailed, msg, what, time.Since(start))
+		return
+	}
+
+	cause := fmt.Errorf("%w: %v %d times", ErrPoison, what, n)
+	w.logger.Printf("quarantining message id=%s: %v", envlp.ID, cause)
+	if w.poisonCount != nil {
+		w.poisonCount.Inc(w.q.Name(), reason)
+	}
+	w.deadLetterWithReason(ctx, raw, envlp, msg, cause, start)
+	if err := w.poison.Forget(ctx, envlp.ID); err != nil {
+		w.logger.Printf("poison detection: forget error: %v", err)
+	}
+}
+
+// hold records raw as in flight before the handler runs.
+func (w *Worker) hold(ctx context.Context, raw string) {
+	if w.poison == nil {
+		return
+	}
+	if err := w.poison.Hold(ctx, w.hostname, raw); err != nil {
+		w.logger.Printf("poison detection: hold error: %v", err)
+	}
+}
+
+func (w *Worker) release(ctx context.Context) {
+	if err := w.poison.Release(ctx, w.hostname); err != nil {
+		w.logger.Printf("poison detection: release error: %v", err)
+	}
+}
+
+// settle releases a message the handler finished with, one way or the
+// other, and clears its strikes.
+func (w *Worker) settle(ctx context.Context, id string) {
+	if w.poison == nil {
+		return
+	}
+	w.release(ctx)
+	if err := w.poison.Forget(ctx, id); err != nil {
+		w.logger.Printf("poison detection: forget error: %v", err)
+	}
+}
+
+// timedOut reports whether a handler error came from its own deadline
+// rather than from the worker shutting down.
+func timedOut(ctx, handlerCtx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+)
+
+// memPoison is a PoisonStore in memory.
+type memPoison struct {
+	mu      sync.Mutex
+	held    map[string]string
+	strikes map[string]int
+}
+
+func newMemPoison() *memPoison {
+	return &memPoison{held: map[string]string{}, strikes: map[string]int{}}
+}
+
+func (p *memPoison) Hold(_ context.Context, worker, raw string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.held[worker] = raw
+	return nil
+}
+
+func (p *memPoison) Held(_ context.Context, worker string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.held[worker], nil
+}
+
+func (p *memPoison) Release(_ context.Context, worker string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.held, worker)
+	return nil
+}
+
+func (p *memPoison) Strike(_ context.Context, id string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strikes[id]++
+	return p.strikes[id], nil
+}
+
+func (p *memPoison) Forget(_ context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.strikes, id)
+	return nil
+}
+
+func TestTimeoutsQuarantineAfterMaxStrikes(t *testing.T) {
+	slow := envelope.New("slow")
+	q := newMemQueue(t, slow, envelope.New("fast"))
+	store := newMemPoison()
+	attempts := map[string]int{}
+	h := HandlerFunc(func(ctx context.Context, m Message) error {
+		attempts[m.Text]++
+		if m.Text == "slow" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	w := New(q, h, WithHostname("w1"), WithDrainIdle(time.Second), WithHandlerTimeout(10*time.Millisecond),
+		WithPoisonDetection(store, 3, nil), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if attempts["slow"] != 3 || attempts["fast"] != 1 {
+		t.Errorf("attempts %v, want slow 3 times and fast once", attempts)
+	}
+	if len(q.dlq) != 1 {
+		t.Fatalf("dlq %q, want the slow message", q.dlq)
+	}
+	got := envelope.Decode(q.dlq[0])
+	if got.ID != slow.ID || !strings.HasPrefix(got.Metadata[MetaDeadLetterReason], "poison pill: timed out") {
+		t.Errorf("quarantined %s with reason %q", got.ID, got.Metadata[MetaDeadLetterReason])
+	}
+	if h := History(got); len(h) != 3 || h[0].Error != "timed out after 10ms" {
+		t.Errorf("history %+v, want the 3 timeouts", h)
+	}
+	if len(store.held) != 0 || len(store.strikes) != 0 {
+		t.Errorf("store left held %v, strikes %v", store.held, store.strikes)
+	}
+}
+
+func TestCrashedMessageRequeuedOnRestart(t *testing.T) {
+	crashed := envelope.New("crashed")
+	raw, _ := crashed.Encode()
+	q := newMemQueue(t)
+	store := newMemPoison()
+	store.held["w1"] = raw
+	store.strikes[crashed.ID] = 1
+
+	var handled []string
+	w := New(q, HandlerFunc(func(_ context.Context, m Message) error {
+		handled = append(handled, m.Text)
+		return errors.New("still broken")
+	}), WithHostname("w1"), WithDrainIdle(time.Second), WithPoisonDetection(store, 3, nil), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	// Requeued on strike 2, then failed normally: an error isn't a strike.
+	if len(handled) != 1 || handled[0] != "crashed" {
+		t.Errorf("handled %q, want the crashed message once", handled)
+	}
+	if len(q.dlq) != 1 || envelope.Decode(q.dlq[0]).Metadata[MetaDeadLetterReason] != "" {
+		t.Errorf("dlq %q, want the message dead-lettered without a poison reason", q.dlq)
+	}
+	if len(store.held) != 0 || len(store.strikes) != 0 {
+		t.Errorf("store left held %v, strikes %v", store.held, store.strikes)
+	}
+}
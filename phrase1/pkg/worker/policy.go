@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// MetaAttempt is the envelope metadata key counting how many times a
+// message has been handed to the handler before; it's absent on the first
+// attempt.
+const MetaAttempt = "attempt"
+
+// ErrExpired is the cause of a message dead-lettered for outliving its
+// queue's TTL.
+var ErrExpired = errors.New("expired")
+
+// ErrPermanent marks a handler error that retrying won't fix, like a
+// malformed request or a recipient that doesn't exist. Wrap it with
+// Permanent.
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps err so the message is dead-lettered at once rather than
+// retried under the queue's policy.
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// WithPolicy applies the policy fn returns, read again for every message so
+// reloaded policies take effect at once: messages older than its TTL are
+// dead-lettered unhandled, failed messages are retried up to its
+// MaxAttempts, after its backoff, if the queue can delay messages (as
+// queue.RedisQueue can), and no messages are taken during its quiet hours.
+func WithPolicy(fn func() policy.Policy) Option {
+	return func(w *Worker) { w.policy = fn }
+}
+
+// retrier is a queue that can hold a message back for a while.
+type retrier interface {
+	RetryAt(ctx context.Context, payload string, at time.Time) error
+}
+
+// attempt is which attempt at envlp this is, from 1.
+func attempt(envlp envelope.Envelope) int {
+	if n, err := strconv.Atoi(envlp.Metadata[MetaAttempt]); err == nil && n > 1 {
+		return n
+	}
+	return 1
+}
+
+// expired reports whether envlp has waited longer than p allows.
+func expired(envlp envelope.Envelope, p policy.Policy) (time.Duration, bool) {
+	if p.TTL <= 0 || envlp.EnqueuedAt.IsZero() {
+		return 0, false
+	}
+	waited := time.Since(envlp.EnqueuedAt)
+	return waited, waited > p.TTL
+}
+
+// retry schedules another attempt at a message whose handler failed with
+// cause, if p allows one, cause isn't permanent, and the queue can delay
+// messages, and reports whether it did.
+func (w *Worker) retry(ctx context.Context, envlp envelope.Envelope, msg string, cause error, p policy.Policy, start time.Time) bool {
+	n := attempt(envlp)
+	if n >= p.MaxAttempts || errors.Is(cause, ErrPermanent) {
+		return false
+	}
+	r, ok := w.q.(retrier)
+	if !ok {
+		return false
+	}
+	next := w.withAttempt(envlp, cause, start)
+	next.Metadata[MetaAttempt] = strconv.Itoa(n + 1)
+	raw, err := w.q.Encode(next)
+	if err != nil {
+		w.logger.Printf("encode retry error: %v", err)
+		return false
+	}
+	delay := p.RetryDelay(n)
+	if err := r.RetryAt(ctx, raw, time.Now().Add(delay)); err != nil {
+		w.logger.Printf("schedule retry error: %v", err)
+		return false
+	}
+	w.logger.Printf("retrying message id=%s in %s (attempt %d of %d)", envlp.ID, delay, n+1, p.MaxAttempts)
+	w.setStatus(ctx, envlp, queue.StateRetrying, cause, start)
+	w.emit(events.MessageFailed, msg, cause, time.Since(start))
+	return true
+}
+
+// deadLetterWithReason dead-letters a message with cause recorded in its
+// metadata, for failures that aren't the handler's, like expiry or poison
+// pills.
+func (w *Worker) deadLetterWithReason(ctx context.Context, raw string, envlp envelope.Envelope, msg string, cause error, start time.Time) {
+	if envlp.Check() == nil {
+		envlp.Metadata = maps.Clone(envlp.Metadata)
+		if envlp.Metadata == nil {
+			envlp.Metadata = map[string]string{}
+		}
+		envlp.Metadata[MetaDeadLetterReason] = cause.Error()
+	}
+	w.deadLetter(ctx, raw, envlp, msg, cause, start)
+}
+
+// expire dead-letters a message that waited d, over p's TTL.
+func (w *Worker) expire(ctx context.Context, raw string, envlp envelope.Envelope, d time.Duration, p policy.Policy, start time.Time) {
+	cause := fmt.Errorf("%w: waited %s, over the %s ttl", ErrExpired, d.Round(time.Millisecond), p.TTL)
+	w.logger.Printf("expired message id=%s: %v", envlp.ID, cause)
+	w.deadLetterWithReason(ctx, raw, envlp, envlp.Payload, cause, start)
+}
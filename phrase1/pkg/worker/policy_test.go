@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/policy"
+)
+
+// retryQueue is a memQueue that can delay messages; it doesn't, so retries
+// come back at once.
+type retryQueue struct {
+	*memQueue
+	delays []time.Duration
+}
+
+func (q *retryQueue) RetryAt(_ context.Context, raw string, at time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.delays = append(q.delays, time.Until(at).Round(time.Second))
+	q.pending = append(q.pending, raw)
+	return nil
+}
+
+func TestPolicyRetriesThenDeadLetters(t *testing.T) {
+	q := &retryQueue{memQueue: newMemQueue(t, envelope.New("flaky"), envelope.New("broken"))}
+	var attempts []string
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		attempts = append(attempts, fmt.Sprintf("%s:%d", m.Text, m.Attempt))
+		if m.Text == "flaky" && m.Attempt < 2 || m.Text == "broken" {
+			return errors.New("nope")
+		}
+		return nil
+	})
+	p := policy.Policy{MaxAttempts: 3, Backoff: 2 * time.Second}
+	w := New(q, h, WithDrainIdle(time.Second), WithPolicy(func() policy.Policy { return p }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	want := "flaky:1 broken:1 flaky:2 broken:2 broken:3"
+	if got := strings.Join(attempts, " "); got != want {
+		t.Errorf("attempts %q, want %q", got, want)
+	}
+	if len(q.dlq) != 1 || envelope.Decode(q.dlq[0]).Payload != "broken" {
+		t.Errorf("dlq %q, want broken after its last attempt", q.dlq)
+	}
+	if len(q.delays) != 3 || q.delays[0] != 2*time.Second || q.delays[2] != 4*time.Second {
+		t.Errorf("retry delays %v, want 2s, 2s, 4s", q.delays)
+	}
+}
+
+func TestDeadLetterKeepsAttemptHistory(t *testing.T) {
+	q := &retryQueue{memQueue: newMemQueue(t, envelope.New("broken"))}
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		return fmt.Errorf("failure %d", m.Attempt)
+	})
+	p := policy.Policy{MaxAttempts: 3}
+	w := New(q, h, WithHostname("w1"), WithHandlerName("test"), WithDrainIdle(time.Second),
+		WithPolicy(func() policy.Policy { return p }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if len(q.dlq) != 1 {
+		t.Fatalf("dlq %q, want broken", q.dlq)
+	}
+	history := History(envelope.Decode(q.dlq[0]))
+	if len(history) != 3 {
+		t.Fatalf("history %+v, want 3 attempts", history)
+	}
+	for i, a := range history {
+		if a.Attempt != i+1 || a.Error != fmt.Sprintf("failure %d", i+1) || a.Worker != "w1" || a.Handler != "test" || a.At.IsZero() {
+			t.Errorf("attempt %d: %+v", i+1, a)
+		}
+	}
+}
+
+func TestPolicyPermanentErrorSkipsRetries(t *testing.T) {
+	q := &retryQueue{memQueue: newMemQueue(t, envelope.New("bad"))}
+	var attempts int
+	h := HandlerFunc(func(context.Context, Message) error {
+		attempts++
+		return Permanent(errors.New("no such recipient"))
+	})
+	p := policy.Policy{MaxAttempts: 3}
+	w := New(q, h, WithDrainIdle(time.Second), WithPolicy(func() policy.Policy { return p }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if attempts != 1 || len(q.delays) != 0 {
+		t.Errorf("%d attempts and %d retries, want 1 and none", attempts, len(q.delays))
+	}
+	if len(q.dlq) != 1 {
+		t.Errorf("dlq %q, want bad dead-lettered", q.dlq)
+	}
+}
+
+func TestPolicyTTLExpires(t *testing.T) {
+	old := envelope.New("old")
+	old.EnqueuedAt = time.Now().Add(-time.Hour)
+	q := newMemQueue(t, old, envelope.New("fresh"))
+	var handled []string
+	w := New(q, HandlerFunc(func(_ context.Context, m Message) error {
+		handled = append(handled, m.Text)
+		return nil
+	}), WithDrainIdle(time.Second), WithPolicy(func() policy.Policy { return policy.Policy{TTL: time.Minute} }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	if len(handled) != 1 || handled[0] != "fresh" {
+		t.Errorf("handled %q, want only fresh", handled)
+	}
+	if len(q.dlq) != 1 || !strings.HasPrefix(envelope.Decode(q.dlq[0]).Metadata[MetaDeadLetterReason], "expired: waited 1h") {
+		t.Errorf("dlq %q, want old marked expired", q.dlq)
+	}
+}
+
+func TestPolicyQuietHoursHoldMessages(t *testing.T) {
+	q := newMemQueue(t, envelope.New("later"))
+	d, err := policy.Parse([]byte("default:\n  quiet_hours: [\"* * * * *\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	h := HandlerFunc(func(context.Context, Message) error {
+		handled++
+		return nil
+	})
+	w := New(q, h, WithPolicy(func() policy.Policy { return d.Default }), WithLogger(log.New(io.Discard, "", 0)))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = w.Run(ctx)
+
+	if handled != 0 || len(q.pending) != 1 {
+		t.Errorf("handled %d, %d pending; want the message left on the queue", handled, len(q.pending))
+	}
+	if !w.Quiet() {
+		t.Error("Quiet() = false during quiet hours")
+	}
+}
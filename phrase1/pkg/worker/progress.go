@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// StatusStore keeps a status record per message, for the api's
+// GET /messages/{id}. queue.RedisQueue implements it.
+type StatusStore interface {
+	SetStatus(ctx context.Context, st queue.MessageStatus, ttl time.Duration) error
+}
+
+// progressInterval spaces out the writes of the progress a handler
+// reports, so it can report from a tight loop.
+const progressInterval = 500 * time.Millisecond
+
+// WithStatus records each message's status in store, kept for ttl after
+// it last changed: processing while the handler runs, with the progress it
+// reports (see Message.Progress), then processed, retrying, or
+// dead_lettered.
+func WithStatus(store StatusStore, ttl time.Duration) Option {
+	return func(w *Worker) { w.status, w.statusTTL = store, ttl }
+}
+
+// progress is the status of the message being handled, which its handler
+// updates.
+type progress struct {
+	w    *Worker
+	ctx  context.Context
+	mu   sync.Mutex
+	st   queue.MessageStatus
+	last time.Time
+	// done is set once the handler has returned; later reports are
+	// dropped, so they can't overwrite how the message ended.
+	done bool
+}
+
+// Progress reports how far the handler has got with m: percent, from 0 to
+// 100, and the stage it's in, if it has stages. It's recorded in the
+// message's status (see WithStatus), and does nothing without one. Reports
+// are written at most every half second unless the stage changes or
+// percent reaches 100, so they're cheap to make often. It's safe to call
+// from several goroutines.
+func (m Message) Progress(percent float64, stage string) {
+	if m.progress != nil {
+		m.progress.report(percent, stage)
+	}
+}
+
+func (p *progress) report(percent float64, stage string) {
+	percent = min(max(percent, 0), 100)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return
+	}
+	now := time.Now()
+	due := stage != p.st.Stage || percent == 100 || now.Sub(p.last) >= progressInterval
+	p.st.Percent, p.st.Stage = percent, stage
+	if !due {
+		return
+	}
+	p.last = now
+	p.st.UpdatedAt = now.UTC()
+	p.w.writeStatus(p.ctx, p.st)
+}
+
+// stop ends the handler's reports and returns the last one.
+func (p *progress) stop() (float64, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	return p.st.Percent, p.st.Stage
+}
+
+// startProgress records envlp, taken at start, as being handled and
+// returns the progress its handler reports into, or nil without a
+// StatusStore.
+func (w *Worker) startProgress(ctx context.Context, envlp envelope.Envelope, start time.Time) *progress {
+	if w.status == nil {
+		return nil
+	}
+	p := &progress{w: w, ctx: ctx, st: w.newStatus(envlp, queue.StateProcessing, start), last: time.Now()}
+	w.writeStatus(ctx, p.st)
+	w.progress.Store(p)
+	return p
+}
+
+// setStatus records how handling envlp, taken at start, ended: state, and
+// cause if it failed. The handler's last progress report is kept.
+func (w *Worker) setStatus(ctx context.Context, envlp envelope.Envelope, state string, cause error, start time.Time) {
+	if w.status == nil || envlp.ID == "" {
+		return
+	}
+	st := w.newStatus(envlp, state, start)
+	if p := w.progress.Swap(nil); p != nil && p.st.ID == envlp.ID {
+		st.Percent, st.Stage = p.stop()
+	}
+	if state == queue.StateProcessed {
+		st.Percent = 100
+	}
+	if cause != nil {
+		st.Error = cause.Error()
+		if len(st.Error) > maxErrorLen {
+			st.Error = st.Error[:maxErrorLen] + "..."
+		}
+	}
+	w.writeStatus(ctx, st)
+}
+
+func (w *Worker) newStatus(envlp envelope.Envelope, state string, start time.Time) queue.MessageStatus {
+	return queue.MessageStatus{
+		ID:        envlp.ID,
+		Queue:     w.q.Name(),
+		State:     state,
+		Attempt:   attempt(envlp),
+		Worker:    w.hostname,
+		Handler:   w.name,
+		StartedAt: start.UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+func (w *Worker) writeStatus(ctx context.Context, st queue.MessageStatus) {
+	if err := w.status.SetStatus(ctx, st, w.statusTTL); err != nil {
+		w.logger.Printf("status error: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// memStatus is a StatusStore in memory that keeps every write.
+type memStatus struct {
+	mu     sync.Mutex
+	writes []queue.MessageStatus
+}
+
+func (s *memStatus) SetStatus(_ context.Context, st queue.MessageStatus, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, st)
+	return nil
+}
+
+// trail is the writes as "state percent stage" for each.
+func (s *memStatus) trail() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for _, st := range s.writes {
+		parts = append(parts, fmt.Sprintf("%s %g %s", st.State, st.Percent, st.Stage))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestStatusRecordsProgress(t *testing.T) {
+	q := newMemQueue(t, envelope.New("job"))
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		m.Progress(10, "download")
+		m.Progress(20, "download") // within the interval, not written
+		m.Progress(50, "resize")
+		m.Progress(150, "upload")
+		return nil
+	})
+	store := &memStatus{}
+	w := New(q, h, WithStatus(store, time.Hour), WithHostname("w1"), WithDrainIdle(time.Second), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	want := "processing 0 , processing 10 download, processing 50 resize, processing 100 upload, processed 100 upload"
+	if got := store.trail(); got != want {
+		t.Errorf("status writes %q, want %q", got, want)
+	}
+	last := store.writes[len(store.writes)-1]
+	if last.Worker != "w1" || last.Queue != "test" || last.Attempt != 1 || last.StartedAt.IsZero() {
+		t.Errorf("status %+v", last)
+	}
+}
+
+func TestStatusRecordsFailures(t *testing.T) {
+	q := &retryQueue{memQueue: newMemQueue(t, envelope.New("broken"))}
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		m.Progress(30, "")
+		return errors.New("nope")
+	})
+	store := &memStatus{}
+	p := policy.Policy{MaxAttempts: 2}
+	w := New(q, h, WithStatus(store, time.Hour), WithDrainIdle(time.Second),
+		WithPolicy(func() policy.Policy { return p }), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+
+	var got []string
+	for _, st := range store.writes {
+		got = append(got, fmt.Sprintf("%s:%d:%s", st.State, st.Attempt, st.Error))
+	}
+	want := "processing:1:, retrying:1:nope, processing:2:, dead_lettered:2:nope"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("status writes %q, want %q", strings.Join(got, ", "), want)
+	}
+	// The report came too soon to be written on its own, but the final
+	// status keeps it.
+	if last := store.writes[len(store.writes)-1]; last.Percent != 30 {
+		t.Errorf("dead-lettered at %g%%, want the 30%% reported", last.Percent)
+	}
+}
@@ -0,0 +1,520 @@
+// Package worker is the consume loop of cmd/worker as a library, for
+// services that want to embed a consumer instead of running the worker
+// binary:
+//
+//	w := worker.New(q, worker.HandlerFunc(func(ctx context.Context, m worker.Message) error {
+//		return process(m.Text)
+//	}), worker.WithLogger(logger))
+//	err := w.Run(ctx)
+//
+// The loop decodes each envelope, renders binary payloads as text, upgrades
+// versioned payloads, and hands the result to the Handler. A handler error
+// dead-letters the message, or retries it under the queue's policy; success
+// records it as processed, and a cancelled message is dropped. Pausing,
+// quiet hours, drain mode, slow-message reports, the failure budget, and the
+// latency SLO work as in the binary.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"learn_k8s/phrase1/internal/chaos"
+	"learn_k8s/phrase1/internal/codec"
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/errreport"
+	"learn_k8s/phrase1/internal/events"
+	"learn_k8s/phrase1/internal/metrics"
+	"learn_k8s/phrase1/internal/migrate"
+	"learn_k8s/phrase1/internal/policy"
+	"learn_k8s/phrase1/internal/queue"
+	"learn_k8s/phrase1/internal/redact"
+	"learn_k8s/phrase1/internal/slo"
+	"learn_k8s/phrase1/internal/tracecontext"
+)
+
+// Message is a dequeued message as a Handler sees it.
+type Message struct {
+	// Envelope is the message as it was enqueued.
+	Envelope envelope.Envelope
+	// Text is the payload, rendered as text if it was binary and upgraded
+	// to SchemaVersion.
+	Text string
+	// SchemaVersion is the version Text is in; 0 for unversioned payloads.
+	SchemaVersion int
+	// Attempt is 1 the first time the message is handled and counts up as
+	// it's retried under its queue's policy.
+	Attempt int
+	// Started is when the worker took the message off the queue, so a
+	// handler can report the whole processing time.
+	Started time.Time
+	// Span is the message's processing span: a child of the span it was
+	// enqueued under, or a new trace. Handlers that call other services
+	// propagate it with Span.Inject; it's also in the handler's context.
+	Span tracecontext.SpanContext
+
+	progress *progress
+}
+
+// Handler processes one message. An error dead-letters it, unless the
+// queue's policy retries it.
+type Handler interface {
+	Handle(ctx context.Context, m Message) error
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, m Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, m Message) error { return f(ctx, m) }
+
+// EmitFunc publishes a lifecycle event for a message (empty for worker
+// events), with the error and duration where they apply.
+type EmitFunc func(typ events.Type, msg string, cause error, took time.Duration)
+
+// Worker consumes one queue. Its Run is not meant to be called concurrently;
+// run several Workers to consume in parallel.
+type Worker struct {
+	q       queue.Queue
+	handler Handler
+
+	name              string
+	hostname          string
+	drainIdle         time.Duration
+	processingDelay   time.Duration
+	faults            *chaos.Injector
+	migrations        *migrate.Registry
+	latency           *slo.Latency
+	slowThreshold     time.Duration
+	slowCount         *metrics.Counter
+	budget            *FailureBudget
+	handlerTimeout    time.Duration
+	producerDeadlines bool
+	poison            PoisonStore
+	maxStrikes        int
+	poisonCount       *metrics.Counter
+	failures          *metrics.Counter
+	status            StatusStore
+	statusTTL         time.Duration
+	cancels           CancelStore
+	cancelInterval    time.Duration
+	policy            func() policy.Policy
+	redactor          *redact.Messages
+	reporter          errreport.Reporter
+	logger            *log.Logger
+	emit              EmitFunc
+
+	processed atomic.Int64
+	current   atomic.Pointer[InFlight]
+	progress  atomic.Pointer[progress]
+	quiet     atomic.Bool
+}
+
+// Option configures a Worker.
+type Option func(*Worker)
+
+// WithHandlerName names the handler in error and slow-message reports
+// (default "handler").
+func WithHandlerName(name string) Option {
+	return func(w *Worker) { w.name = name }
+}
+
+// WithHostname sets the worker's name in slow-message reports (default the
+// host name).
+func WithHostname(name string) Option {
+	return func(w *Worker) { w.hostname = name }
+}
+
+// WithDrainIdle makes Run return once no message has arrived for d, instead
+// of waiting for more (WORKER_MODE=drain). d under a second is rounded up by
+// Redis.
+func WithDrainIdle(d time.Duration) Option {
+	return func(w *Worker) { w.drainIdle = d }
+}
+
+// WithProcessingDelay sleeps d before handling each message, to make
+// backlogs visible in demos.
+func WithProcessingDelay(d time.Duration) Option {
+	return func(w *Worker) { w.processingDelay = d }
+}
+
+// WithChaos injects f's faults into message handling.
+func WithChaos(f *chaos.Injector) Option {
+	return func(w *Worker) { w.faults = f }
+}
+
+// WithMigrations upgrades versioned payloads with r before handling them.
+// Without it, any versioned payload newer than v0 is dead-lettered.
+func WithMigrations(r *migrate.Registry) Option {
+	return func(w *Worker) { w.migrations = r }
+}
+
+// WithLatency records each processed message's end-to-end latency in l.
+func WithLatency(l *slo.Latency) Option {
+	return func(w *Worker) { w.latency = l }
+}
+
+// WithSlowThreshold reports messages whose handling takes longer than d:
+// a log line, the queue's recent slow list, and count, which is labeled
+// queue and handler.
+func WithSlowThreshold(d time.Duration, count *metrics.Counter) Option {
+	return func(w *Worker) { w.slowThreshold, w.slowCount = d, count }
+}
+
+// WithFailureBudget slows the worker down while b's failure budget is
+// exhausted.
+func WithFailureBudget(b *FailureBudget) Option {
+	return func(w *Worker) { w.budget = b }
+}
+
+// WithRedaction hides what r redacts in the message text the worker logs.
+// Handlers that write messages out apply it themselves.
+func WithRedaction(r *redact.Messages) Option {
+	return func(w *Worker) { w.redactor = r }
+}
+
+// WithReporter sends handler errors and panics to r.
+func WithReporter(r errreport.Reporter) Option {
+	return func(w *Worker) { w.reporter = r }
+}
+
+// WithLogger sets where the worker logs (default stderr).
+func WithLogger(l *log.Logger) Option {
+	return func(w *Worker) { w.logger = l }
+}
+
+// WithEvents publishes message and worker lifecycle events through emit.
+func WithEvents(emit EmitFunc) Option {
+	return func(w *Worker) { w.emit = emit }
+}
+
+// New returns a Worker that hands q's messages to h.
+func New(q queue.Queue, h Handler, opts ...Option) *Worker {
+	hostname, _ := os.Hostname()
+	w := &Worker{
+		q:          q,
+		handler:    h,
+		name:       "handler",
+		hostname:   hostname,
+		migrations: migrate.NewRegistry(),
+		reporter:   errreport.Nop{},
+		logger:     log.New(os.Stderr, "worker ", log.LstdFlags|log.Lmicroseconds),
+		emit:       func(events.Type, string, error, time.Duration) {},
+		policy:     func() policy.Policy { return policy.Policy{} },
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// InFlight describes the message a worker is handling and how far it got.
+type InFlight struct {
+	ID         string    `json:"id"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Started    time.Time `json:"started"`
+	Stage      string    `json:"stage"`
+	Payload    string    `json:"payload"`
+}
+
+// Current is the message being handled, or nil between messages.
+func (w *Worker) Current() *InFlight {
+	return w.current.Load()
+}
+
+// Quiet reports whether the worker is waiting out its queue's quiet hours.
+func (w *Worker) Quiet() bool {
+	return w.quiet.Load()
+}
+
+// Processed is the number of messages handled successfully so far.
+func (w *Worker) Processed() int64 {
+	return w.processed.Load()
+}
+
+// track records that handling of envlp, begun at start, reached stage.
+func (w *Worker) track(envlp envelope.Envelope, start time.Time, stage string) {
+	payload := envlp.Payload
+	if len(payload) > 200 {
+		payload = payload[:200] + "..."
+	}
+	w.current.Store(&InFlight{ID: envlp.ID, EnqueuedAt: envlp.EnqueuedAt, Started: start, Stage: stage, Payload: payload})
+}
+
+// Run processes messages until ctx is canceled, or until the queue has been
+// empty for the drain idle time, idling while the queue is paused or in its
+// policy's quiet hours. A message being handled when ctx is canceled is
+// finished first. Redis outages are logged and retried, so Run currently
+// always returns nil; the error leaves room for handlers that can fail the
+// whole worker.
+func (w *Worker) Run(ctx context.Context) error {
+	w.emit(events.WorkerStarted, "", nil, 0)
+	defer w.emit(events.WorkerStopped, "", nil, 0)
+	w.recoverHeld(ctx)
+	paused, slowed := false, false
+	for {
+		// A failed check keeps the last state; Dequeue reports the outage.
+		if p, err := w.q.Paused(ctx); err == nil && p != paused {
+			paused = p
+			if paused {
+				w.logger.Printf("queue paused, waiting")
+			} else {
+				w.logger.Printf("queue resumed")
+			}
+		}
+		if expr, q := w.policy().QuietAt(time.Now()); q != w.quiet.Load() {
+			w.quiet.Store(q)
+			if q {
+				w.logger.Printf("quiet hours (%s), waiting", expr)
+			} else {
+				w.logger.Printf("quiet hours over")
+			}
+		}
+		if paused || w.quiet.Load() {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(1 * time.Second):
+			}
+			continue
+		}
+
+		if wait := w.budget.wait(); wait > 0 {
+			if !slowed {
+				slowed = true
+				w.logger.Printf("failure budget exhausted (%.0f%% failing), slowing down", w.budget.FailureRate()*100)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+		} else if slowed {
+			slowed = false
+			w.logger.Printf("failure rate back within budget, resuming full speed")
+		}
+
+		var raw string
+		var err error
+		if w.drainIdle > 0 {
+			raw, err = w.q.DequeueWithin(ctx, w.drainIdle)
+		} else {
+			raw, err = w.q.Dequeue(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, queue.ErrEmpty) {
+				w.logger.Printf("queue empty for %s, drain finished (%d processed)", w.drainIdle, w.processed.Load())
+				return nil
+			}
+			w.logger.Printf("dequeue error: %v", err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		// A popped message is finished even if shutdown starts meanwhile;
+		// with ctx its completion writes would fail as canceled.
+		w.Process(context.WithoutCancel(ctx), raw)
+	}
+}
+
+// Process handles one raw message already taken off the queue, for callers
+// that dequeue themselves. Run calls it for every message.
+func (w *Worker) Process(ctx context.Context, raw string) {
+	envlp := envelope.Decode(raw)
+	start := time.Now()
+	defer w.reportPanic(envlp)
+	w.track(envlp, start, "decode")
+	defer w.current.Store(nil)
+	if err := envlp.Check(); err != nil {
+		w.logger.Printf("unreadable envelope id=%s: %v", envlp.ID, err)
+		w.countFailure(ClassValidation)
+		w.deadLetter(ctx, raw, envlp, envlp.Payload, err, start)
+		return
+	}
+	p := w.policy()
+	if waited, ok := expired(envlp, p); ok {
+		w.expire(ctx, raw, envlp, waited, p, start)
+		return
+	}
+	if late, ok := w.pastDeadline(envlp); ok {
+		w.expireLate(ctx, raw, envlp, late, start)
+		return
+	}
+	if w.cancelled(ctx, envlp) {
+		w.drop(ctx, envlp, envlp.Payload, nil, start)
+		return
+	}
+	// Binary payloads are handled in their text rendering from here on.
+	msg, err := codec.Render(envlp.ContentType, []byte(envlp.Payload))
+	if err != nil {
+		w.logger.Printf("undecodable %s payload: %v", envlp.ContentType, err)
+		w.countFailure(ClassValidation)
+		w.deadLetter(ctx, raw, envlp, envlp.Payload, err, start)
+		return
+	}
+	version := envlp.SchemaVersion
+	if version > 0 {
+		upgraded, err := w.migrations.Upgrade(version, msg)
+		if err != nil {
+			w.logger.Printf("payload migration failed: %v", err)
+			w.countFailure(ClassValidation)
+			w.deadLetter(ctx, raw, envlp, msg, err, start)
+			return
+		}
+		if latest := w.migrations.Latest(); version < latest {
+			w.logger.Printf("migrated payload from v%d to v%d", version, latest)
+		}
+		msg, version = upgraded, w.migrations.Latest()
+	}
+	parent := tracecontext.FromMetadata(envlp.Metadata)
+	span := parent.Child()
+	if parent.IsValid() {
+		w.logger.Printf("dequeued message: %q trace_id=%s", w.redactor.Text(msg), span.TraceID)
+	} else {
+		w.logger.Printf("dequeued message: %q", w.redactor.Text(msg))
+	}
+	w.emit(events.MessageDequeued, msg, nil, 0)
+	w.track(envlp, start, "process")
+	if w.processingDelay > 0 {
+		time.Sleep(w.processingDelay)
+	}
+	w.faults.Delay(ctx)
+	w.faults.MaybePanic("worker")
+
+	if w.faults.DropAck() {
+		// The message has already been popped, so skipping completion loses it.
+		w.logger.Printf("chaos: dropping ack for message: %q", w.redactor.Text(msg))
+		return
+	}
+
+	w.track(envlp, start, "handle")
+	w.hold(ctx, raw)
+	m := Message{Envelope: envlp, Text: msg, SchemaVersion: version, Attempt: attempt(envlp), Started: start, Span: span}
+	m.progress = w.startProgress(ctx, envlp, start)
+	deadlineCtx, cancelDeadline := w.withDeadline(tracecontext.NewContext(ctx, span), envlp)
+	defer cancelDeadline()
+	handlerCtx, stopWatch := w.watchCancel(deadlineCtx, envlp)
+	defer stopWatch()
+	if w.handlerTimeout > 0 {
+		var cancel context.CancelFunc
+		handlerCtx, cancel = context.WithTimeout(handlerCtx, w.handlerTimeout)
+		defer cancel()
+	}
+	err = w.handler.Handle(handlerCtx, m)
+	if err != nil && errors.Is(context.Cause(handlerCtx), ErrCancelled) {
+		w.settle(ctx, envlp.ID)
+		w.drop(ctx, envlp, msg, err, start)
+		return
+	}
+	w.budget.record(err != nil)
+	if late, ok := w.pastDeadline(envlp); ok && timedOut(ctx, deadlineCtx, err) {
+		w.countFailure(ClassTimeout)
+		w.settle(ctx, envlp.ID)
+		w.expireLate(ctx, raw, envlp, late, start)
+		return
+	}
+	if w.poison != nil && timedOut(ctx, handlerCtx, err) {
+		w.countFailure(ClassTimeout)
+		w.logger.Printf("%s handler timed out after %s: %v", w.name, w.handlerTimeout, err)
+		w.strike(ctx, raw, envlp, msg, "timeout", fmt.Errorf("timed out after %s", w.handlerTimeout), start)
+		return
+	}
+	w.settle(ctx, envlp.ID)
+	if err != nil {
+		w.countFailure(ClassOf(err))
+		w.logger.Printf("%s handler failed: %v", w.name, err)
+		if !w.retry(ctx, envlp, msg, err, p, start) {
+			w.deadLetter(ctx, raw, envlp, msg, err, start)
+		}
+		return
+	}
+	w.logger.Printf("processed message: %q", w.redactor.Text(msg))
+	w.processed.Add(1)
+	w.setStatus(ctx, envlp, queue.StateProcessed, nil, start)
+	w.latency.Observe(w.q.Name(), envlp.EnqueuedAt, time.Now())
+	w.checkSlow(ctx, envlp.ID, msg, time.Since(start))
+	w.track(envlp, start, "record")
+	if err := w.q.RecordProcessed(ctx, msg, time.Now()); err != nil {
+		w.logger.Printf("record processed error: %v", err)
+	}
+	w.archive(ctx, envlp, msg, time.Since(start))
+	w.emit(events.MessageProcessed, msg, nil, time.Since(start))
+}
+
+// deadLetter parks raw on the DLQ after processing failed with cause. A
+// valid envelope is parked with this last attempt added to its history
+// (see MetaHistory).
+func (w *Worker) deadLetter(ctx context.Context, raw string, envlp envelope.Envelope, msg string, cause error, start time.Time) {
+	if envlp.Check() == nil {
+		if stamped, err := w.q.Encode(w.withAttempt(envlp, cause, start)); err == nil {
+			raw = stamped
+		}
+	}
+	w.reporter.Report(errreport.Event{
+		Err:       cause,
+		Message:   "message processing failed",
+		MessageID: envlp.ID,
+		TraceID:   errreport.TraceID(envlp.Metadata["traceparent"]),
+		Tags:      map[string]string{"queue": w.q.Name(), "handler": w.name},
+	})
+	if err := w.q.DeadLetter(ctx, raw); err != nil {
+		w.logger.Printf("dead-letter error: %v", err)
+	} else {
+		w.logger.Printf("dead-lettered message: %q (to %s)", w.redactor.Text(msg), w.q.DLQName())
+		w.setStatus(ctx, envlp, queue.StateDeadLettered, cause, start)
+	}
+	w.emit(events.MessageFailed, msg, cause, time.Since(start))
+	w.emit(events.MessageDeadLettered, msg, cause, 0)
+}
+
+// reportPanic sends a panic while handling envlp to the error tracker before
+// letting it crash the worker as before.
+func (w *Worker) reportPanic(envlp envelope.Envelope) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	w.reporter.Report(errreport.Event{
+		Err:       fmt.Errorf("%v", rec),
+		Message:   "panic handling message",
+		Level:     errreport.Fatal,
+		MessageID: envlp.ID,
+		TraceID:   errreport.TraceID(envlp.Metadata["traceparent"]),
+		Tags:      map[string]string{"queue": w.q.Name(), "handler": w.name},
+		Stack:     debug.Stack(),
+	})
+	w.reporter.Flush(2 * time.Second)
+	panic(rec)
+}
+
+// checkSlow reports a message whose processing took longer than the slow
+// threshold: a key=value warning, a counter, and an entry in the queue's
+// recent slow list.
+func (w *Worker) checkSlow(ctx context.Context, id, msg string, took time.Duration) {
+	if w.slowThreshold <= 0 || took <= w.slowThreshold {
+		return
+	}
+	w.logger.Printf("slow message id=%s handler=%s duration=%s threshold=%s queue=%s", id, w.name, took.Round(time.Millisecond), w.slowThreshold, w.q.Name())
+	if w.slowCount != nil {
+		w.slowCount.Inc(w.q.Name(), w.name)
+	}
+	m := queue.SlowMessage{
+		ID:          id,
+		Queue:       w.q.Name(),
+		Handler:     w.name,
+		Worker:      w.hostname,
+		DurationMS:  took.Milliseconds(),
+		Message:     msg,
+		ProcessedAt: time.Now(),
+	}
+	if err := w.q.RecordSlow(ctx, m); err != nil {
+		w.logger.Printf("record slow error: %v", err)
+	}
+}
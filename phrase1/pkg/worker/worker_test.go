@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"learn_k8s/phrase1/internal/envelope"
+	"learn_k8s/phrase1/internal/migrate"
+	"learn_k8s/phrase1/internal/queue"
+)
+
+// memQueue is the part of queue.Queue the worker uses, in memory. Calling
+// anything else panics on the nil embedded interface.
+type memQueue struct {
+	queue.Queue
+
+	mu        sync.Mutex
+	pending   []string
+	dlq       []string
+	processed []string
+}
+
+func (q *memQueue) Name() string    { return "test" }
+func (q *memQueue) DLQName() string { return "test:dlq" }
+
+func (q *memQueue) Paused(context.Context) (bool, error) { return false, nil }
+
+func (q *memQueue) Dequeue(ctx context.Context) (string, error) {
+	return q.DequeueWithin(ctx, 0)
+}
+
+func (q *memQueue) DequeueWithin(ctx context.Context, _ time.Duration) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return "", queue.ErrEmpty
+	}
+	raw := q.pending[0]
+	q.pending = q.pending[1:]
+	return raw, nil
+}
+
+func (q *memQueue) Encode(e envelope.Envelope) (string, error) { return e.Encode() }
+
+func (q *memQueue) Requeue(_ context.Context, raw string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append([]string{raw}, q.pending...)
+	return nil
+}
+
+func (q *memQueue) DeadLetter(_ context.Context, raw string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dlq = append(q.dlq, raw)
+	return nil
+}
+
+func (q *memQueue) RecordProcessed(_ context.Context, msg string, _ time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.processed = append(q.processed, msg)
+	return nil
+}
+
+func newMemQueue(t *testing.T, envs ...envelope.Envelope) *memQueue {
+	t.Helper()
+	q := &memQueue{}
+	for _, e := range envs {
+		raw, err := e.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.pending = append(q.pending, raw)
+	}
+	return q
+}
+
+func TestRunHandsMessagesToHandler(t *testing.T) {
+	v1 := envelope.New(`{"qty":2}`)
+	v1.SchemaVersion = 1
+	bad := envelope.New("fail me")
+	q := newMemQueue(t, envelope.New("plain"), v1, bad)
+
+	migrations := migrate.NewRegistry()
+	migrations.Register(1, func(p string) (string, error) { return `{"quantity":2}`, nil })
+	var got []Message
+	h := HandlerFunc(func(_ context.Context, m Message) error {
+		if m.Text == "fail me" {
+			return errors.New("nope")
+		}
+		got = append(got, m)
+		return nil
+	})
+	w := New(q, h, WithDrainIdle(time.Second), WithMigrations(migrations), WithLogger(log.New(io.Discard, "", 0)))
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Text != "plain" || got[1].Text != `{"quantity":2}` || got[1].SchemaVersion != 2 {
+		t.Fatalf("handled %+v", got)
+	}
+	if got[1].Envelope.ID != v1.ID {
+		t.Errorf("envelope id %q, want %q", got[1].Envelope.ID, v1.ID)
+	}
+	if w.Processed() != 2 || len(q.processed) != 2 {
+		t.Errorf("processed %d, recorded %q", w.Processed(), q.processed)
+	}
+	if len(q.dlq) != 1 || envelope.Decode(q.dlq[0]).ID != bad.ID {
+		t.Errorf("dlq %q, want only the failed message", q.dlq)
+	}
+	if w.Current() != nil {
+		t.Errorf("current %+v after run, want nil", w.Current())
+	}
+}
+
+func TestRunWithoutMigrationsDeadLettersVersioned(t *testing.T) {
+	v2 := envelope.New("x")
+	v2.SchemaVersion = 2
+	q := newMemQueue(t, v2)
+	w := New(q, HandlerFunc(func(context.Context, Message) error {
+		t.Error("handler called for a payload newer than it supports")
+		return nil
+	}), WithDrainIdle(time.Second), WithLogger(log.New(io.Discard, "", 0)))
+	_ = w.Run(context.Background())
+	if len(q.dlq) != 1 {
+		t.Errorf("dlq %q, want the versioned message", q.dlq)
+	}
+}